@@ -47,6 +47,13 @@ func runCode(cmd *cobra.Command, args []string) error {
 	env = append(env, "ANTHROPIC_BASE_URL=http://"+cfg.Host+":"+strconv.Itoa(cfg.Port))
 	env = append(env, "API_TIMEOUT_MS=600000")
 
+	// Background requests are recognized by the small fast model, so Claude Code has to use
+	// the configured one
+	if cfg.Router.SmallFastModel != "" {
+		env = filterEnv(env, "ANTHROPIC_SMALL_FAST_MODEL")
+		env = append(env, "ANTHROPIC_SMALL_FAST_MODEL="+cfg.Router.SmallFastModel)
+	}
+
 	// Track reference count
 	procMgr.IncrementRef()
 
@@ -4,13 +4,16 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 
 	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/router"
 )
 
 var configCmd = &cobra.Command{
@@ -165,10 +168,20 @@ func runConfigShow(cmd *cobra.Command, _ []string) error {
 
 	fmt.Println("\nProviders:")
 
-	for _, provider := range cfg.Providers {
+	for i := range cfg.Providers {
+		provider := &cfg.Providers[i]
+
 		fmt.Printf("  - Name: %s\n", provider.Name)
+
+		if provider.Type != "" {
+			fmt.Printf("    Type: %s\n", provider.Type)
+		}
+
 		fmt.Printf("    URL: %s\n", provider.APIBase)
-		fmt.Printf("    API Key: %s\n", maskString(provider.APIKey.(string)))
+
+		if apiKey, ok := provider.APIKey.(string); ok {
+			fmt.Printf("    API Key: %s\n", maskString(apiKey))
+		}
 
 		if len(provider.DefaultModels) > 0 {
 			fmt.Printf("    Default Models: %v\n", provider.DefaultModels)
@@ -193,17 +206,38 @@ func runConfigShow(cmd *cobra.Command, _ []string) error {
 	}
 
 	if cfg.Router.Background != "" {
-		fmt.Printf("  %-15s: %s\n", "Background", cfg.Router.Background)
+		fmt.Printf("  %-15s: %s (for %s)\n", "Background", cfg.Router.Background, cfg.Router.GetSmallFastModel())
 	}
 
 	if cfg.Router.LongContext != "" {
-		fmt.Printf("  %-15s: %s\n", "Long Context", cfg.Router.LongContext)
+		fmt.Printf("  %-15s: %s (above %d tokens)\n", "Long Context", cfg.Router.LongContext, cfg.Router.GetLongContextThreshold())
 	}
 
 	if cfg.Router.WebSearch != "" {
 		fmt.Printf("  %-15s: %s\n", "Web Search", cfg.Router.WebSearch)
 	}
 
+	if len(cfg.Router.Rules) > 0 {
+		fmt.Println("  Rules:")
+
+		for i, rule := range cfg.Router.Rules {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("#%d", i)
+			}
+
+			fmt.Printf("    %-13s: %s\n", name, rule.Target)
+		}
+	}
+
+	if len(cfg.Router.Fallback) > 0 {
+		fmt.Println("  Fallback:")
+
+		for _, target := range slices.Sorted(maps.Keys(cfg.Router.Fallback)) {
+			fmt.Printf("    %s -> %s\n", target, strings.Join(cfg.Router.Fallback[target], " -> "))
+		}
+	}
+
 	return nil
 }
 
@@ -224,24 +258,62 @@ func runConfigValidate(cmd *cobra.Command, _ []string) error {
 		validationErrors = append(validationErrors, "no providers configured")
 	}
 
-	for i, provider := range cfg.Providers {
+	for i := range cfg.Providers {
+		provider := &cfg.Providers[i]
+
 		if provider.Name == "" {
 			validationErrors = append(validationErrors, fmt.Sprintf("provider %d: name is required", i))
 		}
 
-		if provider.APIBase == "" {
+		if !slices.Contains(config.SupportedProviderTypes, provider.GetType()) {
+			validationErrors = append(validationErrors, fmt.Sprintf("provider %d: unknown type %q (supported: %s)",
+				i, provider.GetType(), strings.Join(config.SupportedProviderTypes, ", ")))
+		}
+
+		// Bedrock and Vertex AI derive their endpoints from the region
+		regional := provider.GetType() == "bedrock" || provider.GetType() == "vertex"
+		if provider.APIBase == "" && !regional {
 			validationErrors = append(validationErrors, fmt.Sprintf("provider %d: API base URL is required", i))
 		}
 
-		if provider.APIKey == "" {
+		// Local servers commonly run without authentication, while Bedrock and Vertex AI
+		// authenticate with cloud credentials
+		local := provider.GetType() == config.ProviderTypeOpenAICompatible || provider.GetType() == "ollama"
+		keyOptional := local || regional
+		if (provider.APIKey == nil || provider.APIKey == "") && !keyOptional {
 			validationErrors = append(validationErrors, fmt.Sprintf("provider %d: API key is required", i))
 		}
+
+		for j, setting := range provider.SafetySettings {
+			if setting.Category == "" || setting.Threshold == "" {
+				validationErrors = append(validationErrors,
+					fmt.Sprintf("provider %d: safety setting %d needs a category and a threshold", i, j))
+			}
+		}
+
+		if retry := provider.Retry; retry != nil {
+			if retry.Jitter != nil && (*retry.Jitter < 0 || *retry.Jitter > 1) {
+				validationErrors = append(validationErrors, fmt.Sprintf("provider %d: retry jitter must be between 0 and 1", i))
+			}
+
+			if policy := provider.GetRetryPolicy(); policy.MaxBackoffMs < policy.BaseBackoffMs {
+				validationErrors = append(validationErrors,
+					fmt.Sprintf("provider %d: retry max backoff is shorter than the base backoff", i))
+			}
+		}
 	}
 
 	if cfg.Router.Default == "" {
 		validationErrors = append(validationErrors, "default router model is required")
 	}
 
+	// Each invalid routing rule is reported on its own line
+	if _, err := router.New(&cfg.Router); err != nil {
+		for _, ruleErr := range strings.Split(err.Error(), "\n") {
+			validationErrors = append(validationErrors, "router "+ruleErr)
+		}
+	}
+
 	if len(validationErrors) > 0 {
 		color.Red("Configuration validation failed:")
 
@@ -303,9 +375,5 @@ func maskString(s string) string {
 		return "(not set)"
 	}
 
-	if len(s) <= 8 {
-		return strings.Repeat("*", len(s))
-	}
-
-	return s[:4] + strings.Repeat("*", len(s)-8) + s[len(s)-4:]
+	return config.MaskAPIKey(s)
 }
@@ -148,6 +148,9 @@ func setupLogging(verbose, logFile bool) {
 	}
 
 	logger = slog.New(handler)
+
+	// Providers have no logger of their own and log through the default one
+	slog.SetDefault(logger)
 }
 
 func ensureConfigExists() error {
@@ -55,7 +55,10 @@ func runStart(cmd *cobra.Command, _ []string) error {
 	defer procMgr.CleanupPID()
 
 	// Create and start server
-	srv := server.New(cfgMgr, logger)
+	srv, err := server.New(cfgMgr, logger)
+	if err != nil {
+		return err
+	}
 
 	return srv.Start()
 }
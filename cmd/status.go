@@ -1,12 +1,19 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/handlers"
 	"github.com/Davincible/claude-code-open/internal/process"
+	"github.com/Davincible/claude-code-open/internal/server"
 )
 
 var statusCmd = &cobra.Command{
@@ -38,4 +45,76 @@ func runStatus(cmd *cobra.Command, _ []string) {
 	fmt.Printf("  %-15s: %s\n", "Config Path", cfgMgr.GetPath())
 	fmt.Printf("  %-15s: %d\n", "References", refs)
 	fmt.Printf("  %-15s: v%s\n", "Version", Version)
+
+	if running && cfg != nil {
+		printKeyHealth(cmd.Context(), cfg)
+	}
+}
+
+// printKeyHealth shows the health of the API keys as tracked by the running service
+func printKeyHealth(ctx context.Context, cfg *config.Config) {
+	statuses, err := fetchKeyHealth(ctx, cfg)
+	if err != nil {
+		color.Yellow("  Key health unavailable: %v", err)
+		return
+	}
+
+	if len(statuses) == 0 {
+		return
+	}
+
+	fmt.Println()
+	color.Blue("API Keys:")
+
+	for _, provider := range statuses {
+		fmt.Printf("  %s:\n", provider.Provider)
+
+		for _, key := range provider.Keys {
+			fmt.Printf("    %-20s %s\n", key.Key, describeKeyStatus(key))
+		}
+	}
+}
+
+func fetchKeyHealth(ctx context.Context, cfg *config.Config) ([]handlers.ProviderKeyStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	url := fmt.Sprintf("http://%s:%d%s", cfg.Host, cfg.Port, server.KeyStatusPath)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("service answered %s", resp.Status)
+	}
+
+	var statuses []handlers.ProviderKeyStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, fmt.Errorf("decode key status: %w", err)
+	}
+
+	return statuses, nil
+}
+
+func describeKeyStatus(key config.KeyStatus) string {
+	switch key.State {
+	case config.KeyCoolingDown:
+		return color.YellowString("cooling down until %s (%d)", key.Until.Local().Format(time.TimeOnly), key.LastStatus)
+	case config.KeyDisabled:
+		return color.RedString("disabled since %s (%d)", key.Since.Local().Format(time.TimeOnly), key.LastStatus)
+	default:
+		return color.GreenString("healthy")
+	}
 }
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,9 +19,31 @@ const (
 	DefaultConfigFilename = "config.json"
 	DefaultYAMLFilename   = "config.yaml"
 	DefaultHost           = "127.0.0.1"
+
+	// ProviderTypeOpenAICompatible selects the OpenAI translator for any backend that
+	// speaks the OpenAI chat completions API (Ollama, vLLM, LM Studio, llama.cpp server).
+	ProviderTypeOpenAICompatible = "openai-compatible"
+
+	// DefaultLongContextThreshold is the input token count above which requests take the
+	// long context route
+	DefaultLongContextThreshold = 60000
+
+	// DefaultSmallFastModel is the model Claude Code sends background requests to unless
+	// ANTHROPIC_SMALL_FAST_MODEL says otherwise
+	DefaultSmallFastModel = "claude-3-5-haiku"
+
+	// Defaults of a provider's retry policy, once it has one
+	DefaultRetryMaxAttempts   = 3
+	DefaultRetryBaseBackoffMs = 500
+	DefaultRetryMaxBackoffMs  = 30000
+	DefaultRetryJitter        = 0.2
 )
 
 var (
+	// DefaultRetryStatusCodes are the upstream statuses retried unless a policy lists its own:
+	// timeouts, rate limits, server errors and Anthropic's overloaded error
+	DefaultRetryStatusCodes = []int{408, 429, 500, 502, 503, 504, 529}
+
 	// Default provider URLs
 	DefaultProviderURLs = map[string]string{
 		"openrouter": "https://openrouter.ai/api/v1/chat/completions",
@@ -26,6 +51,7 @@ var (
 		"anthropic":  "https://api.anthropic.com/v1/messages",
 		"nvidia":     "https://integrate.api.nvidia.com/v1/chat/completions",
 		"gemini":     "https://generativelanguage.googleapis.com/v1beta/models",
+		"ollama":     "http://localhost:11434/api/chat",
 	}
 
 	// Default models for each provider
@@ -57,22 +83,128 @@ var (
 			"gemini-1.5-flash",
 		},
 	}
+
+	// SupportedProviderTypes lists every value accepted by the provider type field
+	SupportedProviderTypes = []string{
+		"openrouter",
+		"openai",
+		"anthropic",
+		"nvidia",
+		"gemini",
+		"azure",
+		"bedrock",
+		"vertex",
+		"ollama",
+		ProviderTypeOpenAICompatible,
+	}
 )
 
 type Provider struct {
 	Name           string   `json:"name" yaml:"name"`
+	Type           string   `json:"type,omitempty" yaml:"type,omitempty"`
 	APIBase        string   `json:"api_base_url" yaml:"url,omitempty"`
 	APIKey         any      `json:"api_key,omitempty" yaml:"api_key,omitempty"`
 	Models         []string `json:"models" yaml:"models,omitempty"`
 	ModelWhitelist []string `json:"model_whitelist,omitempty" yaml:"model_whitelist,omitempty"`
 	DefaultModels  []string `json:"default_models,omitempty" yaml:"default_models,omitempty"`
 
+	// TextOnlyModels lists globs of models that can't take image input, where * matches
+	// any run of characters and ? a single one
+	TextOnlyModels []string `json:"text_only_models,omitempty" yaml:"text_only_models,omitempty"`
+
+	// APIVersion is the api-version query parameter sent to Azure OpenAI
+	APIVersion string `json:"api_version,omitempty" yaml:"api_version,omitempty"`
+
+	// Region is the cloud region for providers addressed by region (Bedrock, Vertex AI)
+	Region string `json:"region,omitempty" yaml:"region,omitempty"`
+
+	// Google Cloud settings for Vertex AI
+	Project         string `json:"project,omitempty" yaml:"project,omitempty"`
+	CredentialsFile string `json:"credentials_file,omitempty" yaml:"credentials_file,omitempty"`
+	TokenURL        string `json:"token_url,omitempty" yaml:"token_url,omitempty"`
+
+	// SafetySettings replaces the safety settings sent to Gemini, which block nothing by default
+	SafetySettings []SafetySetting `json:"safety_settings,omitempty" yaml:"safety_settings,omitempty"`
+
+	// Retry is the retry policy of failed requests, which are not retried without one
+	Retry *RetryPolicy `json:"retry,omitempty" yaml:"retry,omitempty"`
+
 	// Internal fields for round-robin
 	apiKeys  []string
 	keyIndex atomic.Uint32
+
+	// keyHealth holds the keys that failed, see ReportKeyResult
+	keyHealthMu sync.Mutex
+	keyHealth   map[string]*keyHealth
+}
+
+// SafetySetting is a Gemini harm category and the threshold at which it is blocked, such as
+// HARM_CATEGORY_HARASSMENT and BLOCK_ONLY_HIGH
+type SafetySetting struct {
+	Category  string `json:"category" yaml:"category"`
+	Threshold string `json:"threshold" yaml:"threshold"`
+}
+
+// RetryPolicy controls how often and how long after a failure a request to the provider is
+// sent again. Fields left out take the DefaultRetry values.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts, the first one included
+	MaxAttempts int `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty"`
+	// BaseBackoffMs is the wait before the first retry, doubled for every further one
+	BaseBackoffMs int `json:"base_backoff_ms,omitempty" yaml:"base_backoff_ms,omitempty"`
+	// MaxBackoffMs caps the wait between attempts, also when the upstream asks for a longer one
+	MaxBackoffMs int `json:"max_backoff_ms,omitempty" yaml:"max_backoff_ms,omitempty"`
+	// Jitter is the fraction of the wait that is randomized, from 0 to 1
+	Jitter *float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// StatusCodes are the upstream statuses that are retried. Connection errors always are.
+	StatusCodes []int `json:"status_codes,omitempty" yaml:"status_codes,omitempty"`
+}
+
+// GetRetryPolicy returns the retry policy of the provider with its defaults filled in. A
+// provider without one makes a single attempt.
+func (p *Provider) GetRetryPolicy() RetryPolicy {
+	if p.Retry == nil {
+		return RetryPolicy{MaxAttempts: 1}
+	}
+
+	policy := *p.Retry
+
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = DefaultRetryMaxAttempts
+	}
+
+	if policy.BaseBackoffMs <= 0 {
+		policy.BaseBackoffMs = DefaultRetryBaseBackoffMs
+	}
+
+	if policy.MaxBackoffMs <= 0 {
+		policy.MaxBackoffMs = DefaultRetryMaxBackoffMs
+	}
+
+	if policy.Jitter == nil {
+		jitter := DefaultRetryJitter
+		policy.Jitter = &jitter
+	}
+
+	if len(policy.StatusCodes) == 0 {
+		policy.StatusCodes = DefaultRetryStatusCodes
+	}
+
+	return policy
 }
 
-// GetAPIKey returns an API key in a round-robin fashion.
+// GetType returns the provider implementation to use. When no type is configured the
+// name is used, so entries named after a built-in provider keep working unchanged.
+func (p *Provider) GetType() string {
+	if p.Type != "" {
+		return p.Type
+	}
+
+	return p.Name
+}
+
+// GetAPIKey returns an API key in a round-robin fashion, skipping keys that are cooling down
+// or disabled. When none is usable, the rotation goes on over all keys.
 func (p *Provider) GetAPIKey() string {
 	if len(p.apiKeys) == 0 {
 		return ""
@@ -80,7 +212,17 @@ func (p *Provider) GetAPIKey() string {
 	if len(p.apiKeys) == 1 {
 		return p.apiKeys[0]
 	}
-	// Atomically increment and get the index, then modulo for round-robin
+
+	now := time.Now()
+
+	for range p.apiKeys {
+		// Atomically increment and get the index, then modulo for round-robin
+		idx := p.keyIndex.Add(1)
+		if key := p.apiKeys[int(idx-1)%len(p.apiKeys)]; p.keyUsable(key, now) {
+			return key
+		}
+	}
+
 	idx := p.keyIndex.Add(1)
 	return p.apiKeys[int(idx-1)%len(p.apiKeys)]
 }
@@ -91,6 +233,88 @@ type RouterConfig struct {
 	Background  string `json:"background,omitempty" yaml:"background,omitempty"`
 	LongContext string `json:"longContext,omitempty" yaml:"long_context,omitempty"`
 	WebSearch   string `json:"webSearch,omitempty" yaml:"web_search,omitempty"`
+
+	// LongContextThreshold is the input token count above which the long context route is
+	// taken, DefaultLongContextThreshold when zero
+	LongContextThreshold int `json:"longContextThreshold,omitempty" yaml:"long_context_threshold,omitempty"`
+
+	// SmallFastModel is the model Claude Code uses for background requests. Requests for
+	// any model of its family take the background route.
+	SmallFastModel string `json:"smallFastModel,omitempty" yaml:"small_fast_model,omitempty"`
+
+	// Rules are evaluated in order before the routes above, the first matching rule picks
+	// the model
+	Rules []RouteRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+
+	// Fallback maps a target, or the name of a provider, to the targets tried in order when
+	// its upstream fails with 429, a 5xx status or a connection error
+	Fallback map[string][]string `json:"fallback,omitempty" yaml:"fallback,omitempty"`
+}
+
+// GetLongContextThreshold returns the token count above which requests take the long
+// context route
+func (r *RouterConfig) GetLongContextThreshold() int {
+	if r.LongContextThreshold > 0 {
+		return r.LongContextThreshold
+	}
+
+	return DefaultLongContextThreshold
+}
+
+// GetSmallFastModel returns the model Claude Code uses for background requests: the
+// configured one, else the ANTHROPIC_SMALL_FAST_MODEL environment variable Claude Code
+// reads, else its default
+func (r *RouterConfig) GetSmallFastModel() string {
+	if r.SmallFastModel != "" {
+		return r.SmallFastModel
+	}
+
+	if model := os.Getenv("ANTHROPIC_SMALL_FAST_MODEL"); model != "" {
+		return model
+	}
+
+	return DefaultSmallFastModel
+}
+
+// GetFallbacks returns the targets to try, in order, when the upstream of a target fails.
+// Fallbacks configured for the exact target take precedence over those of its provider.
+func (r *RouterConfig) GetFallbacks(target string) []string {
+	if fallbacks, ok := r.Fallback[target]; ok {
+		return fallbacks
+	}
+
+	if provider, _, found := strings.Cut(target, ","); found {
+		return r.Fallback[provider]
+	}
+
+	return nil
+}
+
+// RouteRule routes the requests matching all of its conditions to Target. Conditions that
+// are left empty match every request.
+type RouteRule struct {
+	// Name identifies the rule in logs
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Target is the provider and model to route to, e.g. "openai,gpt-4o"
+	Target string `json:"target" yaml:"target"`
+
+	// Model is a glob matched against the requested model, e.g. "claude-3-5-haiku*"
+	Model string `json:"model,omitempty" yaml:"model,omitempty"`
+	// MinTokens and MaxTokens bound the estimated input tokens, zero leaves a side open
+	MinTokens int `json:"min_tokens,omitempty" yaml:"min_tokens,omitempty"`
+	MaxTokens int `json:"max_tokens,omitempty" yaml:"max_tokens,omitempty"`
+	// HasTools requires the request to offer tools, or to offer none when false
+	HasTools *bool `json:"has_tools,omitempty" yaml:"has_tools,omitempty"`
+	// Tools are globs that must each match the name of a tool the request offers
+	Tools []string `json:"tools,omitempty" yaml:"tools,omitempty"`
+	// Thinking requires extended thinking to be enabled, or disabled when false
+	Thinking *bool `json:"thinking,omitempty" yaml:"thinking,omitempty"`
+	// Stream requires a streaming request, or a non-streaming one when false
+	Stream *bool `json:"stream,omitempty" yaml:"stream,omitempty"`
+	// Headers maps request header names to globs their value must match
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	// System is a regular expression matched against the text of the system prompt
+	System string `json:"system,omitempty" yaml:"system,omitempty"`
 }
 
 type Config struct {
@@ -219,7 +443,7 @@ func (m *Manager) ApplyDefaults(cfg *Config) {
 
 		// Set default URL if not provided
 		if provider.APIBase == "" {
-			if defaultURL, exists := DefaultProviderURLs[provider.Name]; exists {
+			if defaultURL, exists := DefaultProviderURLs[provider.GetType()]; exists {
 				provider.APIBase = defaultURL
 			}
 		}
@@ -240,7 +464,7 @@ func (m *Manager) ApplyDefaults(cfg *Config) {
 
 		// Set default models if not provided
 		if len(provider.DefaultModels) == 0 {
-			if defaultModels, exists := DefaultProviderModels[provider.Name]; exists {
+			if defaultModels, exists := DefaultProviderModels[provider.GetType()]; exists {
 				provider.DefaultModels = make([]string, len(defaultModels))
 				copy(provider.DefaultModels, defaultModels)
 			}
@@ -439,6 +663,43 @@ func (p *Provider) IsModelAllowed(model string) bool {
 	return false
 }
 
+// SupportsImages reports whether a model accepts image input. Models are assumed to be
+// vision capable unless they match an entry in TextOnlyModels. Entries are globs matched
+// against the whole name, so "llama3" doesn't also cover "llama3.2-vision".
+func (p *Provider) SupportsImages(model string) bool {
+	for _, textOnly := range p.TextOnlyModels {
+		if CompileGlob(textOnly).MatchString(model) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CompileGlob turns a glob, where * matches any run of characters and ? a single one, into
+// an anchored regular expression. Unlike path.Match, * also matches slashes, which model
+// names such as "anthropic/claude-sonnet-4" contain.
+func CompileGlob(glob string) *regexp.Regexp {
+	var pattern strings.Builder
+
+	pattern.WriteString("^")
+
+	for _, r := range glob {
+		switch r {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	pattern.WriteString("$")
+
+	return regexp.MustCompile(pattern.String())
+}
+
 // GetAllowedModels returns all models that are allowed based on the whitelist
 func (p *Provider) GetAllowedModels() []string {
 	if len(p.ModelWhitelist) == 0 {
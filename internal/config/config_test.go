@@ -52,7 +52,7 @@ func TestConfig_LoadAndSave(t *testing.T) {
 
 	require.Len(t, loadedCfg.Providers, 1, "should have 1 provider")
 
-	provider := loadedCfg.Providers[0]
+	provider := &loadedCfg.Providers[0]
 	assert.Equal(t, "openrouter", provider.Name, "provider name should match")
 	assert.Equal(t, "https://openrouter.ai/api/v1/chat/completions", provider.APIBase, "API base should match")
 	assert.Equal(t, "openrouter,anthropic/claude-3.5-sonnet", loadedCfg.Router.Default, "default router should match")
@@ -124,3 +124,50 @@ func TestConfig_GetWithoutLoad(t *testing.T) {
 	assert.Equal(t, DefaultPort, cfg.Port, "should return default port")
 	assert.Equal(t, DefaultHost, cfg.Host, "should return default host")
 }
+
+func TestRouterConfig_Getters(t *testing.T) {
+	t.Setenv("ANTHROPIC_SMALL_FAST_MODEL", "")
+
+	routerConfig := &RouterConfig{}
+	assert.Equal(t, DefaultLongContextThreshold, routerConfig.GetLongContextThreshold())
+	assert.Equal(t, DefaultSmallFastModel, routerConfig.GetSmallFastModel())
+
+	t.Setenv("ANTHROPIC_SMALL_FAST_MODEL", "claude-haiku-4-5")
+	assert.Equal(t, "claude-haiku-4-5", routerConfig.GetSmallFastModel())
+
+	routerConfig = &RouterConfig{LongContextThreshold: 100000, SmallFastModel: "gpt-4o-mini"}
+	assert.Equal(t, 100000, routerConfig.GetLongContextThreshold())
+	assert.Equal(t, "gpt-4o-mini", routerConfig.GetSmallFastModel(), "the configured model wins over the environment")
+}
+
+func TestRouterConfig_GetFallbacks(t *testing.T) {
+	routerConfig := &RouterConfig{
+		Fallback: map[string][]string{
+			"openrouter,anthropic/claude-sonnet-4": {"anthropic,claude-sonnet-4-20250514"},
+			"openrouter":                           {"openai,gpt-4o"},
+			"claude-3-5-haiku":                     {"groq,llama-3.1-8b"},
+		},
+	}
+
+	assert.Equal(t, []string{"anthropic,claude-sonnet-4-20250514"}, routerConfig.GetFallbacks("openrouter,anthropic/claude-sonnet-4"))
+	assert.Equal(t, []string{"openai,gpt-4o"}, routerConfig.GetFallbacks("openrouter,google/gemini-2.5-pro"),
+		"provider fallbacks apply to any model")
+	assert.Equal(t, []string{"groq,llama-3.1-8b"}, routerConfig.GetFallbacks("claude-3-5-haiku"))
+	assert.Empty(t, routerConfig.GetFallbacks("gemini,gemini-2.5-pro"))
+}
+
+func TestProvider_GetRetryPolicy(t *testing.T) {
+	assert.Equal(t, RetryPolicy{MaxAttempts: 1}, (&Provider{}).GetRetryPolicy(), "providers without a policy aren't retried")
+
+	policy := (&Provider{Retry: &RetryPolicy{}}).GetRetryPolicy()
+	assert.Equal(t, DefaultRetryMaxAttempts, policy.MaxAttempts)
+	assert.Equal(t, DefaultRetryBaseBackoffMs, policy.BaseBackoffMs)
+	assert.Equal(t, DefaultRetryMaxBackoffMs, policy.MaxBackoffMs)
+	require.NotNil(t, policy.Jitter)
+	assert.InDelta(t, DefaultRetryJitter, *policy.Jitter, 1e-9)
+	assert.Equal(t, DefaultRetryStatusCodes, policy.StatusCodes)
+
+	noJitter := 0.0
+	configured := &RetryPolicy{MaxAttempts: 5, BaseBackoffMs: 250, MaxBackoffMs: 10000, Jitter: &noJitter, StatusCodes: []int{429}}
+	assert.Equal(t, *configured, (&Provider{Retry: configured}).GetRetryPolicy())
+}
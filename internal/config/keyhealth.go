@@ -0,0 +1,154 @@
+package config
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// KeyState is the health of an API key
+type KeyState string
+
+const (
+	// KeyHealthy keys take part in the rotation
+	KeyHealthy KeyState = "healthy"
+	// KeyCoolingDown keys were rate limited and rest until their cooldown ends
+	KeyCoolingDown KeyState = "cooling_down"
+	// KeyDisabled keys were rejected and are left out until a probe succeeds with them
+	KeyDisabled KeyState = "disabled"
+)
+
+// DefaultKeyCooldown is how long a rate limited key rests when the upstream doesn't say
+const DefaultKeyCooldown = time.Minute
+
+// keyHealth is the state of a key that failed
+type keyHealth struct {
+	state      KeyState
+	since      time.Time
+	until      time.Time
+	probedAt   time.Time
+	lastStatus int
+}
+
+// KeyStatus is the health of an API key as shown to users, with the key masked
+type KeyStatus struct {
+	Key   string   `json:"key"`
+	State KeyState `json:"state"`
+	// Since is when the key entered its state, zero for healthy keys
+	Since time.Time `json:"since,omitzero"`
+	// Until is when the cooldown of a rate limited key ends
+	Until time.Time `json:"until,omitzero"`
+	// LastStatus is the upstream status that put the key in its state
+	LastStatus int `json:"last_status,omitempty"`
+}
+
+// ReportKeyResult records the upstream status of a request sent with a key of the provider
+// and returns the key's new state. A 429 cools the key down for retryAfter, or
+// DefaultKeyCooldown when the upstream didn't say, while 401 and 403 disable it. Other
+// statuses below 500 show the key works. Server errors say nothing about the key.
+func (p *Provider) ReportKeyResult(key string, statusCode int, retryAfter time.Duration) KeyState {
+	if !slices.Contains(p.apiKeys, key) {
+		return KeyHealthy
+	}
+
+	p.keyHealthMu.Lock()
+	defer p.keyHealthMu.Unlock()
+
+	now := time.Now()
+
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		p.setKeyHealth(key, &keyHealth{state: KeyDisabled, since: now, probedAt: now, lastStatus: statusCode})
+	case statusCode == http.StatusTooManyRequests:
+		if retryAfter <= 0 {
+			retryAfter = DefaultKeyCooldown
+		}
+
+		p.setKeyHealth(key, &keyHealth{state: KeyCoolingDown, since: now, until: now.Add(retryAfter), lastStatus: statusCode})
+	case statusCode < http.StatusInternalServerError:
+		delete(p.keyHealth, key)
+	}
+
+	return p.keyState(key, now)
+}
+
+func (p *Provider) setKeyHealth(key string, health *keyHealth) {
+	if p.keyHealth == nil {
+		p.keyHealth = make(map[string]*keyHealth)
+	}
+
+	p.keyHealth[key] = health
+}
+
+// keyState returns the state of a key, the lock being held. Keys whose cooldown ended are
+// healthy again.
+func (p *Provider) keyState(key string, now time.Time) KeyState {
+	health, ok := p.keyHealth[key]
+	if !ok || (health.state == KeyCoolingDown && !now.Before(health.until)) {
+		return KeyHealthy
+	}
+
+	return health.state
+}
+
+// keyUsable tells whether a key takes part in the rotation
+func (p *Provider) keyUsable(key string, now time.Time) bool {
+	p.keyHealthMu.Lock()
+	defer p.keyHealthMu.Unlock()
+
+	return p.keyState(key, now) == KeyHealthy
+}
+
+// DisabledKeysDue returns the disabled keys that weren't probed for the interval, and
+// counts them as probed now
+func (p *Provider) DisabledKeysDue(interval time.Duration) []string {
+	p.keyHealthMu.Lock()
+	defer p.keyHealthMu.Unlock()
+
+	var (
+		now  = time.Now()
+		keys []string
+	)
+
+	for _, key := range p.apiKeys {
+		if health, ok := p.keyHealth[key]; ok && health.state == KeyDisabled && now.Sub(health.probedAt) >= interval {
+			health.probedAt = now
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// KeyHealth returns the health of each key of the provider, in the configured order
+func (p *Provider) KeyHealth() []KeyStatus {
+	p.keyHealthMu.Lock()
+	defer p.keyHealthMu.Unlock()
+
+	now := time.Now()
+	statuses := make([]KeyStatus, 0, len(p.apiKeys))
+
+	for _, key := range p.apiKeys {
+		status := KeyStatus{Key: MaskAPIKey(key), State: p.keyState(key, now)}
+
+		if health, ok := p.keyHealth[key]; ok && status.State != KeyHealthy {
+			status.Since = health.since
+			status.Until = health.until
+			status.LastStatus = health.lastStatus
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// MaskAPIKey hides all but the first and last four characters of a key, or all of a short one
+func MaskAPIKey(key string) string {
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+
+	return key[:4] + strings.Repeat("*", len(key)-8) + key[len(key)-4:]
+}
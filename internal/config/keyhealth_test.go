@@ -0,0 +1,87 @@
+package config
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// providerWithKeys returns a provider rotating through the keys
+func providerWithKeys(keys ...string) *Provider {
+	return &Provider{Name: "test", apiKeys: keys}
+}
+
+// nextKeys returns the keys of n calls to GetAPIKey
+func nextKeys(provider *Provider, n int) []string {
+	keys := make([]string, 0, n)
+	for range n {
+		keys = append(keys, provider.GetAPIKey())
+	}
+
+	return keys
+}
+
+func TestProvider_KeyRotationSkipsFailingKeys(t *testing.T) {
+	provider := providerWithKeys("key-a", "key-b", "key-c")
+	assert.Equal(t, []string{"key-a", "key-b", "key-c", "key-a"}, nextKeys(provider, 4))
+
+	assert.Equal(t, KeyDisabled, provider.ReportKeyResult("key-b", http.StatusUnauthorized, 0))
+	assert.Equal(t, KeyCoolingDown, provider.ReportKeyResult("key-c", http.StatusTooManyRequests, time.Hour))
+	assert.Equal(t, []string{"key-a", "key-a", "key-a"}, nextKeys(provider, 3))
+
+	// Server errors say nothing about the key, a working request restores it
+	assert.Equal(t, KeyDisabled, provider.ReportKeyResult("key-b", http.StatusBadGateway, 0))
+	assert.Equal(t, KeyHealthy, provider.ReportKeyResult("key-b", http.StatusOK, 0))
+	assert.ElementsMatch(t, []string{"key-a", "key-b", "key-a", "key-b"}, nextKeys(provider, 4))
+
+	// Without a usable key the rotation goes on over all keys
+	provider.ReportKeyResult("key-a", http.StatusForbidden, 0)
+	provider.ReportKeyResult("key-b", http.StatusForbidden, 0)
+	assert.ElementsMatch(t, []string{"key-a", "key-b", "key-c"}, nextKeys(provider, 3))
+
+	assert.Equal(t, KeyHealthy, provider.ReportKeyResult("unknown", http.StatusUnauthorized, 0), "unknown keys are ignored")
+	assert.NotContains(t, provider.keyHealth, "unknown")
+}
+
+func TestProvider_KeyCooldown(t *testing.T) {
+	provider := providerWithKeys("key-a", "key-b")
+
+	provider.ReportKeyResult("key-a", http.StatusTooManyRequests, 0)
+	health := provider.keyHealth["key-a"]
+	assert.WithinDuration(t, time.Now().Add(DefaultKeyCooldown), health.until, time.Second, "the default cooldown applies without Retry-After")
+
+	// The key rejoins the rotation once its cooldown ended
+	health.until = time.Now().Add(-time.Second)
+	assert.ElementsMatch(t, []string{"key-a", "key-b"}, nextKeys(provider, 2))
+}
+
+func TestProvider_DisabledKeysDue(t *testing.T) {
+	provider := providerWithKeys("key-a", "key-b", "key-c")
+	provider.ReportKeyResult("key-a", http.StatusUnauthorized, 0)
+	provider.ReportKeyResult("key-b", http.StatusTooManyRequests, time.Hour)
+
+	assert.Empty(t, provider.DisabledKeysDue(time.Hour), "keys are only probed after the interval")
+	assert.Equal(t, []string{"key-a"}, provider.DisabledKeysDue(0))
+
+	provider.keyHealth["key-a"].probedAt = time.Now().Add(-2 * time.Hour)
+	assert.Equal(t, []string{"key-a"}, provider.DisabledKeysDue(time.Hour))
+	assert.Empty(t, provider.DisabledKeysDue(time.Hour), "a probed key waits for the next interval")
+}
+
+func TestProvider_KeyHealth(t *testing.T) {
+	provider := providerWithKeys("sk-live-0123456789", "sk-live-abcdefghij", "short")
+	provider.ReportKeyResult("sk-live-abcdefghij", http.StatusForbidden, 0)
+
+	health := provider.KeyHealth()
+	require.Len(t, health, 3)
+
+	assert.Equal(t, KeyStatus{Key: "sk-l**********6789", State: KeyHealthy}, health[0])
+	assert.Equal(t, "sk-l**********ghij", health[1].Key)
+	assert.Equal(t, KeyDisabled, health[1].State)
+	assert.Equal(t, http.StatusForbidden, health[1].LastStatus)
+	assert.False(t, health[1].Since.IsZero())
+	assert.Equal(t, "*****", health[2].Key)
+}
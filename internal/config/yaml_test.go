@@ -45,14 +45,14 @@ router:
 	// Test providers
 	require.Len(t, cfg.Providers, 2)
 
-	openrouter := cfg.Providers[0]
+	openrouter := &cfg.Providers[0]
 	assert.Equal(t, "openrouter", openrouter.Name)
 	assert.Equal(t, "test-openrouter-key", openrouter.APIKey)
 	assert.Equal(t, DefaultProviderURLs["openrouter"], openrouter.APIBase) // Should be set from defaults
 	assert.Equal(t, []string{"claude", "gpt-4"}, openrouter.ModelWhitelist)
 	assert.NotEmpty(t, openrouter.DefaultModels) // Should be populated from defaults
 
-	openai := cfg.Providers[1]
+	openai := &cfg.Providers[1]
 	assert.Equal(t, "openai", openai.Name)
 	assert.Equal(t, "test-openai-key", openai.APIKey)
 	assert.Equal(t, "https://api.openai.com/v1/chat/completions", openai.APIBase)
@@ -167,7 +167,8 @@ func TestManager_CreateExampleYAML(t *testing.T) {
 	assert.Len(t, cfg.Providers, 5)
 
 	providerNames := make([]string, len(cfg.Providers))
-	for i, p := range cfg.Providers {
+	for i := range cfg.Providers {
+		p := &cfg.Providers[i]
 		providerNames[i] = p.Name
 		// Each provider should have default URL and models populated
 		assert.NotEmpty(t, p.APIBase, "Provider %s should have URL", p.Name)
@@ -235,6 +236,23 @@ func TestProvider_NoWhitelist(t *testing.T) {
 	assert.Equal(t, provider.DefaultModels, allowed)
 }
 
+func TestProvider_SupportsImages(t *testing.T) {
+	provider := &Provider{
+		Name:           "openrouter",
+		TextOnlyModels: []string{"deepseek/deepseek-r1", "*/o3-mini", "meta-llama/llama3"},
+	}
+
+	assert.False(t, provider.SupportsImages("deepseek/deepseek-r1"))
+	assert.False(t, provider.SupportsImages("openai/o3-mini"))
+	assert.False(t, provider.SupportsImages("meta-llama/llama3"))
+	assert.True(t, provider.SupportsImages("meta-llama/llama3.2-vision"), "entries aren't substrings")
+	assert.True(t, provider.SupportsImages("deepseek/deepseek-r1-vision"), "entries match the whole name")
+	assert.True(t, provider.SupportsImages("anthropic/claude-3.5-sonnet"))
+
+	// Without a list every model is assumed to accept images
+	assert.True(t, (&Provider{Name: "openai"}).SupportsImages("gpt-4o"))
+}
+
 func TestManager_DefaultsApplication(t *testing.T) {
 	tempDir := t.TempDir()
 	mgr := NewManager(tempDir)
@@ -260,16 +278,141 @@ providers:
 	assert.Equal(t, DefaultPort, cfg.Port)
 
 	// Provider defaults should be applied
-	openrouter := cfg.Providers[0]
+	openrouter := &cfg.Providers[0]
 	assert.Equal(t, DefaultProviderURLs["openrouter"], openrouter.APIBase)
 	assert.Equal(t, DefaultProviderModels["openrouter"], openrouter.DefaultModels)
 
 	// Nonexistent provider should not have URL or models
-	nonexistent := cfg.Providers[1]
+	nonexistent := &cfg.Providers[1]
 	assert.Empty(t, nonexistent.APIBase)
 	assert.Empty(t, nonexistent.DefaultModels)
 }
 
+func TestManager_ProviderType(t *testing.T) {
+	tempDir := t.TempDir()
+	mgr := NewManager(tempDir)
+
+	yamlConfig := `
+providers:
+  - name: "vllm-east"
+    type: "openai-compatible"
+    url: "http://vllm-east.internal:8000/v1/chat/completions"
+    models: ["qwen2.5-coder-32b"]
+  - name: "vllm-west"
+    type: "openai-compatible"
+    url: "http://vllm-west.internal:8000/v1/chat/completions"
+    api_key: "west-key"
+  - name: "work-openai"
+    type: "openai"
+    api_key: "test-key"
+router:
+  default: "vllm-east,qwen2.5-coder-32b"
+`
+
+	yamlPath := filepath.Join(tempDir, DefaultYAMLFilename)
+	err := os.WriteFile(yamlPath, []byte(yamlConfig), 0644)
+	require.NoError(t, err)
+
+	cfg, err := mgr.Load()
+	require.NoError(t, err)
+	require.Len(t, cfg.Providers, 3)
+
+	east := &cfg.Providers[0]
+	assert.Equal(t, ProviderTypeOpenAICompatible, east.GetType())
+	assert.Equal(t, "http://vllm-east.internal:8000/v1/chat/completions", east.APIBase)
+	assert.Empty(t, east.GetAPIKey(), "keyless local backends should be allowed")
+	assert.Empty(t, east.DefaultModels, "openai-compatible has no default models")
+
+	west := &cfg.Providers[1]
+	assert.Equal(t, "west-key", west.GetAPIKey())
+
+	// Built-in types still receive their defaults when used under a custom name
+	work := &cfg.Providers[2]
+	assert.Equal(t, "openai", work.GetType())
+	assert.Equal(t, DefaultProviderURLs["openai"], work.APIBase)
+	assert.Equal(t, DefaultProviderModels["openai"], work.DefaultModels)
+}
+
+func TestManager_SafetySettings(t *testing.T) {
+	tempDir := t.TempDir()
+	mgr := NewManager(tempDir)
+
+	yamlConfig := `
+providers:
+  - name: "gemini"
+    api_key: "test-key"
+    safety_settings:
+      - category: "HARM_CATEGORY_HARASSMENT"
+        threshold: "BLOCK_ONLY_HIGH"
+      - category: "HARM_CATEGORY_DANGEROUS_CONTENT"
+        threshold: "BLOCK_MEDIUM_AND_ABOVE"
+router:
+  default: "gemini,gemini-2.0-flash"
+`
+
+	yamlPath := filepath.Join(tempDir, DefaultYAMLFilename)
+	require.NoError(t, os.WriteFile(yamlPath, []byte(yamlConfig), 0644))
+
+	cfg, err := mgr.Load()
+	require.NoError(t, err)
+	require.Len(t, cfg.Providers, 1)
+
+	assert.Equal(t, []SafetySetting{
+		{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_ONLY_HIGH"},
+		{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_MEDIUM_AND_ABOVE"},
+	}, cfg.Providers[0].SafetySettings)
+}
+
+func TestManager_RouteRules(t *testing.T) {
+	tempDir := t.TempDir()
+	mgr := NewManager(tempDir)
+
+	yamlConfig := `
+providers:
+  - name: "openai"
+    api_key: "test-key"
+router:
+  default: "openai,gpt-4o"
+  rules:
+    - name: "reasoning"
+      target: "openai,o3"
+      thinking: true
+      min_tokens: 1000
+    - target: "openai,gpt-4o-mini"
+      model: "claude-3-5-haiku*"
+      has_tools: false
+      tools: ["web_*"]
+      headers:
+        X-Team: "platform-*"
+      system: "(?i)summari[sz]e"
+`
+
+	yamlPath := filepath.Join(tempDir, DefaultYAMLFilename)
+	require.NoError(t, os.WriteFile(yamlPath, []byte(yamlConfig), 0644))
+
+	cfg, err := mgr.Load()
+	require.NoError(t, err)
+
+	enabled, disabled := true, false
+
+	assert.Equal(t, []RouteRule{
+		{Name: "reasoning", Target: "openai,o3", Thinking: &enabled, MinTokens: 1000},
+		{
+			Target:   "openai,gpt-4o-mini",
+			Model:    "claude-3-5-haiku*",
+			HasTools: &disabled,
+			Tools:    []string{"web_*"},
+			Headers:  map[string]string{"X-Team": "platform-*"},
+			System:   "(?i)summari[sz]e",
+		},
+	}, cfg.Router.Rules)
+}
+
+func TestProvider_GetType(t *testing.T) {
+	assert.Equal(t, "openai", (&Provider{Name: "openai"}).GetType(), "name is used when type is empty")
+	assert.Equal(t, ProviderTypeOpenAICompatible, (&Provider{Name: "ollama", Type: ProviderTypeOpenAICompatible}).GetType())
+}
+
 func TestManager_FileDetection(t *testing.T) {
 	tempDir := t.TempDir()
 	mgr := NewManager(tempDir)
@@ -299,3 +442,10 @@ func TestManager_FileDetection(t *testing.T) {
 	assert.True(t, mgr.HasJSON())
 	assert.Equal(t, yamlPath, mgr.GetPath()) // Should return YAML path
 }
+
+func TestCompileGlob(t *testing.T) {
+	assert.True(t, CompileGlob("anthropic/*").MatchString("anthropic/claude-sonnet-4"))
+	assert.True(t, CompileGlob("gpt-4?").MatchString("gpt-4o"))
+	assert.False(t, CompileGlob("gpt-4?").MatchString("gpt-4o-mini"))
+	assert.False(t, CompileGlob("claude.3").MatchString("claude-3"), "glob characters other than * and ? are literal")
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+const (
+	// keyProbeInterval is how long a disabled API key waits between probes
+	keyProbeInterval = 5 * time.Minute
+	// keyProbeTick is how often the prober looks for disabled keys that are due
+	keyProbeTick = time.Minute
+	// keyProbeTimeout bounds a single probe request
+	keyProbeTimeout = 30 * time.Second
+)
+
+// KeyProber tries disabled API keys again in the background with a one token request, so
+// keys that were rejected by mistake or reinstated rejoin the rotation
+type KeyProber struct {
+	config   *config.Manager
+	registry *providers.Registry
+	logger   *slog.Logger
+	interval time.Duration
+}
+
+func NewKeyProber(config *config.Manager, registry *providers.Registry, logger *slog.Logger) *KeyProber {
+	return &KeyProber{
+		config:   config,
+		registry: registry,
+		logger:   logger,
+		interval: keyProbeInterval,
+	}
+}
+
+// Run probes the keys that are due until the context is done
+func (p *KeyProber) Run(ctx context.Context) {
+	ticker := time.NewTicker(keyProbeTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.probeDue(ctx)
+		}
+	}
+}
+
+// probeDue probes every disabled key that wasn't probed for the interval
+func (p *KeyProber) probeDue(ctx context.Context) {
+	cfg := p.config.Get()
+	if cfg == nil {
+		return
+	}
+
+	for i := range cfg.Providers {
+		providerConfig := &cfg.Providers[i]
+
+		for _, key := range providerConfig.DisabledKeysDue(p.interval) {
+			p.probe(ctx, providerConfig, key)
+		}
+	}
+}
+
+// probe sends a minimal request with the key to the first model of the provider and
+// reports the outcome. Any answer but 401 and 403 shows the key is accepted again.
+func (p *KeyProber) probe(ctx context.Context, providerConfig *config.Provider, key string) {
+	logger := p.logger.With("provider", providerConfig.Name, "key", config.MaskAPIKey(key))
+
+	provider, ok := p.registry.Get(providerConfig.Name)
+	if !ok {
+		return
+	}
+
+	model := probeModel(providerConfig)
+	if model == "" {
+		logger.Debug("No model to probe the API key with")
+		return
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"model":      model,
+		"max_tokens": 1,
+		"messages":   []map[string]string{{"role": "user", "content": "ping"}},
+	})
+	if err != nil {
+		return
+	}
+
+	if transformed, err := provider.TransformRequest(body); err == nil {
+		body = transformed
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, keyProbeTimeout)
+	defer cancel()
+
+	req, err := provider.NewRequest(ctx, providers.UpstreamRequest{
+		Method: http.MethodPost,
+		Model:  model,
+		Body:   body,
+		APIKey: key,
+	})
+	if err != nil {
+		logger.Warn("Failed to create API key probe", "error", err)
+		return
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Debug("API key probe failed", "error", err)
+		return
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if err := resp.Body.Close(); err != nil {
+		logger.Warn("Failed to close probe response body", "error", err)
+	}
+
+	retryAfter, _ := upstreamRetryDelay(resp, time.Now())
+
+	if state := providerConfig.ReportKeyResult(key, resp.StatusCode, retryAfter); state != config.KeyDisabled {
+		logger.Info("API key accepted again", "status", resp.StatusCode, "state", state)
+	} else {
+		logger.Debug("API key not accepted yet", "status", resp.StatusCode)
+	}
+}
+
+// probeModel returns the model probes are sent to, the first one configured for the provider
+func probeModel(providerConfig *config.Provider) string {
+	if len(providerConfig.Models) > 0 {
+		return providerConfig.Models[0]
+	}
+
+	if len(providerConfig.DefaultModels) > 0 {
+		return providerConfig.DefaultModels[0]
+	}
+
+	return ""
+}
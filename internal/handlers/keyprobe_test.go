@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyHealth_DisableAndProbe(t *testing.T) {
+	var (
+		reinstated  atomic.Bool
+		revokedUses atomic.Int32
+	)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Header.Get("x-api-key") == "revoked-key" && !reinstated.Load() {
+			revokedUses.Add(1)
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = io.WriteString(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+
+			return
+		}
+
+		_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514",`+
+			`"content":[{"type":"text","text":"Hi"}],"stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":1}}`)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{Name: "anthropic", APIBase: upstream.URL, APIKey: []any{"revoked-key", "good-key-0123"}},
+		},
+		Router: config.RouterConfig{Default: "anthropic,claude-sonnet-4-20250514"},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	cfgMgr.ApplyDefaults(cfg)
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	registry := providers.NewRegistry()
+	require.NoError(t, registry.Initialize(cfg.Providers))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := NewProxyHandler(cfgMgr, registry, logger)
+
+	var statuses []int
+
+	for range 4 {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"anthropic,claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`)))
+		statuses = append(statuses, rr.Code)
+	}
+
+	assert.Equal(t, []int{http.StatusUnauthorized, http.StatusOK, http.StatusOK, http.StatusOK}, statuses)
+	assert.Equal(t, int32(1), revokedUses.Load(), "a rejected key leaves the rotation")
+	assert.Equal(t, []config.KeyState{config.KeyDisabled, config.KeyHealthy}, keyStates(t, cfgMgr, logger))
+
+	prober := NewKeyProber(cfgMgr, registry, logger)
+	prober.interval = 0
+
+	// Probes with a key that is still revoked leave it disabled
+	prober.probeDue(context.Background())
+	assert.Equal(t, int32(2), revokedUses.Load())
+	assert.Equal(t, []config.KeyState{config.KeyDisabled, config.KeyHealthy}, keyStates(t, cfgMgr, logger))
+
+	reinstated.Store(true)
+	prober.probeDue(context.Background())
+	assert.Equal(t, []config.KeyState{config.KeyHealthy, config.KeyHealthy}, keyStates(t, cfgMgr, logger))
+}
+
+// keyStates returns the states the key status handler reports for the only provider
+func keyStates(t *testing.T, cfgMgr *config.Manager, logger *slog.Logger) []config.KeyState {
+	t.Helper()
+
+	rr := httptest.NewRecorder()
+	NewKeyStatusHandler(cfgMgr, logger).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/keys", http.NoBody))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	var statuses []ProviderKeyStatus
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 1)
+
+	states := make([]config.KeyState, 0, len(statuses[0].Keys))
+	for _, key := range statuses[0].Keys {
+		assert.NotContains(t, key.Key, "key-0", "keys are masked")
+		states = append(states, key.State)
+	}
+
+	return states
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+// ProviderKeyStatus is the health of the API keys of a provider
+type ProviderKeyStatus struct {
+	Provider string             `json:"provider"`
+	Keys     []config.KeyStatus `json:"keys"`
+}
+
+// KeyStatusHandler serves the masked health of the API keys of every provider with keys
+type KeyStatusHandler struct {
+	config *config.Manager
+	logger *slog.Logger
+}
+
+func NewKeyStatusHandler(config *config.Manager, logger *slog.Logger) *KeyStatusHandler {
+	return &KeyStatusHandler{
+		config: config,
+		logger: logger,
+	}
+}
+
+func (h *KeyStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	statuses := []ProviderKeyStatus{}
+
+	if cfg := h.config.Get(); cfg != nil {
+		for i := range cfg.Providers {
+			provider := &cfg.Providers[i]
+
+			if keys := provider.KeyHealth(); len(keys) > 0 {
+				statuses = append(statuses, ProviderKeyStatus{Provider: provider.Name, Keys: keys})
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		h.logger.Error("Failed to write key status", "error", err)
+	}
+}
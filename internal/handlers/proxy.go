@@ -1,27 +1,42 @@
 package handlers
 
 import (
-	"bufio"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
-	
+	"slices"
 	"strings"
+	"sync/atomic"
 
 	"github.com/andybalholm/brotli"
 	"github.com/pkoukk/tiktoken-go"
 
 	"github.com/Davincible/claude-code-open/internal/config"
 	"github.com/Davincible/claude-code-open/internal/providers"
+	"github.com/Davincible/claude-code-open/internal/router"
 )
 
+// passthroughBufferSize is the read size of streams that are copied without transformation
+const passthroughBufferSize = 32 * 1024
+
 type ProxyHandler struct {
 	config   *config.Manager
 	registry *providers.Registry
 	logger   *slog.Logger
+
+	// routes caches the router compiled from the current router config
+	routes atomic.Pointer[compiledRoutes]
+}
+
+// compiledRoutes is a router and the config it was compiled from
+type compiledRoutes struct {
+	config *config.RouterConfig
+	router *router.Router
 }
 
 func NewProxyHandler(config *config.Manager, registry *providers.Registry, logger *slog.Logger) *ProxyHandler {
@@ -43,24 +58,98 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Count input tokens
-	inputTokens := h.countInputTokens(string(body))
+	inputTokens := h.countTokens(string(body))
 
 	// Select model and transform request body
-	transformedBody, modelName := h.selectModel(body, inputTokens, &cfg.Router)
+	transformedBody, modelName := h.selectModel(body, inputTokens, &cfg.Router, r.Header)
+
+	stream := h.isStreamingRequest(transformedBody)
+
+	// A request that can't be built for the selected model is answered right away, the
+	// fallbacks only stand in for failing upstreams
+	call, err := h.prepareUpstream(r, modelName, transformedBody, stream, cfg)
+	if err != nil {
+		h.writePrepareError(w, err)
+		return
+	}
 
-	// Find provider for the model
-	provider, providerConfig, err := h.findProvider(modelName, cfg)
+	resp, call, attempts, err := h.sendWithFallback(r, call, inputTokens, cfg)
 	if err != nil {
-		h.httpError(w, http.StatusBadRequest, "provider not found: %v", err)
+		h.writeUpstreamFailure(w, err, attempts)
 		return
 	}
 
+	provider, model := call.provider, upstreamModel(call.target)
+
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			h.logger.Warn("Failed to close response body", "error", err)
+		}
+	}()
+
+	// Handle response based on streaming
+	switch {
+	case provider.IsStreaming(resp.Header):
+		h.handleStreamingResponse(w, resp, provider, model, inputTokens)
+	case stream && resp.StatusCode == http.StatusOK:
+		// The client expects events even when the upstream couldn't stream
+		h.handleSynthesizedStream(w, resp, provider, model, inputTokens)
+	default:
+		h.handleResponse(w, resp, provider, model, inputTokens, attempts)
+	}
+}
+
+// upstreamCall is the upstream request built for one target of a fallback chain
+type upstreamCall struct {
+	target string
+	// body is the Anthropic request for the target's model
+	body   []byte
+	stream bool
+
+	provider       providers.Provider
+	providerConfig *config.Provider
+	policy         config.RetryPolicy
+	request        *http.Request
+	// apiKey is the key the request authenticates with, empty for keyless providers
+	apiKey string
+}
+
+// statusError is a failure answered with an HTTP status other than 500
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+// prepareUpstream finds the provider of a target and builds its upstream request from the
+// Anthropic request body
+func (h *ProxyHandler) prepareUpstream(
+	r *http.Request, target string, body []byte, stream bool, cfg *config.Config,
+) (*upstreamCall, error) {
+	provider, providerConfig, err := h.findProvider(target, cfg)
+	if err != nil {
+		return nil, &statusError{status: http.StatusBadRequest, err: fmt.Errorf("provider not found: %w", err)}
+	}
+
+	// Reject images for models configured as text only before anything is sent upstream
+	if requestErr := providers.CheckImageSupport(body, providerConfig); requestErr != nil {
+		return nil, requestErr
+	}
+
 	// Transform from Anthropic format to provider format
-	finalBody, err := provider.TransformRequest(transformedBody)
+	finalBody, err := provider.TransformRequest(body)
+
+	var requestErr *providers.RequestError
+	if errors.As(err, &requestErr) {
+		return nil, requestErr
+	}
+
 	if err != nil {
 		h.logger.Warn("Request transformation failed, using original", "error", err)
 
-		finalBody = transformedBody
+		finalBody = body
 	}
 
 	// Debug: Log request being sent to provider (truncated for readability)
@@ -70,52 +159,176 @@ func (h *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.logger.Debug("Sending request to provider", "provider", provider.Name(), "body", string(finalBody))
 	}
 
-	// Build final endpoint URL (handle special cases like Gemini)
-	finalURL := h.buildEndpointURL(provider, providerConfig.APIBase, modelName)
+	// The key is picked here so its outcome can be reported, the provider picks the URL,
+	// authentication scheme and headers of the upstream request
+	apiKey := provider.GetAPIKey()
 
-	// Create upstream request
-	req, err := http.NewRequest(r.Method, finalURL, strings.NewReader(string(finalBody)))
+	req, err := provider.NewRequest(r.Context(), providers.UpstreamRequest{
+		Method: r.Method,
+		Model:  upstreamModel(target),
+		Stream: stream,
+		Body:   finalBody,
+		Header: r.Header,
+		APIKey: apiKey,
+	})
 	if err != nil {
-		h.httpError(w, http.StatusInternalServerError, "failed to create upstream request: %v", err)
+		return nil, fmt.Errorf("failed to create upstream request: %w", err)
+	}
+
+	return &upstreamCall{
+		target:         target,
+		body:           body,
+		stream:         stream,
+		provider:       provider,
+		providerConfig: providerConfig,
+		policy:         providerConfig.GetRetryPolicy(),
+		request:        req,
+		apiKey:         apiKey,
+	}, nil
+}
+
+// writePrepareError answers a request whose upstream request couldn't be built
+func (h *ProxyHandler) writePrepareError(w http.ResponseWriter, err error) {
+	var requestErr *providers.RequestError
+	if errors.As(err, &requestErr) {
+		h.writeRequestError(w, requestErr)
 		return
 	}
 
-	// Copy headers and set auth
-	req.Header = r.Header.Clone()
-	apiKey := providerConfig.GetAPIKey()
-	if apiKey != "" {
-		h.setAuthHeader(req, provider, apiKey)
+	status := http.StatusInternalServerError
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.status
 	}
 
-	h.logger.Info("Proxying request",
-		"provider", provider.Name(),
-		"model", modelName,
-		"url", finalURL,
-		"input_tokens", inputTokens,
+	h.httpError(w, status, "%v", err)
+}
+
+// writeUpstreamFailure answers a request no upstream responded to with an Anthropic error
+func (h *ProxyHandler) writeUpstreamFailure(w http.ResponseWriter, err error, attempts int) {
+	message := fmt.Sprintf("upstream request failed: %v", err)
+	if attempts > 1 {
+		message = fmt.Sprintf("upstream request failed after %d attempts: %v", attempts, err)
+	}
+
+	h.logger.Error("Upstream request failed", "attempts", attempts, "error", err)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadGateway)
+
+	if _, err := w.Write(providers.FormatAnthropicError("api_error", message)); err != nil {
+		h.logger.Error("Failed to write error response", "error", err)
+	}
+}
+
+// sendWithFallback sends the request to its target and, once the retries of the upstream
+// are used up, to the next target of the fallback chain. The request is rebuilt for each
+// fallback, which may use another provider. Nothing has been written to the client at that
+// point, so the switch is transparent. The response of the last target tried is returned
+// whatever its status, along with its call and the number of attempts made across all targets.
+func (h *ProxyHandler) sendWithFallback(
+	r *http.Request, call *upstreamCall, inputTokens int, cfg *config.Config,
+) (*http.Response, *upstreamCall, int, error) {
+	var (
+		body      = call.body
+		fallbacks = fallbackChain(call.target, &cfg.Router)[1:]
+		attempts  int
 	)
 
-	// Make upstream request
-	resp, err := http.DefaultClient.Do(req)
+	for {
+		resp, targetAttempts, err := h.sendWithRetry(r, call, inputTokens, cfg)
+		attempts += targetAttempts
+
+		reason := fallbackReason(r.Context(), resp, err)
+		if reason == "" {
+			return resp, call, attempts, err
+		}
+
+		var next *upstreamCall
+
+		for next == nil && len(fallbacks) > 0 {
+			target := fallbacks[0]
+			fallbacks = fallbacks[1:]
+
+			var prepareErr error
+
+			next, prepareErr = h.prepareFallback(r, target, body, call.stream, cfg)
+			if prepareErr != nil {
+				h.logger.Warn("Skipping fallback", "target", target, "error", prepareErr)
+			}
+		}
+
+		if next == nil {
+			return resp, call, attempts, err
+		}
+
+		h.logger.Warn("Upstream failed, falling back", "target", call.target, "reason", reason, "fallback", next.target)
+
+		if resp != nil {
+			if err := resp.Body.Close(); err != nil {
+				h.logger.Warn("Failed to close response body", "error", err)
+			}
+		}
+
+		call = next
+	}
+}
+
+// prepareFallback builds the upstream request of a fallback target, which is sent the
+// target's model in place of the one selected for the request
+func (h *ProxyHandler) prepareFallback(
+	r *http.Request, target string, body []byte, stream bool, cfg *config.Config,
+) (*upstreamCall, error) {
+	var request providers.MessagesRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, fmt.Errorf("parse request: %w", err)
+	}
+
+	request.Model = upstreamModel(target)
+
+	targetBody, err := json.Marshal(request)
 	if err != nil {
-		h.httpError(w, http.StatusBadGateway, "upstream request failed: %v", err)
-		return
+		return nil, fmt.Errorf("encode request: %w", err)
 	}
 
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			h.logger.Warn("Failed to close response body", "error", err)
+	return h.prepareUpstream(r, target, targetBody, stream, cfg)
+}
+
+// fallbackChain returns the target followed by its configured fallbacks, each target once
+func fallbackChain(target string, routerConfig *config.RouterConfig) []string {
+	chain := []string{target}
+
+	for _, fallback := range routerConfig.GetFallbacks(target) {
+		if !slices.Contains(chain, fallback) {
+			chain = append(chain, fallback)
 		}
-	}()
+	}
 
-	// Handle response based on streaming
-	if provider.IsStreaming(resp.Header) {
-		h.handleStreamingResponse(w, resp, provider, inputTokens)
-	} else {
-		h.handleResponse(w, resp, provider, inputTokens)
+	return chain
+}
+
+// fallbackReason tells why an upstream failed in a way the next target may not: a rate
+// limit, a server error, or a connection error or timeout. It is empty for responses that
+// are relayed to the client, and when the client itself went away.
+func fallbackReason(ctx context.Context, resp *http.Response, err error) string {
+	switch {
+	case err != nil:
+		if ctx.Err() != nil {
+			return ""
+		}
+
+		return err.Error()
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= http.StatusInternalServerError:
+		return resp.Status
 	}
+
+	return ""
 }
 
-func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http.Response, provider providers.Provider, inputTokens int) {
+func (h *ProxyHandler) handleStreamingResponse(
+	w http.ResponseWriter, resp *http.Response, provider providers.Provider, model string, inputTokens int,
+) {
 	// Handle decompression
 	bodyReader, err := h.decompressReader(resp)
 	if err != nil {
@@ -131,14 +344,16 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 		}()
 	}
 
-	// Set streaming headers
+	// Copy relevant headers
+	h.copyHeaders(w, resp)
+
+	// Set streaming headers after the upstream ones, binary stream framing is re-encoded as
+	// server-sent events
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	// Copy relevant headers
-	h.copyHeaders(w, resp)
 	w.WriteHeader(resp.StatusCode)
 
 	// For error responses, capture and print the body
@@ -146,36 +361,62 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 
 	captureError := resp.StatusCode != http.StatusOK
 
-	// Create scanner and state
-	scanner := bufio.NewScanner(bodyReader)
+	// Providers with binary stream framing supply their own decoder
+	if decoderProvider, ok := provider.(providers.StreamDecoderProvider); ok && !captureError {
+		h.streamDecodedMessages(w, decoderProvider.NewStreamDecoder(bodyReader), provider, model, inputTokens)
+
+		h.logger.Info("Completed streaming response",
+			"status", resp.StatusCode,
+			"input_tokens", inputTokens,
+		)
+
+		return
+	}
+
+	// Streams already in the client's format are copied as they arrive
+	if passthrough, ok := provider.(providers.StreamPassthrough); ok && passthrough.PassthroughStream() && !captureError {
+		h.passthroughStream(w, bodyReader)
+
+		h.logger.Info("Completed streaming response",
+			"status", resp.StatusCode,
+			"input_tokens", inputTokens,
+		)
+
+		return
+	}
+
+	// Everything else is server-sent events, transformed one event at a time
+	decoder := providers.NewSSEDecoder(bodyReader)
 	state := &providers.StreamState{}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	for {
+		event, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 
-		// Capture error response body
-		if captureError && line != "" {
-			errorBodyLines = append(errorBodyLines, line)
+		if err != nil {
+			h.logger.Error("Stream decoding error", "error", err)
+			break
 		}
 
-		// Skip empty lines and comments
-		if line == "" {
-			if _, err := fmt.Fprint(w, "\n"); err != nil {
-				h.logger.Error("Failed to write newline", "error", err)
+		// For error responses, capture the data and forward the event as-is
+		if captureError {
+			errorBodyLines = append(errorBodyLines, string(event.Data))
+
+			if !h.writeSSEEvent(w, event) {
 				return
 			}
 
-			h.flushResponse(w)
-
 			continue
 		}
 
-		if strings.HasPrefix(line, ": ") {
-			continue // Skip SSE comments
-		}
-
 		// Handle [DONE] message
-		if line == "data: [DONE]" {
+		if string(event.Data) == "[DONE]" {
+			if !h.finishStream(w, state, inputTokens) {
+				return
+			}
+
 			if _, err := fmt.Fprint(w, "data: [DONE]\n\n"); err != nil {
 				h.logger.Error("Failed to write DONE message", "error", err)
 				return
@@ -186,41 +427,22 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 			break
 		}
 
-		// Process data lines
-		if strings.HasPrefix(line, "data: ") {
-			// For error responses, forward data as-is without transformation
-			if captureError {
-				if _, err := fmt.Fprintf(w, "%s\n\n", line); err != nil {
-					h.logger.Error("Failed to write error response", "error", err)
-					return
-				}
-			} else {
-				jsonData := strings.TrimPrefix(line, "data: ")
-
-				// Transform chunk through provider for successful responses
-				events, err := provider.TransformStream([]byte(jsonData), state)
-				if err != nil {
-					h.logger.Error("Stream transformation error", "error", err)
-					// Send original chunk on error
-					if _, err := fmt.Fprintf(w, "%s\n\n", line); err != nil {
-						h.logger.Error("Failed to write original chunk on transformation error", "error", err)
-						return
-					}
-				} else {
-					if len(events) > 0 {
-						if _, err := w.Write(events); err != nil {
-							h.logger.Error("Failed to write events", "error", err)
-							return
-						}
-					}
-				}
+		// Transform event through provider for successful responses
+		events, err := provider.TransformStream(event.Data, state)
+		if err != nil {
+			h.logger.Error("Stream transformation error", "error", err)
+
+			// Send original event on error
+			if !h.writeSSEEvent(w, event) {
+				return
 			}
 
-			h.flushResponse(w)
-		} else {
-			// Pass through other SSE lines
-			if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
-				h.logger.Error("Failed to write SSE line", "error", err)
+			continue
+		}
+
+		if len(events) > 0 {
+			if _, err := w.Write(events); err != nil {
+				h.logger.Error("Failed to write events", "error", err)
 				return
 			}
 
@@ -228,8 +450,8 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		h.logger.Error("Stream scanning error", "error", err)
+	if !captureError {
+		h.finishStream(w, state, inputTokens)
 	}
 
 	// Print captured error response body
@@ -243,14 +465,157 @@ func (h *ProxyHandler) handleStreamingResponse(w http.ResponseWriter, resp *http
 	)
 }
 
-func (h *ProxyHandler) handleResponse(w http.ResponseWriter, resp *http.Response, provider providers.Provider, inputTokens int) {
-	// Handle decompression
-	bodyReader, err := h.decompressReader(resp)
+// writeSSEEvent forwards an upstream event to the client untransformed. It reports false
+// when the client can't be written to.
+func (h *ProxyHandler) writeSSEEvent(w http.ResponseWriter, event *providers.SSEEvent) bool {
+	var buf strings.Builder
+
+	if event.Event != "" {
+		buf.WriteString("event: " + event.Event + "\n")
+	}
+
+	for _, line := range strings.Split(string(event.Data), "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+
+	buf.WriteString("\n")
+
+	if _, err := io.WriteString(w, buf.String()); err != nil {
+		h.logger.Error("Failed to write event", "error", err)
+		return false
+	}
+
+	h.flushResponse(w)
+
+	return true
+}
+
+// finishStream sends the events a stream is still waiting on when the upstream ended it
+// without reporting usage, with the usage estimated locally. It reports false when the
+// client can't be written to.
+func (h *ProxyHandler) finishStream(w http.ResponseWriter, state *providers.StreamState, inputTokens int) bool {
+	events := providers.FinishStream(state, inputTokens, h.countTokens)
+	if len(events) == 0 {
+		return true
+	}
+
+	h.logger.Debug("Upstream stream reported no usage, sending an estimate", "input_tokens", inputTokens)
+
+	if _, err := w.Write(events); err != nil {
+		h.logger.Error("Failed to write events", "error", err)
+		return false
+	}
+
+	h.flushResponse(w)
+
+	return true
+}
+
+// passthroughStream copies a stream to the client unchanged, flushing after every read so
+// events aren't held back
+func (h *ProxyHandler) passthroughStream(w http.ResponseWriter, body io.Reader) {
+	buf := make([]byte, passthroughBufferSize)
+
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				h.logger.Error("Failed to write stream", "error", writeErr)
+				return
+			}
+
+			h.flushResponse(w)
+		}
+
+		if errors.Is(err, io.EOF) {
+			return
+		}
+
+		if err != nil {
+			h.logger.Error("Stream read error", "error", err)
+			return
+		}
+	}
+}
+
+// streamDecodedMessages transforms and forwards every message produced by a stream decoder.
+// The model the request was sent to names the message unless the upstream reports one.
+func (h *ProxyHandler) streamDecodedMessages(
+	w http.ResponseWriter, decoder providers.StreamDecoder, provider providers.Provider, model string, inputTokens int,
+) {
+	state := &providers.StreamState{Model: model}
+
+	for {
+		message, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			h.finishStream(w, state, inputTokens)
+			return
+		}
+
+		if err != nil {
+			h.logger.Error("Stream decoding error", "error", err)
+			return
+		}
+
+		events, err := provider.TransformStream(message, state)
+		if err != nil {
+			h.logger.Error("Stream transformation error", "error", err)
+			continue
+		}
+
+		if len(events) > 0 {
+			if _, err := w.Write(events); err != nil {
+				h.logger.Error("Failed to write events", "error", err)
+				return
+			}
+
+			h.flushResponse(w)
+		}
+	}
+}
+
+// handleSynthesizedStream answers a streaming request whose upstream returned a single
+// response by replaying the converted message as server-sent events
+func (h *ProxyHandler) handleSynthesizedStream(
+	w http.ResponseWriter, resp *http.Response, provider providers.Provider, model string, inputTokens int,
+) {
+	respBody, err := h.readResponseBody(resp)
 	if err != nil {
-		h.httpError(w, http.StatusBadGateway, "decompression error: %v", err)
+		h.httpError(w, http.StatusBadGateway, "%v", err)
+		return
+	}
+
+	message, err := transformResponse(provider, model, respBody)
+	if err != nil {
+		h.httpError(w, http.StatusBadGateway, "failed to transform upstream response: %v", err)
+		return
+	}
+
+	events, err := providers.MessageToSSE(message)
+	if err != nil {
+		h.httpError(w, http.StatusBadGateway, "failed to convert upstream response to events: %v", err)
 		return
 	}
 
+	h.copyHeaders(w, resp)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+
+	if _, err := w.Write(events); err != nil {
+		h.logger.Error("Failed to write events", "error", err)
+	}
+
+	h.logResponseTokens(message, resp.StatusCode, inputTokens)
+}
+
+// readResponseBody reads the full, decompressed upstream response body
+func (h *ProxyHandler) readResponseBody(resp *http.Response) ([]byte, error) {
+	bodyReader, err := h.decompressReader(resp)
+	if err != nil {
+		return nil, fmt.Errorf("decompression error: %w", err)
+	}
+
 	if closer, ok := bodyReader.(io.Closer); ok {
 		defer func() {
 			if closeErr := closer.Close(); closeErr != nil {
@@ -259,10 +624,23 @@ func (h *ProxyHandler) handleResponse(w http.ResponseWriter, resp *http.Response
 		}()
 	}
 
-	// Read full response
 	respBody, err := io.ReadAll(bodyReader)
 	if err != nil {
-		h.httpError(w, http.StatusBadGateway, "failed to read upstream response: %v", err)
+		return nil, fmt.Errorf("failed to read upstream response: %w", err)
+	}
+
+	return respBody, nil
+}
+
+// handleResponse relays a complete upstream response. Error responses of a request that was
+// retried say how many attempts were made.
+func (h *ProxyHandler) handleResponse(
+	w http.ResponseWriter, resp *http.Response, provider providers.Provider, model string, inputTokens, attempts int,
+) {
+	// Read full response
+	respBody, err := h.readResponseBody(resp)
+	if err != nil {
+		h.httpError(w, http.StatusBadGateway, "%v", err)
 		return
 	}
 
@@ -272,9 +650,22 @@ func (h *ProxyHandler) handleResponse(w http.ResponseWriter, resp *http.Response
 	if resp.StatusCode != http.StatusOK {
 		fmt.Printf("\nUpstream error response body:\n%s\n", string(respBody))
 		finalBody = respBody
+
+		// Some providers return errors in their own format, convert those when possible
+		if errorTransformer, ok := provider.(providers.ErrorTransformer); ok {
+			if transformedBody, err := errorTransformer.TransformError(resp.StatusCode, respBody); err != nil {
+				h.logger.Warn("Error response transformation failed, using original", "error", err)
+			} else {
+				finalBody = transformedBody
+			}
+		}
+
+		if attempts > 1 {
+			finalBody = withAttempts(finalBody, resp.StatusCode, attempts)
+		}
 	} else {
 		// Transform successful responses
-		transformedBody, err := provider.TransformResponse(respBody)
+		transformedBody, err := transformResponse(provider, model, respBody)
 		if err != nil {
 			h.logger.Warn("Response transformation failed, using original", "error", err)
 
@@ -296,6 +687,16 @@ func (h *ProxyHandler) handleResponse(w http.ResponseWriter, resp *http.Response
 	h.logResponseTokens(finalBody, resp.StatusCode, inputTokens)
 }
 
+// transformResponse converts a successful upstream response to the Anthropic format. Providers
+// whose responses don't name the model are given the one the request was sent to.
+func transformResponse(provider providers.Provider, model string, body []byte) ([]byte, error) {
+	if transformer, ok := provider.(providers.ModelResponseTransformer); ok {
+		return transformer.TransformModelResponse(model, body)
+	}
+
+	return provider.TransformResponse(body)
+}
+
 func (h *ProxyHandler) findProvider(modelName string, cfg *config.Config) (providers.Provider, *config.Provider, error) {
 	parts := strings.SplitN(modelName, ",", 2)
 	var providerName, actualModelName string
@@ -309,7 +710,8 @@ func (h *ProxyHandler) findProvider(modelName string, cfg *config.Config) (provi
 
 	// If provider name is not explicit, search for the model in all providers
 	if providerName == "" {
-		for _, p := range cfg.Providers {
+		for i := range cfg.Providers {
+			p := &cfg.Providers[i]
 			// Check both DefaultModels and Models lists
 			allModels := append(p.DefaultModels, p.Models...)
 			for _, m := range allModels {
@@ -343,8 +745,8 @@ func (h *ProxyHandler) findProvider(modelName string, cfg *config.Config) (provi
 
 	// Now that we have a providerName, find its config
 	var providerConfig *config.Provider
-	for i, p := range cfg.Providers {
-		if p.Name == providerName {
+	for i := range cfg.Providers {
+		if cfg.Providers[i].Name == providerName {
 			providerConfig = &cfg.Providers[i]
 			break
 		}
@@ -370,8 +772,8 @@ func (h *ProxyHandler) findProvider(modelName string, cfg *config.Config) (provi
 	return provider, providerConfig, nil
 }
 
-func (h *ProxyHandler) selectModel(inputBody []byte, tokens int, routerConfig *config.RouterConfig) ([]byte, string) {
-	var modelBody map[string]any
+func (h *ProxyHandler) selectModel(inputBody []byte, tokens int, routerConfig *config.RouterConfig, header http.Header) ([]byte, string) {
+	var modelBody providers.MessagesRequest
 	if err := json.Unmarshal(inputBody, &modelBody); err != nil {
 		h.logger.Error("Failed to unmarshal request body for model selection", "error", err)
 		return inputBody, routerConfig.Default
@@ -381,23 +783,13 @@ func (h *ProxyHandler) selectModel(inputBody []byte, tokens int, routerConfig *c
 	var selectedModel string
 
 	// Check if user provided explicit model in request
-	if model, ok := modelBody["model"].(string); ok && len(model) > 0 {
+	if model := modelBody.Model; len(model) > 0 {
 		// If model contains comma (provider,model format), use it directly
 		if strings.Contains(model, ",") {
 			selectedModel = model
 		} else {
-			// Apply automatic routing logic for non-explicit provider requests
-			if tokens > 60000 && routerConfig.LongContext != "" {
-				selectedModel = routerConfig.LongContext
-			} else if strings.HasPrefix(model, "claude-3-5-haiku") && routerConfig.Background != "" {
-				selectedModel = routerConfig.Background
-			} else if routerConfig.Think != "" {
-				selectedModel = routerConfig.Think
-			} else if routerConfig.WebSearch != "" {
-				selectedModel = routerConfig.WebSearch
-			} else {
-				selectedModel = model
-			}
+			// Apply the routing rules for non-explicit provider requests
+			selectedModel = h.route(&modelBody, tokens, routerConfig, header)
 		}
 	} else {
 		// No model specified, use default
@@ -414,7 +806,7 @@ func (h *ProxyHandler) selectModel(inputBody []byte, tokens int, routerConfig *c
 
 	// Handle :online suffix for web search (preserve it for OpenRouter)
 	// OpenRouter expects model:online format, so we keep it as-is
-	modelBody["model"] = finalModel
+	modelBody.Model = finalModel
 
 	updatedBody, err := json.Marshal(modelBody)
 	if err != nil {
@@ -425,7 +817,58 @@ func (h *ProxyHandler) selectModel(inputBody []byte, tokens int, routerConfig *c
 	return updatedBody, selectedModel
 }
 
-func (h *ProxyHandler) countInputTokens(text string) int {
+// route picks the model of a request without an explicit provider from the routing rules,
+// keeping the requested model when no rule matches
+func (h *ProxyHandler) route(request *providers.MessagesRequest, tokens int, routerConfig *config.RouterConfig, header http.Header) string {
+	toolNames := make([]string, 0, len(request.Tools))
+	for _, tool := range request.Tools {
+		toolNames = append(toolNames, tool.Name)
+	}
+
+	target, rule, ok := h.routerFor(routerConfig).Route(&router.Request{
+		Model:    request.Model,
+		Tokens:   tokens,
+		Tools:    toolNames,
+		Thinking: request.ThinkingEnabled(),
+		Stream:   request.Stream,
+		System:   request.SystemText(),
+		Header:   header,
+	})
+	if !ok {
+		return request.Model
+	}
+
+	h.logger.Debug("Routing rule matched", "rule", rule, "model", request.Model, "target", target)
+
+	return target
+}
+
+// routerFor returns the router compiled from a router config. Loaded configs are replaced,
+// never modified, so it is compiled once per config. When the configured rules
+// don't compile, only the built-in routes are used.
+func (h *ProxyHandler) routerFor(routerConfig *config.RouterConfig) *router.Router {
+	if cached := h.routes.Load(); cached != nil && cached.config == routerConfig {
+		return cached.router
+	}
+
+	compiled, err := router.New(routerConfig)
+	if err != nil {
+		h.logger.Error("Invalid routing rules, using the built-in routes only", "error", err)
+
+		builtin := *routerConfig
+		builtin.Rules = nil
+
+		if compiled, err = router.New(&builtin); err != nil {
+			compiled = &router.Router{}
+		}
+	}
+
+	h.routes.Store(&compiledRoutes{config: routerConfig, router: compiled})
+
+	return compiled
+}
+
+func (h *ProxyHandler) countTokens(text string) int {
 	tke, err := tiktoken.GetEncoding("cl100k_base")
 	if err != nil {
 		h.logger.Error("Failed to get tiktoken encoding", "error", err)
@@ -480,45 +923,39 @@ func (h *ProxyHandler) httpError(w http.ResponseWriter, code int, format string,
 	http.Error(w, msg, code)
 }
 
-// buildEndpointURL constructs the final endpoint URL for the provider
-func (h *ProxyHandler) buildEndpointURL(provider providers.Provider, baseURL, modelName string) string {
-	// Handle Gemini's special URL requirement
-	if provider.Name() == "gemini" {
-		// Extract actual model name from modelName (remove provider prefix if present)
-		actualModel := modelName
-		if parts := strings.SplitN(modelName, ",", 2); len(parts) > 1 {
-			actualModel = parts[1]
-		}
+// writeRequestError answers a request the provider can't serve with an Anthropic error
+func (h *ProxyHandler) writeRequestError(w http.ResponseWriter, requestErr *providers.RequestError) {
+	h.logger.Warn("Rejected request", "code", requestErr.StatusCode, "message", requestErr.Message)
 
-		// Gemini requires the model in the URL path
-		// Format: https://generativelanguage.googleapis.com/v1beta/models/{model}:generateContent
-		if strings.HasSuffix(baseURL, "/models") {
-			return fmt.Sprintf("%s/%s:generateContent", baseURL, actualModel)
-		} else if strings.Contains(baseURL, "/models/") {
-			// Base URL already has a model specified, replace it
-			baseIndex := strings.LastIndex(baseURL, "/models/")
-			basePart := baseURL[:baseIndex+8] // Keep "/models/"
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(requestErr.StatusCode)
 
-			return fmt.Sprintf("%s%s:generateContent", basePart, actualModel)
-		}
-		// Fallback to appending the model
-		return fmt.Sprintf("%s/%s:generateContent", strings.TrimSuffix(baseURL, "/"), actualModel)
+	if _, err := w.Write(providers.FormatAnthropicError(requestErr.Type, requestErr.Message)); err != nil {
+		h.logger.Error("Failed to write error response", "error", err)
 	}
+}
 
-	// For all other providers, use the base URL as-is
-	return baseURL
+// isStreamingRequest reports whether the client asked for a streaming response
+func (h *ProxyHandler) isStreamingRequest(body []byte) bool {
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+
+	if err := json.Unmarshal(body, &request); err != nil {
+		return false
+	}
+
+	return request.Stream
 }
 
-// setAuthHeader sets the appropriate authentication header for the provider
-func (h *ProxyHandler) setAuthHeader(req *http.Request, provider providers.Provider, apiKey string) {
-	switch provider.Name() {
-	case "gemini":
-		// Gemini uses x-goog-api-key header
-		req.Header.Set("x-goog-api-key", apiKey)
-	default:
-		// All other providers use Bearer token
-		req.Header.Set("Authorization", "Bearer "+apiKey)
+// upstreamModel strips the provider prefix from a routed model name, e.g. "openai,gpt-4o"
+// becomes "gpt-4o"
+func upstreamModel(modelName string) string {
+	if _, model, found := strings.Cut(modelName, ","); found {
+		return model
 	}
+
+	return modelName
 }
 
 func (h *ProxyHandler) logResponseTokens(respBody []byte, statusCode int, inputTokens int) {
@@ -528,13 +965,9 @@ func (h *ProxyHandler) logResponseTokens(respBody []byte, statusCode int, inputT
 	}
 
 	// Try to extract output tokens from response
-	var response map[string]any
-	if err := json.Unmarshal(respBody, &response); err == nil {
-		if usage, ok := response["usage"].(map[string]any); ok {
-			if outputTokens, ok := usage["output_tokens"]; ok {
-				logFields = append(logFields, "output_tokens", outputTokens)
-			}
-		}
+	var response providers.MessagesResponse
+	if err := json.Unmarshal(respBody, &response); err == nil && response.Usage != nil {
+		logFields = append(logFields, "output_tokens", response.Usage.OutputTokens)
 	}
 
 	if statusCode != http.StatusOK {
@@ -1,12 +1,20 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -71,6 +79,8 @@ func TestRemoveFieldsRecursively(t *testing.T) {
 }
 
 func TestSelectModel_DynamicProviderSelection(t *testing.T) {
+	t.Setenv("ANTHROPIC_SMALL_FAST_MODEL", "")
+
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
 	handler := &ProxyHandler{logger: logger}
 
@@ -86,6 +96,8 @@ func TestSelectModel_DynamicProviderSelection(t *testing.T) {
 		name          string
 		inputModel    string
 		tokens        int
+		tools         []any
+		thinking      bool
 		expectedModel string
 		expectedBody  string
 		description   string
@@ -122,13 +134,39 @@ func TestSelectModel_DynamicProviderSelection(t *testing.T) {
 			expectedBody:  "claude-3-5-haiku",
 			description:   "should use background routing for haiku model",
 		},
+		{
+			name:          "automatic routing for haiku snapshot",
+			inputModel:    "claude-3-5-haiku-20241022",
+			tokens:        1000,
+			expectedModel: "background,claude-3-5-haiku",
+			expectedBody:  "claude-3-5-haiku",
+			description:   "should use background routing for any snapshot of the small fast model",
+		},
 		{
 			name:          "passthrough for simple model",
 			inputModel:    "claude-3-5-sonnet",
 			tokens:        1000,
+			expectedModel: "claude-3-5-sonnet",
+			expectedBody:  "claude-3-5-sonnet",
+			description:   "should keep the model when no route applies",
+		},
+		{
+			name:          "automatic routing for thinking",
+			inputModel:    "claude-3-5-sonnet",
+			tokens:        1000,
+			thinking:      true,
 			expectedModel: "think,claude-3-5-sonnet",
 			expectedBody:  "claude-3-5-sonnet",
-			description:   "should use think routing when no other rules apply",
+			description:   "should use think routing when extended thinking is enabled",
+		},
+		{
+			name:          "automatic routing for web search",
+			inputModel:    "claude-3-5-sonnet",
+			tokens:        1000,
+			tools:         []any{map[string]any{"type": "web_search_20250305", "name": "web_search"}},
+			expectedModel: "websearch,claude-3-5-sonnet:online",
+			expectedBody:  "claude-3-5-sonnet:online",
+			description:   "should use web search routing when the request offers the web search tool",
 		},
 		{
 			name:          "online suffix preservation",
@@ -149,11 +187,19 @@ func TestSelectModel_DynamicProviderSelection(t *testing.T) {
 				"max_tokens": 100,
 			}
 
+			if tc.tools != nil {
+				requestBody["tools"] = tc.tools
+			}
+
+			if tc.thinking {
+				requestBody["thinking"] = map[string]any{"type": "enabled", "budget_tokens": 2048}
+			}
+
 			inputBody, err := json.Marshal(requestBody)
 			require.NoError(t, err)
 
 			// Call selectModel
-			resultBody, selectedModel := handler.selectModel(inputBody, tc.tokens, routerConfig)
+			resultBody, selectedModel := handler.selectModel(inputBody, tc.tokens, routerConfig, nil)
 
 			// Verify selected model
 			assert.Equal(t, tc.expectedModel, selectedModel, tc.description)
@@ -186,7 +232,7 @@ func TestSelectModel_NoModelProvided(t *testing.T) {
 	require.NoError(t, err)
 
 	// Call selectModel
-	resultBody, selectedModel := handler.selectModel(inputBody, 1000, routerConfig)
+	resultBody, selectedModel := handler.selectModel(inputBody, 1000, routerConfig, nil)
 
 	// Should use default
 	assert.Equal(t, "default,claude-3-5-sonnet", selectedModel)
@@ -199,6 +245,40 @@ func TestSelectModel_NoModelProvided(t *testing.T) {
 	assert.Equal(t, "claude-3-5-sonnet", parsedResult["model"])
 }
 
+func TestSelectModel_Rules(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := &ProxyHandler{logger: logger}
+
+	enabled := true
+	routerConfig := &config.RouterConfig{
+		Default: "default,claude-3-5-sonnet",
+		Think:   "think,claude-3-5-sonnet",
+		Rules: []config.RouteRule{
+			{Name: "team", Target: "azure,gpt-4o-prod", Headers: map[string]string{"X-Team": "platform"}},
+			{Name: "reasoning", Target: "openai,o3", Thinking: &enabled},
+		},
+	}
+
+	inputBody := []byte(`{"model":"claude-sonnet-4","max_tokens":100,"messages":[],"thinking":{"type":"enabled","budget_tokens":2048}}`)
+
+	resultBody, selectedModel := handler.selectModel(inputBody, 1000, routerConfig, nil)
+	assert.Equal(t, "openai,o3", selectedModel)
+	assert.JSONEq(t, `{"model":"o3","max_tokens":100,"messages":[],"thinking":{"type":"enabled","budget_tokens":2048}}`, string(resultBody))
+
+	header := http.Header{}
+	header.Set("X-Team", "platform")
+
+	_, selectedModel = handler.selectModel(inputBody, 1000, routerConfig, header)
+	assert.Equal(t, "azure,gpt-4o-prod", selectedModel, "rules are evaluated in order")
+
+	// Configs are replaced rather than modified, the compiled router is cached per config
+	withoutReasoning := *routerConfig
+	withoutReasoning.Rules = routerConfig.Rules[:1]
+
+	_, selectedModel = handler.selectModel(inputBody, 1000, &withoutReasoning, nil)
+	assert.Equal(t, "think,claude-3-5-sonnet", selectedModel, "the built-in routes follow the rules")
+}
+
 func TestHandleResponse_ErrorForwarding(t *testing.T) {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
 
@@ -259,7 +339,7 @@ func TestHandleResponse_ErrorForwarding(t *testing.T) {
 			}
 
 			// Call handleResponse
-			handler.handleResponse(w, resp, mockProvider, 100)
+			handler.handleResponse(w, resp, mockProvider, "test-model", 100, 1)
 
 			// Verify transformation was called only for success responses
 			if tc.shouldTransform {
@@ -300,6 +380,10 @@ func (m *MockProvider) TransformStream(chunk []byte, state *providers.StreamStat
 	return chunk, nil
 }
 
+func (m *MockProvider) NewRequest(ctx context.Context, upstream providers.UpstreamRequest) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, upstream.Method, "http://mock", bytes.NewReader(upstream.Body))
+}
+
 func (m *MockProvider) TransformRequest(request []byte) ([]byte, error) {
 	return request, nil
 }
@@ -359,7 +443,7 @@ func TestHandleStreamingResponse_ErrorForwarding(t *testing.T) {
 	}
 
 	// Call handleStreamingResponse
-	handler.handleStreamingResponse(w, resp, mockProvider, 100)
+	handler.handleStreamingResponse(w, resp, mockProvider, "test-model", 100)
 
 	// Verify transformation was NOT called for error response
 	assert.False(t, mockProvider.transformCalled, "error streaming responses should not be transformed")
@@ -372,3 +456,497 @@ func TestHandleStreamingResponse_ErrorForwarding(t *testing.T) {
 	assert.Contains(t, responseBody, "invalid_request_error", "error response should be forwarded as-is")
 	assert.Contains(t, responseBody, "Invalid model specified", "error message should be preserved")
 }
+
+func TestHandleResponse_ErrorTransformer(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := &ProxyHandler{logger: logger}
+	provider := providers.NewAzureProvider(&config.Provider{Name: "azure"})
+
+	responseBody := `{"error":{"code":"content_filter","message":"The response was filtered"}}`
+	resp := &http.Response{
+		StatusCode: http.StatusBadRequest,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(responseBody)),
+	}
+
+	w := &MockResponseWriter{
+		headers: make(http.Header),
+		body:    &bytes.Buffer{},
+	}
+
+	handler.handleResponse(w, resp, provider, "test-model", 100, 1)
+
+	assert.Equal(t, http.StatusBadRequest, w.statusCode, "status code should be preserved")
+	assert.JSONEq(t, `{"type":"error","error":{"type":"invalid_request_error","message":"The response was filtered"}}`, w.body.String())
+}
+
+func TestServeHTTP_TextOnlyModelRejectsImages(t *testing.T) {
+	upstreamCalled := false
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalled = true
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{
+				Name:           "openai",
+				APIBase:        upstream.URL,
+				APIKey:         "test-key",
+				TextOnlyModels: []string{"o3-mini"},
+			},
+		},
+		Router: config.RouterConfig{Default: "openai,o3-mini"},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	registry := providers.NewRegistry()
+	require.NoError(t, registry.Initialize(cfg.Providers))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := NewProxyHandler(cfgMgr, registry, logger)
+
+	requestBody := `{"model":"openai,o3-mini","max_tokens":100,"messages":[{"role":"user","content":[` +
+		`{"type":"text","text":"What is this?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aW1n"}}]}]}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(requestBody)))
+
+	assert.False(t, upstreamCalled, "request should be rejected before reaching the upstream")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var errorBody map[string]any
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &errorBody))
+	assert.Equal(t, "error", errorBody["type"])
+
+	errorDetails, ok := errorBody["error"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, "invalid_request_error", errorDetails["type"])
+	assert.Contains(t, errorDetails["message"], "does not support image input")
+}
+
+func TestServeHTTP_AnthropicStreamPassthrough(t *testing.T) {
+	// The upstream stream has a line longer than any scanner buffer and framing the line
+	// scanner would normalize
+	stream := "event: message_start\r\n" +
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet-4-20250514"}}` + "\r\n\r\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"` +
+		strings.Repeat("a", 200*1024) + `"}}` + "\n\n" +
+		": keep-alive\n\n" +
+		"event: message_stop\n" +
+		`data: {"type":"message_stop"}` + "\n\n"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, stream)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{
+				Name:    "anthropic",
+				APIBase: upstream.URL,
+				APIKey:  "test-key",
+			},
+		},
+		Router: config.RouterConfig{Default: "anthropic,claude-sonnet-4-20250514"},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	registry := providers.NewRegistry()
+	require.NoError(t, registry.Initialize(cfg.Providers))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := NewProxyHandler(cfgMgr, registry, logger)
+
+	requestBody := `{"model":"anthropic,claude-sonnet-4-20250514","max_tokens":100,"stream":true,` +
+		`"messages":[{"role":"user","content":"Hi"}]}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(requestBody)))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	assert.Equal(t, stream, rr.Body.String())
+}
+
+func TestServeHTTP_VertexClaudeStream(t *testing.T) {
+	events := []string{"message_start", "content_block_start", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}
+	stream := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"id":"msg_vrtx_1","type":"message","role":"assistant","model":"claude-sonnet-4","content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":10,"output_tokens":1}}}` + "\n\n" +
+		"event: content_block_start\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}` + "\n\n" +
+		"event: content_block_stop\n" +
+		`data: {"type":"content_block_stop","index":0}` + "\n\n" +
+		"event: message_delta\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":2}}` + "\n\n" +
+		"event: message_stop\n" +
+		`data: {"type":"message_stop"}` + "\n\n"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"access_token":"ya29.test-token","expires_in":3599,"token_type":"Bearer"}`)
+
+			return
+		}
+
+		assert.True(t, strings.HasSuffix(r.URL.Path, "/publishers/anthropic/models/claude-sonnet-4:streamRawPredict"), r.URL.Path)
+		assert.Equal(t, "Bearer ya29.test-token", r.Header.Get("Authorization"))
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, stream)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{
+				Name:            "vertex",
+				APIBase:         upstream.URL,
+				CredentialsFile: writeServiceAccountKey(t),
+				TokenURL:        upstream.URL + "/token",
+			},
+		},
+		Router: config.RouterConfig{Default: "vertex,claude-sonnet-4"},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	registry := providers.NewRegistry()
+	require.NoError(t, registry.Initialize(cfg.Providers))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := NewProxyHandler(cfgMgr, registry, logger)
+
+	requestBody := `{"model":"vertex,claude-sonnet-4","max_tokens":100,"stream":true,` +
+		`"messages":[{"role":"user","content":"Hi"}]}`
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(requestBody)))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// Every event keeps its event line, directly followed by its data
+	var received []string
+
+	scanner := bufio.NewScanner(strings.NewReader(rr.Body.String()))
+	for scanner.Scan() {
+		if eventType, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+			received = append(received, eventType)
+
+			require.True(t, scanner.Scan())
+			assert.True(t, strings.HasPrefix(scanner.Text(), `data: {"type":"`+eventType+`"`), scanner.Text())
+		}
+	}
+
+	assert.Equal(t, events, received)
+}
+
+func TestServeHTTP_KeylessProvidersSkipKeyHealth(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	// Both providers reject every request with 401, which would disable a configured key
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"access_token":"ya29.test-token","expires_in":3599,"token_type":"Bearer"}`)
+
+			return
+		}
+
+		assert.NotContains(t, r.Header.Get("Authorization"), "configured-key", "the configured key should not be sent")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = io.WriteString(w, `{"message":"Unauthorized"}`)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{
+				Name:            "vertex",
+				APIBase:         upstream.URL,
+				APIKey:          "configured-key-vertex",
+				CredentialsFile: writeServiceAccountKey(t),
+				TokenURL:        upstream.URL + "/token",
+			},
+			{Name: "bedrock", APIBase: upstream.URL, Region: "us-east-1"},
+		},
+		Router: config.RouterConfig{Default: "vertex,claude-sonnet-4"},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	cfgMgr.ApplyDefaults(cfg)
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	registry := providers.NewRegistry()
+	require.NoError(t, registry.Initialize(cfg.Providers))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := NewProxyHandler(cfgMgr, registry, logger)
+
+	for _, model := range []string{"vertex,claude-sonnet-4", "bedrock,amazon.nova-pro-v1:0"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages",
+			strings.NewReader(`{"model":"`+model+`","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`)))
+		assert.Equal(t, http.StatusUnauthorized, rr.Code, model)
+	}
+
+	providers := cfgMgr.Get().Providers
+	for i := range providers {
+		provider := &providers[i]
+		for _, status := range provider.KeyHealth() {
+			assert.Equal(t, config.KeyHealthy, status.State, "%s key %s", provider.Name, status.Key)
+		}
+	}
+}
+
+// writeServiceAccountKey writes a Google service account key with a fresh RSA key
+func writeServiceAccountKey(t *testing.T) string {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	key, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   "my-project",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		"client_email": "proxy@my-project.iam.gserviceaccount.com",
+	})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	require.NoError(t, os.WriteFile(path, key, 0o600))
+
+	return path
+}
+
+// recordingProvider records the stream events it is given
+type recordingProvider struct {
+	MockProvider
+	chunks []string
+}
+
+func (p *recordingProvider) TransformStream(chunk []byte, state *providers.StreamState) ([]byte, error) {
+	p.chunks = append(p.chunks, string(chunk))
+	return append(append([]byte("data: "), chunk...), "\n\n"...), nil
+}
+
+func TestHandleStreamingResponse_LargeEvents(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := &ProxyHandler{logger: logger}
+	provider := &recordingProvider{}
+
+	arguments := strings.Repeat("a", 100*1024)
+	streamBody := ": OPENROUTER PROCESSING\r\n\r\n" +
+		`data: {"choices":[{"delta":{"tool_calls":[{"function":{"arguments":"` + arguments + `"}}]}}]}` + "\r\n\r\n" +
+		"data: {\"part\":1,\r\ndata: \"part\":2}\r\n\r\n" +
+		"data: [DONE]\r\n\r\n"
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(streamBody)),
+	}
+	resp.Header.Set("Content-Type", "text/event-stream")
+
+	w := &MockResponseWriter{
+		headers: make(http.Header),
+		body:    &bytes.Buffer{},
+	}
+
+	handler.handleStreamingResponse(w, resp, provider, "test-model", 100)
+
+	require.Len(t, provider.chunks, 2)
+	assert.Len(t, provider.chunks[0], len(arguments)+len(`{"choices":[{"delta":{"tool_calls":[{"function":{"arguments":""}}]}}]}`))
+	assert.Equal(t, "{\"part\":1,\n\"part\":2}", provider.chunks[1])
+	assert.True(t, strings.HasSuffix(w.body.String(), "data: [DONE]\n\n"))
+}
+
+// decodingProvider has binary stream framing, decoded here as one message per line
+type decodingProvider struct {
+	MockProvider
+}
+
+type lineDecoder struct {
+	scanner *bufio.Scanner
+}
+
+func (d *lineDecoder) Next() ([]byte, error) {
+	if !d.scanner.Scan() {
+		return nil, io.EOF
+	}
+
+	return d.scanner.Bytes(), nil
+}
+
+func (p *decodingProvider) NewStreamDecoder(reader io.Reader) providers.StreamDecoder {
+	return &lineDecoder{scanner: bufio.NewScanner(reader)}
+}
+
+func (p *decodingProvider) TransformStream(chunk []byte, state *providers.StreamState) ([]byte, error) {
+	if string(chunk) == "stop" {
+		endTurn := "end_turn"
+		return providers.DeferFinishReason(&endTurn, nil, state), nil
+	}
+
+	state.Output.Write(chunk)
+
+	return append(append([]byte("data: "), chunk...), "\n\n"...), nil
+}
+
+func TestHandleStreamingResponse_DecodedStream(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := &ProxyHandler{logger: logger}
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader("hello\nstop\n")),
+	}
+	resp.Header.Set("Content-Type", "application/vnd.amazon.eventstream")
+
+	rr := httptest.NewRecorder()
+	handler.handleStreamingResponse(rr, resp, &decodingProvider{}, "test-model", 100)
+
+	// The upstream framing isn't what the client receives
+	assert.Equal(t, []string{"text/event-stream"}, rr.Header().Values("Content-Type"))
+
+	// The stream ended without usage, so the held message_delta is sent with an estimate
+	body := rr.Body.String()
+	assert.Contains(t, body, `"usage":{"input_tokens":100,"output_tokens":`)
+	assert.True(t, strings.HasSuffix(body, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"), body)
+}
+
+func TestServeHTTP_Fallback(t *testing.T) {
+	completion := `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"Hi from the fallback"},"finish_reason":"stop"}],` +
+		`"usage":{"prompt_tokens":5,"completion_tokens":4}}`
+
+	tests := []struct {
+		name            string
+		primaryStatus   int
+		primaryDown     bool
+		fallbackStatus  int
+		expectedStatus  int
+		expectsFallback bool
+	}{
+		{name: "server error", primaryStatus: http.StatusServiceUnavailable, fallbackStatus: http.StatusOK,
+			expectedStatus: http.StatusOK, expectsFallback: true},
+		{name: "rate limited", primaryStatus: http.StatusTooManyRequests, fallbackStatus: http.StatusOK,
+			expectedStatus: http.StatusOK, expectsFallback: true},
+		{name: "connection error", primaryDown: true, fallbackStatus: http.StatusOK,
+			expectedStatus: http.StatusOK, expectsFallback: true},
+		{name: "client error is relayed", primaryStatus: http.StatusBadRequest, fallbackStatus: http.StatusOK,
+			expectedStatus: http.StatusBadRequest},
+		{name: "last failure is relayed", primaryStatus: http.StatusServiceUnavailable, fallbackStatus: http.StatusBadGateway,
+			expectedStatus: http.StatusBadGateway, expectsFallback: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.primaryStatus)
+				_, _ = io.WriteString(w, `{"type":"error","error":{"type":"api_error","message":"primary failed"}}`)
+			}))
+			defer primary.Close()
+
+			if tt.primaryDown {
+				primary.Close()
+			}
+
+			var fallbackBody []byte
+
+			fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fallbackBody, _ = io.ReadAll(r.Body)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.fallbackStatus)
+
+				if tt.fallbackStatus == http.StatusOK {
+					_, _ = io.WriteString(w, completion)
+				} else {
+					_, _ = io.WriteString(w, `{"error":{"message":"fallback failed"}}`)
+				}
+			}))
+			defer fallback.Close()
+
+			cfg := &config.Config{
+				Providers: []config.Provider{
+					{Name: "primary", Type: "anthropic", APIBase: primary.URL, APIKey: "primary-key"},
+					{Name: "backup", Type: "openai", APIBase: fallback.URL, APIKey: "backup-key"},
+				},
+				Router: config.RouterConfig{
+					Default: "primary,claude-sonnet-4-20250514",
+					Fallback: map[string][]string{
+						"primary": {"missing,model", "backup,gpt-4o"},
+					},
+				},
+			}
+
+			cfgMgr := config.NewManager(t.TempDir())
+			require.NoError(t, cfgMgr.Save(cfg))
+
+			registry := providers.NewRegistry()
+			require.NoError(t, registry.Initialize(cfg.Providers))
+
+			logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+			handler := NewProxyHandler(cfgMgr, registry, logger)
+
+			requestBody := `{"model":"primary,claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(requestBody)))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+
+			if !tt.expectsFallback {
+				assert.Nil(t, fallbackBody, "the fallback should not be called")
+				return
+			}
+
+			// The request is transformed anew for the fallback provider and model
+			require.NotNil(t, fallbackBody)
+			assert.Contains(t, string(fallbackBody), `"model":"gpt-4o"`)
+			assert.Contains(t, string(fallbackBody), `"max_completion_tokens":100`)
+
+			if tt.expectedStatus == http.StatusOK {
+				assert.Contains(t, rr.Body.String(), "Hi from the fallback")
+			}
+		})
+	}
+}
+
+func TestFallbackChain(t *testing.T) {
+	routerConfig := &config.RouterConfig{
+		Fallback: map[string][]string{
+			"openrouter,anthropic/claude-sonnet-4": {"anthropic,claude-sonnet-4-20250514", "openrouter,anthropic/claude-sonnet-4", "openai,gpt-4o"},
+		},
+	}
+
+	assert.Equal(t,
+		[]string{"openrouter,anthropic/claude-sonnet-4", "anthropic,claude-sonnet-4-20250514", "openai,gpt-4o"},
+		fallbackChain("openrouter,anthropic/claude-sonnet-4", routerConfig),
+		"a target is tried once")
+	assert.Equal(t, []string{"openai,gpt-4o"}, fallbackChain("openai,gpt-4o", routerConfig))
+}
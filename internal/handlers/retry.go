@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// rateLimitResetPrefix starts the headers that tell when a rate limit resets, such as
+// OpenAI's x-ratelimit-reset-requests and x-ratelimit-reset-tokens
+const rateLimitResetPrefix = "X-Ratelimit-Reset"
+
+// sendWithRetry sends the request of a call, sending it again while the upstream fails in a
+// way the provider's retry policy retries. Each retry builds the request anew, so signatures
+// are fresh and the next API key is used. The response of the last attempt is returned with
+// the number of attempts.
+func (h *ProxyHandler) sendWithRetry(
+	r *http.Request, call *upstreamCall, inputTokens int, cfg *config.Config,
+) (*http.Response, int, error) {
+	for attempt := 1; ; attempt++ {
+		h.logger.Info("Proxying request",
+			"provider", call.provider.Name(),
+			"model", call.target,
+			"url", call.request.URL.Redacted(),
+			"input_tokens", inputTokens,
+			"attempt", attempt,
+		)
+
+		resp, err := http.DefaultClient.Do(call.request)
+		if err == nil {
+			h.reportKeyResult(call, resp)
+		}
+
+		reason := retryReason(r.Context(), &call.policy, resp, err)
+		if reason == "" {
+			return resp, attempt, err
+		}
+
+		if attempt >= call.policy.MaxAttempts {
+			h.logger.Warn("Upstream attempt failed, no attempts left",
+				"target", call.target, "attempt", attempt, "reason", reason)
+
+			return resp, attempt, err
+		}
+
+		delay, ok := retryDelay(&call.policy, attempt, resp, time.Now())
+		if !ok {
+			h.logger.Warn("Upstream attempt failed, retry would wait longer than the maximum backoff",
+				"target", call.target, "attempt", attempt, "reason", reason, "delay", delay)
+
+			return resp, attempt, err
+		}
+
+		h.logger.Warn("Upstream attempt failed, retrying",
+			"target", call.target, "attempt", attempt, "reason", reason, "delay", delay)
+
+		if resp != nil {
+			if err := resp.Body.Close(); err != nil {
+				h.logger.Warn("Failed to close response body", "error", err)
+			}
+		}
+
+		if err := sleepContext(r.Context(), delay); err != nil {
+			return nil, attempt, err
+		}
+
+		next, err := h.prepareUpstream(r, call.target, call.body, call.stream, cfg)
+		if err != nil {
+			return nil, attempt, err
+		}
+
+		call = next
+	}
+}
+
+// reportKeyResult tells the provider how a request went with its key, so rate limited keys
+// cool down and rejected ones are disabled
+func (h *ProxyHandler) reportKeyResult(call *upstreamCall, resp *http.Response) {
+	if call.apiKey == "" {
+		return
+	}
+
+	retryAfter := config.DefaultKeyCooldown
+	if delay, ok := upstreamRetryDelay(resp, time.Now()); ok && delay > 0 {
+		retryAfter = delay
+	}
+
+	switch call.providerConfig.ReportKeyResult(call.apiKey, resp.StatusCode, retryAfter) {
+	case config.KeyCoolingDown:
+		h.logger.Warn("API key rate limited, cooling down",
+			"provider", call.providerConfig.Name, "key", config.MaskAPIKey(call.apiKey), "cooldown", retryAfter)
+	case config.KeyDisabled:
+		h.logger.Warn("API key rejected, disabled until a probe succeeds",
+			"provider", call.providerConfig.Name, "key", config.MaskAPIKey(call.apiKey), "status", resp.StatusCode)
+	case config.KeyHealthy:
+	}
+}
+
+// retryReason tells why an attempt failed in a way the policy retries: a connection error
+// or timeout, or one of its status codes. It is empty otherwise, and when the client went away.
+func retryReason(ctx context.Context, policy *config.RetryPolicy, resp *http.Response, err error) string {
+	switch {
+	case ctx.Err() != nil:
+		return ""
+	case err != nil:
+		return err.Error()
+	case slices.Contains(policy.StatusCodes, resp.StatusCode):
+		return resp.Status
+	}
+
+	return ""
+}
+
+// retryDelay returns how long to wait before the next attempt. A wait the upstream asks for
+// is used as is, and false is returned when it exceeds the maximum backoff. Otherwise the
+// base backoff doubles with every attempt up to the maximum, minus the random jitter.
+func retryDelay(policy *config.RetryPolicy, attempt int, resp *http.Response, now time.Time) (time.Duration, bool) {
+	maxBackoff := time.Duration(policy.MaxBackoffMs) * time.Millisecond
+
+	if resp != nil {
+		if delay, ok := upstreamRetryDelay(resp, now); ok {
+			return delay, delay <= maxBackoff
+		}
+	}
+
+	delay := time.Duration(policy.BaseBackoffMs) * time.Millisecond
+	for range attempt - 1 {
+		if delay >= maxBackoff {
+			break
+		}
+
+		delay *= 2
+	}
+
+	delay = min(delay, maxBackoff)
+
+	if policy.Jitter != nil && *policy.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * *policy.Jitter * float64(delay))
+	}
+
+	return delay, true
+}
+
+// upstreamRetryDelay returns the wait a response asks for in its Retry-After header or, for
+// rate limited requests, the latest reset of its x-ratelimit-reset-* headers
+func upstreamRetryDelay(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second, true
+		}
+
+		if at, err := http.ParseTime(value); err == nil {
+			return max(at.Sub(now), 0), true
+		}
+	}
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	var (
+		delay time.Duration
+		found bool
+	)
+
+	for name, values := range resp.Header {
+		if !strings.HasPrefix(name, rateLimitResetPrefix) || len(values) == 0 {
+			continue
+		}
+
+		if reset, ok := parseRateLimitReset(values[0], now); ok {
+			delay = max(delay, reset)
+			found = true
+		}
+	}
+
+	return delay, found
+}
+
+// parseRateLimitReset parses the value of a rate limit reset header: a duration such as "1s"
+// or "6m0s", a number of seconds, a Unix timestamp or an RFC 3339 time
+func parseRateLimitReset(value string, now time.Time) (time.Duration, bool) {
+	if delay, err := time.ParseDuration(value); err == nil {
+		return max(delay, 0), true
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		// Values this large are timestamps rather than a number of seconds
+		if seconds > 1e9 {
+			return max(time.Unix(int64(seconds), 0).Sub(now), 0), true
+		}
+
+		return max(time.Duration(seconds*float64(time.Second)), 0), true
+	}
+
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+
+	return 0, false
+}
+
+// sleepContext waits for the delay, returning early with the context's error when it is done
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withAttempts adds the number of attempts to the message of an Anthropic error body. Other
+// bodies become the message of a new Anthropic error.
+func withAttempts(body []byte, statusCode, attempts int) []byte {
+	suffix := fmt.Sprintf(" (after %d attempts)", attempts)
+
+	if annotated, ok := appendErrorMessage(body, suffix); ok {
+		return annotated
+	}
+
+	message := fmt.Sprintf("upstream returned status %d: %s%s", statusCode, strings.TrimSpace(string(body)), suffix)
+
+	return providers.FormatAnthropicError(providers.MapHTTPStatusToErrorType(statusCode), message)
+}
+
+// appendErrorMessage appends to the message of an Anthropic error body, keeping its other
+// fields such as the request ID. It returns false when the body isn't an Anthropic error.
+func appendErrorMessage(body []byte, suffix string) ([]byte, bool) {
+	var errorBody struct {
+		Type  string         `json:"type"`
+		Error map[string]any `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &errorBody); err != nil || errorBody.Type != "error" {
+		return nil, false
+	}
+
+	message, ok := errorBody.Error["message"].(string)
+	if !ok {
+		return nil, false
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, false
+	}
+
+	errorBody.Error["message"] = message + suffix
+	fields["error"] = errorBody.Error
+
+	annotated, err := json.Marshal(fields)
+
+	return annotated, err == nil
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/providers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryDelay(t *testing.T) {
+	noJitter := 0.0
+	policy := &config.RetryPolicy{MaxAttempts: 5, BaseBackoffMs: 100, MaxBackoffMs: 1000, Jitter: &noJitter}
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	response := func(status int, header map[string]string) *http.Response {
+		resp := &http.Response{StatusCode: status, Header: http.Header{}}
+		for name, value := range header {
+			resp.Header.Set(name, value)
+		}
+
+		return resp
+	}
+
+	tests := []struct {
+		name     string
+		attempt  int
+		resp     *http.Response
+		expected time.Duration
+		ok       bool
+	}{
+		{"first retry", 1, nil, 100 * time.Millisecond, true},
+		{"doubles per attempt", 3, response(http.StatusServiceUnavailable, nil), 400 * time.Millisecond, true},
+		{"capped at the maximum", 10, nil, time.Second, true},
+		{"retry after seconds", 1, response(http.StatusServiceUnavailable, map[string]string{"Retry-After": "0"}), 0, true},
+		{"retry after date", 1, response(http.StatusTooManyRequests, map[string]string{"Retry-After": "Sun, 01 Jun 2025 12:00:00 GMT"}), 0, true},
+		{"retry after beyond the maximum", 1, response(http.StatusTooManyRequests, map[string]string{"Retry-After": "120"}),
+			2 * time.Minute, false},
+		{
+			name:    "latest rate limit reset",
+			attempt: 1,
+			resp: response(http.StatusTooManyRequests, map[string]string{
+				"X-Ratelimit-Reset-Requests": "200ms",
+				"X-Ratelimit-Reset-Tokens":   "0.5",
+			}),
+			expected: 500 * time.Millisecond,
+			ok:       true,
+		},
+		{
+			name:     "rate limit reset only counts for 429",
+			attempt:  1,
+			resp:     response(http.StatusInternalServerError, map[string]string{"X-Ratelimit-Reset-Requests": "6m0s"}),
+			expected: 100 * time.Millisecond,
+			ok:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, ok := retryDelay(policy, tt.attempt, tt.resp, now)
+			assert.Equal(t, tt.expected, delay)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+
+	t.Run("jitter shortens the backoff", func(t *testing.T) {
+		jitter := 0.5
+		jittered := *policy
+		jittered.Jitter = &jitter
+
+		for range 20 {
+			delay, ok := retryDelay(&jittered, 2, nil, now)
+			require.True(t, ok)
+			assert.GreaterOrEqual(t, delay, 100*time.Millisecond)
+			assert.LessOrEqual(t, delay, 200*time.Millisecond)
+		}
+	})
+}
+
+func TestParseRateLimitReset(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		{"6m0s", 6 * time.Minute, true},
+		{"20ms", 20 * time.Millisecond, true},
+		{"1.5", 1500 * time.Millisecond, true},
+		{"1748779230", 30 * time.Second, true},
+		{"2025-06-01T12:00:10Z", 10 * time.Second, true},
+		{"2025-06-01T11:00:00Z", 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			delay, ok := parseRateLimitReset(tt.value, now)
+			assert.Equal(t, tt.expected, delay)
+			assert.Equal(t, tt.ok, ok)
+		})
+	}
+}
+
+func TestWithAttempts(t *testing.T) {
+	annotated := withAttempts([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"},"request_id":"req_1"}`),
+		529, 3)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded (after 3 attempts)"},"request_id":"req_1"}`,
+		string(annotated))
+
+	wrapped := withAttempts([]byte("upstream unavailable\n"), http.StatusServiceUnavailable, 2)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"overloaded_error",`+
+		`"message":"upstream returned status 503: upstream unavailable (after 2 attempts)"}}`, string(wrapped))
+}
+
+func TestServeHTTP_Retry(t *testing.T) {
+	tests := []struct {
+		name             string
+		failures         int
+		failureStatus    int
+		expectedStatus   int
+		expectedRequests int32
+		expectedMessage  string
+	}{
+		{name: "recovers", failures: 2, failureStatus: http.StatusServiceUnavailable, expectedStatus: http.StatusOK, expectedRequests: 3},
+		{name: "attempts used up", failures: 5, failureStatus: 529, expectedStatus: 529, expectedRequests: 3,
+			expectedMessage: "Overloaded (after 3 attempts)"},
+		{name: "status not retried", failures: 5, failureStatus: http.StatusBadRequest, expectedStatus: http.StatusBadRequest,
+			expectedRequests: 1, expectedMessage: "Overloaded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+
+				if int(requests.Add(1)) <= tt.failures {
+					w.WriteHeader(tt.failureStatus)
+					_, _ = io.WriteString(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+
+					return
+				}
+
+				_, _ = io.WriteString(w, `{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514",`+
+					`"content":[{"type":"text","text":"Hi"}],"stop_reason":"end_turn","usage":{"input_tokens":5,"output_tokens":1}}`)
+			}))
+			defer upstream.Close()
+
+			cfg := &config.Config{
+				Providers: []config.Provider{
+					{
+						Name:    "anthropic",
+						APIBase: upstream.URL,
+						APIKey:  "test-key",
+						Retry:   &config.RetryPolicy{BaseBackoffMs: 1, MaxBackoffMs: 5},
+					},
+				},
+				Router: config.RouterConfig{Default: "anthropic,claude-sonnet-4-20250514"},
+			}
+
+			cfgMgr := config.NewManager(t.TempDir())
+			require.NoError(t, cfgMgr.Save(cfg))
+
+			registry := providers.NewRegistry()
+			require.NoError(t, registry.Initialize(cfg.Providers))
+
+			logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+			handler := NewProxyHandler(cfgMgr, registry, logger)
+
+			requestBody := `{"model":"anthropic,claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(requestBody)))
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Equal(t, tt.expectedRequests, requests.Load())
+
+			if tt.expectedMessage != "" {
+				assert.Contains(t, rr.Body.String(), `"message":"`+tt.expectedMessage+`"`)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_RetryConnectionError(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{Name: "anthropic", APIBase: upstream.URL, APIKey: "test-key", Retry: &config.RetryPolicy{MaxAttempts: 2, BaseBackoffMs: 1}},
+		},
+		Router: config.RouterConfig{Default: "anthropic,claude-sonnet-4-20250514"},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	registry := providers.NewRegistry()
+	require.NoError(t, registry.Initialize(cfg.Providers))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+	handler := NewProxyHandler(cfgMgr, registry, logger)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/messages",
+		strings.NewReader(`{"model":"anthropic,claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`)))
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), "upstream request failed after 2 attempts")
+}
@@ -1,11 +1,16 @@
 package providers
 
 import (
+	"context"
+	"net/http"
 	"strings"
 
 	"github.com/Davincible/claude-code-open/internal/config"
 )
 
+// AnthropicVersion is the API version sent when the client didn't pick one
+const AnthropicVersion = "2023-06-01"
+
 type AnthropicProvider struct {
 	Provider *config.Provider
 }
@@ -32,6 +37,31 @@ func (p *AnthropicProvider) GetAPIKey() string {
 	return p.Provider.GetAPIKey()
 }
 
+// NewRequest authenticates with the x-api-key header. Without a configured key the client's
+// own credentials are forwarded.
+func (p *AnthropicProvider) NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error) {
+	req, err := newUpstreamRequest(ctx, p.GetEndpoint(), upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey := upstream.apiKey(p.GetAPIKey); apiKey != "" {
+		req.Header.Del("Authorization")
+		req.Header.Set("x-api-key", apiKey)
+	}
+
+	if req.Header.Get("anthropic-version") == "" {
+		req.Header.Set("anthropic-version", AnthropicVersion)
+	}
+
+	return req, nil
+}
+
+// PassthroughStream reports that streams are already in the client's format
+func (p *AnthropicProvider) PassthroughStream() bool {
+	return true
+}
+
 func (p *AnthropicProvider) IsStreaming(headers map[string][]string) bool {
 	if contentType, ok := headers["Content-Type"]; ok {
 		for _, ct := range contentType {
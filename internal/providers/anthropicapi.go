@@ -0,0 +1,458 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Types of the Anthropic Messages API. Fields are declared in the alphabetical order of
+// their JSON names, so the stream events encode byte for byte like the maps they replaced.
+// Objects clients send keep the fields the proxy doesn't know in Extra, which encodes them
+// again as they were received.
+
+// Extra holds the fields of a JSON object that its type doesn't declare
+type Extra map[string]json.RawMessage
+
+// MessagesRequest is a request to the Messages API
+type MessagesRequest struct {
+	Messages []Message       `json:"messages"`
+	Model    string          `json:"model"`
+	Stream   bool            `json:"stream,omitempty"`
+	System   json.RawMessage `json:"system,omitempty"`
+	Tools    []Tool          `json:"tools,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+func (r *MessagesRequest) UnmarshalJSON(data []byte) error {
+	type fields MessagesRequest
+
+	extra, err := unmarshalWithExtra(data, (*fields)(r))
+	r.Extra = extra
+
+	return err
+}
+
+func (r MessagesRequest) MarshalJSON() ([]byte, error) {
+	type fields MessagesRequest
+	return marshalWithExtra(fields(r), r.Extra)
+}
+
+// HasWebSearchTool reports whether the request offers the web search server tool
+func (r *MessagesRequest) HasWebSearchTool() bool {
+	for _, tool := range r.Tools {
+		if strings.HasPrefix(tool.Type, webSearchToolTypePrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ThinkingEnabled reports whether the request turns on extended thinking
+func (r *MessagesRequest) ThinkingEnabled() bool {
+	var thinking struct {
+		Type string `json:"type"`
+	}
+
+	return json.Unmarshal(r.Extra["thinking"], &thinking) == nil && thinking.Type == "enabled"
+}
+
+// SystemText returns the system prompt, with the text of system blocks joined by newlines
+func (r *MessagesRequest) SystemText() string {
+	var content MessageContent
+	if len(r.System) == 0 || json.Unmarshal(r.System, &content) != nil {
+		return ""
+	}
+
+	if content.Blocks == nil {
+		return content.Text
+	}
+
+	texts := make([]string, 0, len(content.Blocks))
+
+	for _, block := range content.Blocks {
+		if block.Text != nil {
+			texts = append(texts, *block.Text)
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// Message is a turn of the conversation
+type Message struct {
+	Content MessageContent `json:"content"`
+	Role    string         `json:"role"`
+
+	Extra Extra `json:"-"`
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type fields Message
+
+	extra, err := unmarshalWithExtra(data, (*fields)(m))
+	m.Extra = extra
+
+	return err
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	type fields Message
+	return marshalWithExtra(fields(m), m.Extra)
+}
+
+// MessageContent is the content of a message, either a string or content blocks. Blocks is
+// nil for string content.
+type MessageContent struct {
+	Text   string
+	Blocks []ContentBlock
+}
+
+func (c *MessageContent) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &c.Text)
+	}
+
+	return json.Unmarshal(data, &c.Blocks)
+}
+
+func (c MessageContent) MarshalJSON() ([]byte, error) {
+	if c.Blocks != nil {
+		return json.Marshal(c.Blocks)
+	}
+
+	return json.Marshal(c.Text)
+}
+
+// ContentBlock is a block of message content. The fields in use depend on the type, text
+// and thinking are pointers as their blocks start out empty when streamed.
+type ContentBlock struct {
+	CacheControl json.RawMessage `json:"cache_control,omitempty"`
+	Citations    json.RawMessage `json:"citations,omitempty"`
+	Content      json.RawMessage `json:"content,omitempty"`
+	Data         string          `json:"data,omitempty"`
+	ID           string          `json:"id,omitempty"`
+	Input        json.RawMessage `json:"input,omitempty"`
+	IsError      bool            `json:"is_error,omitempty"`
+	Name         string          `json:"name,omitempty"`
+	Signature    *string         `json:"signature,omitempty"`
+	Source       json.RawMessage `json:"source,omitempty"`
+	Text         *string         `json:"text,omitempty"`
+	Thinking     *string         `json:"thinking,omitempty"`
+	ToolUseID    string          `json:"tool_use_id,omitempty"`
+	Type         string          `json:"type"`
+
+	Extra Extra `json:"-"`
+}
+
+func (b *ContentBlock) UnmarshalJSON(data []byte) error {
+	type fields ContentBlock
+
+	extra, err := unmarshalWithExtra(data, (*fields)(b))
+	b.Extra = extra
+
+	return err
+}
+
+func (b ContentBlock) MarshalJSON() ([]byte, error) {
+	type fields ContentBlock
+	return marshalWithExtra(fields(b), b.Extra)
+}
+
+// emptyInput is the input of a tool_use block as it starts streaming
+var emptyInput = json.RawMessage("{}")
+
+// newTextBlock returns an empty text block, as sent by content_block_start
+func newTextBlock() *ContentBlock {
+	text := ""
+	return &ContentBlock{Type: ContentTypeText, Text: &text}
+}
+
+// newThinkingBlock returns an empty thinking block, as sent by content_block_start
+func newThinkingBlock() *ContentBlock {
+	thinking, signature := "", ""
+	return &ContentBlock{Type: ContentTypeThinking, Thinking: &thinking, Signature: &signature}
+}
+
+// newToolUseBlock returns a tool_use block without input, as sent by content_block_start
+func newToolUseBlock(blockType, id, name string) *ContentBlock {
+	return &ContentBlock{Type: blockType, ID: id, Name: name, Input: emptyInput}
+}
+
+// Tool is a tool offered to the model. Server tools such as web search carry their options
+// in Extra.
+type Tool struct {
+	CacheControl json.RawMessage `json:"cache_control,omitempty"`
+	Description  string          `json:"description,omitempty"`
+	InputSchema  json.RawMessage `json:"input_schema,omitempty"`
+	Name         string          `json:"name"`
+	Type         string          `json:"type,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+func (t *Tool) UnmarshalJSON(data []byte) error {
+	type fields Tool
+
+	extra, err := unmarshalWithExtra(data, (*fields)(t))
+	t.Extra = extra
+
+	return err
+}
+
+func (t Tool) MarshalJSON() ([]byte, error) {
+	type fields Tool
+	return marshalWithExtra(fields(t), t.Extra)
+}
+
+// MessagesResponse is a complete response of the Messages API
+type MessagesResponse struct {
+	Content      []ContentBlock `json:"content"`
+	ID           string         `json:"id"`
+	Model        string         `json:"model"`
+	Role         string         `json:"role"`
+	StopReason   *string        `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Type         string         `json:"type"`
+	Usage        *Usage         `json:"usage,omitempty"`
+}
+
+// Usage is the token usage of a response
+type Usage struct {
+	CacheCreationInputTokens int            `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int            `json:"cache_read_input_tokens,omitempty"`
+	InputTokens              int            `json:"input_tokens"`
+	OutputTokens             int            `json:"output_tokens"`
+	ServerToolUse            *ServerToolUse `json:"server_tool_use,omitempty"`
+}
+
+// ServerToolUse counts the server tool requests of a response
+type ServerToolUse struct {
+	WebSearchRequests int `json:"web_search_requests"`
+}
+
+// Stream events. Usage stays a map in them, as the providers' usage mapping produces one
+// and it is only sent twice per stream.
+
+// MessageStartEvent starts a stream with the message before any content was generated
+type MessageStartEvent struct {
+	Message StreamMessage `json:"message"`
+	Type    string        `json:"type"`
+}
+
+// StreamMessage is the message of a message_start event
+type StreamMessage struct {
+	Content      []ContentBlock `json:"content"`
+	ID           string         `json:"id"`
+	Model        string         `json:"model"`
+	Role         string         `json:"role"`
+	StopReason   *string        `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Type         string         `json:"type"`
+	Usage        map[string]any `json:"usage"`
+}
+
+// ContentBlockStartEvent starts a content block
+type ContentBlockStartEvent struct {
+	ContentBlock *ContentBlock `json:"content_block"`
+	Index        int           `json:"index"`
+	Type         string        `json:"type"`
+}
+
+// ContentBlockDeltaEvent adds to a content block. Delta is one of the delta types below.
+type ContentBlockDeltaEvent struct {
+	Delta any    `json:"delta"`
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+}
+
+// TextDelta adds text to a text block
+type TextDelta struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// ThinkingDelta adds reasoning to a thinking block
+type ThinkingDelta struct {
+	Thinking string `json:"thinking"`
+	Type     string `json:"type"`
+}
+
+// SignatureDelta adds the signature that ends a thinking block
+type SignatureDelta struct {
+	Signature string `json:"signature"`
+	Type      string `json:"type"`
+}
+
+// InputJSONDelta adds a piece of the JSON input of a tool_use block
+type InputJSONDelta struct {
+	PartialJSON string `json:"partial_json"`
+	Type        string `json:"type"`
+}
+
+// CitationsDelta adds a citation to a text block
+type CitationsDelta struct {
+	Citation any    `json:"citation"`
+	Type     string `json:"type"`
+}
+
+// ContentBlockStopEvent ends a content block
+type ContentBlockStopEvent struct {
+	Index int    `json:"index"`
+	Type  string `json:"type"`
+}
+
+// MessageDeltaEvent reports how the message stopped and its usage
+type MessageDeltaEvent struct {
+	Delta MessageDelta   `json:"delta"`
+	Type  string         `json:"type"`
+	Usage map[string]any `json:"usage,omitempty"`
+}
+
+// MessageDelta is the delta of a message_delta event
+type MessageDelta struct {
+	StopReason   *string `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+}
+
+// MessageStopEvent ends a stream
+type MessageStopEvent struct {
+	Type string `json:"type"`
+}
+
+// ErrorEvent reports an error after the stream has started
+type ErrorEvent struct {
+	Error APIError `json:"error"`
+	Type  string   `json:"type"`
+}
+
+// APIError describes an error of the API
+type APIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func contentBlockStartEvent(index int, block *ContentBlock) []byte {
+	return FormatSSEEvent("content_block_start", ContentBlockStartEvent{
+		Type:         "content_block_start",
+		Index:        index,
+		ContentBlock: block,
+	})
+}
+
+func contentBlockDeltaEvent(index int, delta any) []byte {
+	return FormatSSEEvent("content_block_delta", ContentBlockDeltaEvent{
+		Type:  "content_block_delta",
+		Index: index,
+		Delta: delta,
+	})
+}
+
+func contentBlockStopEvent(index int) []byte {
+	return FormatSSEEvent("content_block_stop", ContentBlockStopEvent{
+		Type:  "content_block_stop",
+		Index: index,
+	})
+}
+
+func errorEvent(errorType, message string) []byte {
+	return FormatSSEEvent("error", ErrorEvent{
+		Type:  "error",
+		Error: APIError{Type: errorType, Message: message},
+	})
+}
+
+// unmarshalWithExtra decodes data into fields, a pointer to a struct without JSON methods,
+// and returns the members of the object that the struct doesn't declare
+func unmarshalWithExtra(data []byte, fields any) (Extra, error) {
+	if err := json.Unmarshal(data, fields); err != nil {
+		return nil, err
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+
+	for _, name := range jsonFieldNames(reflect.TypeOf(fields).Elem()) {
+		delete(members, name)
+	}
+
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	return members, nil
+}
+
+// marshalWithExtra encodes fields and appends the extra members to the object, sorted so
+// the encoding is stable
+func marshalWithExtra(fields any, extra Extra) ([]byte, error) {
+	data, err := json.Marshal(fields)
+	if err != nil || len(extra) == 0 {
+		return data, err
+	}
+
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	// Reopen the object by dropping its closing brace
+	buf := bytes.NewBuffer(data[:len(data)-1])
+
+	for i, name := range names {
+		if i > 0 || len(data) > len("{}") {
+			buf.WriteByte(',')
+		}
+
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(extra[name])
+	}
+
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+var jsonFieldNamesCache sync.Map
+
+// jsonFieldNames returns the JSON member names a struct type declares
+func jsonFieldNames(structType reflect.Type) []string {
+	if names, ok := jsonFieldNamesCache.Load(structType); ok {
+		return names.([]string)
+	}
+
+	names := make([]string, 0, structType.NumField())
+
+	for i := range structType.NumField() {
+		field := structType.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = field.Name
+		}
+
+		names = append(names, name)
+	}
+
+	jsonFieldNamesCache.Store(structType, names)
+
+	return names
+}
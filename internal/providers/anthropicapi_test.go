@@ -0,0 +1,76 @@
+package providers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessagesRequest_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{
+			name:    "string content",
+			request: `{"model":"claude-sonnet-4-20250514","max_tokens":1024,"messages":[{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name: "unknown fields at every level",
+			request: `{
+				"model": "claude-sonnet-4-20250514",
+				"max_tokens": 1024,
+				"stream": true,
+				"metadata": {"user_id": "u1"},
+				"future_option": [1, 2, 3],
+				"system": [{"type": "text", "text": "Be brief", "cache_control": {"type": "ephemeral"}}],
+				"messages": [
+					{"role": "user", "content": [
+						{"type": "text", "text": "Search this", "future_block_field": true},
+						{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aW1n"}}
+					], "future_message_field": "x"},
+					{"role": "assistant", "content": [
+						{"type": "thinking", "thinking": "", "signature": "sig"},
+						{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+					]},
+					{"role": "user", "content": [
+						{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "Sunny"}], "is_error": true}
+					]}
+				],
+				"tools": [
+					{"name": "get_weather", "description": "Weather", "input_schema": {"type": "object"}, "strict": true},
+					{"type": "web_search_20250305", "name": "web_search", "max_uses": 3, "allowed_domains": ["example.com"]}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request MessagesRequest
+			require.NoError(t, json.Unmarshal([]byte(tt.request), &request))
+
+			encoded, err := json.Marshal(request)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.request, string(encoded))
+		})
+	}
+}
+
+func TestMessagesRequest_Fields(t *testing.T) {
+	var request MessagesRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"model":"m","messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}],`+
+		`"tools":[{"type":"web_search_20250305","name":"web_search","max_uses":3}],"temperature":0.5}`), &request))
+
+	assert.Equal(t, "m", request.Model)
+	assert.True(t, request.HasWebSearchTool())
+	assert.JSONEq(t, `0.5`, string(request.Extra["temperature"]))
+	assert.JSONEq(t, `3`, string(request.Tools[0].Extra["max_uses"]))
+
+	require.Len(t, request.Messages, 1)
+	require.Len(t, request.Messages[0].Content.Blocks, 1)
+	assert.Equal(t, "Hi", *request.Messages[0].Content.Blocks[0].Text)
+	assert.Nil(t, request.Messages[0].Content.Blocks[0].Extra)
+}
@@ -0,0 +1,134 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+// DefaultAzureAPIVersion is the Azure OpenAI REST API version used when none is configured
+const DefaultAzureAPIVersion = "2024-10-21"
+
+// AzureProvider talks to Azure OpenAI deployments. The wire format is the OpenAI chat
+// completions API, so it only changes addressing, authentication and content-filter handling.
+type AzureProvider struct {
+	*OpenAIEngine
+	openAIDefaults
+}
+
+func NewAzureProvider(provider *config.Provider) *AzureProvider {
+	p := &AzureProvider{}
+	p.OpenAIEngine = newOpenAIEngine(provider, "Azure", p)
+
+	return p
+}
+
+// DeploymentURL builds the chat completions URL for a deployment. The deployment is the
+// model part of the routed model name, e.g. "azure,gpt-4o-prod" targets "gpt-4o-prod".
+func (p *AzureProvider) DeploymentURL(deployment string) string {
+	apiVersion := p.Provider.APIVersion
+	if apiVersion == "" {
+		apiVersion = DefaultAzureAPIVersion
+	}
+
+	baseURL := strings.TrimSuffix(p.Provider.APIBase, "/")
+	baseURL = strings.TrimSuffix(baseURL, "/openai")
+
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		baseURL, url.PathEscape(deployment), url.QueryEscape(apiVersion))
+}
+
+// NewRequest addresses the deployment named by the model and authenticates with the api-key
+// header in place of the client's Authorization header
+func (p *AzureProvider) NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error) {
+	req, err := newUpstreamRequest(ctx, p.DeploymentURL(upstream.Model), upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey := upstream.apiKey(p.GetAPIKey); apiKey != "" {
+		req.Header.Del("Authorization")
+		req.Header.Set("api-key", apiKey)
+	}
+
+	return req, nil
+}
+
+// TransformError converts an Azure error body into an Anthropic error response
+func (p *AzureProvider) TransformError(statusCode int, body []byte) ([]byte, error) {
+	var azureResp struct {
+		Error *struct {
+			Code       string `json:"code"`
+			Message    string `json:"message"`
+			InnerError *struct {
+				Code                string                    `json:"code"`
+				ContentFilterResult map[string]map[string]any `json:"content_filter_result"`
+			} `json:"innererror"`
+		} `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &azureResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Azure error response: %w", err)
+	}
+
+	if azureResp.Error == nil {
+		return nil, errors.New("azure error response has no error object")
+	}
+
+	azureErr := azureResp.Error
+	message := azureErr.Message
+
+	if azureErr.Code == "content_filter" && azureErr.InnerError != nil {
+		if categories := filteredCategories(azureErr.InnerError.ContentFilterResult); len(categories) > 0 {
+			message = fmt.Sprintf("%s (filtered categories: %s)", message, strings.Join(categories, ", "))
+		}
+	}
+
+	return FormatAnthropicError(p.mapAzureErrorType(statusCode, azureErr.Code), message), nil
+}
+
+// convertStopReason reports a completion stopped by the content filter as a refusal, like
+// Anthropic does
+func (p *AzureProvider) convertStopReason(reason string) *string {
+	if reason == "content_filter" {
+		refusal := "refusal"
+		return &refusal
+	}
+
+	return p.openAIDefaults.convertStopReason(reason)
+}
+
+func (p *AzureProvider) mapAzureErrorType(statusCode int, code string) string {
+	switch code {
+	case "content_filter", "content_policy_violation":
+		return "invalid_request_error"
+	case "DeploymentNotFound":
+		return "not_found_error"
+	case "429", "RateLimitExceeded":
+		return "rate_limit_error"
+	}
+
+	return MapHTTPStatusToErrorType(statusCode)
+}
+
+// filteredCategories returns the sorted names of content filter categories that triggered
+func filteredCategories(results map[string]map[string]any) []string {
+	var categories []string
+
+	for category, result := range results {
+		if filtered, ok := result["filtered"].(bool); ok && filtered {
+			categories = append(categories, category)
+		}
+	}
+
+	sort.Strings(categories)
+
+	return categories
+}
@@ -0,0 +1,164 @@
+package providers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzureProvider_DeploymentURL(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiBase    string
+		apiVersion string
+		deployment string
+		expected   string
+	}{
+		{
+			name:       "default api version",
+			apiBase:    "https://contoso.openai.azure.com",
+			deployment: "gpt-4o-prod",
+			expected:   "https://contoso.openai.azure.com/openai/deployments/gpt-4o-prod/chat/completions?api-version=" + DefaultAzureAPIVersion,
+		},
+		{
+			name:       "configured api version and trailing slash",
+			apiBase:    "https://contoso.openai.azure.com/",
+			apiVersion: "2025-01-01-preview",
+			deployment: "gpt-4o-prod",
+			expected:   "https://contoso.openai.azure.com/openai/deployments/gpt-4o-prod/chat/completions?api-version=2025-01-01-preview",
+		},
+		{
+			name:       "base url with openai suffix",
+			apiBase:    "https://contoso.openai.azure.com/openai",
+			deployment: "o3-mini",
+			expected:   "https://contoso.openai.azure.com/openai/deployments/o3-mini/chat/completions?api-version=" + DefaultAzureAPIVersion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewAzureProvider(&config.Provider{Name: "azure", APIBase: tt.apiBase, APIVersion: tt.apiVersion})
+			assert.Equal(t, tt.expected, provider.DeploymentURL(tt.deployment))
+		})
+	}
+}
+
+func TestAzureProvider_TransformResponse_ContentFilter(t *testing.T) {
+	provider := NewAzureProvider(&config.Provider{Name: "azure"})
+
+	azureResponse := `{
+		"id": "chatcmpl-123",
+		"model": "gpt-4o",
+		"choices": [{"index": 0, "message": {"role": "assistant", "content": "I can"}, "finish_reason": "content_filter"}],
+		"usage": {"prompt_tokens": 10, "completion_tokens": 2}
+	}`
+
+	result, err := provider.TransformResponse([]byte(azureResponse))
+	require.NoError(t, err)
+
+	var response map[string]any
+	require.NoError(t, json.Unmarshal(result, &response))
+
+	assert.Equal(t, "message", response["type"])
+	assert.Equal(t, "refusal", response["stop_reason"])
+
+	// Regular finish reasons keep the OpenAI mapping
+	azureResponse = strings.Replace(azureResponse, "content_filter", "stop", 1)
+	result, err = provider.TransformResponse([]byte(azureResponse))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(result, &response))
+	assert.Equal(t, "end_turn", response["stop_reason"])
+}
+
+func TestAzureProvider_TransformStream_ContentFilter(t *testing.T) {
+	provider := NewAzureProvider(&config.Provider{Name: "azure"})
+	state := &StreamState{}
+
+	chunks := []string{
+		`{"id":"","model":"","choices":[],"prompt_filter_results":[{"prompt_index":0,"content_filter_results":{}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"Hello"}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"content_filter"}]}`,
+		`{"id":"chatcmpl-1","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":9,"completion_tokens":1,"total_tokens":10}}`,
+	}
+
+	var output strings.Builder
+
+	for _, chunk := range chunks {
+		events, err := provider.TransformStream([]byte(chunk), state)
+		require.NoError(t, err)
+		output.Write(events)
+	}
+
+	result := output.String()
+	assert.Contains(t, result, "event: message_start")
+	assert.Contains(t, result, `"text":"Hello"`)
+	assert.Contains(t, result, `"stop_reason":"refusal"`)
+	assert.Contains(t, result, "event: message_stop")
+}
+
+func TestAzureProvider_TransformError(t *testing.T) {
+	provider := NewAzureProvider(&config.Provider{Name: "azure"})
+
+	tests := []struct {
+		name            string
+		statusCode      int
+		body            string
+		expectedType    string
+		expectedMessage string
+	}{
+		{
+			name:       "content filter",
+			statusCode: http.StatusBadRequest,
+			body: `{"error":{"message":"The response was filtered due to the prompt triggering Azure OpenAI's content management policy.","type":null,"param":"prompt","code":"content_filter","status":400,
+				"innererror":{"code":"ResponsibleAIPolicyViolation","content_filter_result":{"hate":{"filtered":false,"severity":"safe"},"violence":{"filtered":true,"severity":"high"},"jailbreak":{"filtered":true,"detected":true}}}}}`,
+			expectedType:    "invalid_request_error",
+			expectedMessage: "The response was filtered due to the prompt triggering Azure OpenAI's content management policy. (filtered categories: jailbreak, violence)",
+		},
+		{
+			name:            "missing deployment",
+			statusCode:      http.StatusNotFound,
+			body:            `{"error":{"code":"DeploymentNotFound","message":"The API deployment for this resource does not exist."}}`,
+			expectedType:    "not_found_error",
+			expectedMessage: "The API deployment for this resource does not exist.",
+		},
+		{
+			name:            "invalid key",
+			statusCode:      http.StatusUnauthorized,
+			body:            `{"error":{"code":"401","message":"Access denied due to invalid subscription key or wrong API endpoint."}}`,
+			expectedType:    "authentication_error",
+			expectedMessage: "Access denied due to invalid subscription key or wrong API endpoint.",
+		},
+		{
+			name:            "rate limited",
+			statusCode:      http.StatusTooManyRequests,
+			body:            `{"error":{"code":"429","message":"Requests to the ChatCompletions_Create Operation have exceeded the call rate limit."}}`,
+			expectedType:    "rate_limit_error",
+			expectedMessage: "Requests to the ChatCompletions_Create Operation have exceeded the call rate limit.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := provider.TransformError(tt.statusCode, []byte(tt.body))
+			require.NoError(t, err)
+
+			var response map[string]any
+			require.NoError(t, json.Unmarshal(result, &response))
+
+			assert.Equal(t, "error", response["type"])
+
+			errorObj, ok := response["error"].(map[string]any)
+			require.True(t, ok, "error should be an object")
+			assert.Equal(t, tt.expectedType, errorObj["type"])
+			assert.Equal(t, tt.expectedMessage, errorObj["message"])
+		})
+	}
+
+	_, err := provider.TransformError(http.StatusBadGateway, []byte("<html>Bad Gateway</html>"))
+	assert.Error(t, err, "non-JSON bodies cannot be transformed")
+}
@@ -1,11 +1,13 @@
 package providers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"net/http"
+	"sort"
 	"strings"
-	"time"
 )
 
 const (
@@ -17,6 +19,7 @@ const (
 
 	// Stop reason constants
 	StopReasonEndTurn = "end_turn"
+	StopReasonRefusal = "refusal"
 
 	// Content types
 	ContentTypeEventStream  = "text/event-stream"
@@ -52,7 +55,7 @@ var (
 		InputTokens:            "input_tokens",
 		OutputTokens:           "output_tokens",
 		CacheReadInputTokens:   "cache_read_input_tokens",
-		CacheCreateInputTokens: "cache_create_input_tokens",
+		CacheCreateInputTokens: "cache_creation_input_tokens",
 	}
 )
 
@@ -63,6 +66,44 @@ func IsStreamingContentType(contentType string) bool {
 	return contentType == "text/event-stream" || strings.Contains(contentType, "stream")
 }
 
+// newUpstreamRequest creates a request to url with the body and the client's headers, for
+// the provider to add its authentication to
+func newUpstreamRequest(ctx context.Context, url string, upstream UpstreamRequest) (*http.Request, error) {
+	method := upstream.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(upstream.Body))
+	if err != nil {
+		return nil, fmt.Errorf("create upstream request: %w", err)
+	}
+
+	if upstream.Header != nil {
+		req.Header = upstream.Header.Clone()
+	}
+
+	return req, nil
+}
+
+// apiKey returns the key the request authenticates with: the one the caller picked, else
+// the provider's next key
+func (u *UpstreamRequest) apiKey(nextKey func() string) string {
+	if u.APIKey != "" {
+		return u.APIKey
+	}
+
+	return nextKey()
+}
+
+// setBearerAuth sends the API key as a bearer token. Without a configured key the client's
+// own Authorization header is left in place.
+func setBearerAuth(req *http.Request, apiKey string) {
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+}
+
 // FormatSSEEvent formats data as a Server-Sent Event
 func FormatSSEEvent(eventType string, data any) []byte {
 	jsonData, err := json.Marshal(data)
@@ -71,40 +112,132 @@ func FormatSSEEvent(eventType string, data any) []byte {
 		return []byte("event: error\ndata: {\"error\":\"failed to marshal data\"}\n\n")
 	}
 
-	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, string(jsonData)))
+	event := make([]byte, 0, len("event: \ndata: \n\n")+len(eventType)+len(jsonData))
+	event = append(event, "event: "...)
+	event = append(event, eventType...)
+	event = append(event, "\ndata: "...)
+	event = append(event, jsonData...)
+
+	return append(event, "\n\n"...)
+}
+
+// FormatAnthropicError builds an Anthropic error response body
+func FormatAnthropicError(errorType, message string) []byte {
+	errorBody, err := json.Marshal(map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errorType,
+			"message": message,
+		},
+	})
+	if err != nil {
+		return []byte(`{"type":"error","error":{"type":"api_error","message":"failed to marshal error"}}`)
+	}
+
+	return errorBody
+}
+
+// RequestError is returned by TransformRequest for requests the provider can't serve.
+// The proxy answers it with an Anthropic error instead of forwarding the request.
+type RequestError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+// NewInvalidRequestError creates a RequestError answered with 400 invalid_request_error
+func NewInvalidRequestError(format string, args ...any) *RequestError {
+	return &RequestError{
+		StatusCode: http.StatusBadRequest,
+		Type:       "invalid_request_error",
+		Message:    fmt.Sprintf(format, args...),
+	}
+}
+
+// MapHTTPStatusToErrorType maps an upstream HTTP status code to an Anthropic error type
+func MapHTTPStatusToErrorType(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		return "overloaded_error"
+	}
+
+	return MessageTypeAPIError
 }
 
-// MapTokenUsage maps token usage from source format to Anthropic format
+// MapTokenUsage maps OpenAI-style token usage to Anthropic usage. OpenAI counts cached
+// prompt tokens in prompt_tokens, while Anthropic reports them next to input_tokens, so
+// they are taken out of the input count.
 func MapTokenUsage(sourceUsage map[string]any, sourceMapping TokenMapping) map[string]any {
 	anthropicUsage := make(map[string]any)
 
-	// Map basic token fields
-	if promptTokens, ok := sourceUsage[sourceMapping.InputTokens]; ok {
-		anthropicUsage[AnthropicTokenMapping.InputTokens] = promptTokens
+	promptDetails, _ := sourceUsage["prompt_tokens_details"].(map[string]any)
+
+	// Cache counts are read from the prompt details, or from the top level where Anthropic
+	// compatible upstreams and DeepSeek (prompt_cache_hit_tokens) put them
+	cacheRead, hasCacheRead := firstTokenCount(
+		promptDetails[sourceMapping.CacheReadInputTokens],
+		sourceUsage[AnthropicTokenMapping.CacheReadInputTokens],
+		sourceUsage["prompt_cache_hit_tokens"],
+	)
+	if hasCacheRead {
+		anthropicUsage[AnthropicTokenMapping.CacheReadInputTokens] = cacheRead
+	}
+
+	cacheCreation, hasCacheCreation := firstTokenCount(
+		promptDetails[sourceMapping.CacheCreateInputTokens],
+		sourceUsage[AnthropicTokenMapping.CacheCreateInputTokens],
+	)
+	if hasCacheCreation {
+		anthropicUsage[AnthropicTokenMapping.CacheCreateInputTokens] = cacheCreation
+	}
+
+	if promptTokens, ok := tokenCount(sourceUsage[sourceMapping.InputTokens]); ok {
+		anthropicUsage[AnthropicTokenMapping.InputTokens] = max(promptTokens-cacheRead-cacheCreation, 0)
 	}
 
-	if completionTokens, ok := sourceUsage[sourceMapping.OutputTokens]; ok {
+	if completionTokens, ok := tokenCount(sourceUsage[sourceMapping.OutputTokens]); ok {
 		anthropicUsage[AnthropicTokenMapping.OutputTokens] = completionTokens
 	}
 
-	// Handle detailed token information
-	if promptDetails, ok := sourceUsage["prompt_tokens_details"].(map[string]any); ok {
-		if cachedTokens, ok := promptDetails[sourceMapping.CacheReadInputTokens]; ok {
-			anthropicUsage[AnthropicTokenMapping.CacheReadInputTokens] = cachedTokens
-		}
+	return anthropicUsage
+}
 
-		if cacheCreationTokens, ok := promptDetails[sourceMapping.CacheCreateInputTokens]; ok {
-			anthropicUsage[AnthropicTokenMapping.CacheCreateInputTokens] = cacheCreationTokens
-		}
+// tokenCount reads a token count decoded from JSON or set in code
+func tokenCount(value any) (int, bool) {
+	switch count := value.(type) {
+	case int:
+		return count, true
+	case float64:
+		return int(count), true
 	}
 
-	if completionDetails, ok := sourceUsage["completion_tokens_details"].(map[string]any); ok {
-		for key, value := range completionDetails {
-			anthropicUsage["completion_"+key] = value
+	return 0, false
+}
+
+func firstTokenCount(values ...any) (int, bool) {
+	for _, value := range values {
+		if count, ok := tokenCount(value); ok {
+			return count, true
 		}
 	}
 
-	return anthropicUsage
+	return 0, false
 }
 
 // ConvertStopReason converts various stop reason formats to Anthropic format
@@ -162,6 +295,30 @@ func RemoveFieldsRecursively(data any, fieldsToRemove []string) any {
 	}
 }
 
+// SystemPromptText returns the text of an Anthropic system prompt, which is either a string
+// or an array of text blocks. Blocks are joined with blank lines and their cache_control
+// markers are dropped.
+func SystemPromptText(system any) string {
+	switch s := system.(type) {
+	case string:
+		return s
+	case []any:
+		var texts []string
+
+		for _, block := range s {
+			if blockMap, ok := block.(map[string]any); ok {
+				if text, ok := blockMap["text"].(string); ok && text != "" {
+					texts = append(texts, text)
+				}
+			}
+		}
+
+		return strings.Join(texts, "\n\n")
+	}
+
+	return ""
+}
+
 // CreateAnthropicContent creates Anthropic content blocks from text
 func CreateAnthropicContent(text string) []map[string]any {
 	if text == "" {
@@ -177,7 +334,7 @@ func CreateAnthropicContent(text string) []map[string]any {
 }
 
 // CreateMessageStartEvent creates a standard Anthropic message_start event
-func CreateMessageStartEvent(messageID, model string, usage map[string]any) map[string]any {
+func CreateMessageStartEvent(messageID, model string, usage map[string]any) MessageStartEvent {
 	if usage == nil {
 		usage = map[string]any{
 			"input_tokens":  0,
@@ -185,17 +342,15 @@ func CreateMessageStartEvent(messageID, model string, usage map[string]any) map[
 		}
 	}
 
-	return map[string]any{
-		"type": "message_start",
-		"message": map[string]any{
-			"id":            messageID,
-			"type":          "message",
-			"role":          "assistant",
-			"model":         model,
-			"content":       []any{},
-			"stop_reason":   nil,
-			"stop_sequence": nil,
-			"usage":         usage,
+	return MessageStartEvent{
+		Type: "message_start",
+		Message: StreamMessage{
+			ID:      messageID,
+			Type:    "message",
+			Role:    RoleAssistant,
+			Model:   model,
+			Content: []ContentBlock{},
+			Usage:   usage,
 		},
 	}
 }
@@ -210,123 +365,165 @@ func ExtractModelFromConfig(modelConfig string) (provider, model string) {
 	return "", strings.TrimSpace(modelConfig)
 }
 
-// ProviderInterface defines methods needed for HandleFinishReason
-type ProviderInterface interface {
-	formatSSEEvent(eventType string, data map[string]any) []byte
-	convertStopReason(reason string) *string
+// DeferFinishReason closes the content blocks of an OpenAI-style stream. These report usage
+// in a chunk of its own after the finish reason, so without usage the message_delta is held
+// in the state for SendPendingMessageDelta or FinishStream.
+func DeferFinishReason(stopReason *string, usage map[string]any, state *StreamState) []byte {
+	events := closeContentBlocks(state)
+	messageDeltaEvent := finishMessageDelta(stopReason)
+
+	if len(usage) == 0 {
+		state.PendingMessageDelta = messageDeltaEvent
+		return events
+	}
+
+	messageDeltaEvent.Usage = usage
+
+	return append(events, stopMessage(messageDeltaEvent)...)
+}
+
+// SendPendingMessageDelta sends the held message_delta with the usage that followed it
+func SendPendingMessageDelta(state *StreamState, usage map[string]any) []byte {
+	messageDeltaEvent := state.PendingMessageDelta
+	if messageDeltaEvent == nil {
+		return nil
+	}
+
+	state.PendingMessageDelta = nil
+
+	if len(usage) > 0 {
+		messageDeltaEvent.Usage = usage
+	}
+
+	return stopMessage(messageDeltaEvent)
+}
+
+// FinishStream sends the message_delta still held when a stream ends without usage,
+// closing any content block left open by a stream cut short. The usage is estimated from
+// the request's token count and the streamed output, counted with countTokens.
+func FinishStream(state *StreamState, inputTokens int, countTokens func(string) int) []byte {
+	if state.PendingMessageDelta == nil {
+		return nil
+	}
+
+	events := closeContentBlocks(state)
+
+	return append(events, SendPendingMessageDelta(state, map[string]any{
+		"input_tokens":  inputTokens,
+		"output_tokens": countTokens(state.Output.String()),
+	})...)
 }
 
-// HandleFinishReason processes finish reasons and sends appropriate events
-func HandleFinishReason(p ProviderInterface, reason string, chunk map[string]any, state *StreamState, getUsage func(map[string]any) map[string]any) []byte {
+// closeContentBlocks sends content_block_stop for all active content blocks, in index order
+func closeContentBlocks(state *StreamState) []byte {
 	var events []byte
 
-	// Send content_block_stop for all active content blocks
-	for index, contentBlock := range state.ContentBlocks {
+	for _, index := range sortedBlockIndexes(state) {
+		contentBlock := state.ContentBlocks[index]
 		if contentBlock.StartSent && !contentBlock.StopSent {
-			contentStopEvent := map[string]any{
-				"type":  "content_block_stop",
-				"index": index,
-			}
-			events = append(events, p.formatSSEEvent("content_block_stop", contentStopEvent)...)
+			events = append(events, contentBlockStopEvent(index)...)
 			contentBlock.StopSent = true
 		}
 	}
 
-	// Send message_delta with stop reason
-	messageDeltaEvent := map[string]any{
-		"type": "message_delta",
-		"delta": map[string]any{
-			"stop_reason":   p.convertStopReason(reason),
-			"stop_sequence": nil,
-		},
-	}
+	return events
+}
 
-	// Add usage if present - use the provided function to extract usage
-	if getUsage != nil {
-		usageData := getUsage(chunk)
-		if len(usageData) > 0 {
-			messageDeltaEvent["usage"] = usageData
-		}
+func sortedBlockIndexes(state *StreamState) []int {
+	indexes := make([]int, 0, len(state.ContentBlocks))
+	for index := range state.ContentBlocks {
+		indexes = append(indexes, index)
 	}
 
-	events = append(events, p.formatSSEEvent("message_delta", messageDeltaEvent)...)
+	sort.Ints(indexes)
 
-	// Send message_stop
-	messageStopEvent := map[string]any{
-		"type": "message_stop",
-	}
-	events = append(events, p.formatSSEEvent("message_stop", messageStopEvent)...)
+	return indexes
+}
 
-	return events
+func finishMessageDelta(stopReason *string) *MessageDeltaEvent {
+	return &MessageDeltaEvent{
+		Type:  "message_delta",
+		Delta: MessageDelta{StopReason: stopReason},
+	}
 }
 
-// StreamProviderInterface extends ProviderInterface for stream processing
-type StreamProviderInterface interface {
-	formatSSEEvent(eventType string, data map[string]any) []byte
-	convertStopReason(reason string) *string
-	createMessageStartEvent(messageID, model string, chunk map[string]any) map[string]any
-	handleToolCalls(toolCalls []any, state *StreamState) []byte
-	handleTextContent(content string, state *StreamState) []byte
-	handleFinishReason(reason string, chunk map[string]any, state *StreamState) []byte
+// stopMessage sends the message_delta followed by message_stop
+func stopMessage(messageDeltaEvent *MessageDeltaEvent) []byte {
+	events := FormatSSEEvent("message_delta", messageDeltaEvent)
+
+	return append(events, FormatSSEEvent("message_stop", MessageStopEvent{Type: "message_stop"})...)
 }
 
-// ConvertOpenAIStyleToAnthropicStream handles OpenAI-style streaming responses (OpenAI/Nvidia)
-func ConvertOpenAIStyleToAnthropicStream(data []byte, state *StreamState, provider StreamProviderInterface, errorPrefix string) ([]byte, error) {
-	var rawChunk map[string]any
-	if err := json.Unmarshal(data, &rawChunk); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal %s streaming response: %w", errorPrefix, err)
-	}
+// TransformToolResults converts a user message holding tool_result blocks into OpenAI tool
+// messages, followed by a user message with the rest of the content in its original order.
+// Tool messages only carry text, so images returned by a tool move to that user message.
+// It returns nil when the content has no tool results.
+func TransformToolResults(content []any) []any {
+	var toolMessages, rest []any
 
-	var events []byte
+	for _, block := range content {
+		blockMap, ok := block.(map[string]any)
+		if !ok || blockMap["type"] != MessageTypeToolResult {
+			rest = append(rest, block)
+			continue
+		}
 
-	// Store message ID and model from first chunk
-	if id, ok := rawChunk["id"].(string); ok && state.MessageID == "" {
-		state.MessageID = id
+		toolUseID, ok := blockMap["tool_use_id"].(string)
+		if !ok {
+			continue
+		}
+
+		text, images := flattenToolResultContent(blockMap["content"])
+
+		toolMessages = append(toolMessages, map[string]any{
+			"role":         "tool",
+			"tool_call_id": strings.Replace(toolUseID, "toolu_", "call_", 1),
+			"content":      text,
+		})
+		rest = append(rest, images...)
 	}
 
-	if model, ok := rawChunk["model"].(string); ok && state.Model == "" {
-		state.Model = model
+	if len(toolMessages) == 0 {
+		return nil
 	}
 
-	// Handle choices array
-	if choices, ok := rawChunk["choices"].([]any); ok && len(choices) > 0 {
-		if firstChoice, ok := choices[0].(map[string]any); ok {
-			// Send message_start event if not sent yet
-			if !state.MessageStartSent {
-				messageStartEvent := provider.createMessageStartEvent(state.MessageID, state.Model, rawChunk)
-				events = append(events, provider.formatSSEEvent("message_start", messageStartEvent)...)
-				state.MessageStartSent = true
-			}
+	if len(rest) > 0 {
+		toolMessages = append(toolMessages, map[string]any{"role": RoleUser, "content": rest})
+	}
 
-			// Handle delta content
-			if delta, ok := firstChoice["delta"].(map[string]any); ok {
-				// Initialize content blocks map if needed
-				if state.ContentBlocks == nil {
-					state.ContentBlocks = make(map[int]*ContentBlockState)
-				}
+	return toolMessages
+}
 
-				// Check if we have tool calls - if so, prioritize them over text content
-				if toolCalls, ok := delta["tool_calls"].([]any); ok {
-					toolEvents := provider.handleToolCalls(toolCalls, state)
-					events = append(events, toolEvents...)
-				} else if content, ok := delta["content"].(string); ok && content != "" {
-					// Only handle text content if no tool calls are present
-					textEvents := provider.handleTextContent(content, state)
-					events = append(events, textEvents...)
-				}
-			}
+// flattenToolResultContent joins the text of a tool result and returns its image blocks
+func flattenToolResultContent(content any) (string, []any) {
+	blocks, ok := content.([]any)
+	if !ok {
+		text, _ := content.(string)
+		return text, nil
+	}
 
-			// Handle finish_reason
-			if finishReason, ok := firstChoice["finish_reason"]; ok && finishReason != nil {
-				if reason, ok := finishReason.(string); ok {
-					finishEvents := provider.handleFinishReason(reason, rawChunk, state)
-					events = append(events, finishEvents...)
-				}
+	var (
+		texts  []string
+		images []any
+	)
+
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		switch blockMap["type"] {
+		case ContentTypeText:
+			if text, ok := blockMap["text"].(string); ok {
+				texts = append(texts, text)
 			}
+		case ContentTypeImage:
+			images = append(images, blockMap)
 		}
 	}
 
-	return events, nil
+	return strings.Join(texts, "\n\n"), images
 }
 
 // TransformAssistantMessage converts assistant messages with tool_use to tool_calls format
@@ -377,6 +574,8 @@ func TransformAssistantMessage(msgMap map[string]any, content []any) map[string]
 						toolCalls = append(toolCalls, toolCall)
 					}
 				}
+			case ContentTypeThinking, ContentTypeRedactedThinking:
+				// Dropped, thinking from earlier turns can't be replayed upstream
 			}
 		}
 	}
@@ -438,297 +637,3 @@ func TransformTools(tools []any) ([]any, error) {
 
 	return transformedTools, nil
 }
-
-// OpenAITransformerInterface defines methods that OpenAI-compatible providers need
-type OpenAITransformerInterface interface {
-	removeAnthropicSpecificFields(request map[string]any) map[string]any
-	transformMessages(messages []any) []any
-	transformTools(tools []any) ([]any, error)
-}
-
-// TransformAnthropicToOpenAI is a shared transformation function for OpenAI-compatible providers
-func TransformAnthropicToOpenAI(anthropicRequest []byte, transformer OpenAITransformerInterface) ([]byte, error) {
-	var request map[string]any
-	if err := json.Unmarshal(anthropicRequest, &request); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal Anthropic request: %w", err)
-	}
-
-	// Remove Anthropic-specific fields that OpenAI doesn't support
-	cleanedRequest := transformer.removeAnthropicSpecificFields(request)
-
-	// Handle system parameter - convert it to a system message in messages array
-	if systemContent, hasSystem := cleanedRequest["system"]; hasSystem {
-		if messages, ok := cleanedRequest["messages"].([]any); ok {
-			// Create system message
-			systemMessage := map[string]any{
-				"role":    "system",
-				"content": systemContent,
-			}
-
-			// Prepend system message to messages array
-			newMessages := append([]any{systemMessage}, messages...)
-			cleanedRequest["messages"] = newMessages
-		}
-		// Remove the system parameter as OpenAI doesn't support it at root level
-		delete(cleanedRequest, "system")
-	}
-
-	// Handle max_tokens parameter - convert to max_completion_tokens for OpenAI compatibility
-	if maxTokens, hasMaxTokens := cleanedRequest["max_tokens"]; hasMaxTokens {
-		cleanedRequest["max_completion_tokens"] = maxTokens
-		delete(cleanedRequest, "max_tokens")
-	}
-
-	// Transform any Anthropic-specific message formats if needed
-	if messages, ok := cleanedRequest["messages"].([]any); ok {
-		cleanedRequest["messages"] = transformer.transformMessages(messages)
-	}
-
-	// Transform tools from Claude format to OpenAI format if present
-	if tools, ok := cleanedRequest["tools"].([]any); ok {
-		transformedTools, err := transformer.transformTools(tools)
-		if err != nil {
-			// If tools transformation fails, remove tool_choice to prevent validation errors
-			delete(cleanedRequest, "tool_choice")
-		} else {
-			cleanedRequest["tools"] = transformedTools
-
-			// Re-validate tool_choice after successful transformation
-			// If transformed tools array is empty, remove tool_choice
-			if len(transformedTools) == 0 {
-				delete(cleanedRequest, "tool_choice")
-			}
-		}
-	}
-
-	return json.Marshal(cleanedRequest)
-}
-
-// Common response structures
-type CommonResponse struct {
-	ID      string                 `json:"id"`
-	Model   string                 `json:"model"`
-	Error   *CommonError           `json:"error,omitempty"`
-	Choices []CommonChoice         `json:"choices,omitempty"`
-	Usage   *CommonUsage           `json:"usage,omitempty"`
-}
-
-type CommonError struct {
-	Type    string `json:"type"`
-	Message string `json:"message"`
-}
-
-type CommonChoice struct {
-	Message      *CommonMessage `json:"message,omitempty"`
-	Delta        *CommonMessage `json:"delta,omitempty"`
-	FinishReason *string        `json:"finish_reason,omitempty"`
-}
-
-type CommonMessage struct {
-	Role         string                 `json:"role,omitempty"`
-	Content      *string                `json:"content,omitempty"`
-	ToolCalls    []CommonToolCall       `json:"tool_calls,omitempty"`
-	ToolCallID   *string                `json:"tool_call_id,omitempty"`
-	FunctionCall *CommonFunctionCall    `json:"function_call,omitempty"`
-}
-
-type CommonToolCall struct {
-	ID       string              `json:"id"`
-	Type     string              `json:"type"`
-	Function CommonFunctionCall  `json:"function"`
-}
-
-type CommonFunctionCall struct {
-	Name      string `json:"name"`
-	Arguments string `json:"arguments"`
-}
-
-type CommonUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-}
-
-// Anthropic response structures
-type AnthropicResponse struct {
-	ID         string              `json:"id"`
-	Type       string              `json:"type"`
-	Role       string              `json:"role,omitempty"`
-	Model      string              `json:"model"`
-	Content    []AnthropicContent  `json:"content,omitempty"`
-	StopReason *string             `json:"stop_reason,omitempty"`
-	Usage      *AnthropicUsage     `json:"usage,omitempty"`
-	Error      *AnthropicError     `json:"error,omitempty"`
-}
-
-type AnthropicContent struct {
-	Type      string      `json:"type"`
-	Text      *string     `json:"text,omitempty"`
-	ID        *string     `json:"id,omitempty"`
-	Name      *string     `json:"name,omitempty"`
-	Input     any `json:"input,omitempty"`
-	ToolUseID *string     `json:"tool_use_id,omitempty"`
-	Content   any `json:"content,omitempty"`
-}
-
-type AnthropicUsage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
-}
-
-type AnthropicError struct {
-	Type    string `json:"type"`
-	Message string `json:"message"`
-}
-
-// ConvertToAnthropic converts OpenAI-style response to Anthropic format
-func ConvertToAnthropic(responseData []byte, errorTypeMapper func(string) string, toolCallIDConverter func(string) string) ([]byte, error) {
-	var commonResp CommonResponse
-	if err := json.Unmarshal(responseData, &commonResp); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
-	}
-
-	// Handle error responses
-	if commonResp.Error != nil {
-		anthropicResp := AnthropicResponse{
-			ID:    commonResp.ID,
-			Type:  "error",
-			Model: commonResp.Model,
-			Error: &AnthropicError{
-				Type:    errorTypeMapper(commonResp.Error.Type),
-				Message: commonResp.Error.Message,
-			},
-		}
-
-		return json.Marshal(anthropicResp)
-	}
-
-	// Handle streaming vs non-streaming responses
-	if len(commonResp.Choices) == 0 {
-		return nil, errors.New("no choices in response")
-	}
-
-	choice := commonResp.Choices[0]
-
-	message := choice.Message
-	if message == nil {
-		message = choice.Delta // Handle streaming responses
-	}
-
-	if message == nil {
-		return nil, errors.New("no message content in choice")
-	}
-
-	anthropicResp := AnthropicResponse{
-		ID:    commonResp.ID,
-		Type:  "message",
-		Role:  "assistant",
-		Model: commonResp.Model,
-	}
-
-	// Convert content based on message type
-	content, err := convertMessageContent(message, toolCallIDConverter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to convert message content: %w", err)
-	}
-
-	anthropicResp.Content = content
-
-	// Convert stop reason
-	if choice.FinishReason != nil {
-		stopReason := ConvertStopReason(*choice.FinishReason)
-		anthropicResp.StopReason = stopReason
-	}
-
-	// Convert usage
-	if commonResp.Usage != nil {
-		usage := &AnthropicUsage{
-			InputTokens:  commonResp.Usage.PromptTokens,
-			OutputTokens: commonResp.Usage.CompletionTokens,
-		}
-		anthropicResp.Usage = usage
-	}
-
-	return json.Marshal(anthropicResp)
-}
-
-func convertMessageContent(message *CommonMessage, toolCallIDConverter func(string) string) ([]AnthropicContent, error) {
-	var content []AnthropicContent
-
-	// Handle regular text content
-	if message.Content != nil && *message.Content != "" {
-		content = append(content, AnthropicContent{
-			Type: "text",
-			Text: message.Content,
-		})
-	}
-
-	// Handle tool calls
-	if len(message.ToolCalls) > 0 {
-		for _, toolCall := range message.ToolCalls {
-			var input map[string]any
-			if toolCall.Function.Arguments != "" {
-				if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &input); err != nil {
-					return nil, fmt.Errorf("failed to parse tool call arguments: %w", err)
-				}
-			}
-
-			claudeID := toolCallIDConverter(toolCall.ID)
-			content = append(content, AnthropicContent{
-				Type:  "tool_use",
-				ID:    &claudeID,
-				Name:  &toolCall.Function.Name,
-				Input: input,
-			})
-		}
-	}
-
-	// Handle tool results
-	if message.Role == "tool" && message.ToolCallID != nil {
-		var toolContent any
-
-		if message.Content != nil {
-			var jsonContent any
-			if err := json.Unmarshal([]byte(*message.Content), &jsonContent); err == nil {
-				toolContent = jsonContent
-			} else {
-				toolContent = *message.Content
-			}
-		}
-
-		claudeToolID := toolCallIDConverter(*message.ToolCallID)
-		content = append(content, AnthropicContent{
-			Type:      "tool_result",
-			ToolUseID: &claudeToolID,
-			Content:   toolContent,
-		})
-	}
-
-	// Handle legacy function calls
-	if message.FunctionCall != nil {
-		var input map[string]any
-		if message.FunctionCall.Arguments != "" {
-			if err := json.Unmarshal([]byte(message.FunctionCall.Arguments), &input); err != nil {
-				return nil, fmt.Errorf("failed to parse function call arguments: %w", err)
-			}
-		}
-
-		id := fmt.Sprintf("func_%d", time.Now().UnixNano())
-		content = append(content, AnthropicContent{
-			Type:  "tool_use",
-			ID:    &id,
-			Name:  &message.FunctionCall.Name,
-			Input: input,
-		})
-	}
-
-	// If no content was generated, add empty text block
-	if len(content) == 0 {
-		emptyText := ""
-		content = append(content, AnthropicContent{
-			Type: "text",
-			Text: &emptyText,
-		})
-	}
-
-	return content, nil
-}
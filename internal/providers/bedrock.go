@@ -0,0 +1,831 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+const (
+	// BedrockAnthropicVersion is the anthropic_version Bedrock expects for InvokeModel
+	BedrockAnthropicVersion = "bedrock-2023-05-31"
+
+	bedrockSigningService = "bedrock"
+	bedrockDefaultRegion  = "us-east-1"
+)
+
+// BedrockProvider talks to the AWS Bedrock runtime. Anthropic models are called through
+// InvokeModel, which takes the Anthropic Messages format natively. All other models go
+// through the Converse API.
+type BedrockProvider struct {
+	Provider *config.Provider
+}
+
+func NewBedrockProvider(provider *config.Provider) *BedrockProvider {
+	return &BedrockProvider{
+		Provider: provider,
+	}
+}
+
+func (p *BedrockProvider) Name() string {
+	return p.Provider.Name
+}
+
+func (p *BedrockProvider) SupportsStreaming() bool {
+	return true
+}
+
+func (p *BedrockProvider) GetEndpoint() string {
+	if p.Provider.APIBase != "" {
+		return strings.TrimSuffix(p.Provider.APIBase, "/")
+	}
+
+	return fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", p.region())
+}
+
+// GetAPIKey returns the next configured Bedrock API key, or none when requests are signed
+// with SigV4, so key health is only tracked for keys that are actually sent
+func (p *BedrockProvider) GetAPIKey() string {
+	return p.Provider.GetAPIKey()
+}
+
+func (p *BedrockProvider) IsStreaming(headers map[string][]string) bool {
+	for _, ct := range headers["Content-Type"] {
+		if strings.HasPrefix(ct, ContentTypeAmazonEventStream) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EndpointURL returns the runtime URL for a model id, picking the API from the model family
+func (p *BedrockProvider) EndpointURL(modelID string, stream bool) string {
+	var action string
+
+	switch {
+	case isBedrockAnthropicModel(modelID) && stream:
+		action = "invoke-with-response-stream"
+	case isBedrockAnthropicModel(modelID):
+		action = "invoke"
+	case stream:
+		action = "converse-stream"
+	default:
+		action = "converse"
+	}
+
+	// Model ids contain ':' which has to be escaped in the path for the signature to match
+	return fmt.Sprintf("%s/model/%s/%s", p.GetEndpoint(), awsURIEncode(modelID), action)
+}
+
+// SignRequest authenticates the request. A configured api_key is sent as a Bedrock API
+// key, otherwise the request is signed with SigV4 using the standard AWS credentials.
+func (p *BedrockProvider) SignRequest(req *http.Request, body []byte) error {
+	if apiKey := p.GetAPIKey(); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		return nil
+	}
+
+	creds, err := loadAWSCredentials()
+	if err != nil {
+		return fmt.Errorf("load AWS credentials: %w", err)
+	}
+
+	signAWSRequest(req, body, creds, p.region(), bedrockSigningService, time.Now())
+
+	return nil
+}
+
+// NewRequest addresses the API the model family uses and signs the request
+func (p *BedrockProvider) NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error) {
+	req, err := newUpstreamRequest(ctx, p.EndpointURL(upstream.Model, upstream.Stream), upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	// A key picked by the proxy is sent as a Bedrock API key, like a configured one
+	if upstream.APIKey != "" {
+		setBearerAuth(req, upstream.APIKey)
+		return req, nil
+	}
+
+	if err := p.SignRequest(req, upstream.Body); err != nil {
+		return nil, fmt.Errorf("sign Bedrock request: %w", err)
+	}
+
+	return req, nil
+}
+
+func (p *BedrockProvider) NewStreamDecoder(reader io.Reader) StreamDecoder {
+	return NewEventStreamDecoder(reader)
+}
+
+func (p *BedrockProvider) region() string {
+	if p.Provider.Region != "" {
+		return p.Provider.Region
+	}
+
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+
+	return bedrockDefaultRegion
+}
+
+// isBedrockAnthropicModel reports whether a model id, including cross-region inference
+// profiles such as "us.anthropic.claude-...", belongs to the Anthropic family
+func isBedrockAnthropicModel(modelID string) bool {
+	return strings.HasPrefix(modelID, "anthropic.") || strings.Contains(modelID, ".anthropic.")
+}
+
+func (p *BedrockProvider) TransformRequest(request []byte) ([]byte, error) {
+	var anthropicRequest map[string]any
+	if err := json.Unmarshal(request, &anthropicRequest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic request: %w", err)
+	}
+
+	model, _ := anthropicRequest["model"].(string)
+	if isBedrockAnthropicModel(model) {
+		// InvokeModel takes the Messages API body, with the model and streaming mode in the URL
+		delete(anthropicRequest, "model")
+		delete(anthropicRequest, "stream")
+
+		if _, ok := anthropicRequest["anthropic_version"]; !ok {
+			anthropicRequest["anthropic_version"] = BedrockAnthropicVersion
+		}
+
+		return json.Marshal(anthropicRequest)
+	}
+
+	for _, image := range collectImageBlocks(anthropicRequest) {
+		if source, ok := parseImageSource(image); ok && source.Type == imageSourceURL {
+			return nil, NewInvalidRequestError("Bedrock Converse does not fetch image URLs, send images as base64 data instead")
+		}
+	}
+
+	return json.Marshal(p.transformAnthropicToConverse(anthropicRequest))
+}
+
+func (p *BedrockProvider) transformAnthropicToConverse(request map[string]any) map[string]any {
+	converseRequest := make(map[string]any)
+
+	if messages, ok := request["messages"].([]any); ok {
+		converseRequest["messages"] = p.convertMessages(messages)
+	}
+
+	if system := p.convertSystem(request["system"]); len(system) > 0 {
+		converseRequest["system"] = system
+	}
+
+	inferenceConfig := make(map[string]any)
+	if maxTokens, ok := request["max_tokens"]; ok {
+		inferenceConfig["maxTokens"] = maxTokens
+	}
+
+	if temperature, ok := request["temperature"]; ok {
+		inferenceConfig["temperature"] = temperature
+	}
+
+	if topP, ok := request["top_p"]; ok {
+		inferenceConfig["topP"] = topP
+	}
+
+	if stopSequences, ok := request["stop_sequences"]; ok {
+		inferenceConfig["stopSequences"] = stopSequences
+	}
+
+	if len(inferenceConfig) > 0 {
+		converseRequest["inferenceConfig"] = inferenceConfig
+	}
+
+	// Model specific parameters without a Converse equivalent are passed through
+	additionalFields := make(map[string]any)
+	if topK, ok := request["top_k"]; ok {
+		additionalFields["top_k"] = topK
+	}
+
+	if len(additionalFields) > 0 {
+		converseRequest["additionalModelRequestFields"] = additionalFields
+	}
+
+	if tools, ok := request["tools"].([]any); ok {
+		if toolConfig := p.convertToolConfig(tools, request["tool_choice"]); toolConfig != nil {
+			converseRequest["toolConfig"] = toolConfig
+		}
+	}
+
+	return converseRequest
+}
+
+func (p *BedrockProvider) convertSystem(system any) []any {
+	var blocks []any
+
+	switch s := system.(type) {
+	case string:
+		if s != "" {
+			blocks = append(blocks, map[string]any{"text": s})
+		}
+	case []any:
+		for _, block := range s {
+			if blockMap, ok := block.(map[string]any); ok {
+				if text, ok := blockMap["text"].(string); ok && text != "" {
+					blocks = append(blocks, map[string]any{"text": text})
+				}
+			}
+		}
+	}
+
+	return blocks
+}
+
+func (p *BedrockProvider) convertMessages(messages []any) []any {
+	converseMessages := make([]any, 0, len(messages))
+
+	for _, msg := range messages {
+		msgMap, ok := msg.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		role, _ := msgMap["role"].(string)
+
+		var content []any
+
+		switch c := msgMap["content"].(type) {
+		case string:
+			content = append(content, map[string]any{"text": c})
+		case []any:
+			for _, block := range c {
+				if blockMap, ok := block.(map[string]any); ok {
+					if converted := p.convertContentBlock(blockMap); converted != nil {
+						content = append(content, converted)
+					}
+				}
+			}
+		}
+
+		converseMessages = append(converseMessages, map[string]any{
+			"role":    role,
+			"content": content,
+		})
+	}
+
+	return converseMessages
+}
+
+// convertContentBlock converts an Anthropic content block, returning nil for blocks
+// that have no Converse equivalent. Thinking blocks are dropped too: their signatures
+// are only valid for Anthropic models, which go through InvokeModel instead.
+func (p *BedrockProvider) convertContentBlock(block map[string]any) map[string]any {
+	blockType, _ := block["type"].(string)
+
+	switch blockType {
+	case ContentTypeText:
+		return map[string]any{"text": block["text"]}
+	case "image":
+		return p.convertImageBlock(block)
+	case ContentTypeToolUse:
+		input := block["input"]
+		if input == nil {
+			input = map[string]any{}
+		}
+
+		return map[string]any{
+			"toolUse": map[string]any{
+				"toolUseId": block["id"],
+				"name":      block["name"],
+				"input":     input,
+			},
+		}
+	case MessageTypeToolResult:
+		toolResult := map[string]any{
+			"toolUseId": block["tool_use_id"],
+			"content":   p.convertToolResultContent(block["content"]),
+		}
+
+		if isError, ok := block["is_error"].(bool); ok && isError {
+			toolResult["status"] = "error"
+		}
+
+		return map[string]any{"toolResult": toolResult}
+	}
+
+	return nil
+}
+
+func (p *BedrockProvider) convertImageBlock(block map[string]any) map[string]any {
+	source, ok := block["source"].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	if sourceType, _ := source["type"].(string); sourceType != "base64" {
+		return nil
+	}
+
+	mediaType, _ := source["media_type"].(string)
+
+	return map[string]any{
+		"image": map[string]any{
+			"format": strings.TrimPrefix(mediaType, "image/"),
+			"source": map[string]any{"bytes": source["data"]},
+		},
+	}
+}
+
+func (p *BedrockProvider) convertToolResultContent(content any) []any {
+	var blocks []any
+
+	switch c := content.(type) {
+	case string:
+		blocks = append(blocks, map[string]any{"text": c})
+	case []any:
+		for _, block := range c {
+			if blockMap, ok := block.(map[string]any); ok {
+				blockType, _ := blockMap["type"].(string)
+				if blockType == ContentTypeText || blockType == "image" {
+					if converted := p.convertContentBlock(blockMap); converted != nil {
+						blocks = append(blocks, converted)
+					}
+				}
+			}
+		}
+	}
+
+	// Converse rejects tool results without content
+	if len(blocks) == 0 {
+		blocks = append(blocks, map[string]any{"text": ""})
+	}
+
+	return blocks
+}
+
+func (p *BedrockProvider) convertToolConfig(tools []any, toolChoice any) map[string]any {
+	var converseTools []any
+
+	for _, tool := range tools {
+		toolMap, ok := tool.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		name, _ := toolMap["name"].(string)
+		schema, hasSchema := toolMap["input_schema"]
+
+		// Server tools such as web search have no schema and can't be offered to the model
+		if name == "" || !hasSchema {
+			continue
+		}
+
+		toolSpec := map[string]any{
+			"name":        name,
+			"inputSchema": map[string]any{"json": schema},
+		}
+
+		if description, ok := toolMap["description"].(string); ok && description != "" {
+			toolSpec["description"] = description
+		}
+
+		converseTools = append(converseTools, map[string]any{"toolSpec": toolSpec})
+	}
+
+	if len(converseTools) == 0 {
+		return nil
+	}
+
+	toolConfig := map[string]any{"tools": converseTools}
+
+	if choiceMap, ok := toolChoice.(map[string]any); ok {
+		switch choiceMap["type"] {
+		case "auto":
+			toolConfig["toolChoice"] = map[string]any{"auto": map[string]any{}}
+		case "any":
+			toolConfig["toolChoice"] = map[string]any{"any": map[string]any{}}
+		case "tool":
+			toolConfig["toolChoice"] = map[string]any{"tool": map[string]any{"name": choiceMap["name"]}}
+		}
+	}
+
+	return toolConfig
+}
+
+// Bedrock Converse response structures
+type bedrockConverseResponse struct {
+	Output *struct {
+		Message struct {
+			Role    string                `json:"role"`
+			Content []bedrockContentBlock `json:"content"`
+		} `json:"message"`
+	} `json:"output"`
+	StopReason string        `json:"stopReason"`
+	Usage      *bedrockUsage `json:"usage"`
+}
+
+// bedrockContentBlock is a content block of a Converse message, which sets one of the fields
+type bedrockContentBlock struct {
+	Text    *string `json:"text"`
+	ToolUse *struct {
+		ToolUseID string          `json:"toolUseId"`
+		Name      string          `json:"name"`
+		Input     json.RawMessage `json:"input"`
+	} `json:"toolUse"`
+	ReasoningContent *struct {
+		ReasoningText *struct {
+			Text      string `json:"text"`
+			Signature string `json:"signature"`
+		} `json:"reasoningText"`
+		RedactedContent string `json:"redactedContent"`
+	} `json:"reasoningContent"`
+}
+
+type bedrockUsage struct {
+	InputTokens           int `json:"inputTokens"`
+	OutputTokens          int `json:"outputTokens"`
+	CacheReadInputTokens  int `json:"cacheReadInputTokens"`
+	CacheWriteInputTokens int `json:"cacheWriteInputTokens"`
+}
+
+func (p *BedrockProvider) TransformResponse(response []byte) ([]byte, error) {
+	return p.TransformModelResponse("", response)
+}
+
+// TransformModelResponse converts a Converse response into an Anthropic message of the model
+// the request was sent to, which Converse responses don't name
+func (p *BedrockProvider) TransformModelResponse(model string, response []byte) ([]byte, error) {
+	var converseResp bedrockConverseResponse
+	if err := json.Unmarshal(response, &converseResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
+	}
+
+	// InvokeModel responses are already in the Anthropic format
+	if converseResp.Output == nil {
+		return response, nil
+	}
+
+	content := make([]ContentBlock, 0, len(converseResp.Output.Message.Content))
+
+	for i := range converseResp.Output.Message.Content {
+		if converted := p.convertResponseBlock(&converseResp.Output.Message.Content[i]); converted != nil {
+			content = append(content, *converted)
+		}
+	}
+
+	anthropicResp := MessagesResponse{
+		ID:         fmt.Sprintf("msg_bedrock_%d", time.Now().UnixNano()),
+		Type:       "message",
+		Role:       RoleAssistant,
+		Model:      model,
+		Content:    content,
+		StopReason: p.convertStopReason(converseResp.StopReason),
+	}
+
+	if usage := converseResp.Usage; usage != nil {
+		anthropicResp.Usage = &Usage{
+			InputTokens:              usage.InputTokens,
+			OutputTokens:             usage.OutputTokens,
+			CacheReadInputTokens:     usage.CacheReadInputTokens,
+			CacheCreationInputTokens: usage.CacheWriteInputTokens,
+		}
+	}
+
+	return json.Marshal(anthropicResp)
+}
+
+func (p *BedrockProvider) convertResponseBlock(block *bedrockContentBlock) *ContentBlock {
+	if block.Text != nil {
+		return &ContentBlock{Type: ContentTypeText, Text: block.Text}
+	}
+
+	if toolUse := block.ToolUse; toolUse != nil {
+		converted := newToolUseBlock(ContentTypeToolUse, toolUse.ToolUseID, toolUse.Name)
+		if len(toolUse.Input) > 0 && string(toolUse.Input) != "null" {
+			converted.Input = toolUse.Input
+		}
+
+		return converted
+	}
+
+	if reasoning := block.ReasoningContent; reasoning != nil {
+		if reasoningText := reasoning.ReasoningText; reasoningText != nil {
+			return &ContentBlock{
+				Type:      ContentTypeThinking,
+				Thinking:  &reasoningText.Text,
+				Signature: &reasoningText.Signature,
+			}
+		}
+
+		if reasoning.RedactedContent != "" {
+			return &ContentBlock{Type: ContentTypeRedactedThinking, Data: reasoning.RedactedContent}
+		}
+	}
+
+	return nil
+}
+
+func (p *BedrockProvider) convertStopReason(reason string) *string {
+	mapping := map[string]string{
+		"end_turn":             StopReasonEndTurn,
+		"tool_use":             "tool_use",
+		"max_tokens":           "max_tokens",
+		"stop_sequence":        "stop_sequence",
+		"guardrail_intervened": "refusal",
+		"content_filtered":     "refusal",
+	}
+
+	if anthropicReason, exists := mapping[reason]; exists {
+		return &anthropicReason
+	}
+
+	defaultReason := StopReasonEndTurn
+
+	return &defaultReason
+}
+
+func (p *BedrockProvider) convertUsage(usage *bedrockUsage) map[string]any {
+	anthropicUsage := map[string]any{
+		"input_tokens":  usage.InputTokens,
+		"output_tokens": usage.OutputTokens,
+	}
+
+	if usage.CacheReadInputTokens > 0 {
+		anthropicUsage["cache_read_input_tokens"] = usage.CacheReadInputTokens
+	}
+
+	if usage.CacheWriteInputTokens > 0 {
+		anthropicUsage["cache_creation_input_tokens"] = usage.CacheWriteInputTokens
+	}
+
+	return anthropicUsage
+}
+
+// TransformError converts a Bedrock error body into an Anthropic error response
+func (p *BedrockProvider) TransformError(statusCode int, body []byte) ([]byte, error) {
+	var bedrockErr struct {
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+
+	if err := json.Unmarshal(body, &bedrockErr); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Bedrock error response: %w", err)
+	}
+
+	message := bedrockErr.Message
+	if message == "" {
+		message = bedrockErr.MessageUpper
+	}
+
+	return FormatAnthropicError(MapHTTPStatusToErrorType(statusCode), message), nil
+}
+
+// bedrockStreamEvent is a message of a Converse or InvokeModel stream, which sets one of the
+// event fields. Messages that set none are exceptions, named by their only key.
+type bedrockStreamEvent struct {
+	Chunk *struct {
+		Bytes []byte `json:"bytes"`
+	} `json:"chunk"`
+	MessageStart      *struct{}                 `json:"messageStart"`
+	ContentBlockStart *bedrockContentBlockStart `json:"contentBlockStart"`
+	ContentBlockDelta *bedrockContentBlockDelta `json:"contentBlockDelta"`
+	ContentBlockStop  *struct {
+		ContentBlockIndex int `json:"contentBlockIndex"`
+	} `json:"contentBlockStop"`
+	MessageStop *struct {
+		StopReason string `json:"stopReason"`
+	} `json:"messageStop"`
+	Metadata *struct {
+		Usage *bedrockUsage `json:"usage"`
+	} `json:"metadata"`
+}
+
+type bedrockContentBlockStart struct {
+	ContentBlockIndex int `json:"contentBlockIndex"`
+	Start             struct {
+		ToolUse *struct {
+			ToolUseID string `json:"toolUseId"`
+			Name      string `json:"name"`
+		} `json:"toolUse"`
+	} `json:"start"`
+}
+
+type bedrockContentBlockDelta struct {
+	ContentBlockIndex int `json:"contentBlockIndex"`
+	Delta             struct {
+		Text    *string `json:"text"`
+		ToolUse *struct {
+			Input string `json:"input"`
+		} `json:"toolUse"`
+		ReasoningContent *struct {
+			Text      *string `json:"text"`
+			Signature *string `json:"signature"`
+		} `json:"reasoningContent"`
+	} `json:"delta"`
+}
+
+// TransformStream converts one decoded event stream message into Anthropic SSE events
+func (p *BedrockProvider) TransformStream(chunk []byte, state *StreamState) ([]byte, error) {
+	var event bedrockStreamEvent
+	if err := json.Unmarshal(chunk, &event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Bedrock stream event: %w", err)
+	}
+
+	if state.ContentBlocks == nil {
+		state.ContentBlocks = make(map[int]*ContentBlockState)
+	}
+
+	switch {
+	case event.Chunk != nil:
+		return p.handleInvokeChunk(event.Chunk.Bytes)
+	case event.MessageStart != nil:
+		return p.handleMessageStart(state), nil
+	case event.ContentBlockStart != nil:
+		return p.handleContentBlockStart(event.ContentBlockStart, state), nil
+	case event.ContentBlockDelta != nil:
+		return p.handleContentBlockDelta(event.ContentBlockDelta, state), nil
+	case event.ContentBlockStop != nil:
+		return p.handleContentBlockStop(event.ContentBlockStop.ContentBlockIndex, state), nil
+	case event.MessageStop != nil:
+		return p.handleMessageStop(event.MessageStop.StopReason, state), nil
+	case event.Metadata != nil:
+		return p.handleMetadata(event.Metadata.Usage, state), nil
+	}
+
+	return p.handleStreamException(chunk), nil
+}
+
+// handleInvokeChunk unwraps an InvokeModel stream chunk, which carries a base64 encoded
+// Anthropic stream event
+func (p *BedrockProvider) handleInvokeChunk(data []byte) ([]byte, error) {
+	var event struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic event from Bedrock chunk: %w", err)
+	}
+
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Type, data)), nil
+}
+
+func (p *BedrockProvider) handleMessageStart(state *StreamState) []byte {
+	if state.MessageStartSent {
+		return nil
+	}
+
+	state.MessageID = fmt.Sprintf("msg_bedrock_%d", time.Now().UnixNano())
+	state.MessageStartSent = true
+
+	usage := map[string]any{
+		"input_tokens":  0,
+		"output_tokens": 0,
+	}
+
+	return FormatSSEEvent("message_start", CreateMessageStartEvent(state.MessageID, state.Model, usage))
+}
+
+func (p *BedrockProvider) handleContentBlockStart(event *bedrockContentBlockStart, state *StreamState) []byte {
+	toolUse := event.Start.ToolUse
+	if toolUse == nil {
+		return nil
+	}
+
+	state.ContentBlocks[event.ContentBlockIndex] = &ContentBlockState{
+		Type:       ContentTypeToolUse,
+		ToolCallID: toolUse.ToolUseID,
+		ToolName:   toolUse.Name,
+		StartSent:  true,
+	}
+
+	return contentBlockStartEvent(event.ContentBlockIndex, newToolUseBlock(ContentTypeToolUse, toolUse.ToolUseID, toolUse.Name))
+}
+
+func (p *BedrockProvider) handleContentBlockDelta(event *bedrockContentBlockDelta, state *StreamState) []byte {
+	index := event.ContentBlockIndex
+	delta := event.Delta
+	reasoning := delta.ReasoningContent
+
+	switch {
+	case delta.Text != nil:
+		state.Output.WriteString(*delta.Text)
+
+		events := p.ensureBlockStarted(index, ContentTypeText, state)
+
+		return append(events, contentBlockDeltaEvent(index, TextDelta{Type: "text_delta", Text: *delta.Text})...)
+	case delta.ToolUse != nil:
+		state.Output.WriteString(delta.ToolUse.Input)
+
+		return contentBlockDeltaEvent(index, InputJSONDelta{Type: "input_json_delta", PartialJSON: delta.ToolUse.Input})
+	case reasoning != nil && reasoning.Text != nil:
+		state.Output.WriteString(*reasoning.Text)
+
+		events := p.ensureBlockStarted(index, ContentTypeThinking, state)
+
+		return append(events, contentBlockDeltaEvent(index, ThinkingDelta{Type: "thinking_delta", Thinking: *reasoning.Text})...)
+	case reasoning != nil && reasoning.Signature != nil:
+		events := p.ensureBlockStarted(index, ContentTypeThinking, state)
+
+		return append(events, contentBlockDeltaEvent(index, SignatureDelta{Type: "signature_delta", Signature: *reasoning.Signature})...)
+	}
+
+	return nil
+}
+
+// ensureBlockStarted emits content_block_start for text and thinking blocks, which the
+// Converse stream starts implicitly with their first delta
+func (p *BedrockProvider) ensureBlockStarted(index int, blockType string, state *StreamState) []byte {
+	if block, exists := state.ContentBlocks[index]; exists && block.StartSent {
+		return nil
+	}
+
+	state.ContentBlocks[index] = &ContentBlockState{Type: blockType, StartSent: true}
+
+	if blockType == ContentTypeThinking {
+		return contentBlockStartEvent(index, newThinkingBlock())
+	}
+
+	return contentBlockStartEvent(index, newTextBlock())
+}
+
+func (p *BedrockProvider) handleContentBlockStop(index int, state *StreamState) []byte {
+	block, exists := state.ContentBlocks[index]
+	if !exists || !block.StartSent || block.StopSent {
+		return nil
+	}
+
+	block.StopSent = true
+
+	return contentBlockStopEvent(index)
+}
+
+// handleMessageStop closes the content blocks and holds message_delta, as the usage only
+// arrives in the metadata event that follows. When the stream ends without it, the proxy
+// sends the held event with estimated usage.
+func (p *BedrockProvider) handleMessageStop(stopReason string, state *StreamState) []byte {
+	return DeferFinishReason(p.convertStopReason(stopReason), nil, state)
+}
+
+func (p *BedrockProvider) handleMetadata(usage *bedrockUsage, state *StreamState) []byte {
+	if usage == nil {
+		return nil
+	}
+
+	return SendPendingMessageDelta(state, p.convertUsage(usage))
+}
+
+// handleStreamException converts an exception message, whose only key names the exception.
+// Bedrock names every exception with an "Exception" suffix; other keys are events this
+// translator doesn't know yet and are skipped rather than failing the stream.
+func (p *BedrockProvider) handleStreamException(chunk []byte) []byte {
+	var frame map[string]json.RawMessage
+	if err := json.Unmarshal(chunk, &frame); err != nil {
+		return nil
+	}
+
+	for key, payload := range frame {
+		if !strings.HasSuffix(key, "Exception") {
+			slog.Debug("Skipping unknown Bedrock stream event", "event", key)
+			continue
+		}
+
+		var exception struct {
+			Message string `json:"message"`
+		}
+
+		_ = json.Unmarshal(payload, &exception)
+
+		if exception.Message == "" {
+			exception.Message = key
+		}
+
+		return errorEvent(p.mapBedrockExceptionType(key), exception.Message)
+	}
+
+	return nil
+}
+
+func (p *BedrockProvider) mapBedrockExceptionType(exceptionType string) string {
+	mapping := map[string]string{
+		"throttlingException":         "rate_limit_error",
+		"validationException":         "invalid_request_error",
+		"serviceUnavailableException": "overloaded_error",
+		"accessDeniedException":       "permission_error",
+		"resourceNotFoundException":   "not_found_error",
+	}
+
+	if anthropicType, exists := mapping[exceptionType]; exists {
+		return anthropicType
+	}
+
+	return MessageTypeAPIError
+}
@@ -0,0 +1,428 @@
+package providers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeEventStreamMessage builds an application/vnd.amazon.eventstream message with
+// string headers, as sent by the Bedrock runtime
+func encodeEventStreamMessage(headers [][2]string, payload []byte) []byte {
+	var headerBytes bytes.Buffer
+
+	for _, header := range headers {
+		headerBytes.WriteByte(byte(len(header[0])))
+		headerBytes.WriteString(header[0])
+		headerBytes.WriteByte(7)
+		_ = binary.Write(&headerBytes, binary.BigEndian, uint16(len(header[1])))
+		headerBytes.WriteString(header[1])
+	}
+
+	totalLength := uint32(eventStreamPreludeLength + headerBytes.Len() + len(payload) + eventStreamCRCLength)
+
+	var message bytes.Buffer
+	_ = binary.Write(&message, binary.BigEndian, totalLength)
+	_ = binary.Write(&message, binary.BigEndian, uint32(headerBytes.Len()))
+	_ = binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	message.Write(headerBytes.Bytes())
+	message.Write(payload)
+	_ = binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+
+	return message.Bytes()
+}
+
+func encodeBedrockEvent(eventType, payload string) []byte {
+	return encodeEventStreamMessage([][2]string{
+		{":event-type", eventType},
+		{":content-type", "application/json"},
+		{":message-type", "event"},
+	}, []byte(payload))
+}
+
+func TestSignAWSRequest_VanillaVector(t *testing.T) {
+	// get-vanilla from the AWS SigV4 test suite
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+
+	creds := awsCredentials{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+
+	signAWSRequest(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t,
+		"AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func TestSignAWSRequest_SessionTokenAndEscapedPath(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock", Region: "us-west-2"})
+
+	req, err := http.NewRequest(http.MethodPost, provider.EndpointURL("anthropic.claude-3-5-sonnet-20241022-v2:0", false), nil)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	creds := awsCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret", SessionToken: "session"}
+	signAWSRequest(req, []byte(`{}`), creds, "us-west-2", "bedrock", time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	assert.Equal(t, "/model/anthropic.claude-3-5-sonnet-20241022-v2%3A0/invoke", req.URL.EscapedPath())
+	assert.Equal(t, "/model/anthropic.claude-3-5-sonnet-20241022-v2%253A0/invoke", awsCanonicalURI(req.URL), "path segments should be encoded twice")
+	assert.Equal(t, "session", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "Credential=AKID/20250102/us-west-2/bedrock/aws4_request")
+	assert.Contains(t, req.Header.Get("Authorization"), "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token")
+}
+
+func TestLoadAWSCredentials(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	err := os.WriteFile(credentialsFile, []byte(`[default]
+aws_access_key_id = DEFAULTKEY
+aws_secret_access_key = defaultsecret
+
+# work account
+[work]
+aws_access_key_id=WORKKEY
+aws_secret_access_key=worksecret
+aws_session_token=worktoken
+`), 0600)
+	require.NoError(t, err)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+
+	t.Setenv("AWS_PROFILE", "work")
+	creds, err := loadAWSCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, awsCredentials{AccessKeyID: "WORKKEY", SecretAccessKey: "worksecret", SessionToken: "worktoken"}, creds)
+
+	t.Setenv("AWS_PROFILE", "")
+	creds, err = loadAWSCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "DEFAULTKEY", creds.AccessKeyID)
+
+	t.Setenv("AWS_PROFILE", "missing")
+	_, err = loadAWSCredentials()
+	assert.Error(t, err)
+
+	// Environment variables take precedence over the credentials file
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	creds, err = loadAWSCredentials()
+	require.NoError(t, err)
+	assert.Equal(t, "ENVKEY", creds.AccessKeyID)
+}
+
+func TestEventStreamDecoder(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(encodeBedrockEvent("messageStart", `{"role":"assistant"}`))
+	stream.Write(encodeEventStreamMessage([][2]string{
+		{":exception-type", "throttlingException"},
+		{":message-type", "exception"},
+	}, []byte(`{"message":"Too many requests"}`)))
+
+	decoder := NewEventStreamDecoder(&stream)
+
+	message, err := decoder.Next()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"messageStart":{"role":"assistant"}}`, string(message))
+
+	message, err = decoder.Next()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"throttlingException":{"message":"Too many requests"}}`, string(message))
+
+	_, err = decoder.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestEventStreamDecoder_ChecksumMismatch(t *testing.T) {
+	message := encodeBedrockEvent("messageStart", `{"role":"assistant"}`)
+	message[len(message)-6] ^= 0xFF // Corrupt the payload
+
+	_, err := NewEventStreamDecoder(bytes.NewReader(message)).Next()
+	assert.ErrorContains(t, err, "checksum")
+}
+
+func TestBedrockProvider_EndpointURL(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock", Region: "eu-central-1"})
+
+	assert.Equal(t, "https://bedrock-runtime.eu-central-1.amazonaws.com/model/amazon.nova-pro-v1%3A0/converse",
+		provider.EndpointURL("amazon.nova-pro-v1:0", false))
+	assert.Equal(t, "https://bedrock-runtime.eu-central-1.amazonaws.com/model/amazon.nova-pro-v1%3A0/converse-stream",
+		provider.EndpointURL("amazon.nova-pro-v1:0", true))
+	assert.Equal(t, "https://bedrock-runtime.eu-central-1.amazonaws.com/model/eu.anthropic.claude-3-7-sonnet-20250219-v1%3A0/invoke-with-response-stream",
+		provider.EndpointURL("eu.anthropic.claude-3-7-sonnet-20250219-v1:0", true))
+
+	custom := NewBedrockProvider(&config.Provider{Name: "bedrock", APIBase: "http://localhost:9000/"})
+	assert.Equal(t, "http://localhost:9000/model/anthropic.claude-v2/invoke", custom.EndpointURL("anthropic.claude-v2", false))
+}
+
+func TestBedrockProvider_TransformRequest_InvokeModel(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+
+	request := `{"model":"anthropic.claude-3-5-sonnet-20241022-v2:0","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"anthropic_version":"bedrock-2023-05-31","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`, string(result))
+}
+
+func TestBedrockProvider_TransformRequest_Converse(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+
+	request := map[string]any{
+		"model":          "amazon.nova-pro-v1:0",
+		"stream":         true,
+		"system":         []any{map[string]any{"type": "text", "text": "Be brief"}},
+		"max_tokens":     512,
+		"temperature":    0.5,
+		"top_k":          40,
+		"stop_sequences": []any{"END"},
+		"messages": []any{
+			map[string]any{"role": "user", "content": "What's the weather?"},
+			map[string]any{"role": "assistant", "content": []any{
+				map[string]any{"type": "thinking", "thinking": "Use the tool", "signature": "c2lnbmF0dXJl"},
+				map[string]any{"type": "redacted_thinking", "data": "cmVkYWN0ZWQ="},
+				map[string]any{"type": "text", "text": "Checking"},
+				map[string]any{"type": "tool_use", "id": "tooluse_1", "name": "get_weather", "input": map[string]any{"city": "Paris"}},
+			}},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "tool_result", "tool_use_id": "tooluse_1", "content": "Sunny", "is_error": false},
+				map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "aW1n"}},
+			}},
+		},
+		"tools": []any{
+			map[string]any{"name": "get_weather", "description": "Get weather", "input_schema": map[string]any{"type": "object"}},
+			map[string]any{"type": "web_search_20250305", "name": "web_search"},
+		},
+		"tool_choice": map[string]any{"type": "tool", "name": "get_weather"},
+	}
+
+	requestBytes, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	result, err := provider.TransformRequest(requestBytes)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"system": [{"text": "Be brief"}],
+		"inferenceConfig": {"maxTokens": 512, "temperature": 0.5, "stopSequences": ["END"]},
+		"additionalModelRequestFields": {"top_k": 40},
+		"messages": [
+			{"role": "user", "content": [{"text": "What's the weather?"}]},
+			{"role": "assistant", "content": [
+				{"text": "Checking"},
+				{"toolUse": {"toolUseId": "tooluse_1", "name": "get_weather", "input": {"city": "Paris"}}}
+			]},
+			{"role": "user", "content": [
+				{"toolResult": {"toolUseId": "tooluse_1", "content": [{"text": "Sunny"}]}},
+				{"image": {"format": "png", "source": {"bytes": "aW1n"}}}
+			]}
+		],
+		"toolConfig": {
+			"tools": [{"toolSpec": {"name": "get_weather", "description": "Get weather", "inputSchema": {"json": {"type": "object"}}}}],
+			"toolChoice": {"tool": {"name": "get_weather"}}
+		}
+	}`, string(result))
+}
+
+func TestBedrockProvider_TransformRequest_ConverseImageURL(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+
+	request := `{"model":"amazon.nova-pro-v1:0","messages":[{"role":"user","content":[
+		{"type":"image","source":{"type":"url","url":"https://example.com/cat.png"}}
+	]}]}`
+
+	_, err := provider.TransformRequest([]byte(request))
+
+	var requestErr *RequestError
+	require.ErrorAs(t, err, &requestErr)
+	assert.Contains(t, requestErr.Error(), "image URLs")
+}
+
+func TestBedrockProvider_TransformResponse(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+
+	converseResponse := `{
+		"output": {"message": {"role": "assistant", "content": [
+			{"text": "Let me check."},
+			{"toolUse": {"toolUseId": "tooluse_abc", "name": "get_weather", "input": {"city": "Paris"}}}
+		]}},
+		"stopReason": "tool_use",
+		"usage": {"inputTokens": 30, "outputTokens": 12, "totalTokens": 42, "cacheReadInputTokens": 5},
+		"metrics": {"latencyMs": 300}
+	}`
+
+	result, err := provider.TransformModelResponse("amazon.nova-pro-v1:0", []byte(converseResponse))
+	require.NoError(t, err)
+
+	var response map[string]any
+	require.NoError(t, json.Unmarshal(result, &response))
+
+	assert.Equal(t, "message", response["type"])
+	assert.Equal(t, "amazon.nova-pro-v1:0", response["model"])
+	assert.Equal(t, "assistant", response["role"])
+	assert.Equal(t, "tool_use", response["stop_reason"])
+	assert.True(t, strings.HasPrefix(response["id"].(string), "msg_bedrock_"))
+
+	content, ok := response["content"].([]any)
+	require.True(t, ok)
+	require.Len(t, content, 2)
+	assert.Equal(t, map[string]any{"type": "text", "text": "Let me check."}, content[0])
+	assert.Equal(t, map[string]any{"type": "tool_use", "id": "tooluse_abc", "name": "get_weather", "input": map[string]any{"city": "Paris"}}, content[1])
+
+	assert.Equal(t, map[string]any{"input_tokens": float64(30), "output_tokens": float64(12), "cache_read_input_tokens": float64(5)}, response["usage"])
+
+	// InvokeModel responses are already Anthropic messages
+	anthropicResponse := `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"Hi"}],"stop_reason":"end_turn"}`
+	result, err = provider.TransformResponse([]byte(anthropicResponse))
+	require.NoError(t, err)
+	assert.Equal(t, anthropicResponse, string(result))
+}
+
+func TestBedrockProvider_TransformStream_Converse(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+
+	var stream bytes.Buffer
+	stream.Write(encodeBedrockEvent("messageStart", `{"p":"abc","role":"assistant"}`))
+	stream.Write(encodeBedrockEvent("contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"Hello"}}`))
+	stream.Write(encodeBedrockEvent("contentBlockStop", `{"contentBlockIndex":0}`))
+	stream.Write(encodeBedrockEvent("contentBlockStart", `{"contentBlockIndex":1,"start":{"toolUse":{"toolUseId":"tooluse_1","name":"get_weather"}}}`))
+	stream.Write(encodeBedrockEvent("contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"{\"city\":"}}}`))
+	stream.Write(encodeBedrockEvent("contentBlockDelta", `{"contentBlockIndex":1,"delta":{"toolUse":{"input":"\"Paris\"}"}}}`))
+	stream.Write(encodeBedrockEvent("contentBlockStop", `{"contentBlockIndex":1}`))
+	stream.Write(encodeBedrockEvent("messageStop", `{"stopReason":"tool_use"}`))
+	stream.Write(encodeBedrockEvent("metadata", `{"usage":{"inputTokens":20,"outputTokens":8,"totalTokens":28},"metrics":{"latencyMs":100}}`))
+
+	decoder := provider.NewStreamDecoder(&stream)
+	state := &StreamState{}
+
+	var output strings.Builder
+
+	for {
+		message, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		events, err := provider.TransformStream(message, state)
+		require.NoError(t, err)
+		output.Write(events)
+	}
+
+	result := output.String()
+
+	expectedOrder := []string{
+		"event: message_start",
+		`"content_block":{"text":"","type":"text"}`,
+		`"delta":{"text":"Hello","type":"text_delta"}`,
+		`event: content_block_stop` + "\n" + `data: {"index":0,"type":"content_block_stop"}`,
+		`"content_block":{"id":"tooluse_1","input":{},"name":"get_weather","type":"tool_use"}`,
+		`"partial_json":"{\"city\":"`,
+		`"partial_json":"\"Paris\"}"`,
+		`data: {"index":1,"type":"content_block_stop"}`,
+		`"delta":{"stop_reason":"tool_use","stop_sequence":null}`,
+		`"usage":{"input_tokens":20,"output_tokens":8}`,
+		"event: message_stop",
+	}
+
+	position := 0
+
+	for _, expected := range expectedOrder {
+		index := strings.Index(result[position:], expected)
+		require.GreaterOrEqual(t, index, 0, "expected %q after position %d in:\n%s", expected, position, result)
+		position += index + len(expected)
+	}
+}
+
+func TestBedrockProvider_TransformStream_MissingMetadata(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+	state := &StreamState{}
+
+	var output strings.Builder
+
+	for _, message := range []string{
+		`{"messageStart":{"role":"assistant"}}`,
+		`{"contentBlockDelta":{"contentBlockIndex":0,"delta":{"text":"Hello there"}}}`,
+		`{"contentBlockStop":{"contentBlockIndex":0}}`,
+		`{"messageStop":{"stopReason":"end_turn"}}`,
+	} {
+		events, err := provider.TransformStream([]byte(message), state)
+		require.NoError(t, err)
+		output.Write(events)
+	}
+
+	assert.NotContains(t, output.String(), "message_delta")
+	require.NotNil(t, state.PendingMessageDelta)
+
+	events := string(FinishStream(state, 12, func(text string) int { return len(strings.Fields(text)) }))
+	assert.Contains(t, events, `"delta":{"stop_reason":"end_turn","stop_sequence":null}`)
+	assert.Contains(t, events, `"usage":{"input_tokens":12,"output_tokens":2}`)
+	assert.Contains(t, events, "event: message_stop")
+}
+
+func TestBedrockProvider_TransformStream_InvokeChunk(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+
+	anthropicEvent := `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`
+	chunk := `{"chunk":{"bytes":"` + base64.StdEncoding.EncodeToString([]byte(anthropicEvent)) + `"}}`
+
+	events, err := provider.TransformStream([]byte(chunk), &StreamState{})
+	require.NoError(t, err)
+	assert.Equal(t, "event: content_block_delta\ndata: "+anthropicEvent+"\n\n", string(events))
+}
+
+func TestBedrockProvider_TransformStream_Exception(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+
+	events, err := provider.TransformStream([]byte(`{"throttlingException":{"message":"Too many tokens"}}`), &StreamState{})
+	require.NoError(t, err)
+	assert.Equal(t, "event: error\ndata: {\"error\":{\"message\":\"Too many tokens\",\"type\":\"rate_limit_error\"},\"type\":\"error\"}\n\n", string(events))
+
+	// Events this translator doesn't know are not exceptions and must not fail the stream
+	events, err = provider.TransformStream([]byte(`{"guardrailTrace":{"action":"NONE"}}`), &StreamState{})
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
+
+func TestBedrockProvider_TransformError(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+
+	result, err := provider.TransformError(http.StatusBadRequest, []byte(`{"message":"The provided model identifier is invalid."}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"invalid_request_error","message":"The provided model identifier is invalid."}}`, string(result))
+
+	result, err = provider.TransformError(http.StatusForbidden, []byte(`{"Message":"User is not authorized"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"permission_error","message":"User is not authorized"}}`, string(result))
+}
+
+// TestBedrockProvider_GoldenStreams replays the recorded stream chunks in testdata/bedrock
+func TestBedrockProvider_GoldenStreams(t *testing.T) {
+	provider := NewBedrockProvider(&config.Provider{Name: "bedrock"})
+	testGoldenStreams(t, "bedrock", map[string]Provider{"bedrock": provider}, provider)
+}
+
+// BenchmarkBedrockProvider_TransformStream converts the recorded streams, reporting the
+// allocations per chunk
+func BenchmarkBedrockProvider_TransformStream(b *testing.B) {
+	benchmarkStreams(b, "bedrock", NewBedrockProvider(&config.Provider{Name: "bedrock"}))
+}
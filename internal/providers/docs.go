@@ -22,18 +22,22 @@ All providers must implement the Provider interface:
 		TransformStream(chunk []byte, state *StreamState) ([]byte, error)
 		IsStreaming(headers map[string][]string) bool
 		GetEndpoint() string
-		SetAPIKey(key string)
+		GetAPIKey() string
+		NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error)
 	}
 
 ## Core Concepts
 
 ### Request Flow
-1. Client sends Claude-format request
-2. Router selects provider based on model name
-3. **Provider transforms request**: Claude format → Provider format using `TransformRequest()`
-4. HTTP request sent to provider
-5. **Provider transforms response**: Provider format → Claude format using `TransformResponse()`
-6. Response sent back to client
+ 1. Client sends Claude-format request
+ 2. Router selects provider based on model name
+ 3. **Provider transforms request**: Claude format → Provider format using `TransformRequest()`
+ 4. **Provider builds the upstream request** with `NewRequest()`: URL, authentication and
+    required headers. Steps 3 and 4 are repeated for each retry the provider's retry policy
+    allows and then, when the upstream fails with 429, a 5xx status or a connection error,
+    for the next target of the router's fallback chain
+ 5. **Provider transforms response**: Provider format → Claude format using `TransformResponse()`
+ 6. Response sent back to client
 
 ### Content Formats
 
@@ -47,7 +51,7 @@ All providers must implement the Provider interface:
 - **OpenAI/OpenRouter**: Messages with tool_calls arrays
 - **OpenAI/OpenRouter Tool Schema**: Objects with type: "function", function: {name, description, parameters}
 - **Different field names**: content vs message, input vs arguments, input_schema vs parameters, etc.
-- **Web Search**: OpenRouter annotations for search results
+- **Web Search**: OpenRouter url_citation annotations and Gemini grounding metadata for search results
 - **Enhanced Usage**: Server tool use metrics, cache information
 
 ### Request Transformation (Provider Interface)
@@ -89,9 +93,58 @@ Each provider implements bidirectional transformations between Claude and provid
 #### Tool Choice Validation
 - **Removes** `tool_choice` when no tools are provided
 - **Removes** `tool_choice` when tools array is empty or null
-- **Preserves** `tool_choice` when valid tools are present
+- **Translates** `tool_choice` when valid tools are present (see below)
 - **Prevents** "tool_choice may only be specified while providing tools" errors
 
+#### Tool Choice Translation
+| Anthropic | OpenAI | Gemini `toolConfig.functionCallingConfig` |
+|-----------|--------|--------------------------------------------|
+| `{"type": "auto"}` | `"auto"` | `{"mode": "AUTO"}` |
+| `{"type": "any"}` | `"required"` | `{"mode": "ANY"}` |
+| `{"type": "tool", "name": "x"}` | `{"type": "function", "function": {"name": "x"}}` | `{"mode": "ANY", "allowedFunctionNames": ["x"]}` |
+| `{"type": "none"}` | `"none"` | `{"mode": "NONE"}` |
+| `"disable_parallel_tool_use": true` | `"parallel_tool_calls": false` | not supported |
+
+#### Web Search Emulation
+The `web_search_20250305` server tool is taken out of the tools and mapped to the upstream's own search:
+- **OpenRouter**: `"plugins": [{"id": "web"}]`, unless the model already ends in `:online`
+- **Gemini**: a `{"googleSearch": {}}` tool next to the function declarations
+- **Others**: the tool is dropped
+
+The pages and citations that come back are returned as a `server_tool_use` block, a `web_search_tool_result`
+block and text blocks with `web_search_result_location` citations. When streaming, the citations are sent as
+`citations_delta` events on the text block and the search blocks follow it.
+
+## OpenAI-Compatible Vendors
+
+Vendors of the OpenAI chat completions API share one translator, `OpenAIEngine` in `openaiengine.go`, for
+requests, responses and streams. Where a vendor differs from OpenAI the engine asks the vendor's `OpenAIDialect`
+hooks: error type mapping, stop reason and usage mapping, the reasoning option for extended thinking, the web
+search mapping and extra request fields. A new vendor embeds the engine and `openAIDefaults`, and only overrides
+the hooks that differ:
+
+	type ExampleProvider struct {
+		*OpenAIEngine
+		openAIDefaults
+	}
+
+	func NewExampleProvider(provider *config.Provider) *ExampleProvider {
+		p := &ExampleProvider{}
+		p.OpenAIEngine = newOpenAIEngine(provider, "Example", p)
+
+		return p
+	}
+
+	// applyExtraFields adds the vendor's own request fields
+	func (p *ExampleProvider) applyExtraFields(request map[string]any) {
+		request["safe_mode"] = true
+	}
+
+A different URL or authentication is set up by overriding `GetEndpoint` and `GetAPIKey`. Every vendor must
+produce the events recorded in `testdata/openai/*.golden` for the chunks next to them, add it to
+`openAIDialectProviders` in `openaiengine_test.go`. Run `go test ./internal/providers -run GoldenStreams -update`
+to rewrite the golden files after an intended change of the stream output.
+
 ## Implementation Steps
 
 ### Important Note: Request vs Response Transformation
@@ -136,11 +189,35 @@ Create a new provider struct and implement basic methods:
 		return p.endpoint
 	}
 
-	func (p *NewProvider) SetAPIKey(key string) {
-		p.apiKey = key
+	func (p *NewProvider) GetAPIKey() string {
+		return p.apiKey
 	}
 
-### 2. Streaming Detection
+### 2. Upstream Requests
+
+NewRequest builds the request the proxy sends. The model comes without the provider
+prefix, so providers that address models in the URL can use it directly. newUpstreamRequest
+copies the client's headers and the body, the provider then adds its authentication:
+
+	func (p *NewProvider) NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error) {
+		req, err := newUpstreamRequest(ctx, p.GetEndpoint(), upstream)
+		if err != nil {
+			return nil, err
+		}
+
+		setBearerAuth(req, upstream.apiKey(p.GetAPIKey))
+
+		return req, nil
+	}
+
+upstream.apiKey returns the key the proxy picked for the request, or the provider's next key
+when it picked none. The proxy reports how each request went with its key, so failing keys
+leave the rotation.
+
+Signed schemes work the same way: Bedrock signs the finished request with SigV4 and
+Vertex AI adds an OAuth access token.
+
+### 3. Streaming Detection
 
 Implement IsStreaming to detect if a response is streamed:
 
@@ -156,7 +233,7 @@ Implement IsStreaming to detect if a response is streamed:
 		return false
 	}
 
-### 3. Request Transformation
+### 4. Request Transformation
 
 Implement TransformRequest for converting Claude requests to provider format:
 
@@ -174,7 +251,7 @@ Implement TransformRequest for converting Claude requests to provider format:
 
 Note: This method transforms Claude requests TO provider format.
 
-### 4. Response Transformation
+### 5. Response Transformation
 
 Implement TransformResponse for complete responses (Provider → Claude format):
 
@@ -192,7 +269,7 @@ Implement TransformResponse for complete responses (Provider → Claude format):
 
 Note: This method transforms provider responses TO Claude format.
 
-### 5. Streaming Response Transformation
+### 6. Streaming Response Transformation
 
 Implement TransformStream for real-time chunk processing (Provider → Claude format):
 
@@ -223,12 +300,15 @@ transformation (including tool schema) is handled by the TransformRequest method
 The StreamState tracks streaming conversion across multiple chunks:
 
 	type StreamState struct {
-		MessageStartSent  bool
-		MessageID         string
-		Model             string
-		InitialUsage      map[string]any
-		ContentBlocks     map[int]*ContentBlockState
-		CurrentIndex      int
+		MessageStartSent    bool
+		MessageID           string
+		Model               string
+		InitialUsage        map[string]any
+		StopReason          string
+		PendingMessageDelta *MessageDeltaEvent
+		Output              strings.Builder
+		ContentBlocks       map[int]*ContentBlockState
+		CurrentIndex        int
 	}
 
 	type ContentBlockState struct {
@@ -242,130 +322,55 @@ The StreamState tracks streaming conversion across multiple chunks:
 	}
 
 Key principles:
-- **Initialize** ContentBlocks map on first use
-- **Track** multiple content blocks by index
-- **Manage** start/stop events per content block
-- **Accumulate** partial data (like tool arguments)
-- **Handle** multiple tool calls in single response
-- **Generate** proper input_json_delta events for tool arguments
+  - **Initialize** ContentBlocks map on first use
+  - **Track** multiple content blocks by index
+  - **Manage** start/stop events per content block
+  - **Accumulate** partial data (like tool arguments)
+  - **Handle** multiple tool calls in single response
+  - **Generate** proper input_json_delta events for tool arguments
+  - **Defer** message_delta on OpenAI-style streams: the usage requested with
+    stream_options.include_usage arrives after the finish reason. DeferFinishReason holds the
+    event, SendPendingMessageDelta sends it with the usage, and the proxy calls FinishStream at
+    the end of the stream to send it with a local tiktoken estimate when no usage came
 
 ### Content Block Types
 
 #### Text Content Blocks
-For streaming text responses:
-
-	// Check for text content
-	if content, ok := delta["content"].(string); ok && content != "" {
-		// Get or create text content block
-		if _, exists := state.ContentBlocks[state.CurrentIndex]; !exists {
-			state.ContentBlocks[state.CurrentIndex] = &ContentBlockState{
-				Type: "text",
-			}
-		}
+Events are built from the typed Messages API structs in anthropicapi.go rather than maps.
+Their fields are declared in the alphabetical order of the JSON names, which keeps the
+encoded events identical to what maps produced and saves the allocations of building them:
 
-		contentBlock := state.ContentBlocks[state.CurrentIndex]
-
-		// Send content_block_start if needed
-		if !contentBlock.StartSent {
-			startEvent := map[string]any{
-				"type":  "content_block_start",
-				"index": state.CurrentIndex,
-				"content_block": map[string]any{
-					"type": "text",
-					"text": "",
-				},
-			}
-			events = append(events, formatSSEEvent("content_block_start", startEvent)...)
-			contentBlock.StartSent = true
-		}
+	if delta.Content != "" {
+		block := state.ContentBlocks[state.CurrentIndex]
 
-		// Send content_block_delta
-		deltaEvent := map[string]any{
-			"type":  "content_block_delta",
-			"index": state.CurrentIndex,
-			"delta": map[string]any{
-				"type": "text_delta",
-				"text": content,
-			},
+		if !block.StartSent {
+			events = append(events, contentBlockStartEvent(state.CurrentIndex, newTextBlock())...)
+			block.StartSent = true
 		}
-		events = append(events, formatSSEEvent("content_block_delta", deltaEvent)...)
+
+		events = append(events, contentBlockDeltaEvent(state.CurrentIndex, TextDelta{
+			Type: "text_delta",
+			Text: delta.Content,
+		})...)
 	}
 
 #### Tool Use Content Blocks
-For streaming tool calls:
-
-	// Check for tool calls
-	if toolCalls, ok := delta["tool_calls"].([]any); ok {
-		for _, toolCall := range toolCalls {
-			if tcMap, ok := toolCall.(map[string]any); ok {
-				// Process individual tool call
-				toolCallID, _ := tcMap["id"].(string)
-
-				// Find or create content block for this tool call
-				var contentBlockIndex int = -1
-				for idx, block := range state.ContentBlocks {
-					if block.Type == "tool_use" && block.ToolCallID == toolCallID {
-						contentBlockIndex = idx
-						break
-					}
-				}
-
-				if contentBlockIndex == -1 {
-					state.CurrentIndex++
-					contentBlockIndex = state.CurrentIndex
-					state.ContentBlocks[contentBlockIndex] = &ContentBlockState{
-						Type:       "tool_use",
-						ToolCallID: toolCallID,
-						// ... other fields
-					}
-				}
+Tool call fragments are matched to their block by the upstream index, then by ID. The
+arguments accumulate in the block state; an upstream that resends the whole accumulation
+only has the new suffix forwarded:
+
+	if !block.StartSent && block.ToolCallID != "" && block.ToolName != "" {
+		events = append(events, contentBlockStartEvent(index,
+			newToolUseBlock(ContentTypeToolUse, toolUseID, block.ToolName))...)
+		block.StartSent = true
+	}
 
-				// Send content_block_start for tool_use if not sent
-				if !contentBlock.StartSent {
-					claudeToolID := "toolu_" + strings.TrimPrefix(toolCallID, "call_")
-					startEvent := map[string]any{
-						"type":  "content_block_start",
-						"index": contentBlockIndex,
-						"content_block": map[string]any{
-							"type":  "tool_use",
-							"id":    claudeToolID,
-							"name":  functionName,
-							"input": map[string]any{},
-						},
-					}
-					events = append(events, formatSSEEvent("content_block_start", startEvent)...)
-					contentBlock.StartSent = true
-				}
+	events = append(events, contentBlockDeltaEvent(index, InputJSONDelta{
+		Type:        "input_json_delta",
+		PartialJSON: newPart,
+	})...)
 
-				// Send input_json_delta for streaming arguments
-				// Handle both incremental and non-incremental argument updates
-				if arguments != "" && arguments != contentBlock.Arguments {
-					var newPart string
-
-					// Check if arguments are incremental (common case)
-					if len(arguments) > len(contentBlock.Arguments) &&
-					   strings.HasPrefix(arguments, contentBlock.Arguments) {
-						newPart = arguments[len(contentBlock.Arguments):]
-					} else {
-						// Non-incremental update - send entire new part
-						newPart = arguments
-					}
-
-					contentBlock.Arguments = arguments
-
-					deltaEvent := map[string]any{
-						"type":  "content_block_delta",
-						"index": contentBlockIndex,
-						"delta": map[string]any{
-							"type":         "input_json_delta",
-							"partial_json": newPart,
-						},
-					}
-					events = append(events, formatSSEEvent("content_block_delta", deltaEvent)...)
-				}
-			}
-		}
-	}
+Anthropic upstreams skip all of this: their streams are copied to the client byte for byte.
 
 ### Claude Streaming Event Format
 
@@ -421,12 +426,12 @@ Generate events in Claude's expected format:
 
 **Tool Choice Validation:**
 - `tool_choice` removed if `tools` is missing, null, or empty array
-- `tool_choice` preserved if valid `tools` array is provided
+- `tool_choice` translated to the OpenAI form if valid `tools` array is provided
 
 **Usage/Tokens:**
-- `usage.prompt_tokens` → `usage.input_tokens`
+- `usage.prompt_tokens` → `usage.input_tokens`, minus the cached tokens Anthropic reports apart
 - `usage.completion_tokens` → `usage.output_tokens`
-- `usage.prompt_tokens_details.cached_tokens` → `usage.cache_read_input_tokens`
+- `usage.prompt_tokens_details.cached_tokens` (or DeepSeek's `prompt_cache_hit_tokens`) → `usage.cache_read_input_tokens`
 - `usage.cache_creation_input_tokens` → `usage.cache_creation_input_tokens` (preserved)
 - `usage.server_tool_use.web_search_requests` → `usage.server_tool_use.web_search_requests` (preserved)
 
@@ -535,16 +540,16 @@ Add domain mapping for automatic provider selection:
 See existing implementations for detailed examples:
 
 ### Provider Examples (Request and Response Transformation)
-- **OpenRouter** (`openrouter.go`): Full implementation with bidirectional transformation and tool calling
-- **OpenAI** (`openai.go`): Similar to OpenRouter with minor differences
+- **OpenAI engine** (`openaiengine.go`): Bidirectional transformation and tool calling for OpenAI-compatible vendors
+- **OpenRouter** (`openrouter.go`): Engine dialect with unified reasoning, the web plugin and server tool usage
+- **OpenAI** (`openai.go`), **Nvidia** (`nvidia.go`), **Azure** (`azure.go`): Engine dialects with minor variations
 - **Gemini** (`gemini.go`): Different API format requiring custom transformation
-- **Nvidia** (`nvidia.go`): OpenAI-compatible format with minor variations
 - **Anthropic** (`anthropic.go`): Pass-through implementation for requests, identity transformation
 
-The OpenRouter provider is the most complete reference implementation,
-including comprehensive streaming tool call support, web search annotations,
-enhanced usage information handling with server tool use metrics, and full
-bidirectional transformation between Claude and OpenAI formats.
+The OpenAI engine is the most complete reference implementation, including
+comprehensive streaming tool call support, web search annotations, deferred
+usage reporting, and full bidirectional transformation between Claude and
+OpenAI formats.
 
 ## Common Issues and Solutions
 
@@ -0,0 +1,172 @@
+package providers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// ContentTypeAmazonEventStream is the binary framing used by AWS streaming APIs
+	ContentTypeAmazonEventStream = "application/vnd.amazon.eventstream"
+
+	eventStreamPreludeLength = 12
+	eventStreamCRCLength     = 4
+	eventStreamMaxMessage    = 16 * 1024 * 1024
+)
+
+// eventStreamDecoder reads application/vnd.amazon.eventstream messages. Every message is
+// returned as a single-key JSON object, {"<event or exception type>": <payload>}, so the
+// provider can dispatch on the key the same way it would for a JSON union.
+type eventStreamDecoder struct {
+	reader io.Reader
+}
+
+// NewEventStreamDecoder creates a decoder for the AWS event stream framing
+func NewEventStreamDecoder(reader io.Reader) StreamDecoder {
+	return &eventStreamDecoder{reader: reader}
+}
+
+func (d *eventStreamDecoder) Next() ([]byte, error) {
+	prelude := make([]byte, eventStreamPreludeLength)
+	if _, err := io.ReadFull(d.reader, prelude); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		return nil, fmt.Errorf("read event stream prelude: %w", err)
+	}
+
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, errors.New("event stream prelude checksum mismatch")
+	}
+
+	minLength := uint32(eventStreamPreludeLength + eventStreamCRCLength)
+	if totalLength < minLength || totalLength > eventStreamMaxMessage || headersLength > totalLength-minLength {
+		return nil, fmt.Errorf("invalid event stream message length %d", totalLength)
+	}
+
+	message := make([]byte, totalLength)
+	copy(message, prelude)
+
+	if _, err := io.ReadFull(d.reader, message[eventStreamPreludeLength:]); err != nil {
+		return nil, fmt.Errorf("read event stream message: %w", err)
+	}
+
+	crcOffset := totalLength - eventStreamCRCLength
+	if crc32.ChecksumIEEE(message[:crcOffset]) != binary.BigEndian.Uint32(message[crcOffset:]) {
+		return nil, errors.New("event stream message checksum mismatch")
+	}
+
+	headersEnd := eventStreamPreludeLength + headersLength
+
+	headers, err := parseEventStreamHeaders(message[eventStreamPreludeLength:headersEnd])
+	if err != nil {
+		return nil, err
+	}
+
+	payload := message[headersEnd:crcOffset]
+
+	var key string
+
+	switch headers[":message-type"] {
+	case "event":
+		key = headers[":event-type"]
+	case "exception":
+		key = headers[":exception-type"]
+	case "error":
+		// Errors carry their details in headers instead of the payload
+		key = headers[":error-code"]
+
+		payload, err = json.Marshal(map[string]string{"message": headers[":error-message"]})
+		if err != nil {
+			return nil, fmt.Errorf("marshal event stream error: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown event stream message type %q", headers[":message-type"])
+	}
+
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+
+	keyJSON, err := json.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("marshal event stream key: %w", err)
+	}
+
+	frame := make([]byte, 0, len(keyJSON)+len(payload)+3)
+	frame = append(frame, '{')
+	frame = append(frame, keyJSON...)
+	frame = append(frame, ':')
+	frame = append(frame, payload...)
+	frame = append(frame, '}')
+
+	return frame, nil
+}
+
+// parseEventStreamHeaders decodes the header block. Only string headers are kept, the
+// other value types are skipped since none of them are needed for dispatching.
+func parseEventStreamHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	errTruncated := errors.New("truncated event stream header")
+
+	for offset := 0; offset < len(data); {
+		nameLength := int(data[offset])
+		offset++
+
+		if offset+nameLength+1 > len(data) {
+			return nil, errTruncated
+		}
+
+		name := string(data[offset : offset+nameLength])
+		offset += nameLength
+
+		valueType := data[offset]
+		offset++
+
+		var valueLength int
+
+		switch valueType {
+		case 0, 1: // bool true, bool false
+			valueLength = 0
+		case 2: // byte
+			valueLength = 1
+		case 3: // short
+			valueLength = 2
+		case 4: // int
+			valueLength = 4
+		case 5, 8: // long, timestamp
+			valueLength = 8
+		case 9: // uuid
+			valueLength = 16
+		case 6, 7: // byte array, string
+			if offset+2 > len(data) {
+				return nil, errTruncated
+			}
+
+			valueLength = int(binary.BigEndian.Uint16(data[offset : offset+2]))
+			offset += 2
+		default:
+			return nil, fmt.Errorf("unknown event stream header type %d", valueType)
+		}
+
+		if offset+valueLength > len(data) {
+			return nil, errTruncated
+		}
+
+		if valueType == 7 {
+			headers[name] = string(data[offset : offset+valueLength])
+		}
+
+		offset += valueLength
+	}
+
+	return headers, nil
+}
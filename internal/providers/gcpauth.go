@@ -0,0 +1,274 @@
+package providers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGoogleTokenURL is the OAuth token endpoint for Google service accounts
+	DefaultGoogleTokenURL = "https://oauth2.googleapis.com/token"
+
+	googleCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+	googleJWTBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	googleTokenLifetime      = time.Hour
+
+	// Tokens are refreshed this long before they expire to absorb clock skew and latency
+	googleTokenRefreshMargin = time.Minute
+
+	// googleTokenTimeout bounds a token request, which is shared by every waiting caller
+	googleTokenTimeout = 30 * time.Second
+)
+
+// googleServiceAccount holds the fields of a service account JSON key that are needed
+// for the JWT-bearer flow
+type googleServiceAccount struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+func loadGoogleServiceAccount(path string) (*googleServiceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read service account key: %w", err)
+	}
+
+	var account googleServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("unmarshal service account key: %w", err)
+	}
+
+	if account.Type != "service_account" {
+		return nil, fmt.Errorf("credentials file has type %q, expected service_account", account.Type)
+	}
+
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, errors.New("service account key is missing client_email or private_key")
+	}
+
+	return &account, nil
+}
+
+// googleTokenSource mints OAuth access tokens for a service account and caches them
+// until shortly before they expire
+type googleTokenSource struct {
+	account  *googleServiceAccount
+	tokenURL string
+	client   *http.Client
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+	fetch     *googleTokenFetch
+}
+
+// googleTokenFetch is an access token request shared by every caller that needs a
+// token while it is in flight
+type googleTokenFetch struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+func newGoogleTokenSource(account *googleServiceAccount, tokenURL string) *googleTokenSource {
+	if tokenURL == "" {
+		tokenURL = account.TokenURI
+	}
+
+	if tokenURL == "" {
+		tokenURL = DefaultGoogleTokenURL
+	}
+
+	return &googleTokenSource{
+		account:  account,
+		tokenURL: tokenURL,
+		client:   &http.Client{Timeout: googleTokenTimeout},
+	}
+}
+
+// Token returns a cached access token or fetches a new one. Concurrent callers share a
+// single fetch, which runs without the lock held and is not cancelled when the caller
+// that started it gives up; each caller only waits as long as its own context allows.
+func (s *googleTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+
+	if s.token != "" && time.Now().Before(s.expiresAt.Add(-googleTokenRefreshMargin)) {
+		token := s.token
+		s.mu.Unlock()
+
+		return token, nil
+	}
+
+	fetch := s.fetch
+	if fetch == nil {
+		fetch = &googleTokenFetch{done: make(chan struct{})}
+		s.fetch = fetch
+
+		go s.refresh(context.WithoutCancel(ctx), fetch)
+	}
+
+	s.mu.Unlock()
+
+	select {
+	case <-fetch.done:
+		return fetch.token, fetch.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// refresh requests a new access token, caches it on success and releases the callers
+// waiting on fetch
+func (s *googleTokenSource) refresh(ctx context.Context, fetch *googleTokenFetch) {
+	now := time.Now()
+	token, expiresIn, err := s.requestToken(ctx, now)
+
+	s.mu.Lock()
+	if err == nil {
+		s.token = token
+		s.expiresAt = now.Add(expiresIn)
+	}
+	s.fetch = nil
+	s.mu.Unlock()
+
+	fetch.token, fetch.err = token, err
+	close(fetch.done)
+}
+
+// requestToken exchanges a signed assertion for an access token and its lifetime
+func (s *googleTokenSource) requestToken(ctx context.Context, now time.Time) (string, time.Duration, error) {
+	assertion, err := s.signAssertion(now)
+	if err != nil {
+		return "", 0, err
+	}
+
+	form := url.Values{
+		"grant_type": {googleJWTBearerGrantType},
+		"assertion":  {assertion},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("create token request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("request access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("read token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", 0, fmt.Errorf("unmarshal token response: %w", err)
+	}
+
+	if tokenResp.AccessToken == "" {
+		return "", 0, errors.New("token response has no access_token")
+	}
+
+	return tokenResp.AccessToken, time.Duration(tokenResp.ExpiresIn) * time.Second, nil
+}
+
+// signAssertion builds the RS256 signed JWT exchanged for an access token
+func (s *googleTokenSource) signAssertion(now time.Time) (string, error) {
+	privateKey, err := parseRSAPrivateKey(s.account.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+	}
+
+	if s.account.PrivateKeyID != "" {
+		header["kid"] = s.account.PrivateKeyID
+	}
+
+	claims := map[string]any{
+		"iss":   s.account.ClientEmail,
+		"scope": googleCloudPlatformScope,
+		"aud":   s.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(googleTokenLifetime).Unix(),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("marshal JWT header: %w", err)
+	}
+
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshal JWT claims: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign JWT: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func parseRSAPrivateKey(pemData string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemData))
+	if block == nil {
+		return nil, errors.New("service account private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("service account private key is not an RSA key")
+		}
+
+		return rsaKey, nil
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse service account private key: %w", err)
+	}
+
+	return key, nil
+}
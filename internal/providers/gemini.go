@@ -1,17 +1,38 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Davincible/claude-code-open/internal/config"
 )
 
+// geminiPromptBlocked stands in for a finish reason when Gemini blocks the prompt, which
+// ends the response without a candidate
+const geminiPromptBlocked = "PROMPT_BLOCKED"
+
+// geminiHarmCategories are the categories sent with BLOCK_NONE when no safety settings are
+// configured
+var geminiHarmCategories = []string{
+	"HARM_CATEGORY_HARASSMENT",
+	"HARM_CATEGORY_HATE_SPEECH",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"HARM_CATEGORY_DANGEROUS_CONTENT",
+}
+
 type GeminiProvider struct {
 	Provider *config.Provider
+
+	// Names of the tools whose dropped schema keywords were logged, so each is logged once
+	loggedSchemaDrops sync.Map
 }
 
 func NewGeminiProvider(provider *config.Provider) *GeminiProvider {
@@ -36,6 +57,38 @@ func (p *GeminiProvider) GetAPIKey() string {
 	return p.Provider.GetAPIKey()
 }
 
+// EndpointURL builds the generateContent URL for a model, or the streamGenerateContent URL
+// with server-sent events when the client asked to stream. The configured base URL may end
+// in /models or name a model, which is replaced.
+func (p *GeminiProvider) EndpointURL(model string, stream bool) string {
+	action := "generateContent"
+	if stream {
+		action = "streamGenerateContent?alt=sse"
+	}
+
+	baseURL := strings.TrimSuffix(p.Provider.APIBase, "/")
+
+	if index := strings.LastIndex(baseURL, "/models/"); index >= 0 {
+		baseURL = baseURL[:index+len("/models")]
+	}
+
+	return fmt.Sprintf("%s/%s:%s", baseURL, model, action)
+}
+
+// NewRequest authenticates with the x-goog-api-key header
+func (p *GeminiProvider) NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error) {
+	req, err := newUpstreamRequest(ctx, p.EndpointURL(upstream.Model, upstream.Stream), upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey := upstream.apiKey(p.GetAPIKey); apiKey != "" {
+		req.Header.Set("x-goog-api-key", apiKey)
+	}
+
+	return req, nil
+}
+
 func (p *GeminiProvider) IsStreaming(headers map[string][]string) bool {
 	if contentType, ok := headers["Content-Type"]; ok {
 		for _, ct := range contentType {
@@ -81,11 +134,12 @@ type geminiResponse struct {
 }
 
 type geminiCandidate struct {
-	Content       *geminiContent       `json:"content,omitempty"`
-	FinishReason  string               `json:"finishReason,omitempty"`
-	SafetyRatings []geminiSafetyRating `json:"safetyRatings,omitempty"`
-	TokenCount    int                  `json:"tokenCount,omitempty"`
-	Index         int                  `json:"index,omitempty"`
+	Content           *geminiContent           `json:"content,omitempty"`
+	FinishReason      string                   `json:"finishReason,omitempty"`
+	SafetyRatings     []geminiSafetyRating     `json:"safetyRatings,omitempty"`
+	GroundingMetadata *geminiGroundingMetadata `json:"groundingMetadata,omitempty"`
+	TokenCount        int                      `json:"tokenCount,omitempty"`
+	Index             int                      `json:"index,omitempty"`
 }
 
 type geminiContent struct {
@@ -95,18 +149,24 @@ type geminiContent struct {
 
 type geminiPart struct {
 	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
 	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
 	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
 }
 
 type geminiFunctionCall struct {
-	Name string                 `json:"name"`
-	Args map[string]any `json:"args,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// hasArgs reports whether the call carries arguments
+func (c *geminiFunctionCall) hasArgs() bool {
+	return len(c.Args) > 0 && string(c.Args) != "null"
 }
 
 type geminiFunctionResponse struct {
-	Name     string      `json:"name"`
-	Response any `json:"response"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
 }
 
 type geminiPromptFeedback struct {
@@ -120,10 +180,36 @@ type geminiSafetyRating struct {
 	Blocked     bool   `json:"blocked,omitempty"`
 }
 
+// geminiGroundingMetadata describes the Google Search grounding of a candidate. Segment
+// offsets are bytes of the candidate text.
+type geminiGroundingMetadata struct {
+	WebSearchQueries  []string                 `json:"webSearchQueries,omitempty"`
+	GroundingChunks   []geminiGroundingChunk   `json:"groundingChunks,omitempty"`
+	GroundingSupports []geminiGroundingSupport `json:"groundingSupports,omitempty"`
+}
+
+type geminiGroundingChunk struct {
+	Web *struct {
+		URI   string `json:"uri"`
+		Title string `json:"title"`
+	} `json:"web,omitempty"`
+}
+
+type geminiGroundingSupport struct {
+	Segment struct {
+		StartIndex int    `json:"startIndex"`
+		EndIndex   int    `json:"endIndex"`
+		Text       string `json:"text"`
+	} `json:"segment"`
+	GroundingChunkIndices []int `json:"groundingChunkIndices,omitempty"`
+}
+
 type geminiUsageMetadata struct {
-	PromptTokenCount     int `json:"promptTokenCount,omitempty"`
-	CandidatesTokenCount int `json:"candidatesTokenCount,omitempty"`
-	TotalTokenCount      int `json:"totalTokenCount,omitempty"`
+	PromptTokenCount        int `json:"promptTokenCount,omitempty"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount,omitempty"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
+	TotalTokenCount         int `json:"totalTokenCount,omitempty"`
 }
 
 type geminiError struct {
@@ -140,94 +226,118 @@ func (p *GeminiProvider) convertGeminiToAnthropic(geminiData []byte) ([]byte, er
 
 	// Handle error responses
 	if geminiResp.Error != nil {
-		anthropicResp := anthropicResponse{
-			ID:    geminiResp.ResponseID,
-			Type:  "error",
-			Model: geminiResp.ModelVersion,
-			Error: &anthropicError{
-				Type:    p.mapGeminiErrorType(geminiResp.Error.Status),
-				Message: geminiResp.Error.Message,
-			},
-		}
+		return FormatAnthropicError(p.mapGeminiErrorType(geminiResp.Error.Status), geminiResp.Error.Message), nil
+	}
 
-		return json.Marshal(anthropicResp)
+	anthropicResp := MessagesResponse{
+		ID:    geminiResp.ResponseID,
+		Type:  "message",
+		Role:  RoleAssistant,
+		Model: geminiResp.ModelVersion,
 	}
 
-	// Handle streaming vs non-streaming responses
-	if len(geminiResp.Candidates) == 0 {
-		return nil, errors.New("no candidates in Gemini response")
+	// Convert usage
+	if metadata := geminiResp.UsageMetadata; metadata != nil {
+		anthropicResp.Usage = &Usage{
+			InputTokens:          max(metadata.PromptTokenCount-metadata.CachedContentTokenCount, 0),
+			OutputTokens:         metadata.CandidatesTokenCount + metadata.ThoughtsTokenCount,
+			CacheReadInputTokens: metadata.CachedContentTokenCount,
+		}
 	}
 
-	candidate := geminiResp.Candidates[0]
+	if len(geminiResp.Candidates) == 0 {
+		// A blocked prompt is answered with feedback and no candidates
+		feedback := geminiResp.PromptFeedback
+		if feedback == nil || feedback.BlockReason == "" {
+			return nil, errors.New("no candidates in Gemini response")
+		}
 
-	anthropicResp := anthropicResponse{
-		ID:    geminiResp.ResponseID,
-		Type:  "message",
-		Role:  "assistant",
-		Model: geminiResp.ModelVersion,
+		text := geminiBlockedMessage("prompt", feedback.BlockReason, feedback.SafetyRatings)
+		anthropicResp.Content = []ContentBlock{{Type: ContentTypeText, Text: &text}}
+		anthropicResp.StopReason = p.convertStopReason(geminiPromptBlocked)
+
+		return json.Marshal(anthropicResp)
 	}
 
-	// Convert content
-	content := p.convertGeminiContent(candidate.Content)
+	candidate := &geminiResp.Candidates[0]
 
-	anthropicResp.Content = content
+	// Convert content
+	anthropicResp.Content = p.convertGeminiContent(candidate.Content, geminiResp.ResponseID)
 
 	// Convert stop reason
 	if candidate.FinishReason != "" {
 		anthropicResp.StopReason = p.convertStopReason(candidate.FinishReason)
 	}
 
-	// Convert usage
-	if geminiResp.UsageMetadata != nil {
-		usage := &anthropicUsage{
-			InputTokens:  geminiResp.UsageMetadata.PromptTokenCount,
-			OutputTokens: geminiResp.UsageMetadata.CandidatesTokenCount,
+	// Return Google Search grounding as the web search Anthropic would have run
+	if candidate.GroundingMetadata != nil {
+		if content, searched := p.groundContent(anthropicResp.Content, candidate.GroundingMetadata, geminiResp.ResponseID); searched {
+			anthropicResp.Content = content
+
+			if anthropicResp.Usage == nil {
+				anthropicResp.Usage = &Usage{}
+			}
+
+			anthropicResp.Usage.ServerToolUse = &ServerToolUse{
+				WebSearchRequests: max(1, len(candidate.GroundingMetadata.WebSearchQueries)),
+			}
 		}
-		anthropicResp.Usage = usage
+	}
+
+	// Explain a blocked response rather than returning an empty message
+	if isRefusal(anthropicResp.StopReason) && (candidate.Content == nil || len(candidate.Content.Parts) == 0) {
+		text := geminiBlockedMessage("response", candidate.FinishReason, candidate.SafetyRatings)
+		anthropicResp.Content = []ContentBlock{{Type: ContentTypeText, Text: &text}}
 	}
 
 	return json.Marshal(anthropicResp)
 }
 
-func (p *GeminiProvider) convertGeminiContent(content *geminiContent) []anthropicContent {
+func (p *GeminiProvider) convertGeminiContent(content *geminiContent, responseID string) []ContentBlock {
 	if content == nil {
 		// Return empty text block if no content
-		emptyText := ""
-
-		return []anthropicContent{{
-			Type: "text",
-			Text: &emptyText,
-		}}
+		return []ContentBlock{*newTextBlock()}
 	}
 
-	var result []anthropicContent
+	var (
+		result        []ContentBlock
+		functionCalls int
+	)
+
+	for i := range content.Parts {
+		part := &content.Parts[i]
+
+		// Handle thought summaries, returned when includeThoughts is set
+		if part.Thought && part.Text != "" {
+			block := newThinkingBlock()
+			block.Thinking = &part.Text
+			result = append(result, *block)
+
+			continue
+		}
 
-	for _, part := range content.Parts {
 		// Handle text content
 		if part.Text != "" {
-			result = append(result, anthropicContent{
-				Type: "text",
-				Text: &part.Text,
-			})
+			result = append(result, ContentBlock{Type: ContentTypeText, Text: &part.Text})
 		}
 
 		// Handle function calls (tool use)
 		if part.FunctionCall != nil {
-			id := fmt.Sprintf("toolu_%d", time.Now().UnixNano())
-			result = append(result, anthropicContent{
-				Type:  "tool_use",
-				ID:    &id,
-				Name:  &part.FunctionCall.Name,
-				Input: part.FunctionCall.Args,
-			})
+			block := newToolUseBlock(ContentTypeToolUse, geminiToolUseID(responseID, functionCalls), part.FunctionCall.Name)
+			if part.FunctionCall.hasArgs() {
+				block.Input = part.FunctionCall.Args
+			}
+
+			functionCalls++
+
+			result = append(result, *block)
 		}
 
 		// Handle function responses (tool results)
 		if part.FunctionResponse != nil {
-			id := fmt.Sprintf("toolu_%s_%d", part.FunctionResponse.Name, time.Now().UnixNano())
-			result = append(result, anthropicContent{
-				Type:      "tool_result",
-				ToolUseID: &id,
+			result = append(result, ContentBlock{
+				Type:      MessageTypeToolResult,
+				ToolUseID: fmt.Sprintf("toolu_%s_%d", part.FunctionResponse.Name, time.Now().UnixNano()),
 				Content:   part.FunctionResponse.Response,
 			})
 		}
@@ -235,11 +345,7 @@ func (p *GeminiProvider) convertGeminiContent(content *geminiContent) []anthropi
 
 	// If no content was generated, add empty text block
 	if len(result) == 0 {
-		emptyText := ""
-		result = append(result, anthropicContent{
-			Type: "text",
-			Text: &emptyText,
-		})
+		result = append(result, *newTextBlock())
 	}
 
 	return result
@@ -249,15 +355,17 @@ func (p *GeminiProvider) convertStopReason(geminiReason string) *string {
 	mapping := map[string]string{
 		"STOP":                      "end_turn",
 		"MAX_TOKENS":                "max_tokens",
-		"SAFETY":                    "stop_sequence",
-		"RECITATION":                "stop_sequence",
+		"SAFETY":                    StopReasonRefusal,
+		"RECITATION":                StopReasonRefusal,
 		"LANGUAGE":                  "stop_sequence",
 		"OTHER":                     "end_turn",
-		"BLOCKLIST":                 "stop_sequence",
-		"PROHIBITED_CONTENT":        "stop_sequence",
-		"SPII":                      "stop_sequence",
+		"BLOCKLIST":                 StopReasonRefusal,
+		"PROHIBITED_CONTENT":        StopReasonRefusal,
+		"SPII":                      StopReasonRefusal,
+		"IMAGE_SAFETY":              StopReasonRefusal,
 		"MALFORMED_FUNCTION_CALL":   "tool_use",
 		"FINISH_REASON_UNSPECIFIED": "end_turn",
+		geminiPromptBlocked:         StopReasonRefusal,
 	}
 
 	if anthropicReason, exists := mapping[geminiReason]; exists {
@@ -269,6 +377,119 @@ func (p *GeminiProvider) convertStopReason(geminiReason string) *string {
 	return &defaultReason
 }
 
+// convertGrounding returns the first query, the pages and the citations of a grounded
+// candidate
+func (p *GeminiProvider) convertGrounding(grounding *geminiGroundingMetadata) (string, []webSearchResult, []webCitation) {
+	var (
+		query     string
+		results   []webSearchResult
+		citations []webCitation
+	)
+
+	if len(grounding.WebSearchQueries) > 0 {
+		query = grounding.WebSearchQueries[0]
+	}
+
+	for _, chunk := range grounding.GroundingChunks {
+		if chunk.Web != nil {
+			results = append(results, webSearchResult{URL: chunk.Web.URI, Title: chunk.Web.Title})
+		}
+	}
+
+	for _, support := range grounding.GroundingSupports {
+		for _, chunkIndex := range support.GroundingChunkIndices {
+			if chunkIndex < 0 || chunkIndex >= len(grounding.GroundingChunks) || grounding.GroundingChunks[chunkIndex].Web == nil {
+				continue
+			}
+
+			web := grounding.GroundingChunks[chunkIndex].Web
+
+			citations = append(citations, webCitation{
+				Start:     support.Segment.StartIndex,
+				End:       support.Segment.EndIndex,
+				URL:       web.URI,
+				Title:     web.Title,
+				CitedText: support.Segment.Text,
+			})
+		}
+	}
+
+	return query, results, citations
+}
+
+// groundContent puts the search blocks before the answer and splits the answer text at the
+// cited segments. It reports false when the grounding holds no pages.
+func (p *GeminiProvider) groundContent(
+	content []ContentBlock, grounding *geminiGroundingMetadata, responseID string,
+) ([]ContentBlock, bool) {
+	query, results, citations := p.convertGrounding(grounding)
+	if len(results) == 0 {
+		return content, false
+	}
+
+	var (
+		result []ContentBlock
+		others []ContentBlock
+		text   strings.Builder
+	)
+
+	for _, block := range content {
+		switch {
+		case block.Type == ContentTypeThinking:
+			result = append(result, block)
+		case block.Type == ContentTypeText && block.Text != nil:
+			text.WriteString(*block.Text)
+		default:
+			others = append(others, block)
+		}
+	}
+
+	id := serverToolUseID(responseID)
+	input, _ := json.Marshal(map[string]string{"query": query})
+	searchResults, _ := json.Marshal(webSearchResultContent(results))
+
+	result = append(result,
+		ContentBlock{Type: ContentTypeServerToolUse, ID: id, Name: webSearchToolName, Input: input},
+		ContentBlock{Type: ContentTypeWebSearchToolResult, ToolUseID: id, Content: searchResults},
+	)
+
+	for _, piece := range splitCitedText(text.String(), citations) {
+		block := ContentBlock{Type: ContentTypeText, Text: &piece.Text}
+		if piece.Citations != nil {
+			block.Citations, _ = json.Marshal(piece.Citations)
+		}
+
+		result = append(result, block)
+	}
+
+	return append(result, others...), true
+}
+
+// isRefusal reports whether a converted stop reason is a refusal
+func isRefusal(stopReason *string) bool {
+	return stopReason != nil && *stopReason == StopReasonRefusal
+}
+
+// geminiBlockedMessage describes why Gemini blocked the prompt or the response, naming the
+// harm categories that were flagged
+func geminiBlockedMessage(subject, reason string, ratings []geminiSafetyRating) string {
+	message := fmt.Sprintf("Gemini blocked the %s: %s", subject, reason)
+
+	var categories []string
+
+	for _, rating := range ratings {
+		if rating.Blocked {
+			categories = append(categories, rating.Category)
+		}
+	}
+
+	if len(categories) > 0 {
+		message += " (" + strings.Join(categories, ", ") + ")"
+	}
+
+	return message
+}
+
 func (p *GeminiProvider) mapGeminiErrorType(geminiStatus string) string {
 	mapping := map[string]string{
 		"INVALID_ARGUMENT":   "invalid_request_error",
@@ -289,265 +510,218 @@ func (p *GeminiProvider) mapGeminiErrorType(geminiStatus string) string {
 }
 
 func (p *GeminiProvider) convertGeminiToAnthropicStream(geminiData []byte, state *StreamState) ([]byte, error) {
-	var rawChunk map[string]any
-	if err := json.Unmarshal(geminiData, &rawChunk); err != nil {
+	var chunk geminiResponse
+	if err := json.Unmarshal(geminiData, &chunk); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal Gemini streaming response: %w", err)
 	}
 
 	var events []byte
 
 	// Store response ID and model from first chunk
-	if responseID, ok := rawChunk["responseId"].(string); ok && state.MessageID == "" {
-		state.MessageID = responseID
+	if state.MessageID == "" {
+		state.MessageID = chunk.ResponseID
 	}
 
-	if modelVersion, ok := rawChunk["modelVersion"].(string); ok && state.Model == "" {
-		state.Model = modelVersion
+	if state.Model == "" {
+		state.Model = chunk.ModelVersion
 	}
 
-	// Handle candidates array
-	if candidates, ok := rawChunk["candidates"].([]any); ok && len(candidates) > 0 {
-		if firstCandidate, ok := candidates[0].(map[string]any); ok {
-			// Send message_start event if not sent yet
-			if !state.MessageStartSent {
-				messageStartEvent := p.createMessageStartEvent(state.MessageID, state.Model, rawChunk)
-				events = append(events, p.formatSSEEvent("message_start", messageStartEvent)...)
-				state.MessageStartSent = true
-			}
+	if len(chunk.Candidates) > 0 {
+		candidate := &chunk.Candidates[0]
 
-			// Handle content
-			if content, ok := firstCandidate["content"].(map[string]any); ok {
-				// Initialize content blocks map if needed
-				if state.ContentBlocks == nil {
-					state.ContentBlocks = make(map[int]*ContentBlockState)
-				}
+		events = append(events, p.startMessage(&chunk, state)...)
 
-				// Handle parts array
-				if parts, ok := content["parts"].([]any); ok {
-					contentEvents := p.handleGeminiParts(parts, state)
-					events = append(events, contentEvents...)
-				}
-			}
+		if candidate.Content != nil {
+			events = append(events, p.handleGeminiParts(candidate.Content.Parts, state)...)
+		}
 
-			// Handle finish_reason
-			if finishReason, ok := firstCandidate["finishReason"]; ok && finishReason != nil {
-				if reason, ok := finishReason.(string); ok {
-					finishEvents := p.handleFinishReason(reason, rawChunk, state)
-					events = append(events, finishEvents...)
-				}
+		// Grounding arrives with the last chunks, after the answer has been streamed
+		if candidate.GroundingMetadata != nil && !hasWebSearchResult(state) {
+			events = append(events, p.handleGrounding(candidate.GroundingMetadata, state)...)
+		}
+
+		if candidate.FinishReason != "" {
+			// Explain a response that was blocked before anything was streamed
+			if isRefusal(p.convertStopReason(candidate.FinishReason)) && len(state.ContentBlocks) == 0 {
+				message := geminiBlockedMessage("response", candidate.FinishReason, candidate.SafetyRatings)
+				events = append(events, p.handleTextContent(message, state)...)
 			}
+
+			events = append(events, p.handleFinishReason(candidate.FinishReason, &chunk, state)...)
 		}
+
+		return events, nil
+	}
+
+	// A blocked prompt is answered with feedback and no candidates
+	if feedback := chunk.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+		message := geminiBlockedMessage("prompt", feedback.BlockReason, feedback.SafetyRatings)
+
+		events = append(events, p.startMessage(&chunk, state)...)
+		events = append(events, p.handleTextContent(message, state)...)
+		events = append(events, p.handleFinishReason(geminiPromptBlocked, &chunk, state)...)
 	}
 
 	return events, nil
 }
 
-func (p *GeminiProvider) createMessageStartEvent(messageID, model string, firstChunk map[string]any) map[string]any {
-	usage := map[string]any{
-		"input_tokens":  0,
-		"output_tokens": 1,
+// handleGrounding streams the web search of a grounded chunk
+func (p *GeminiProvider) handleGrounding(grounding *geminiGroundingMetadata, state *StreamState) []byte {
+	query, results, citations := p.convertGrounding(grounding)
+	if len(results) == 0 {
+		return nil
 	}
 
-	if usageMetadata, ok := firstChunk["usageMetadata"].(map[string]any); ok {
-		if promptTokens, ok := usageMetadata["promptTokenCount"]; ok {
-			usage["input_tokens"] = promptTokens
-		}
+	return webSearchStreamEvents(state, serverToolUseID(state.MessageID), query, results, citations)
+}
+
+// startMessage sends message_start for the first chunk of a stream
+func (p *GeminiProvider) startMessage(chunk *geminiResponse, state *StreamState) []byte {
+	if state.ContentBlocks == nil {
+		state.ContentBlocks = make(map[int]*ContentBlockState)
 	}
 
-	return map[string]any{
-		"type": "message_start",
-		"message": map[string]any{
-			"id":            messageID,
-			"type":          "message",
-			"role":          "assistant",
-			"model":         model,
-			"content":       []any{},
-			"stop_reason":   nil,
-			"stop_sequence": nil,
-			"usage":         usage,
-		},
-	}
-}
-
-func (p *GeminiProvider) formatSSEEvent(eventType string, data map[string]any) []byte {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return []byte("event: error\ndata: {\"error\":\"failed to marshal data\"}\n\n")
+	if state.MessageStartSent {
+		return nil
+	}
+
+	state.MessageStartSent = true
+
+	usage := map[string]any{"input_tokens": 0}
+	if chunk.UsageMetadata != nil {
+		usage = p.convertUsage(chunk.UsageMetadata)
 	}
 
-	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, string(jsonData)))
+	// Output tokens are reported by message_delta
+	usage["output_tokens"] = 1
+
+	return FormatSSEEvent("message_start", CreateMessageStartEvent(state.MessageID, state.Model, usage))
 }
 
 // handleGeminiParts processes Gemini content parts for streaming
-func (p *GeminiProvider) handleGeminiParts(parts []any, state *StreamState) []byte {
+func (p *GeminiProvider) handleGeminiParts(parts []geminiPart, state *StreamState) []byte {
 	var events []byte
 
-	for _, part := range parts {
-		if partMap, ok := part.(map[string]any); ok {
-			// Handle text content
-			if text, ok := partMap["text"].(string); ok && text != "" {
-				textEvents := p.handleTextContent(text, state)
-				events = append(events, textEvents...)
-			}
+	for i := range parts {
+		part := &parts[i]
 
-			// Handle function calls
-			if functionCall, ok := partMap["functionCall"].(map[string]any); ok {
-				functionEvents := p.handleFunctionCall(functionCall, state)
-				events = append(events, functionEvents...)
+		// Handle thought summaries
+		if part.Thought {
+			if part.Text != "" {
+				events = append(events, handleThinkingDelta(part.Text, state)...)
 			}
-		}
-	}
 
-	return events
-}
-
-// handleTextContent processes text content streaming
-func (p *GeminiProvider) handleTextContent(content string, state *StreamState) []byte {
-	var events []byte
+			continue
+		}
 
-	// Get or create text content block at index 0
-	textIndex := p.getOrCreateTextBlock(state)
-	contentBlock := state.ContentBlocks[textIndex]
+		// Handle text content
+		if part.Text != "" {
+			events = append(events, closeThinkingBlock(state)...)
+			events = append(events, p.handleTextContent(part.Text, state)...)
+		}
 
-	// Send content_block_start event if needed
-	if !contentBlock.StartSent {
-		events = append(events, p.createTextBlockStartEvent(textIndex)...)
-		contentBlock.StartSent = true
+		// Handle function calls, which must not overlap the thinking or text before them
+		if part.FunctionCall != nil {
+			events = append(events, closeContentBlocks(state)...)
+			events = append(events, p.handleFunctionCall(part.FunctionCall, state)...)
+		}
 	}
 
-	// Send content_block_delta event
-	events = append(events, p.createTextDeltaEvent(textIndex, content)...)
-
 	return events
 }
 
-// handleFunctionCall processes function call streaming
-func (p *GeminiProvider) handleFunctionCall(functionCall map[string]any, state *StreamState) []byte {
+// handleTextContent streams text into the open text block, which follows any thinking block
+func (p *GeminiProvider) handleTextContent(content string, state *StreamState) []byte {
 	var events []byte
 
-	name, _ := functionCall["name"].(string)
-	args, _ := functionCall["args"].(map[string]any)
+	index := openTextBlockIndex(state)
+	block := state.ContentBlocks[index]
 
-	// Create new content block for tool use
-	contentBlockIndex := len(state.ContentBlocks)
-	toolCallID := fmt.Sprintf("toolu_gemini_%d", time.Now().UnixNano())
-
-	state.ContentBlocks[contentBlockIndex] = &ContentBlockState{
-		Type:       "tool_use",
-		ToolCallID: toolCallID,
-		ToolName:   name,
-		Arguments:  "",
+	if !block.StartSent {
+		events = append(events, contentBlockStartEvent(index, newTextBlock())...)
+		block.StartSent = true
 	}
 
-	contentBlock := state.ContentBlocks[contentBlockIndex]
+	return append(events, contentBlockDeltaEvent(index, TextDelta{Type: "text_delta", Text: content})...)
+}
 
-	// Send content_block_start event
-	events = append(events, p.createToolBlockStartEvent(contentBlockIndex, contentBlock)...)
-	contentBlock.StartSent = true
+// handleFunctionCall streams a function call as a complete tool_use block. Gemini sends
+// each call whole, so its arguments follow in a single input_json_delta.
+func (p *GeminiProvider) handleFunctionCall(functionCall *geminiFunctionCall, state *StreamState) []byte {
+	// Tool use blocks are numbered like the non-streaming conversion
+	functionCalls := 0
 
-	// Send function arguments as input_json_delta if we have args
-	if args != nil {
-		argsJSON, err := json.Marshal(args)
-		if err == nil {
-			events = append(events, p.createInputDeltaEvent(contentBlockIndex, string(argsJSON))...)
+	for _, block := range state.ContentBlocks {
+		if block.Type == ContentTypeToolUse {
+			functionCalls++
 		}
 	}
 
-	return events
-}
-
-// getOrCreateTextBlock gets or creates text content block at index 0
-func (p *GeminiProvider) getOrCreateTextBlock(state *StreamState) int {
-	textIndex := 0
-	if _, exists := state.ContentBlocks[textIndex]; !exists {
-		state.ContentBlocks[textIndex] = &ContentBlockState{
-			Type: "text",
-		}
+	index := len(state.ContentBlocks)
+	block := &ContentBlockState{
+		Type:       ContentTypeToolUse,
+		ToolCallID: geminiToolUseID(state.MessageID, functionCalls),
+		ToolName:   functionCall.Name,
+		StartSent:  true,
 	}
+	state.ContentBlocks[index] = block
 
-	return textIndex
-}
+	events := contentBlockStartEvent(index, newToolUseBlock(ContentTypeToolUse, block.ToolCallID, block.ToolName))
 
-// createTextBlockStartEvent creates content_block_start event for text
-func (p *GeminiProvider) createTextBlockStartEvent(index int) []byte {
-	contentBlockStartEvent := map[string]any{
-		"type":  "content_block_start",
-		"index": index,
-		"content_block": map[string]any{
-			"type": "text",
-			"text": "",
-		},
+	if functionCall.hasArgs() {
+		events = append(events, contentBlockDeltaEvent(index, InputJSONDelta{
+			Type:        "input_json_delta",
+			PartialJSON: string(functionCall.Args),
+		})...)
 	}
 
-	return p.formatSSEEvent("content_block_start", contentBlockStartEvent)
-}
-
-// createTextDeltaEvent creates content_block_delta event for text
-func (p *GeminiProvider) createTextDeltaEvent(index int, text string) []byte {
-	contentDeltaEvent := map[string]any{
-		"type":  "content_block_delta",
-		"index": index,
-		"delta": map[string]any{
-			"type": "text_delta",
-			"text": text,
-		},
-	}
+	block.StopSent = true
 
-	return p.formatSSEEvent("content_block_delta", contentDeltaEvent)
+	return append(events, contentBlockStopEvent(index)...)
 }
 
-// createToolBlockStartEvent creates content_block_start event for tool use
-func (p *GeminiProvider) createToolBlockStartEvent(index int, block *ContentBlockState) []byte {
-	contentBlockStartEvent := map[string]any{
-		"type":  "content_block_start",
-		"index": index,
-		"content_block": map[string]any{
-			"type":  "tool_use",
-			"id":    block.ToolCallID,
-			"name":  block.ToolName,
-			"input": map[string]any{},
-		},
+// geminiToolUseID builds a tool_use id for a Gemini function call, which carries none. The
+// id is derived from the response id and the position of the call, so it is the same for
+// streaming and non-streaming responses and when a response is converted again.
+func geminiToolUseID(responseID string, index int) string {
+	if responseID == "" {
+		responseID = strconv.FormatInt(time.Now().UnixNano(), 36)
 	}
 
-	return p.formatSSEEvent("content_block_start", contentBlockStartEvent)
-}
+	sanitized := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
 
-// createInputDeltaEvent creates input_json_delta SSE event
-func (p *GeminiProvider) createInputDeltaEvent(index int, partialJSON string) []byte {
-	inputDeltaEvent := map[string]any{
-		"type":  "content_block_delta",
-		"index": index,
-		"delta": map[string]any{
-			"type":         "input_json_delta",
-			"partial_json": partialJSON,
-		},
-	}
+		return '_'
+	}, responseID)
 
-	return p.formatSSEEvent("content_block_delta", inputDeltaEvent)
+	return fmt.Sprintf("toolu_gemini_%s_%d", sanitized, index)
 }
 
-// handleFinishReason processes finish reasons and sends appropriate events
-func (p *GeminiProvider) handleFinishReason(reason string, chunk map[string]any, state *StreamState) []byte {
-	return HandleFinishReason(p, reason, chunk, state, func(chunk map[string]any) map[string]any {
-		if usageMetadata, ok := chunk["usageMetadata"].(map[string]any); ok {
-			return p.convertUsage(usageMetadata)
-		}
+// handleFinishReason closes the content blocks and ends the message. Gemini reports the
+// usage in the same chunk as the finish reason, so nothing is held back.
+func (p *GeminiProvider) handleFinishReason(reason string, chunk *geminiResponse, state *StreamState) []byte {
+	events := closeContentBlocks(state)
+	messageDeltaEvent := finishMessageDelta(p.convertStopReason(reason))
 
-		return nil
-	})
-}
+	if chunk.UsageMetadata != nil {
+		messageDeltaEvent.Usage = p.convertUsage(chunk.UsageMetadata)
+	}
 
-// convertUsage handles usage information conversion
-func (p *GeminiProvider) convertUsage(usage map[string]any) map[string]any {
-	anthropicUsage := make(map[string]any)
+	return append(events, stopMessage(messageDeltaEvent)...)
+}
 
-	// Map token fields
-	if promptTokens, ok := usage["promptTokenCount"]; ok {
-		anthropicUsage["input_tokens"] = promptTokens
+// convertUsage handles usage information conversion. Gemini counts cached tokens in the
+// prompt and thinking apart from the candidates, unlike Anthropic.
+func (p *GeminiProvider) convertUsage(usage *geminiUsageMetadata) map[string]any {
+	anthropicUsage := map[string]any{
+		"input_tokens":  max(usage.PromptTokenCount-usage.CachedContentTokenCount, 0),
+		"output_tokens": usage.CandidatesTokenCount + usage.ThoughtsTokenCount,
 	}
 
-	if candidatesTokens, ok := usage["candidatesTokenCount"]; ok {
-		anthropicUsage["output_tokens"] = candidatesTokens
+	if usage.CachedContentTokenCount > 0 {
+		anthropicUsage["cache_read_input_tokens"] = usage.CachedContentTokenCount
 	}
 
 	return anthropicUsage
@@ -570,6 +744,15 @@ func (p *GeminiProvider) transformAnthropicToGemini(requestBody []byte) ([]byte,
 
 	geminiReq["contents"] = contents
 
+	// The system prompt goes in systemInstruction rather than a leading user turn
+	if systemText := SystemPromptText(anthropicReq["system"]); systemText != "" {
+		geminiReq["systemInstruction"] = map[string]any{
+			"parts": []any{
+				map[string]any{"text": systemText},
+			},
+		}
+	}
+
 	// Convert generation config
 	generationConfig := make(map[string]any)
 
@@ -589,72 +772,107 @@ func (p *GeminiProvider) transformAnthropicToGemini(requestBody []byte) ([]byte,
 		generationConfig["topK"] = int(topK)
 	}
 
+	// Map extended thinking to a thinking budget, asking for thought summaries so they can
+	// be returned as thinking blocks
+	if budget, ok := thinkingBudget(anthropicReq); ok {
+		generationConfig["thinkingConfig"] = map[string]any{
+			"thinkingBudget":  budget,
+			"includeThoughts": true,
+		}
+	}
+
 	if len(generationConfig) > 0 {
 		geminiReq["generationConfig"] = generationConfig
 	}
 
+	// The web search server tool maps to Google Search grounding
+	_, webSearch := extractWebSearchTool(anthropicReq)
+
 	// Convert tools
 	if tools, ok := anthropicReq["tools"].([]any); ok && len(tools) > 0 {
 		geminiTools := p.convertAnthropicToolsToGemini(tools)
 
 		geminiReq["tools"] = geminiTools
+
+		if toolConfig := convertToolChoiceToGemini(anthropicReq["tool_choice"]); toolConfig != nil && len(geminiTools) > 0 {
+			geminiReq["toolConfig"] = toolConfig
+		}
 	}
 
-	// Convert safety settings if needed
-	safetySettings := []map[string]any{
-		{
-			"category":  "HARM_CATEGORY_HARASSMENT",
-			"threshold": "BLOCK_NONE",
-		},
-		{
-			"category":  "HARM_CATEGORY_HATE_SPEECH",
-			"threshold": "BLOCK_NONE",
-		},
-		{
-			"category":  "HARM_CATEGORY_SEXUALLY_EXPLICIT",
-			"threshold": "BLOCK_NONE",
-		},
-		{
-			"category":  "HARM_CATEGORY_DANGEROUS_CONTENT",
-			"threshold": "BLOCK_NONE",
-		},
+	if webSearch {
+		geminiTools, _ := geminiReq["tools"].([]any)
+		geminiReq["tools"] = append(geminiTools, map[string]any{"googleSearch": map[string]any{}})
 	}
-	geminiReq["safetySettings"] = safetySettings
+
+	geminiReq["safetySettings"] = p.safetySettings()
 
 	return json.Marshal(geminiReq)
 }
 
+// safetySettings returns the configured safety settings, or settings that block nothing so
+// the proxy behaves like the Anthropic API. Some keys reject BLOCK_NONE and need to set
+// their own thresholds.
+func (p *GeminiProvider) safetySettings() []map[string]any {
+	if len(p.Provider.SafetySettings) > 0 {
+		settings := make([]map[string]any, 0, len(p.Provider.SafetySettings))
+		for _, setting := range p.Provider.SafetySettings {
+			settings = append(settings, map[string]any{
+				"category":  setting.Category,
+				"threshold": setting.Threshold,
+			})
+		}
+
+		return settings
+	}
+
+	settings := make([]map[string]any, 0, len(geminiHarmCategories))
+	for _, category := range geminiHarmCategories {
+		settings = append(settings, map[string]any{
+			"category":  category,
+			"threshold": "BLOCK_NONE",
+		})
+	}
+
+	return settings
+}
+
 // Helper methods for transformAnthropicToGemini
 func (p *GeminiProvider) convertAnthropicMessagesToGeminiContents(anthropicReq map[string]any) ([]any, error) {
 	var contents []any
 
-	// Handle system message first
-	if systemContent, hasSystem := anthropicReq["system"]; hasSystem {
-		if systemStr, ok := systemContent.(string); ok {
-			systemContent := map[string]any{
-				"parts": []any{
-					map[string]any{
-						"text": systemStr,
-					},
-				},
-				"role": RoleUser,
-			}
-			contents = append(contents, systemContent)
-		}
-	}
+	// functionResponse parts are matched by function name, which tool_result blocks don't
+	// carry, so names are looked up from the tool_use blocks seen so far
+	toolNames := make(map[string]string)
 
 	// Convert messages
 	if messages, ok := anthropicReq["messages"].([]any); ok {
 		for _, message := range messages {
 			if msgMap, ok := message.(map[string]any); ok {
-				geminiContent, err := p.convertAnthropicMessageToGemini(msgMap)
+				p.collectToolNames(msgMap, toolNames)
+
+				geminiContent, err := p.convertAnthropicMessageToGemini(msgMap, toolNames)
 				if err != nil {
 					return nil, err
 				}
 
-				if geminiContent != nil {
-					contents = append(contents, geminiContent)
+				if geminiContent == nil {
+					continue
+				}
+
+				// Consecutive turns of one role are merged, so results of parallel function
+				// calls reach Gemini as a single user turn
+				if len(contents) > 0 {
+					previous, _ := contents[len(contents)-1].(map[string]any)
+					if previous["role"] == geminiContent["role"] {
+						previousParts, _ := previous["parts"].([]any)
+						currentParts, _ := geminiContent["parts"].([]any)
+						previous["parts"] = append(previousParts, currentParts...)
+
+						continue
+					}
 				}
+
+				contents = append(contents, geminiContent)
 			}
 		}
 	}
@@ -662,7 +880,26 @@ func (p *GeminiProvider) convertAnthropicMessagesToGeminiContents(anthropicReq m
 	return contents, nil
 }
 
-func (p *GeminiProvider) convertAnthropicMessageToGemini(message map[string]any) (map[string]any, error) {
+// collectToolNames records the names of the tool_use blocks in an assistant message
+func (p *GeminiProvider) collectToolNames(message map[string]any, toolNames map[string]string) {
+	blocks, _ := message["content"].([]any)
+
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok || blockMap["type"] != ContentTypeToolUse {
+			continue
+		}
+
+		id, _ := blockMap["id"].(string)
+		name, _ := blockMap["name"].(string)
+
+		if id != "" && name != "" {
+			toolNames[id] = name
+		}
+	}
+}
+
+func (p *GeminiProvider) convertAnthropicMessageToGemini(message map[string]any, toolNames map[string]string) (map[string]any, error) {
 	role, _ := message["role"].(string)
 	content := message["content"]
 
@@ -678,17 +915,27 @@ func (p *GeminiProvider) convertAnthropicMessageToGemini(message map[string]any)
 		// Array of content blocks
 		for _, block := range contentType {
 			if blockMap, ok := block.(map[string]any); ok {
-				part := p.convertContentBlockToGeminiPart(blockMap)
+				part := p.convertContentBlockToGeminiPart(blockMap, toolNames)
 
 				if part != nil {
 					parts = append(parts, part)
 				}
+
+				// Function responses can't carry images, so they follow as separate parts
+				if blockMap["type"] == MessageTypeToolResult {
+					parts = append(parts, p.convertToolResultImages(blockMap)...)
+				}
 			}
 		}
 	default:
 		return nil, fmt.Errorf("unsupported content type: %T", content)
 	}
 
+	// Turns that only held thinking blocks have nothing left to send
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
 	// Convert role
 	geminiRole := RoleUser
 	if role == "assistant" {
@@ -701,7 +948,7 @@ func (p *GeminiProvider) convertAnthropicMessageToGemini(message map[string]any)
 	}, nil
 }
 
-func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any) map[string]any {
+func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any, toolNames map[string]string) map[string]any {
 	blockType, _ := block["type"].(string)
 
 	switch blockType {
@@ -711,6 +958,11 @@ func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any) m
 				"text": text,
 			}
 		}
+	case ContentTypeImage:
+		return convertImageToGemini(block)
+	case ContentTypeThinking, ContentTypeRedactedThinking:
+		// Dropped, thinking from earlier turns can't be replayed upstream
+		return nil
 	case "tool_use":
 		// Convert tool_use to function_call for Gemini
 		if name, ok := block["name"].(string); ok {
@@ -738,6 +990,11 @@ func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any) m
 					response = map[string]any{
 						"content": contentStr,
 					}
+				} else if contentBlocks, ok := content.([]any); ok {
+					// Block arrays keep their text, images are sent as separate parts
+					response = map[string]any{
+						"content": p.joinToolResultText(contentBlocks),
+					}
 				} else {
 					response = content
 				}
@@ -745,10 +1002,16 @@ func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any) m
 				response = map[string]any{}
 			}
 
+			// Fall back to the id when the matching tool_use isn't in the history
+			name, ok := toolNames[toolUseID]
+			if !ok {
+				name = toolUseID
+			}
+
 			return map[string]any{
 				"functionResponse": map[string]any{
-					"name":     toolUseID, // Use tool_use_id as function name reference
-					"response": response,  // Structured object instead of plain string
+					"name":     name,
+					"response": response, // Structured object instead of plain string
 				},
 			}
 		}
@@ -757,6 +1020,48 @@ func (p *GeminiProvider) convertContentBlockToGeminiPart(block map[string]any) m
 	return nil
 }
 
+// joinToolResultText joins the text blocks of a tool result
+func (p *GeminiProvider) joinToolResultText(blocks []any) string {
+	var texts []string
+
+	for _, block := range blocks {
+		if blockMap, ok := block.(map[string]any); ok && blockMap["type"] == ContentTypeText {
+			if text, ok := blockMap["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// convertToolResultImages converts the images of a tool result to Gemini parts
+func (p *GeminiProvider) convertToolResultImages(block map[string]any) []any {
+	var parts []any
+
+	if contentBlocks, ok := block["content"].([]any); ok {
+		for _, contentBlock := range contentBlocks {
+			if blockMap, ok := contentBlock.(map[string]any); ok && blockMap["type"] == ContentTypeImage {
+				if part := convertImageToGemini(blockMap); part != nil {
+					parts = append(parts, part)
+				}
+			}
+		}
+	}
+
+	return parts
+}
+
+// logSchemaDrops warns about the keywords dropped from a tool's schema, which change what
+// Gemini validates, the first time the tool is sent
+func (p *GeminiProvider) logSchemaDrops(toolName string, dropped []string) {
+	if _, logged := p.loggedSchemaDrops.LoadOrStore(toolName, true); logged {
+		return
+	}
+
+	slog.Warn("Dropped JSON Schema keywords Gemini doesn't support", "provider", p.Provider.Name, "tool", toolName, "keywords", dropped)
+}
+
 func (p *GeminiProvider) convertAnthropicToolsToGemini(tools []any) []any {
 	var geminiTools []any
 
@@ -772,8 +1077,17 @@ func (p *GeminiProvider) convertAnthropicToolsToGemini(tools []any) []any {
 				functionDecl["description"] = description
 			}
 
-			if inputSchema, ok := toolMap["input_schema"]; ok {
-				functionDecl["parameters"] = inputSchema
+			if inputSchema, ok := toolMap["input_schema"].(map[string]any); ok {
+				parameters, dropped := sanitizeGeminiSchema(inputSchema)
+				if len(dropped) > 0 {
+					toolName, _ := toolMap["name"].(string)
+					p.logSchemaDrops(toolName, dropped)
+				}
+
+				// Gemini rejects object parameters without properties, tools without input omit them
+				if properties, _ := parameters["properties"].(map[string]any); len(properties) > 0 || parameters["type"] != "object" {
+					functionDecl["parameters"] = parameters
+				}
 			}
 
 			functionDeclarations = append(functionDeclarations, functionDecl)
@@ -1,7 +1,10 @@
 package providers
 
 import (
+	"bytes"
 	"encoding/json"
+	"log/slog"
+	"strings"
 	"testing"
 
 	"github.com/Davincible/claude-code-open/internal/config"
@@ -203,13 +206,14 @@ func TestGeminiProvider_ConvertStopReason(t *testing.T) {
 	}{
 		{"STOP", "end_turn"},
 		{"MAX_TOKENS", "max_tokens"},
-		{"SAFETY", "stop_sequence"},
-		{"RECITATION", "stop_sequence"},
+		{"SAFETY", "refusal"},
+		{"RECITATION", "refusal"},
 		{"LANGUAGE", "stop_sequence"},
 		{"OTHER", "end_turn"},
-		{"BLOCKLIST", "stop_sequence"},
-		{"PROHIBITED_CONTENT", "stop_sequence"},
-		{"SPII", "stop_sequence"},
+		{"BLOCKLIST", "refusal"},
+		{"PROHIBITED_CONTENT", "refusal"},
+		{"SPII", "refusal"},
+		{"IMAGE_SAFETY", "refusal"},
 		{"MALFORMED_FUNCTION_CALL", "tool_use"},
 		{"FINISH_REASON_UNSPECIFIED", "end_turn"},
 		{"unknown", "end_turn"},
@@ -445,19 +449,52 @@ func TestGeminiProvider_StreamingFunctionCalls(t *testing.T) {
 	assert.Contains(t, eventStr, "UTC")
 }
 
+func TestGeminiProvider_StreamingTextThenFunctionCall(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+	state := &StreamState{}
+
+	chunks := []string{
+		`{"responseId":"r1","candidates":[{"content":{"role":"model","parts":[{"text":"Let me check"}]}}]}`,
+		`{"responseId":"r1","candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"Paris"}}}]}}]}`,
+		`{"responseId":"r1","candidates":[{"content":{"role":"model","parts":[{"text":"Done"}]}}]}`,
+	}
+
+	var output strings.Builder
+
+	for _, chunk := range chunks {
+		events, err := provider.TransformStream([]byte(chunk), state)
+		require.NoError(t, err)
+		output.Write(events)
+	}
+
+	// Each block is stopped before the next one starts
+	var blockEvents []string
+
+	for _, line := range strings.Split(output.String(), "\n") {
+		if line == "event: content_block_start" || line == "event: content_block_stop" {
+			blockEvents = append(blockEvents, strings.TrimPrefix(line, "event: content_block_"))
+		}
+	}
+
+	assert.Equal(t, []string{"start", "stop", "start", "stop", "start"}, blockEvents)
+}
+
 func TestGeminiProvider_ConvertUsage(t *testing.T) {
 	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
 
-	usage := map[string]any{
-		"promptTokenCount":     100,
-		"candidatesTokenCount": 50,
-		"totalTokenCount":      150,
+	usage := &geminiUsageMetadata{
+		PromptTokenCount:        100,
+		CandidatesTokenCount:    50,
+		CachedContentTokenCount: 30,
+		ThoughtsTokenCount:      20,
+		TotalTokenCount:         170,
 	}
 
 	result := provider.convertUsage(usage)
 
-	assert.Equal(t, 100, result["input_tokens"])
-	assert.Equal(t, 50, result["output_tokens"])
+	assert.Equal(t, 70, result["input_tokens"])
+	assert.Equal(t, 70, result["output_tokens"])
+	assert.Equal(t, 30, result["cache_read_input_tokens"])
 }
 
 func TestGeminiProvider_MapGeminiErrorType(t *testing.T) {
@@ -526,3 +563,648 @@ func TestGeminiProvider_EmptyContent(t *testing.T) {
 		assert.Equal(t, "", text.(string))
 	}
 }
+
+func TestGeminiProvider_TransformRequest_Images(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := map[string]any{
+		"model": "gemini-2.0-flash",
+		"messages": []any{
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "text", "text": "Compare these"},
+				map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "aW1n"}},
+				map[string]any{"type": "image", "source": map[string]any{"type": "url", "url": "https://example.com/photos/cat.webp?size=large"}},
+			}},
+			map[string]any{"role": "assistant", "content": []any{
+				map[string]any{"type": "tool_use", "id": "toolu_1", "name": "screenshot", "input": map[string]any{}},
+			}},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{
+					map[string]any{"type": "text", "text": "Captured"},
+					map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/jpeg", "data": "c2hvdA=="}},
+				}},
+			}},
+		},
+	}
+
+	requestBytes, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	result, err := provider.TransformRequest(requestBytes)
+	require.NoError(t, err)
+
+	var geminiReq struct {
+		Contents []struct {
+			Role  string           `json:"role"`
+			Parts []map[string]any `json:"parts"`
+		} `json:"contents"`
+	}
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+	require.Len(t, geminiReq.Contents, 3)
+
+	assert.Equal(t, []map[string]any{
+		{"text": "Compare these"},
+		{"inlineData": map[string]any{"mimeType": "image/png", "data": "aW1n"}},
+		{"fileData": map[string]any{"mimeType": "image/webp", "fileUri": "https://example.com/photos/cat.webp?size=large"}},
+	}, geminiReq.Contents[0].Parts)
+
+	toolResultParts := geminiReq.Contents[2].Parts
+	require.Len(t, toolResultParts, 2)
+
+	functionResponse, ok := toolResultParts[0]["functionResponse"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, map[string]any{"content": "Captured"}, functionResponse["response"])
+	assert.Equal(t, map[string]any{"inlineData": map[string]any{"mimeType": "image/jpeg", "data": "c2hvdA=="}}, toolResultParts[1])
+}
+
+func TestGeminiProvider_Thinking(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"model": "gemini-2.5-pro",
+		"max_tokens": 16000,
+		"thinking": {"type": "enabled", "budget_tokens": 4000},
+		"messages": [
+			{"role": "user", "content": "Hi"},
+			{"role": "assistant", "content": [{"type": "redacted_thinking", "data": "opaque"}]},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "Greet back", "signature": "sig"},
+				{"type": "text", "text": "Hello!"}
+			]},
+			{"role": "user", "content": "Bye"}
+		]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	generationConfig, ok := geminiReq["generationConfig"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, map[string]any{"thinkingBudget": float64(4000), "includeThoughts": true}, generationConfig["thinkingConfig"])
+
+	assert.Equal(t, []any{
+		map[string]any{"role": "user", "parts": []any{map[string]any{"text": "Hi"}}},
+		map[string]any{"role": "model", "parts": []any{map[string]any{"text": "Hello!"}}},
+		map[string]any{"role": "user", "parts": []any{map[string]any{"text": "Bye"}}},
+	}, geminiReq["contents"], "thinking blocks and turns left empty should be dropped")
+
+	response := `{
+		"responseId": "resp-1",
+		"modelVersion": "gemini-2.5-pro",
+		"candidates": [{"content": {"role": "model", "parts": [
+			{"text": "**Greeting**\nThe user says bye", "thought": true},
+			{"text": "Goodbye!"}
+		]}, "finishReason": "STOP"}]
+	}`
+
+	result, err = provider.TransformResponse([]byte(response))
+	require.NoError(t, err)
+
+	var anthropicResp map[string]any
+	require.NoError(t, json.Unmarshal(result, &anthropicResp))
+	assert.Equal(t, []any{
+		map[string]any{"type": "thinking", "thinking": "**Greeting**\nThe user says bye", "signature": ""},
+		map[string]any{"type": "text", "text": "Goodbye!"},
+	}, anthropicResp["content"])
+
+	state := &StreamState{}
+
+	var output []byte
+
+	for _, chunk := range []string{
+		`{"responseId":"resp-1","candidates":[{"content":{"role":"model","parts":[{"text":"The user says bye","thought":true}]}}]}`,
+		`{"responseId":"resp-1","candidates":[{"content":{"role":"model","parts":[{"text":"Goodbye!"}]},"finishReason":"STOP"}]}`,
+	} {
+		events, err := provider.TransformStream([]byte(chunk), state)
+		require.NoError(t, err)
+
+		output = append(output, events...)
+	}
+
+	stream := string(output)
+	assert.Contains(t, stream, `"delta":{"thinking":"The user says bye","type":"thinking_delta"},"index":0`)
+	assert.Contains(t, stream, `"delta":{"text":"Goodbye!","type":"text_delta"},"index":1`)
+	assert.Less(t, strings.Index(stream, `data: {"index":0,"type":"content_block_stop"}`), strings.Index(stream, "text_delta"),
+		"the thinking block should be closed before text starts")
+}
+
+func TestGeminiProvider_TransformRequest_ToolChoice(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	tests := []struct {
+		name       string
+		toolChoice string
+		expected   any
+	}{
+		{"auto", `{"type":"auto"}`, map[string]any{"functionCallingConfig": map[string]any{"mode": "AUTO"}}},
+		{"any", `{"type":"any","disable_parallel_tool_use":true}`, map[string]any{"functionCallingConfig": map[string]any{"mode": "ANY"}}},
+		{"none", `{"type":"none"}`, map[string]any{"functionCallingConfig": map[string]any{"mode": "NONE"}}},
+		{"tool", `{"type":"tool","name":"get_weather"}`, map[string]any{"functionCallingConfig": map[string]any{
+			"mode": "ANY", "allowedFunctionNames": []any{"get_weather"},
+		}}},
+		{"tool without name", `{"type":"tool"}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"Weather?"}],` +
+				`"tools":[{"name":"get_weather","input_schema":{"type":"object"}}],"tool_choice":` + tt.toolChoice + `}`
+
+			result, err := provider.TransformRequest([]byte(request))
+			require.NoError(t, err)
+
+			var geminiReq map[string]any
+			require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+			assert.Equal(t, tt.expected, geminiReq["toolConfig"])
+		})
+	}
+}
+
+func TestGeminiProvider_TransformRequest_SystemInstruction(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"model": "gemini-2.5-pro",
+		"system": [
+			{"type": "text", "text": "You are Claude Code."},
+			{"type": "text", "text": "Be concise.", "cache_control": {"type": "ephemeral"}}
+		],
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	assert.Equal(t, map[string]any{
+		"parts": []any{map[string]any{"text": "You are Claude Code.\n\nBe concise."}},
+	}, geminiReq["systemInstruction"])
+	assert.Equal(t, []any{
+		map[string]any{"role": "user", "parts": []any{map[string]any{"text": "Hi"}}},
+	}, geminiReq["contents"], "the system prompt should not be sent as a user turn")
+}
+
+func TestGeminiProvider_TransformRequest_FunctionResponseNames(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"model": "gemini-2.5-flash",
+		"messages": [
+			{"role": "user", "content": "Weather and time in Paris?"},
+			{"role": "assistant", "content": [
+				{"type": "tool_use", "id": "toolu_gemini_r1_0", "name": "get_weather", "input": {"city": "Paris"}},
+				{"type": "tool_use", "id": "toolu_gemini_r1_1", "name": "get_time", "input": {"zone": "CET"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_gemini_r1_0", "content": "Sunny"}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_gemini_r1_1", "content": "14:00"},
+				{"type": "tool_result", "tool_use_id": "toolu_unknown", "content": "?"}
+			]}
+		]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	contents, ok := geminiReq["contents"].([]any)
+	require.True(t, ok)
+	require.Len(t, contents, 3, "parallel results should be grouped into one user turn")
+
+	assert.Equal(t, map[string]any{"role": "user", "parts": []any{
+		map[string]any{"functionResponse": map[string]any{"name": "get_weather", "response": map[string]any{"content": "Sunny"}}},
+		map[string]any{"functionResponse": map[string]any{"name": "get_time", "response": map[string]any{"content": "14:00"}}},
+		map[string]any{"functionResponse": map[string]any{"name": "toolu_unknown", "response": map[string]any{"content": "?"}}},
+	}}, contents[2])
+}
+
+func TestGeminiProvider_StableToolUseIDs(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	response := `{
+		"responseId": "Abc-123.x",
+		"candidates": [{"content": {"role": "model", "parts": [
+			{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+			{"functionCall": {"name": "get_time", "args": {"zone": "CET"}}}
+		]}, "finishReason": "STOP"}]
+	}`
+
+	toolIDs := func() []any {
+		result, err := provider.TransformResponse([]byte(response))
+		require.NoError(t, err)
+
+		var anthropicResp map[string]any
+		require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+		content, _ := anthropicResp["content"].([]any)
+
+		var ids []any
+		for _, block := range content {
+			ids = append(ids, block.(map[string]any)["id"])
+		}
+
+		return ids
+	}
+
+	expected := []any{"toolu_gemini_Abc-123_x_0", "toolu_gemini_Abc-123_x_1"}
+	assert.Equal(t, expected, toolIDs())
+	assert.Equal(t, expected, toolIDs(), "converting the same response again should give the same ids")
+
+	// Streaming numbers calls the same way
+	state := &StreamState{}
+	events, err := provider.TransformStream([]byte(response), state)
+	require.NoError(t, err)
+	assert.Contains(t, string(events), `"id":"toolu_gemini_Abc-123_x_0"`)
+	assert.Contains(t, string(events), `"id":"toolu_gemini_Abc-123_x_1"`)
+}
+
+func TestSanitizeGeminiSchema(t *testing.T) {
+	var schema map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"additionalProperties": false,
+		"required": ["file_path", "edits", "missing"],
+		"properties": {
+			"file_path": {"type": "string", "format": "uri", "description": "Absolute path"},
+			"limit": {"type": "number", "exclusiveMinimum": 0},
+			"created": {"type": "string", "format": "date-time"},
+			"mode": {"oneOf": [{"const": "fast"}, {"type": "integer", "enum": [1, 2]}]},
+			"owner": {"type": ["string", "null"]},
+			"edits": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/edit"}},
+			"node": {"$ref": "#/$defs/node"}
+		},
+		"$defs": {
+			"edit": {
+				"allOf": [
+					{"type": "object", "properties": {"old_string": {"type": "string"}}, "required": ["old_string"]},
+					{"properties": {"new_string": {"type": "string"}}, "required": ["new_string"], "additionalProperties": false}
+				]
+			},
+			"node": {"type": "object", "properties": {"child": {"$ref": "#/$defs/node"}}}
+		}
+	}`), &schema))
+
+	sanitized, dropped := sanitizeGeminiSchema(schema)
+
+	expected := `{
+		"type": "object",
+		"required": ["file_path", "edits"],
+		"properties": {
+			"file_path": {"type": "string", "description": "Absolute path"},
+			"limit": {"type": "number", "minimum": 0},
+			"created": {"type": "string", "format": "date-time"},
+			"mode": {"anyOf": [{"enum": ["fast"]}, {"type": "integer"}]},
+			"owner": {"type": "string", "nullable": true},
+			"edits": {"type": "array", "minItems": 1, "items": {
+				"type": "object",
+				"properties": {"old_string": {"type": "string"}, "new_string": {"type": "string"}},
+				"required": ["old_string", "new_string"]
+			}},
+			"node": {"type": "object", "properties": {"child": {"type": "object"}}}
+		}
+	}`
+
+	sanitizedJSON, err := json.Marshal(sanitized)
+	require.NoError(t, err)
+	assert.JSONEq(t, expected, string(sanitizedJSON))
+
+	assert.ElementsMatch(t, []string{
+		"$schema",
+		"additionalProperties",
+		"properties.file_path.format",
+		"properties.mode.oneOf.1.enum",
+		"properties.edits.items.allOf.1.additionalProperties",
+		"properties.node.properties.child.$ref",
+	}, dropped)
+}
+
+func TestGeminiProvider_TransformRequest_ToolSchemas(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"model": "gemini-2.5-pro",
+		"messages": [{"role": "user", "content": "Plan"}],
+		"tools": [
+			{"name": "ExitPlanMode", "input_schema": {"type": "object", "properties": {}, "additionalProperties": false}},
+			{"name": "Read", "input_schema": {"$schema": "http://json-schema.org/draft-07/schema#", "type": "object",
+				"properties": {"file_path": {"type": "string"}}, "required": ["file_path"], "additionalProperties": false}}
+		]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	assert.Equal(t, []any{map[string]any{"functionDeclarations": []any{
+		map[string]any{"name": "ExitPlanMode"},
+		map[string]any{"name": "Read", "parameters": map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"file_path": map[string]any{"type": "string"}},
+			"required":   []any{"file_path"},
+		}},
+	}}}, geminiReq["tools"])
+}
+
+func TestGeminiProvider_TransformRequest_LogsSchemaDropsOnce(t *testing.T) {
+	var logs bytes.Buffer
+
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{
+		"model": "gemini-2.5-pro",
+		"messages": [{"role": "user", "content": "Plan"}],
+		"tools": [
+			{"name": "Read", "input_schema": {"type": "object", "properties": {"file_path": {"type": "string"}},
+				"additionalProperties": false}},
+			{"name": "Edit", "input_schema": {"type": "object", "properties": {"edit": {"$ref": "#/$defs/edit"}},
+				"$defs": {"edit": {"type": "string"}}}}
+		]
+	}`
+
+	for range 3 {
+		_, err := provider.TransformRequest([]byte(request))
+		require.NoError(t, err)
+	}
+
+	output := logs.String()
+	assert.Equal(t, 1, strings.Count(output, "level=WARN"), "each tool should be logged once:\n%s", output)
+	assert.Contains(t, output, "tool=Read keywords=[additionalProperties]")
+	assert.NotContains(t, output, "tool=Edit", "inlined $defs are not dropped keywords")
+}
+
+func TestGeminiProvider_EndpointURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiBase  string
+		stream   bool
+		expected string
+	}{
+		{"models base", "https://generativelanguage.googleapis.com/v1beta/models", false,
+			"https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:generateContent"},
+		{"models base streaming", "https://generativelanguage.googleapis.com/v1beta/models/", true,
+			"https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse"},
+		{"model in base", "https://generativelanguage.googleapis.com/v1beta/models/gemini-pro:generateContent", true,
+			"https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse"},
+		{"other base", "https://proxy.example.com/gemini", false,
+			"https://proxy.example.com/gemini/gemini-2.5-pro:generateContent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewGeminiProvider(&config.Provider{Name: "gemini", APIBase: tt.apiBase})
+			assert.Equal(t, tt.expected, provider.EndpointURL("gemini-2.5-pro", tt.stream))
+		})
+	}
+}
+
+func TestGeminiProvider_TransformRequest_SafetySettings(t *testing.T) {
+	request := []byte(`{"model":"gemini-2.0-flash","messages":[{"role":"user","content":"Hi"}]}`)
+
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	result, err := provider.TransformRequest(request)
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	settings, ok := geminiReq["safetySettings"].([]any)
+	require.True(t, ok)
+	require.Len(t, settings, 4)
+
+	for _, setting := range settings {
+		assert.Equal(t, "BLOCK_NONE", setting.(map[string]any)["threshold"])
+	}
+
+	provider = NewGeminiProvider(&config.Provider{Name: "gemini", SafetySettings: []config.SafetySetting{
+		{Category: "HARM_CATEGORY_DANGEROUS_CONTENT", Threshold: "BLOCK_ONLY_HIGH"},
+	}})
+
+	result, err = provider.TransformRequest(request)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	assert.Equal(t, []any{
+		map[string]any{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_ONLY_HIGH"},
+	}, geminiReq["safetySettings"], "configured settings should replace the defaults")
+}
+
+func TestGeminiProvider_BlockedResponses(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	t.Run("blocked prompt", func(t *testing.T) {
+		response := `{
+			"promptFeedback": {"blockReason": "SAFETY", "safetyRatings": [
+				{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "HIGH", "blocked": true},
+				{"category": "HARM_CATEGORY_HARASSMENT", "probability": "NEGLIGIBLE"}
+			]},
+			"usageMetadata": {"promptTokenCount": 12},
+			"modelVersion": "gemini-2.0-flash",
+			"responseId": "blocked-1"
+		}`
+
+		result, err := provider.TransformResponse([]byte(response))
+		require.NoError(t, err)
+
+		assert.JSONEq(t, `{
+			"id": "blocked-1",
+			"type": "message",
+			"role": "assistant",
+			"model": "gemini-2.0-flash",
+			"content": [{"type": "text", "text": "Gemini blocked the prompt: SAFETY (HARM_CATEGORY_DANGEROUS_CONTENT)"}],
+			"stop_reason": "refusal",
+			"stop_sequence": null,
+			"usage": {"input_tokens": 12, "output_tokens": 0}
+		}`, string(result))
+	})
+
+	t.Run("blocked response", func(t *testing.T) {
+		response := `{"candidates": [{"finishReason": "RECITATION"}], "responseId": "blocked-2"}`
+
+		result, err := provider.TransformResponse([]byte(response))
+		require.NoError(t, err)
+
+		var anthropicResp map[string]any
+		require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+		assert.Equal(t, "refusal", anthropicResp["stop_reason"])
+		assert.Equal(t, []any{map[string]any{"type": "text", "text": "Gemini blocked the response: RECITATION"}}, anthropicResp["content"])
+	})
+
+	t.Run("partial response", func(t *testing.T) {
+		response := `{"candidates": [{"finishReason": "SAFETY", "content": {"role": "model", "parts": [{"text": "Step one"}]}}]}`
+
+		result, err := provider.TransformResponse([]byte(response))
+		require.NoError(t, err)
+
+		var anthropicResp map[string]any
+		require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+		assert.Equal(t, "refusal", anthropicResp["stop_reason"])
+		assert.Equal(t, []any{map[string]any{"type": "text", "text": "Step one"}}, anthropicResp["content"], "generated text should be kept")
+	})
+
+	t.Run("blocked prompt stream", func(t *testing.T) {
+		state := &StreamState{}
+
+		events, err := provider.TransformStream([]byte(`{"promptFeedback":{"blockReason":"PROHIBITED_CONTENT"},"responseId":"blocked-3"}`), state)
+		require.NoError(t, err)
+
+		result := string(events)
+		assert.Contains(t, result, "event: message_start")
+		assert.Contains(t, result, `"text":"Gemini blocked the prompt: PROHIBITED_CONTENT"`)
+		assert.Contains(t, result, `"stop_reason":"refusal"`)
+		assert.Contains(t, result, "event: message_stop")
+	})
+
+	t.Run("blocked response stream", func(t *testing.T) {
+		state := &StreamState{}
+
+		chunk := `{"candidates":[{"finishReason":"SAFETY","safetyRatings":[` +
+			`{"category":"HARM_CATEGORY_HATE_SPEECH","probability":"HIGH","blocked":true}]}],"responseId":"blocked-4"}`
+
+		events, err := provider.TransformStream([]byte(chunk), state)
+		require.NoError(t, err)
+
+		result := string(events)
+		assert.Contains(t, result, `"text":"Gemini blocked the response: SAFETY (HARM_CATEGORY_HATE_SPEECH)"`)
+		assert.Contains(t, result, `"stop_reason":"refusal"`)
+		assert.Equal(t, 1, strings.Count(result, "event: content_block_stop"))
+	})
+}
+
+func TestGeminiProvider_WebSearch(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+
+	request := `{"model":"gemini-2.5-flash","messages":[{"role":"user","content":"Weather in Paris?"}],"tools":[
+		{"type":"web_search_20250305","name":"web_search","max_uses":5},
+		{"name":"get_time","input_schema":{"type":"object","properties":{"zone":{"type":"string"}}}}
+	]}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var geminiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiReq))
+
+	tools, err := json.Marshal(geminiReq["tools"])
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"functionDeclarations": [{"name": "get_time", "parameters": {"type": "object", "properties": {"zone": {"type": "string"}}}}]},
+		{"googleSearch": {}}
+	]`, string(tools))
+
+	grounding := `"groundingMetadata": {
+		"webSearchQueries": ["paris weather today"],
+		"groundingChunks": [
+			{"web": {"uri": "https://example.com/paris", "title": "example.com"}},
+			{"web": {"uri": "https://weather.example/fr", "title": "weather.example"}}
+		],
+		"groundingSupports": [
+			{"segment": {"endIndex": 15, "text": "Paris is 21°C."}, "groundingChunkIndices": [0, 1]},
+			{"segment": {"startIndex": 16, "endIndex": 27, "text": "Rain later."}, "groundingChunkIndices": [1]}
+		]
+	}`
+
+	response := `{"responseId": "resp-1", "modelVersion": "gemini-2.5-flash", "candidates": [{
+		"content": {"role": "model", "parts": [{"text": "Paris is 21°C. "}, {"text": "Rain later."}]},
+		"finishReason": "STOP",
+		` + grounding + `
+	}], "usageMetadata": {"promptTokenCount": 8, "candidatesTokenCount": 6}}`
+
+	result, err = provider.TransformResponse([]byte(response))
+	require.NoError(t, err)
+
+	var anthropicResp map[string]any
+	require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+	content, err := json.Marshal(anthropicResp["content"])
+	require.NoError(t, err)
+
+	// Segment offsets are bytes of the joined text parts
+	assert.JSONEq(t, `[
+		{"type": "server_tool_use", "id": "srvtoolu_resp-1", "name": "web_search", "input": {"query": "paris weather today"}},
+		{"type": "web_search_tool_result", "tool_use_id": "srvtoolu_resp-1", "content": [
+			{"type": "web_search_result", "url": "https://example.com/paris", "title": "example.com",
+			 "encrypted_content": "", "page_age": null},
+			{"type": "web_search_result", "url": "https://weather.example/fr", "title": "weather.example",
+			 "encrypted_content": "", "page_age": null}
+		]},
+		{"type": "text", "text": "Paris is 21°C.", "citations": [
+			{"type": "web_search_result_location", "url": "https://example.com/paris", "title": "example.com",
+			 "encrypted_index": "", "cited_text": "Paris is 21°C."},
+			{"type": "web_search_result_location", "url": "https://weather.example/fr", "title": "weather.example",
+			 "encrypted_index": "", "cited_text": "Paris is 21°C."}
+		]},
+		{"type": "text", "text": " "},
+		{"type": "text", "text": "Rain later.", "citations": [
+			{"type": "web_search_result_location", "url": "https://weather.example/fr", "title": "weather.example",
+			 "encrypted_index": "", "cited_text": "Rain later."}
+		]}
+	]`, string(content))
+	assert.Equal(t, map[string]any{"web_search_requests": float64(1)}, anthropicResp["usage"].(map[string]any)["server_tool_use"])
+
+	state := &StreamState{}
+
+	var output strings.Builder
+
+	for _, chunk := range []string{
+		`{"responseId":"resp-2","candidates":[{"content":{"role":"model","parts":[{"text":"Paris is 21°C. Rain later."}]}}]}`,
+		`{"responseId":"resp-2","candidates":[{"content":{"role":"model","parts":[{"text":""}]},"finishReason":"STOP",` + grounding + `}]}`,
+	} {
+		events, err := provider.TransformStream([]byte(chunk), state)
+		require.NoError(t, err)
+		output.Write(events)
+	}
+
+	stream := output.String()
+
+	expectedOrder := []string{
+		`"delta":{"text":"Paris is 21°C. Rain later.","type":"text_delta"}`,
+		`"type":"citations_delta"},"index":0`,
+		`data: {"index":0,"type":"content_block_stop"}`,
+		`"content_block":{"id":"srvtoolu_resp-2","input":{},"name":"web_search","type":"server_tool_use"},"index":1`,
+		`"type":"web_search_tool_result"},"index":2`,
+		`"stop_reason":"end_turn"`,
+	}
+
+	position := 0
+
+	for _, expected := range expectedOrder {
+		index := strings.Index(stream[position:], expected)
+		require.GreaterOrEqual(t, index, 0, "expected %q after position %d in:\n%s", expected, position, stream)
+		position += index + len(expected)
+	}
+
+	assert.Equal(t, 3, strings.Count(stream, "citations_delta"))
+	assert.Equal(t, 3, strings.Count(stream, "event: content_block_stop"), "every block should be closed once")
+}
+
+// TestGeminiProvider_GoldenStreams replays the recorded stream chunks in testdata/gemini
+func TestGeminiProvider_GoldenStreams(t *testing.T) {
+	provider := NewGeminiProvider(&config.Provider{Name: "gemini"})
+	testGoldenStreams(t, "gemini", map[string]Provider{"gemini": provider}, provider)
+}
+
+// BenchmarkGeminiProvider_TransformStream converts the recorded streams, reporting the
+// allocations per chunk
+func BenchmarkGeminiProvider_TransformStream(b *testing.B) {
+	benchmarkStreams(b, "gemini", NewGeminiProvider(&config.Provider{Name: "gemini"}))
+}
@@ -0,0 +1,313 @@
+package providers
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// geminiSchemaKeywords are the OpenAPI schema fields Gemini accepts in function declarations.
+// Anything else makes the request fail with a 400.
+var geminiSchemaKeywords = map[string]bool{
+	"type":             true,
+	"title":            true,
+	"description":      true,
+	"nullable":         true,
+	"default":          true,
+	"example":          true,
+	"minimum":          true,
+	"maximum":          true,
+	"minLength":        true,
+	"maxLength":        true,
+	"pattern":          true,
+	"minItems":         true,
+	"maxItems":         true,
+	"minProperties":    true,
+	"maxProperties":    true,
+	"propertyOrdering": true,
+}
+
+// geminiSchemaFormats are the format values Gemini accepts, per type
+var geminiSchemaFormats = map[string][]string{
+	"string":  {"enum", "date-time"},
+	"number":  {"float", "double"},
+	"integer": {"int32", "int64"},
+}
+
+// geminiSchemaSanitizer rewrites a JSON Schema into the subset Gemini accepts
+type geminiSchemaSanitizer struct {
+	defs    map[string]any
+	dropped []string
+}
+
+// sanitizeGeminiSchema rewrites a tool input schema for Gemini. References are inlined,
+// oneOf becomes anyOf, allOf is merged, type unions become nullable or anyOf, and
+// unsupported keywords are dropped. It returns the paths of the dropped keywords.
+func sanitizeGeminiSchema(schema map[string]any) (map[string]any, []string) {
+	sanitizer := &geminiSchemaSanitizer{defs: make(map[string]any)}
+
+	for _, key := range []string{"$defs", "definitions"} {
+		if defs, ok := schema[key].(map[string]any); ok {
+			for name, def := range defs {
+				sanitizer.defs["#/"+key+"/"+name] = def
+			}
+		}
+	}
+
+	return sanitizer.sanitize(schema, "", nil), sanitizer.dropped
+}
+
+func (s *geminiSchemaSanitizer) drop(path, keyword string) {
+	s.dropped = append(s.dropped, joinSchemaPath(path, keyword))
+}
+
+func joinSchemaPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// sanitize converts one schema node. resolving holds the references being inlined, so
+// recursive definitions are cut off instead of expanded forever.
+func (s *geminiSchemaSanitizer) sanitize(schema map[string]any, path string, resolving []string) map[string]any {
+	if ref, ok := schema["$ref"].(string); ok {
+		def, found := s.defs[ref].(map[string]any)
+		if !found || slices.Contains(resolving, ref) {
+			s.drop(path, "$ref")
+			return map[string]any{"type": "object"}
+		}
+
+		// Keywords next to $ref, such as a description, take precedence over the definition
+		merged := make(map[string]any, len(def)+len(schema))
+		for key, value := range def {
+			merged[key] = value
+		}
+
+		for key, value := range schema {
+			if key != "$ref" {
+				merged[key] = value
+			}
+		}
+
+		return s.sanitize(merged, path, append(resolving, ref))
+	}
+
+	result := make(map[string]any)
+
+	keys := make([]string, 0, len(schema))
+	for key := range schema {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := schema[key]
+
+		switch key {
+		case "type":
+			s.convertType(value, result)
+		case "properties":
+			if properties, ok := value.(map[string]any); ok {
+				converted := make(map[string]any, len(properties))
+
+				for name, property := range properties {
+					if propertyMap, ok := property.(map[string]any); ok {
+						converted[name] = s.sanitize(propertyMap, joinSchemaPath(path, "properties."+name), resolving)
+					}
+				}
+
+				result["properties"] = converted
+			}
+		case "items":
+			if items, ok := value.(map[string]any); ok {
+				result["items"] = s.sanitize(items, joinSchemaPath(path, "items"), resolving)
+			} else {
+				s.drop(path, key)
+			}
+		case "anyOf", "oneOf":
+			// Gemini has no exclusive unions, anyOf is the closest match
+			if variants := s.sanitizeList(value, joinSchemaPath(path, key), resolving); len(variants) > 0 {
+				result["anyOf"] = append(asList(result["anyOf"]), variants...)
+			}
+		case "allOf":
+			// Merged below, after the keywords of this schema are converted
+		case "$defs", "definitions":
+			// Root definitions are inlined where they are referenced, nested ones can't be
+			if path != "" {
+				s.drop(path, key)
+			}
+		case "required":
+			result["required"] = value
+		case "enum":
+			if values, ok := stringList(value); ok {
+				result["enum"] = values
+			} else {
+				s.drop(path, key)
+			}
+		case "const":
+			if value, ok := value.(string); ok {
+				result["enum"] = []any{value}
+			} else {
+				s.drop(path, key)
+			}
+		case "format":
+			s.convertFormat(schema, value, path, result)
+		case "exclusiveMinimum", "exclusiveMaximum":
+			// Draft 6 numeric bounds are kept as inclusive ones, the draft 4 boolean form is dropped
+			bound := "minimum"
+			if key == "exclusiveMaximum" {
+				bound = "maximum"
+			}
+
+			if number, ok := value.(float64); ok && schema[bound] == nil {
+				result[bound] = number
+			} else if _, ok := value.(bool); !ok {
+				s.drop(path, key)
+			}
+		default:
+			if geminiSchemaKeywords[key] {
+				result[key] = value
+			} else {
+				s.drop(path, key)
+			}
+		}
+	}
+
+	if allOf, ok := schema["allOf"]; ok {
+		for _, variant := range s.sanitizeList(allOf, joinSchemaPath(path, "allOf"), resolving) {
+			mergeGeminiSchema(result, variant.(map[string]any))
+		}
+	}
+
+	// Required entries must name a declared property, and only once
+	if required, ok := result["required"].([]any); ok {
+		properties, _ := result["properties"].(map[string]any)
+
+		filtered := make([]any, 0, len(required))
+		for _, name := range required {
+			if nameStr, ok := name.(string); ok && properties[nameStr] != nil && !slices.Contains(filtered, any(nameStr)) {
+				filtered = append(filtered, nameStr)
+			}
+		}
+
+		result["required"] = filtered
+		if len(filtered) == 0 {
+			delete(result, "required")
+		}
+	}
+
+	return result
+}
+
+func (s *geminiSchemaSanitizer) sanitizeList(value any, path string, resolving []string) []any {
+	list, _ := value.([]any)
+
+	converted := make([]any, 0, len(list))
+
+	for i, item := range list {
+		if itemMap, ok := item.(map[string]any); ok {
+			converted = append(converted, s.sanitize(itemMap, joinSchemaPath(path, strconv.Itoa(i)), resolving))
+		}
+	}
+
+	return converted
+}
+
+// convertType maps JSON Schema type unions to Gemini, which takes a single type. A null
+// member becomes nullable, several other members become anyOf.
+func (s *geminiSchemaSanitizer) convertType(value any, result map[string]any) {
+	switch typeValue := value.(type) {
+	case string:
+		if typeValue == "null" {
+			result["nullable"] = true
+		} else {
+			result["type"] = typeValue
+		}
+	case []any:
+		var types []string
+
+		for _, member := range typeValue {
+			memberType, _ := member.(string)
+
+			switch memberType {
+			case "":
+			case "null":
+				result["nullable"] = true
+			default:
+				types = append(types, memberType)
+			}
+		}
+
+		if len(types) == 1 {
+			result["type"] = types[0]
+			return
+		}
+
+		for _, memberType := range types {
+			result["anyOf"] = append(asList(result["anyOf"]), map[string]any{"type": memberType})
+		}
+	}
+}
+
+func (s *geminiSchemaSanitizer) convertFormat(schema map[string]any, value any, path string, result map[string]any) {
+	format, _ := value.(string)
+	schemaType, _ := schema["type"].(string)
+
+	if slices.Contains(geminiSchemaFormats[schemaType], format) {
+		result["format"] = format
+		return
+	}
+
+	s.drop(path, "format")
+}
+
+// mergeGeminiSchema merges an allOf member into the schema. Properties and required entries
+// are combined, other keywords are only taken when the schema doesn't set them.
+func mergeGeminiSchema(schema, member map[string]any) {
+	for key, value := range member {
+		switch key {
+		case "properties":
+			properties, _ := schema["properties"].(map[string]any)
+			if properties == nil {
+				properties = make(map[string]any)
+				schema["properties"] = properties
+			}
+
+			for name, property := range value.(map[string]any) {
+				if _, exists := properties[name]; !exists {
+					properties[name] = property
+				}
+			}
+		case "required":
+			schema["required"] = append(asList(schema["required"]), asList(value)...)
+		default:
+			if _, exists := schema[key]; !exists {
+				schema[key] = value
+			}
+		}
+	}
+}
+
+func asList(value any) []any {
+	list, _ := value.([]any)
+	return list
+}
+
+// stringList reports whether every enum value is a string, the only kind Gemini accepts
+func stringList(value any) ([]any, bool) {
+	list, ok := value.([]any)
+	if !ok {
+		return nil, false
+	}
+
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return nil, false
+		}
+	}
+
+	return list, true
+}
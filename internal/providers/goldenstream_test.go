@@ -0,0 +1,113 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of the stream tests")
+
+// timestampID matches the nanosecond timestamps in the IDs some providers generate
+var timestampID = regexp.MustCompile(`_\d{15,}`)
+
+// testGoldenStreams replays the recorded chunks in testdata/<dir> through every provider,
+// which must all produce the events of the matching golden file. The first provider writes
+// the golden files with -update.
+func testGoldenStreams(t *testing.T, dir string, providers map[string]Provider, record Provider) {
+	t.Helper()
+
+	inputs, err := filepath.Glob(filepath.Join("testdata", dir, "*.jsonl"))
+	require.NoError(t, err)
+	require.NotEmpty(t, inputs)
+
+	for _, input := range inputs {
+		golden := strings.TrimSuffix(input, ".jsonl") + ".golden"
+
+		t.Run(filepath.Base(golden), func(t *testing.T) {
+			if *update {
+				require.NoError(t, os.WriteFile(golden, replayStream(t, record, input), 0o600))
+			}
+
+			expected, err := os.ReadFile(golden)
+			require.NoError(t, err)
+
+			for name, provider := range providers {
+				assert.Equal(t, string(expected), string(replayStream(t, provider, input)), name)
+			}
+		})
+	}
+}
+
+// replayStream transforms each line of the file as a stream chunk and finishes the stream
+// like the proxy does, estimating four characters per token. Generated timestamp IDs are
+// replaced by zero so the events are the same on every run.
+func replayStream(t *testing.T, provider Provider, path string) []byte {
+	t.Helper()
+
+	chunks, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var (
+		events  bytes.Buffer
+		state   = &StreamState{}
+		scanner = bufio.NewScanner(bytes.NewReader(chunks))
+	)
+
+	for scanner.Scan() {
+		chunkEvents, err := provider.TransformStream(scanner.Bytes(), state)
+		require.NoError(t, err)
+		events.Write(chunkEvents)
+	}
+
+	require.NoError(t, scanner.Err())
+
+	events.Write(FinishStream(state, 50, func(text string) int {
+		return len(text) / 4
+	}))
+
+	return timestampID.ReplaceAll(events.Bytes(), []byte("_0"))
+}
+
+// benchmarkStreams converts the recorded streams in testdata/<dir>, reporting the
+// allocations per chunk
+func benchmarkStreams(b *testing.B, dir string, provider Provider) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", dir, "*.jsonl"))
+	require.NoError(b, err)
+
+	for _, input := range inputs {
+		data, err := os.ReadFile(input)
+		require.NoError(b, err)
+
+		chunks := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+
+		b.Run(strings.TrimSuffix(filepath.Base(input), ".jsonl"), func(b *testing.B) {
+			b.ReportAllocs()
+
+			for range b.N {
+				state := &StreamState{}
+
+				for _, chunk := range chunks {
+					if _, err := provider.TransformStream(chunk, state); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+
+			b.ReportMetric(float64(testing.AllocsPerRun(100, func() {
+				state := &StreamState{}
+				for _, chunk := range chunks {
+					_, _ = provider.TransformStream(chunk, state)
+				}
+			}))/float64(len(chunks)), "allocs/chunk")
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+const (
+	ContentTypeImage = "image"
+
+	imageSourceBase64 = "base64"
+	imageSourceURL    = "url"
+
+	// Used when the media type of an image URL can't be derived from its extension
+	defaultImageMediaType = "image/jpeg"
+)
+
+// imageSource holds the source of an Anthropic image block
+type imageSource struct {
+	Type      string
+	MediaType string
+	Data      string
+	URL       string
+}
+
+// parseImageSource reads the source of an image block, reporting false for unknown source
+// types or sources without data
+func parseImageSource(block map[string]any) (imageSource, bool) {
+	sourceMap, ok := block["source"].(map[string]any)
+	if !ok {
+		return imageSource{}, false
+	}
+
+	var source imageSource
+
+	source.Type, _ = sourceMap["type"].(string)
+	source.MediaType, _ = sourceMap["media_type"].(string)
+	source.Data, _ = sourceMap["data"].(string)
+	source.URL, _ = sourceMap["url"].(string)
+
+	switch source.Type {
+	case imageSourceBase64:
+		return source, source.Data != ""
+	case imageSourceURL:
+		return source, source.URL != ""
+	}
+
+	return imageSource{}, false
+}
+
+// ConvertImageToOpenAI converts an Anthropic image block to an OpenAI image_url content
+// part. Base64 images are sent as data URLs.
+func ConvertImageToOpenAI(block map[string]any) map[string]any {
+	source, ok := parseImageSource(block)
+	if !ok {
+		return nil
+	}
+
+	imageURL := source.URL
+	if source.Type == imageSourceBase64 {
+		imageURL = fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data)
+	}
+
+	return map[string]any{
+		"type": "image_url",
+		"image_url": map[string]any{
+			"url": imageURL,
+		},
+	}
+}
+
+// convertImageToGemini converts an Anthropic image block to a Gemini inlineData part, or a
+// fileData part for image URLs
+func convertImageToGemini(block map[string]any) map[string]any {
+	source, ok := parseImageSource(block)
+	if !ok {
+		return nil
+	}
+
+	if source.Type == imageSourceURL {
+		return map[string]any{
+			"fileData": map[string]any{
+				"mimeType": imageMediaTypeFromURL(source.URL),
+				"fileUri":  source.URL,
+			},
+		}
+	}
+
+	return map[string]any{
+		"inlineData": map[string]any{
+			"mimeType": source.MediaType,
+			"data":     source.Data,
+		},
+	}
+}
+
+// imageMediaTypeFromURL guesses the media type of an image URL from its file extension
+func imageMediaTypeFromURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return defaultImageMediaType
+	}
+
+	if mediaType := mime.TypeByExtension(strings.ToLower(path.Ext(parsed.Path))); strings.HasPrefix(mediaType, "image/") {
+		return mediaType
+	}
+
+	return defaultImageMediaType
+}
+
+// collectImageBlocks returns every image block in the request messages, including images
+// nested in tool results
+func collectImageBlocks(request map[string]any) []map[string]any {
+	var images []map[string]any
+
+	var collect func(content any)
+
+	collect = func(content any) {
+		blocks, ok := content.([]any)
+		if !ok {
+			return
+		}
+
+		for _, block := range blocks {
+			blockMap, ok := block.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			switch blockMap["type"] {
+			case ContentTypeImage:
+				images = append(images, blockMap)
+			case MessageTypeToolResult:
+				collect(blockMap["content"])
+			}
+		}
+	}
+
+	messages, _ := request["messages"].([]any)
+	for _, message := range messages {
+		if msgMap, ok := message.(map[string]any); ok {
+			collect(msgMap["content"])
+		}
+	}
+
+	return images
+}
+
+// CheckImageSupport returns a RequestError when a request carries images for a model the
+// provider has configured as text only
+func CheckImageSupport(request []byte, provider *config.Provider) *RequestError {
+	var anthropicRequest map[string]any
+	if err := json.Unmarshal(request, &anthropicRequest); err != nil {
+		// Malformed requests are reported by the transformation
+		return nil
+	}
+
+	model, _ := anthropicRequest["model"].(string)
+	if provider.SupportsImages(model) || len(collectImageBlocks(anthropicRequest)) == 0 {
+		return nil
+	}
+
+	return NewInvalidRequestError(
+		"model %q on provider %q does not support image input, remove the images or route the request to a vision model",
+		model, provider.Name)
+}
+
+// convertOpenAIImages converts image blocks left in user messages into image_url parts
+func convertOpenAIImages(messages []any) []any {
+	result := make([]any, 0, len(messages))
+
+	for _, message := range messages {
+		msgMap, ok := message.(map[string]any)
+		if !ok {
+			result = append(result, message)
+			continue
+		}
+
+		content, ok := msgMap["content"].([]any)
+		if !ok || msgMap["role"] != RoleUser {
+			result = append(result, message)
+			continue
+		}
+
+		parts := make([]any, 0, len(content))
+
+		for _, block := range content {
+			blockMap, ok := block.(map[string]any)
+			if !ok || blockMap["type"] != ContentTypeImage {
+				parts = append(parts, block)
+				continue
+			}
+
+			if imagePart := ConvertImageToOpenAI(blockMap); imagePart != nil {
+				parts = append(parts, imagePart)
+			}
+		}
+
+		converted := make(map[string]any, len(msgMap))
+		for k, v := range msgMap {
+			converted[k] = v
+		}
+
+		converted["content"] = parts
+		if len(parts) == 0 {
+			converted["content"] = ""
+		}
+
+		result = append(result, converted)
+	}
+
+	return result
+}
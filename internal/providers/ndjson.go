@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ContentTypeNDJSON is the newline-delimited JSON framing used by Ollama's native API
+const ContentTypeNDJSON = "application/x-ndjson"
+
+// ndjsonDecoder reads one JSON document per line. Lines have no length limit, since a
+// single message can carry a full tool call or a large image.
+type ndjsonDecoder struct {
+	reader *bufio.Reader
+}
+
+// NewNDJSONDecoder creates a decoder for newline-delimited JSON streams
+func NewNDJSONDecoder(reader io.Reader) StreamDecoder {
+	return &ndjsonDecoder{reader: bufio.NewReader(reader)}
+}
+
+func (d *ndjsonDecoder) Next() ([]byte, error) {
+	for {
+		line, err := d.reader.ReadBytes('\n')
+
+		// The last line may not be newline terminated
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("read NDJSON line: %w", err)
+		}
+	}
+}
@@ -513,7 +513,7 @@ func TestNvidiaProvider_ConvertUsage(t *testing.T) {
 
 	result := provider.convertUsage(usage)
 
-	assert.Equal(t, 100, result["input_tokens"])
+	assert.Equal(t, 70, result["input_tokens"], "cached prompt tokens are reported apart from input_tokens")
 	assert.Equal(t, 50, result["output_tokens"])
 	assert.Equal(t, 20, result["cache_read_input_tokens"])
 	assert.Equal(t, 10, result["cache_creation_input_tokens"])
@@ -559,7 +559,7 @@ func TestNvidiaProvider_MapNvidiaErrorType(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.nvidiaType, func(t *testing.T) {
-			result := provider.mapNvidiaErrorType(tt.nvidiaType)
+			result := provider.mapErrorType(tt.nvidiaType)
 			assert.Equal(t, tt.expectedAnthropic, result)
 		})
 	}
@@ -0,0 +1,567 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+// OllamaProvider talks to Ollama's native /api/chat endpoint, which keeps tool calls and
+// token counts that the OpenAI compatibility layer drops. Streams are newline-delimited
+// JSON rather than server-sent events.
+type OllamaProvider struct {
+	Provider *config.Provider
+}
+
+func NewOllamaProvider(provider *config.Provider) *OllamaProvider {
+	return &OllamaProvider{
+		Provider: provider,
+	}
+}
+
+func (p *OllamaProvider) Name() string {
+	return p.Provider.Name
+}
+
+func (p *OllamaProvider) SupportsStreaming() bool {
+	return true
+}
+
+func (p *OllamaProvider) GetEndpoint() string {
+	return p.Provider.APIBase
+}
+
+func (p *OllamaProvider) GetAPIKey() string {
+	return p.Provider.GetAPIKey()
+}
+
+// NewRequest posts to the configured /api/chat URL. Ollama is usually keyless, a key is
+// sent as a bearer token for instances behind an authenticating proxy.
+func (p *OllamaProvider) NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error) {
+	req, err := newUpstreamRequest(ctx, p.GetEndpoint(), upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	setBearerAuth(req, upstream.apiKey(p.GetAPIKey))
+
+	return req, nil
+}
+
+func (p *OllamaProvider) IsStreaming(headers map[string][]string) bool {
+	for _, ct := range headers["Content-Type"] {
+		if strings.HasPrefix(ct, ContentTypeNDJSON) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *OllamaProvider) NewStreamDecoder(reader io.Reader) StreamDecoder {
+	return NewNDJSONDecoder(reader)
+}
+
+func (p *OllamaProvider) TransformRequest(request []byte) ([]byte, error) {
+	var anthropicRequest map[string]any
+	if err := json.Unmarshal(request, &anthropicRequest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic request: %w", err)
+	}
+
+	for _, image := range collectImageBlocks(anthropicRequest) {
+		if source, ok := parseImageSource(image); ok && source.Type == imageSourceURL {
+			return nil, NewInvalidRequestError("Ollama does not fetch image URLs, send images as base64 data instead")
+		}
+	}
+
+	// Ollama streams unless told otherwise, so the mode is always set explicitly
+	stream, _ := anthropicRequest["stream"].(bool)
+
+	ollamaRequest := map[string]any{
+		"model":    anthropicRequest["model"],
+		"messages": p.convertMessages(anthropicRequest),
+		"stream":   stream,
+	}
+
+	options := make(map[string]any)
+
+	optionNames := map[string]string{
+		"max_tokens":     "num_predict",
+		"temperature":    "temperature",
+		"top_p":          "top_p",
+		"top_k":          "top_k",
+		"stop_sequences": "stop",
+	}
+
+	for anthropicName, ollamaName := range optionNames {
+		if value, ok := anthropicRequest[anthropicName]; ok {
+			options[ollamaName] = value
+		}
+	}
+
+	if len(options) > 0 {
+		ollamaRequest["options"] = options
+	}
+
+	// Ollama can't force or forbid tool calls, so tool_choice none is honored by not offering
+	// the tools at all
+	choice, _ := parseToolChoice(anthropicRequest["tool_choice"])
+
+	if tools, ok := anthropicRequest["tools"].([]any); ok && choice.Type != toolChoiceNone {
+		if ollamaTools := p.convertTools(tools); len(ollamaTools) > 0 {
+			ollamaRequest["tools"] = ollamaTools
+		}
+	}
+
+	return json.Marshal(ollamaRequest)
+}
+
+func (p *OllamaProvider) convertMessages(request map[string]any) []any {
+	var messages []any
+
+	if system := SystemPromptText(request["system"]); system != "" {
+		messages = append(messages, map[string]any{"role": "system", "content": system})
+	}
+
+	// Tool results reference their call by id, but Ollama matches them by tool name
+	toolNames := make(map[string]string)
+
+	anthropicMessages, _ := request["messages"].([]any)
+	for _, msg := range anthropicMessages {
+		msgMap, ok := msg.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		role, _ := msgMap["role"].(string)
+
+		switch content := msgMap["content"].(type) {
+		case string:
+			messages = append(messages, map[string]any{"role": role, "content": content})
+		case []any:
+			messages = append(messages, p.convertContentBlocks(role, content, toolNames)...)
+		}
+	}
+
+	return messages
+}
+
+// convertContentBlocks converts the blocks of one Anthropic message. Tool results become
+// separate tool messages placed before the remaining text and images.
+func (p *OllamaProvider) convertContentBlocks(role string, blocks []any, toolNames map[string]string) []any {
+	var (
+		messages  []any
+		texts     []string
+		images    []any
+		toolCalls []any
+	)
+
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		blockType, _ := blockMap["type"].(string)
+
+		switch blockType {
+		case ContentTypeText:
+			if text, ok := blockMap["text"].(string); ok && text != "" {
+				texts = append(texts, text)
+			}
+		case ContentTypeImage:
+			if data := ollamaImageData(blockMap); data != "" {
+				images = append(images, data)
+			}
+		case ContentTypeToolUse:
+			id, _ := blockMap["id"].(string)
+			name, _ := blockMap["name"].(string)
+			toolNames[id] = name
+
+			input := blockMap["input"]
+			if input == nil {
+				input = map[string]any{}
+			}
+
+			toolCalls = append(toolCalls, map[string]any{
+				"function": map[string]any{
+					"name":      name,
+					"arguments": input,
+				},
+			})
+		case MessageTypeToolResult:
+			toolUseID, _ := blockMap["tool_use_id"].(string)
+			messages = append(messages, p.convertToolResult(blockMap, toolNames[toolUseID]))
+		}
+		// Thinking blocks are dropped, their signatures mean nothing to a local model
+	}
+
+	if len(texts) == 0 && len(images) == 0 && len(toolCalls) == 0 {
+		return messages
+	}
+
+	message := map[string]any{
+		"role":    role,
+		"content": strings.Join(texts, "\n\n"),
+	}
+
+	if len(images) > 0 {
+		message["images"] = images
+	}
+
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	return append(messages, message)
+}
+
+func (p *OllamaProvider) convertToolResult(block map[string]any, toolName string) map[string]any {
+	var (
+		texts  []string
+		images []any
+	)
+
+	switch content := block["content"].(type) {
+	case string:
+		texts = append(texts, content)
+	case []any:
+		for _, item := range content {
+			itemMap, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+
+			switch itemMap["type"] {
+			case ContentTypeText:
+				if text, ok := itemMap["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			case ContentTypeImage:
+				if data := ollamaImageData(itemMap); data != "" {
+					images = append(images, data)
+				}
+			}
+		}
+	}
+
+	message := map[string]any{
+		"role":    "tool",
+		"content": strings.Join(texts, "\n\n"),
+	}
+
+	if toolName != "" {
+		message["tool_name"] = toolName
+	}
+
+	if len(images) > 0 {
+		message["images"] = images
+	}
+
+	return message
+}
+
+// ollamaImageData returns the base64 data of an image block. Ollama doesn't fetch URLs,
+// so only inline images can be sent.
+func ollamaImageData(block map[string]any) string {
+	source, ok := parseImageSource(block)
+	if !ok || source.Type != imageSourceBase64 {
+		return ""
+	}
+
+	return source.Data
+}
+
+func (p *OllamaProvider) convertTools(tools []any) []any {
+	var ollamaTools []any
+
+	for _, tool := range tools {
+		toolMap, ok := tool.(map[string]any)
+		if !ok {
+			continue
+		}
+
+		name, _ := toolMap["name"].(string)
+		schema, hasSchema := toolMap["input_schema"]
+
+		// Server tools such as web search have no schema and can't be offered to the model
+		if name == "" || !hasSchema {
+			continue
+		}
+
+		function := map[string]any{
+			"name":       name,
+			"parameters": schema,
+		}
+
+		if description, ok := toolMap["description"].(string); ok && description != "" {
+			function["description"] = description
+		}
+
+		ollamaTools = append(ollamaTools, map[string]any{
+			"type":     "function",
+			"function": function,
+		})
+	}
+
+	return ollamaTools
+}
+
+// Ollama /api/chat response structures, shared by complete responses and stream lines
+type ollamaChatResponse struct {
+	Model   string `json:"model"`
+	Message struct {
+		Role      string           `json:"role"`
+		Content   string           `json:"content"`
+		ToolCalls []ollamaToolCall `json:"tool_calls"`
+	} `json:"message"`
+	Done            bool   `json:"done"`
+	DoneReason      string `json:"done_reason"`
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+type ollamaToolCall struct {
+	ID       string `json:"id"`
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// toolUseID returns the call id, or a synthetic one since most Ollama versions don't
+// assign ids and Claude Code needs them to match tool results to calls
+func (c *ollamaToolCall) toolUseID(index int) string {
+	if c.ID != "" {
+		return c.ID
+	}
+
+	return fmt.Sprintf("toolu_ollama_%d_%d", time.Now().UnixNano(), index)
+}
+
+// input returns the call arguments as a JSON object
+func (c *ollamaToolCall) input() json.RawMessage {
+	arguments := bytes.TrimSpace(c.Function.Arguments)
+
+	// Some models produce the arguments as an encoded JSON string
+	var encoded string
+	if err := json.Unmarshal(arguments, &encoded); err == nil {
+		arguments = []byte(encoded)
+	}
+
+	if !json.Valid(arguments) || bytes.Equal(arguments, []byte("null")) {
+		return json.RawMessage("{}")
+	}
+
+	return arguments
+}
+
+func (p *OllamaProvider) TransformResponse(response []byte) ([]byte, error) {
+	var ollamaResp ollamaChatResponse
+	if err := json.Unmarshal(response, &ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Ollama response: %w", err)
+	}
+
+	content := make([]ContentBlock, 0, len(ollamaResp.Message.ToolCalls)+1)
+
+	if ollamaResp.Message.Content != "" || len(ollamaResp.Message.ToolCalls) == 0 {
+		content = append(content, ContentBlock{Type: ContentTypeText, Text: &ollamaResp.Message.Content})
+	}
+
+	for i := range ollamaResp.Message.ToolCalls {
+		call := &ollamaResp.Message.ToolCalls[i]
+
+		block := newToolUseBlock(ContentTypeToolUse, call.toolUseID(len(content)), call.Function.Name)
+		block.Input = call.input()
+
+		content = append(content, *block)
+	}
+
+	anthropicResp := MessagesResponse{
+		ID:         fmt.Sprintf("msg_ollama_%d", time.Now().UnixNano()),
+		Type:       "message",
+		Role:       RoleAssistant,
+		Model:      ollamaResp.Model,
+		Content:    content,
+		StopReason: p.convertStopReason(ollamaResp.DoneReason, len(ollamaResp.Message.ToolCalls) > 0),
+		Usage: &Usage{
+			InputTokens:  ollamaResp.PromptEvalCount,
+			OutputTokens: ollamaResp.EvalCount,
+		},
+	}
+
+	return json.Marshal(anthropicResp)
+}
+
+// convertStopReason maps done_reason. Ollama reports "stop" after tool calls too, so the
+// presence of tool calls decides tool_use.
+func (p *OllamaProvider) convertStopReason(doneReason string, hasToolCalls bool) *string {
+	reason := StopReasonEndTurn
+
+	switch {
+	case hasToolCalls:
+		reason = ContentTypeToolUse
+	case doneReason == "length":
+		reason = "max_tokens"
+	}
+
+	return &reason
+}
+
+func (p *OllamaProvider) convertUsage(ollamaResp *ollamaChatResponse) map[string]any {
+	return map[string]any{
+		"input_tokens":  ollamaResp.PromptEvalCount,
+		"output_tokens": ollamaResp.EvalCount,
+	}
+}
+
+// TransformError converts an Ollama error body into an Anthropic error response
+func (p *OllamaProvider) TransformError(statusCode int, body []byte) ([]byte, error) {
+	var ollamaErr struct {
+		Error string `json:"error"`
+	}
+
+	if err := json.Unmarshal(body, &ollamaErr); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Ollama error response: %w", err)
+	}
+
+	return FormatAnthropicError(MapHTTPStatusToErrorType(statusCode), ollamaErr.Error), nil
+}
+
+// TransformStream converts one NDJSON stream line into Anthropic SSE events
+func (p *OllamaProvider) TransformStream(chunk []byte, state *StreamState) ([]byte, error) {
+	var ollamaResp ollamaChatResponse
+	if err := json.Unmarshal(chunk, &ollamaResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Ollama stream line: %w", err)
+	}
+
+	// Errors after the stream has started arrive as a line with only an error field, which
+	// ends the message, so nothing is sent for it when the stream closes
+	if ollamaResp.Error != "" {
+		state.PendingMessageDelta = nil
+		return errorEvent(MessageTypeAPIError, ollamaResp.Error), nil
+	}
+
+	var events []byte
+
+	if !state.MessageStartSent {
+		state.MessageID = fmt.Sprintf("msg_ollama_%d", time.Now().UnixNano())
+		state.Model = ollamaResp.Model
+		state.ContentBlocks = make(map[int]*ContentBlockState)
+		state.MessageStartSent = true
+
+		// Held until the done line, so a stream that ends without one is still finished,
+		// with estimated usage
+		state.PendingMessageDelta = finishMessageDelta(p.convertStopReason("", false))
+
+		usage := map[string]any{
+			"input_tokens":  0,
+			"output_tokens": 0,
+		}
+
+		events = append(events, FormatSSEEvent("message_start", CreateMessageStartEvent(state.MessageID, state.Model, usage))...)
+	}
+
+	if ollamaResp.Message.Content != "" {
+		events = append(events, p.handleTextDelta(ollamaResp.Message.Content, state)...)
+	}
+
+	for i := range ollamaResp.Message.ToolCalls {
+		events = append(events, p.handleToolCall(&ollamaResp.Message.ToolCalls[i], state)...)
+	}
+
+	if ollamaResp.Done {
+		events = append(events, p.handleDone(&ollamaResp, state)...)
+	}
+
+	return events, nil
+}
+
+func (p *OllamaProvider) handleTextDelta(text string, state *StreamState) []byte {
+	var events []byte
+
+	state.Output.WriteString(text)
+
+	block, exists := state.ContentBlocks[state.CurrentIndex]
+	if !exists || block.Type != ContentTypeText || block.StopSent {
+		events = p.closeOpenBlock(state)
+
+		state.CurrentIndex = len(state.ContentBlocks)
+		state.ContentBlocks[state.CurrentIndex] = &ContentBlockState{Type: ContentTypeText, StartSent: true}
+
+		events = append(events, contentBlockStartEvent(state.CurrentIndex, newTextBlock())...)
+	}
+
+	return append(events, contentBlockDeltaEvent(state.CurrentIndex, TextDelta{Type: "text_delta", Text: text})...)
+}
+
+// handleToolCall emits a complete tool_use block. Ollama sends each tool call whole in a
+// single line, so the block is opened and closed right away.
+func (p *OllamaProvider) handleToolCall(call *ollamaToolCall, state *StreamState) []byte {
+	events := p.closeOpenBlock(state)
+
+	index := len(state.ContentBlocks)
+	input := call.input()
+
+	block := &ContentBlockState{
+		Type:       ContentTypeToolUse,
+		ToolCallID: call.toolUseID(index),
+		ToolName:   call.Function.Name,
+		Arguments:  string(input),
+		StartSent:  true,
+	}
+	state.ContentBlocks[index] = block
+	state.CurrentIndex = index
+
+	state.Output.WriteString(block.ToolName)
+	state.Output.WriteString(block.Arguments)
+
+	if state.PendingMessageDelta != nil {
+		state.PendingMessageDelta.Delta.StopReason = p.convertStopReason("", true)
+	}
+
+	events = append(events, contentBlockStartEvent(index, newToolUseBlock(ContentTypeToolUse, block.ToolCallID, block.ToolName))...)
+	events = append(events, contentBlockDeltaEvent(index, InputJSONDelta{Type: "input_json_delta", PartialJSON: block.Arguments})...)
+
+	return append(events, p.closeOpenBlock(state)...)
+}
+
+func (p *OllamaProvider) handleDone(ollamaResp *ollamaChatResponse, state *StreamState) []byte {
+	state.PendingMessageDelta = nil
+
+	events := p.closeOpenBlock(state)
+
+	hasToolCalls := false
+
+	for _, block := range state.ContentBlocks {
+		if block.Type == ContentTypeToolUse {
+			hasToolCalls = true
+			break
+		}
+	}
+
+	return append(events, stopMessage(&MessageDeltaEvent{
+		Type:  "message_delta",
+		Delta: MessageDelta{StopReason: p.convertStopReason(ollamaResp.DoneReason, hasToolCalls)},
+		Usage: p.convertUsage(ollamaResp),
+	})...)
+}
+
+func (p *OllamaProvider) closeOpenBlock(state *StreamState) []byte {
+	block, exists := state.ContentBlocks[state.CurrentIndex]
+	if !exists || !block.StartSent || block.StopSent {
+		return nil
+	}
+
+	block.StopSent = true
+
+	return contentBlockStopEvent(state.CurrentIndex)
+}
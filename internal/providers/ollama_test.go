@@ -0,0 +1,244 @@
+package providers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNDJSONDecoder(t *testing.T) {
+	decoder := NewNDJSONDecoder(strings.NewReader("{\"a\":1}\n\n  \r\n{\"b\":2}\r\n{\"c\":3}"))
+
+	for _, expected := range []string{`{"a":1}`, `{"b":2}`, `{"c":3}`} {
+		line, err := decoder.Next()
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(line))
+	}
+
+	_, err := decoder.Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestOllamaProvider_IsStreaming(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	assert.True(t, provider.IsStreaming(map[string][]string{"Content-Type": {"application/x-ndjson"}}))
+	assert.False(t, provider.IsStreaming(map[string][]string{"Content-Type": {"application/json; charset=utf-8"}}))
+}
+
+func TestOllamaProvider_TransformRequest(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	request := map[string]any{
+		"model":          "llama3.1",
+		"system":         []any{map[string]any{"type": "text", "text": "Be brief"}},
+		"max_tokens":     256,
+		"temperature":    0.2,
+		"stop_sequences": []any{"END"},
+		"messages": []any{
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "text", "text": "What's in this picture?"},
+				map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "aW1n"}},
+			}},
+			map[string]any{"role": "assistant", "content": []any{
+				map[string]any{"type": "thinking", "thinking": "Hmm", "signature": "sig"},
+				map[string]any{"type": "text", "text": "Let me look closer."},
+				map[string]any{"type": "tool_use", "id": "toolu_1", "name": "zoom", "input": map[string]any{"factor": 2}},
+			}},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{
+					map[string]any{"type": "text", "text": "Zoomed"},
+					map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "em9vbQ=="}},
+				}},
+				map[string]any{"type": "text", "text": "Continue"},
+			}},
+		},
+		"tools": []any{
+			map[string]any{"name": "zoom", "description": "Zoom in", "input_schema": map[string]any{"type": "object"}},
+			map[string]any{"type": "web_search_20250305", "name": "web_search"},
+		},
+	}
+
+	requestBytes, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	result, err := provider.TransformRequest(requestBytes)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"model": "llama3.1",
+		"stream": false,
+		"options": {"num_predict": 256, "temperature": 0.2, "stop": ["END"]},
+		"messages": [
+			{"role": "system", "content": "Be brief"},
+			{"role": "user", "content": "What's in this picture?", "images": ["aW1n"]},
+			{"role": "assistant", "content": "Let me look closer.", "tool_calls": [{"function": {"name": "zoom", "arguments": {"factor": 2}}}]},
+			{"role": "tool", "tool_name": "zoom", "content": "Zoomed", "images": ["em9vbQ=="]},
+			{"role": "user", "content": "Continue"}
+		],
+		"tools": [{"type": "function", "function": {"name": "zoom", "description": "Zoom in", "parameters": {"type": "object"}}}]
+	}`, string(result))
+}
+
+func TestOllamaProvider_TransformResponse(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	ollamaResponse := `{
+		"model": "llama3.1",
+		"created_at": "2025-01-01T00:00:00Z",
+		"message": {"role": "assistant", "content": "", "tool_calls": [
+			{"function": {"name": "get_weather", "arguments": {"city": "Paris"}}},
+			{"function": {"name": "get_time", "arguments": "{\"zone\":\"CET\"}"}}
+		]},
+		"done": true,
+		"done_reason": "stop",
+		"prompt_eval_count": 42,
+		"eval_count": 17
+	}`
+
+	result, err := provider.TransformResponse([]byte(ollamaResponse))
+	require.NoError(t, err)
+
+	var response map[string]any
+	require.NoError(t, json.Unmarshal(result, &response))
+
+	assert.Equal(t, "message", response["type"])
+	assert.Equal(t, "llama3.1", response["model"])
+	assert.Equal(t, "tool_use", response["stop_reason"])
+	assert.Equal(t, map[string]any{"input_tokens": float64(42), "output_tokens": float64(17)}, response["usage"])
+
+	content, ok := response["content"].([]any)
+	require.True(t, ok)
+	require.Len(t, content, 2)
+
+	first, _ := content[0].(map[string]any)
+	second, _ := content[1].(map[string]any)
+
+	assert.Equal(t, "get_weather", first["name"])
+	assert.Equal(t, map[string]any{"city": "Paris"}, first["input"])
+	assert.Equal(t, map[string]any{"zone": "CET"}, second["input"], "string encoded arguments should be decoded")
+	assert.True(t, strings.HasPrefix(first["id"].(string), "toolu_ollama_"))
+	assert.NotEqual(t, first["id"], second["id"], "synthetic tool ids should be unique")
+
+	truncated := `{"model":"llama3.1","message":{"role":"assistant","content":"Once upon"},"done":true,"done_reason":"length"}`
+
+	result, err = provider.TransformResponse([]byte(truncated))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(result, &response))
+	assert.Equal(t, "max_tokens", response["stop_reason"])
+	assert.Equal(t, []any{map[string]any{"type": "text", "text": "Once upon"}}, response["content"])
+}
+
+func TestOllamaProvider_TransformStream(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	stream := strings.Join([]string{
+		`{"model":"llama3.1","message":{"role":"assistant","content":"Checking"},"done":false}`,
+		`{"model":"llama3.1","message":{"role":"assistant","content":" now"},"done":false}`,
+		`{"model":"llama3.1","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"get_weather","arguments":{"city":"Paris"}}}]},"done":false}`,
+		`{"model":"llama3.1","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":30,"eval_count":9}`,
+	}, "\n") + "\n"
+
+	decoder := provider.NewStreamDecoder(strings.NewReader(stream))
+	state := &StreamState{}
+
+	var output strings.Builder
+
+	for {
+		line, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		events, err := provider.TransformStream(line, state)
+		require.NoError(t, err)
+		output.Write(events)
+	}
+
+	result := output.String()
+
+	expectedOrder := []string{
+		"event: message_start",
+		`"content_block":{"text":"","type":"text"},"index":0`,
+		`"delta":{"text":"Checking","type":"text_delta"}`,
+		`"delta":{"text":" now","type":"text_delta"}`,
+		`data: {"index":0,"type":"content_block_stop"}`,
+		`"content_block":{"id":"toolu_ollama_`,
+		`"name":"get_weather","type":"tool_use"},"index":1`,
+		`"partial_json":"{\"city\":\"Paris\"}"`,
+		`data: {"index":1,"type":"content_block_stop"}`,
+		`"delta":{"stop_reason":"tool_use","stop_sequence":null}`,
+		`"usage":{"input_tokens":30,"output_tokens":9}`,
+		"event: message_stop",
+	}
+
+	position := 0
+
+	for _, expected := range expectedOrder {
+		index := strings.Index(result[position:], expected)
+		require.GreaterOrEqual(t, index, 0, "expected %q after position %d in:\n%s", expected, position, result)
+		position += index + len(expected)
+	}
+
+	assert.Equal(t, 2, strings.Count(result, "event: content_block_stop"), "every block should be closed once")
+}
+
+func TestOllamaProvider_TransformStream_Error(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	events, err := provider.TransformStream([]byte(`{"error":"an error was encountered while running the model"}`), &StreamState{})
+	require.NoError(t, err)
+	assert.Equal(t, "event: error\ndata: {\"error\":{\"message\":\"an error was encountered while running the model\",\"type\":\"api_error\"},\"type\":\"error\"}\n\n", string(events))
+}
+
+func TestOllamaProvider_TransformError(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	result, err := provider.TransformError(http.StatusNotFound, []byte(`{"error":"model \"llama9\" not found, try pulling it first"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"not_found_error","message":"model \"llama9\" not found, try pulling it first"}}`, string(result))
+}
+
+func TestOllamaProvider_TransformRequest_ImageURL(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	request := `{"model":"llava","messages":[{"role":"user","content":[{"type":"image","source":{"type":"url","url":"https://example.com/a.png"}}]}]}`
+
+	_, err := provider.TransformRequest([]byte(request))
+
+	var requestErr *RequestError
+	require.ErrorAs(t, err, &requestErr)
+	assert.Equal(t, http.StatusBadRequest, requestErr.StatusCode)
+	assert.Contains(t, requestErr.Message, "base64")
+}
+
+func TestOllamaProvider_TransformRequest_ToolChoiceNone(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+
+	request := `{"model":"llama3.1","messages":[{"role":"user","content":"Hi"}],` +
+		`"tools":[{"name":"zoom","input_schema":{"type":"object"}}],"tool_choice":{"type":"none"}}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+	assert.NotContains(t, string(result), "tools")
+}
+
+// TestOllamaProvider_GoldenStreams replays the recorded stream chunks in testdata/ollama
+func TestOllamaProvider_GoldenStreams(t *testing.T) {
+	provider := NewOllamaProvider(&config.Provider{Name: "ollama"})
+	testGoldenStreams(t, "ollama", map[string]Provider{"ollama": provider}, provider)
+}
+
+// BenchmarkOllamaProvider_TransformStream converts the recorded streams, reporting the
+// allocations per chunk
+func BenchmarkOllamaProvider_TransformStream(b *testing.B) {
+	benchmarkStreams(b, "ollama", NewOllamaProvider(&config.Provider{Name: "ollama"}))
+}
@@ -2,6 +2,7 @@ package providers
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 
 	"github.com/Davincible/claude-code-open/internal/config"
@@ -517,7 +518,7 @@ func TestOpenAIProvider_ConvertUsage(t *testing.T) {
 
 	result := provider.convertUsage(usage)
 
-	assert.Equal(t, 100, result["input_tokens"])
+	assert.Equal(t, 70, result["input_tokens"], "cached prompt tokens are reported apart from input_tokens")
 	assert.Equal(t, 50, result["output_tokens"])
 	assert.Equal(t, 20, result["cache_read_input_tokens"])
 	assert.Equal(t, 10, result["cache_creation_input_tokens"])
@@ -542,3 +543,399 @@ func TestOpenAIProvider_ConvertToolCallID(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenAIProvider_TransformRequest_Images(t *testing.T) {
+	provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+
+	request := map[string]any{
+		"model": "gpt-4o",
+		"messages": []any{
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "text", "text": "What's on screen?"},
+				map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "aW1n"}},
+				map[string]any{"type": "image", "source": map[string]any{"type": "url", "url": "https://example.com/cat.jpg"}},
+			}},
+			map[string]any{"role": "assistant", "content": []any{
+				map[string]any{"type": "tool_use", "id": "toolu_1", "name": "screenshot", "input": map[string]any{}},
+			}},
+			map[string]any{"role": "user", "content": []any{
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{
+					map[string]any{"type": "text", "text": "Captured"},
+					map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/jpeg", "data": "c2hvdA=="}},
+				}},
+			}},
+		},
+	}
+
+	requestBytes, err := json.Marshal(request)
+	require.NoError(t, err)
+
+	result, err := provider.TransformRequest(requestBytes)
+	require.NoError(t, err)
+
+	var openAIRequest map[string]any
+	require.NoError(t, json.Unmarshal(result, &openAIRequest))
+
+	messages, ok := openAIRequest["messages"].([]any)
+	require.True(t, ok)
+	require.Len(t, messages, 4)
+
+	assert.Equal(t, map[string]any{"role": "user", "content": []any{
+		map[string]any{"type": "text", "text": "What's on screen?"},
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,aW1n"}},
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/cat.jpg"}},
+	}}, messages[0])
+
+	// Tool messages only carry text, so the screenshot follows in a user message
+	assert.Equal(t, map[string]any{"role": "tool", "tool_call_id": "call_1", "content": "Captured"}, messages[2])
+	assert.Equal(t, map[string]any{"role": "user", "content": []any{
+		map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/jpeg;base64,c2hvdA=="}},
+	}}, messages[3])
+}
+
+func TestTransformRequest_MixedToolResults(t *testing.T) {
+	request := `{
+		"model": "gpt-4o",
+		"messages": [
+			{"role": "user", "content": "Check the weather"},
+			{"role": "assistant", "content": [
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}},
+				{"type": "tool_use", "id": "toolu_2", "name": "get_map", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [
+					{"type": "text", "text": "Sunny"},
+					{"type": "text", "text": "21 degrees"}
+				]},
+				{"type": "text", "text": "<system-reminder>Keep it short</system-reminder>"},
+				{"type": "tool_result", "tool_use_id": "toolu_2", "content": [
+					{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "bWFw"}}
+				]},
+				{"type": "text", "text": "Anything else?"}
+			]}
+		]
+	}`
+
+	expected := `[
+		{"role": "user", "content": "Check the weather"},
+		{"role": "assistant", "content": "", "tool_calls": [
+			{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}},
+			{"id": "call_2", "type": "function", "function": {"name": "get_map", "arguments": "{\"city\":\"Paris\"}"}}
+		]},
+		{"role": "tool", "tool_call_id": "call_1", "content": "Sunny\n\n21 degrees"},
+		{"role": "tool", "tool_call_id": "call_2", "content": ""},
+		{"role": "user", "content": [
+			{"type": "text", "text": "<system-reminder>Keep it short</system-reminder>"},
+			{"type": "image_url", "image_url": {"url": "data:image/png;base64,bWFw"}},
+			{"type": "text", "text": "Anything else?"}
+		]}
+	]`
+
+	providers := map[string]Provider{
+		"openai":     NewOpenAIProvider(&config.Provider{Name: "openai"}),
+		"nvidia":     NewNvidiaProvider(&config.Provider{Name: "nvidia"}),
+		"openrouter": NewOpenRouterProvider(&config.Provider{Name: "openrouter"}),
+	}
+
+	for name, provider := range providers {
+		t.Run(name, func(t *testing.T) {
+			result, err := provider.TransformRequest([]byte(request))
+			require.NoError(t, err)
+
+			var openAIRequest map[string]any
+			require.NoError(t, json.Unmarshal(result, &openAIRequest))
+
+			messages, err := json.Marshal(openAIRequest["messages"])
+			require.NoError(t, err)
+			assert.JSONEq(t, expected, string(messages))
+		})
+	}
+}
+
+func TestCheckImageSupport(t *testing.T) {
+	provider := &config.Provider{Name: "deepseek", TextOnlyModels: []string{"deepseek-chat", "*-reasoner"}}
+
+	withImage := `{"model":"deepseek-chat","messages":[{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1",` +
+		`"content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"aW1n"}}]}]}]}`
+
+	requestErr := CheckImageSupport([]byte(withImage), provider)
+	require.NotNil(t, requestErr, "images nested in tool results should be detected")
+	assert.Equal(t, 400, requestErr.StatusCode)
+	assert.Equal(t, "invalid_request_error", requestErr.Type)
+	assert.Contains(t, requestErr.Message, `model "deepseek-chat" on provider "deepseek" does not support image input`)
+
+	textOnly := `{"model":"deepseek-chat","messages":[{"role":"user","content":"Hello"}]}`
+	assert.Nil(t, CheckImageSupport([]byte(textOnly), provider))
+
+	visionModel := `{"model":"deepseek-vl2","messages":[{"role":"user","content":[{"type":"image","source":{"type":"url","url":"https://example.com/a.png"}}]}]}`
+	assert.Nil(t, CheckImageSupport([]byte(visionModel), provider))
+}
+
+func TestOpenAIProvider_TransformRequest_Thinking(t *testing.T) {
+	provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+
+	request := `{
+		"model": "o4-mini",
+		"max_tokens": 20000,
+		"thinking": {"type": "enabled", "budget_tokens": 10000},
+		"messages": [
+			{"role": "user", "content": "What is 17 * 23?"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "17 * 23 = 391", "signature": "sig"},
+				{"type": "redacted_thinking", "data": "opaque"},
+				{"type": "text", "text": "391"}
+			]},
+			{"role": "user", "content": "And 18 * 23?"}
+		]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var openaiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &openaiReq))
+
+	assert.Equal(t, "medium", openaiReq["reasoning_effort"])
+	assert.NotContains(t, openaiReq, "thinking")
+
+	messages, ok := openaiReq["messages"].([]any)
+	require.True(t, ok)
+	require.Len(t, messages, 3)
+	assert.Equal(t, map[string]any{"role": "assistant", "content": "391"}, messages[1], "thinking blocks should be stripped")
+
+	disabled := `{"model":"gpt-4o","thinking":{"type":"disabled"},"messages":[{"role":"user","content":"Hi"}]}`
+
+	result, err = provider.TransformRequest([]byte(disabled))
+	require.NoError(t, err)
+	assert.NotContains(t, string(result), "reasoning_effort")
+	assert.NotContains(t, string(result), "thinking")
+}
+
+func TestSupportsReasoningEffort(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected bool
+	}{
+		{"o1", true},
+		{"o3-mini", true},
+		{"o4-mini-2025-04-16", true},
+		{"gpt-5", true},
+		{"gpt-5-mini", true},
+		{"openai/gpt-5-nano", true},
+		{"gpt-oss-120b", true},
+		{"gpt-5-chat-latest", false},
+		{"gpt-4o", false},
+		{"gpt-4.1", false},
+		{"omni-moderation-latest", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, supportsReasoningEffort(tt.model), "model %q", tt.model)
+	}
+}
+
+func TestReasoningEffortForBudget(t *testing.T) {
+	tests := []struct {
+		budget   int
+		expected string
+	}{
+		{1024, "low"},
+		{4095, "low"},
+		{4096, "medium"},
+		{16000, "medium"},
+		{16384, "high"},
+		{64000, "high"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, reasoningEffortForBudget(tt.budget), "budget %d", tt.budget)
+	}
+}
+
+func TestOpenAIProvider_Transform_ReasoningContent(t *testing.T) {
+	provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+
+	response := `{
+		"id": "chatcmpl-1",
+		"model": "deepseek-reasoner",
+		"choices": [{"message": {"role": "assistant", "reasoning_content": "Multiply it out", "content": "391"}, "finish_reason": "stop"}],
+		"usage": {"prompt_tokens": 10, "completion_tokens": 20}
+	}`
+
+	result, err := provider.TransformResponse([]byte(response))
+	require.NoError(t, err)
+
+	var anthropicResp map[string]any
+	require.NoError(t, json.Unmarshal(result, &anthropicResp))
+
+	assert.Equal(t, []any{
+		map[string]any{"type": "thinking", "thinking": "Multiply it out", "signature": ""},
+		map[string]any{"type": "text", "text": "391"},
+	}, anthropicResp["content"])
+}
+
+func TestOpenAIProvider_TransformStream_ReasoningContent(t *testing.T) {
+	provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+	state := &StreamState{}
+
+	var output []byte
+
+	for _, chunk := range []string{
+		`{"id":"chatcmpl-1","model":"deepseek-reasoner","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"Multiply"}}]}`,
+		`{"id":"chatcmpl-1","model":"deepseek-reasoner","choices":[{"index":0,"delta":{"reasoning_content":" it out"}}]}`,
+		`{"id":"chatcmpl-1","model":"deepseek-reasoner","choices":[{"index":0,"delta":{"content":"391"}}]}`,
+		`{"id":"chatcmpl-1","model":"deepseek-reasoner","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`{"id":"chatcmpl-1","model":"deepseek-reasoner","choices":[],"usage":{"prompt_tokens":8,"completion_tokens":5}}`,
+	} {
+		events, err := provider.TransformStream([]byte(chunk), state)
+		require.NoError(t, err)
+
+		output = append(output, events...)
+	}
+
+	result := string(output)
+	expectedOrder := []string{
+		"event: message_start",
+		`"content_block":{"signature":"","thinking":"","type":"thinking"},"index":0`,
+		`"delta":{"thinking":"Multiply","type":"thinking_delta"},"index":0`,
+		`"delta":{"thinking":" it out","type":"thinking_delta"},"index":0`,
+		`data: {"index":0,"type":"content_block_stop"}`,
+		`"content_block":{"text":"","type":"text"},"index":1`,
+		`"delta":{"text":"391","type":"text_delta"},"index":1`,
+		`data: {"index":1,"type":"content_block_stop"}`,
+		"event: message_stop",
+	}
+
+	position := 0
+
+	for _, expected := range expectedOrder {
+		index := strings.Index(result[position:], expected)
+		require.GreaterOrEqual(t, index, 0, "expected %q after position %d in:\n%s", expected, position, result)
+		position += index + len(expected)
+	}
+}
+
+func TestOpenAIProvider_StreamUsage(t *testing.T) {
+	provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+
+	request := `{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hi"}]}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+	assert.Contains(t, string(result), `"stream_options":{"include_usage":true}`)
+
+	chunks := []string{
+		`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hello there"}}]}`,
+		`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+	}
+
+	t.Run("usage chunk", func(t *testing.T) {
+		state := &StreamState{}
+
+		var output strings.Builder
+
+		for _, chunk := range append(chunks, `{"id":"chatcmpl-1","model":"gpt-4o","choices":[],`+
+			`"usage":{"prompt_tokens":120,"completion_tokens":2,"prompt_tokens_details":{"cached_tokens":100}}}`) {
+			events, err := provider.TransformStream([]byte(chunk), state)
+			require.NoError(t, err)
+			output.Write(events)
+		}
+
+		stream := output.String()
+
+		assert.Equal(t, 1, strings.Count(stream, "event: message_delta"))
+		assert.Contains(t, stream, `"usage":{"cache_read_input_tokens":100,"input_tokens":20,"output_tokens":2}`)
+		assert.True(t, strings.HasSuffix(stream, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+		assert.Empty(t, FinishStream(state, 50, nil), "nothing should be left to send")
+	})
+
+	t.Run("no usage", func(t *testing.T) {
+		state := &StreamState{}
+
+		var output strings.Builder
+
+		for _, chunk := range chunks {
+			events, err := provider.TransformStream([]byte(chunk), state)
+			require.NoError(t, err)
+			output.Write(events)
+		}
+
+		assert.NotContains(t, output.String(), "message_delta", "message_delta should wait for the usage")
+
+		countWords := func(text string) int {
+			assert.Equal(t, "Hello there", text)
+			return len(strings.Fields(text))
+		}
+
+		output.Write(FinishStream(state, 50, countWords))
+
+		stream := output.String()
+		assert.Contains(t, stream, `"delta":{"stop_reason":"end_turn","stop_sequence":null},"type":"message_delta",`+
+			`"usage":{"input_tokens":50,"output_tokens":2}`)
+		assert.Contains(t, stream, "event: message_stop")
+	})
+}
+
+func TestOpenAIProvider_TransformRequest_ToolChoice(t *testing.T) {
+	provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+
+	tests := []struct {
+		name             string
+		toolChoice       string
+		expectedChoice   any
+		expectedParallel any
+	}{
+		{"auto", `{"type":"auto"}`, "auto", nil},
+		{"any", `{"type":"any"}`, "required", nil},
+		{"none", `{"type":"none"}`, "none", nil},
+		{"tool", `{"type":"tool","name":"get_weather"}`,
+			map[string]any{"type": "function", "function": map[string]any{"name": "get_weather"}}, nil},
+		{"disable parallel", `{"type":"any","disable_parallel_tool_use":true}`, "required", false},
+		{"openai string passthrough", `"required"`, "required", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := `{"model":"gpt-4o","messages":[{"role":"user","content":"Weather?"}],` +
+				`"tools":[{"name":"get_weather","input_schema":{"type":"object"}}],"tool_choice":` + tt.toolChoice + `}`
+
+			result, err := provider.TransformRequest([]byte(request))
+			require.NoError(t, err)
+
+			var openaiReq map[string]any
+			require.NoError(t, json.Unmarshal(result, &openaiReq))
+
+			assert.Equal(t, tt.expectedChoice, openaiReq["tool_choice"])
+			assert.Equal(t, tt.expectedParallel, openaiReq["parallel_tool_calls"])
+		})
+	}
+
+	result, err := provider.TransformRequest([]byte(`{"model":"gpt-4o","messages":[],"tool_choice":{"type":"any"}}`))
+	require.NoError(t, err)
+	assert.NotContains(t, string(result), "tool_choice", "tool_choice without tools should be removed")
+}
+
+func TestOpenAIProvider_TransformRequest_SystemBlocks(t *testing.T) {
+	provider := NewOpenAIProvider(&config.Provider{Name: "openai"})
+
+	request := `{
+		"model": "gpt-4o",
+		"system": [
+			{"type": "text", "text": "You are Claude Code."},
+			{"type": "text", "text": "Be concise.", "cache_control": {"type": "ephemeral"}}
+		],
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var openaiReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &openaiReq))
+
+	messages, ok := openaiReq["messages"].([]any)
+	require.True(t, ok)
+	require.Len(t, messages, 2)
+	assert.Equal(t, map[string]any{"role": "system", "content": "You are Claude Code.\n\nBe concise."}, messages[0])
+}
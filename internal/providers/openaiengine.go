@@ -0,0 +1,766 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+// OpenAIDialect holds what sets a vendor of the OpenAI chat completions API apart from
+// OpenAI itself. OpenAIEngine translates requests, responses and streams for every vendor
+// and asks the dialect where they differ. Vendors embed openAIDefaults and only implement
+// the hooks that differ, a different URL or authentication is set up by overriding the
+// engine's NewRequest.
+type OpenAIDialect interface {
+	// mapErrorType maps the type of an error returned in a response body to an Anthropic one
+	mapErrorType(errorType string) string
+	// convertStopReason maps a finish_reason to an Anthropic stop reason
+	convertStopReason(reason string) *string
+	// convertUsage maps a usage object to Anthropic usage
+	convertUsage(usage map[string]any) map[string]any
+	// applyThinking maps the extended thinking budget to the vendor's reasoning option
+	applyThinking(request map[string]any, budget int)
+	// applyWebSearch maps the web search server tool to the vendor's own search
+	applyWebSearch(request map[string]any, webSearch webSearchTool)
+	// applyExtraFields sets vendor specific fields on the translated request
+	applyExtraFields(request map[string]any)
+}
+
+// openAIDefaults implements the OpenAIDialect hooks the way OpenAI's API behaves
+type openAIDefaults struct{}
+
+func (openAIDefaults) mapErrorType(errorType string) string {
+	mapping := map[string]string{
+		"invalid_request_error":    "invalid_request_error",
+		"authentication_error":     "authentication_error",
+		"permission_error":         "permission_error",
+		"not_found_error":          "not_found_error",
+		"rate_limit_error":         "rate_limit_error",
+		"api_error":                "api_error",
+		"overloaded_error":         "overloaded_error",
+		"insufficient_quota_error": "billing_error",
+	}
+
+	if anthropicType, exists := mapping[errorType]; exists {
+		return anthropicType
+	}
+
+	return "api_error"
+}
+
+func (openAIDefaults) convertStopReason(reason string) *string {
+	mapping := map[string]string{
+		"stop":           "end_turn",
+		"length":         "max_tokens",
+		"tool_calls":     "tool_use",
+		"function_call":  "tool_use",
+		"content_filter": "stop_sequence",
+		"null":           "end_turn",
+	}
+
+	if anthropicReason, exists := mapping[reason]; exists {
+		return &anthropicReason
+	}
+
+	defaultReason := "end_turn"
+
+	return &defaultReason
+}
+
+func (openAIDefaults) convertUsage(usage map[string]any) map[string]any {
+	return MapTokenUsage(usage, OpenAITokenMapping)
+}
+
+// applyThinking maps the thinking budget to reasoning_effort, which o-series and other
+// reasoning models accept instead of a token budget. Other models reject the parameter, so
+// thinking is dropped for them.
+func (openAIDefaults) applyThinking(request map[string]any, budget int) {
+	model, _ := request["model"].(string)
+	if !supportsReasoningEffort(model) {
+		slog.Debug("Model takes no reasoning effort, dropping extended thinking", "model", model)
+		return
+	}
+
+	request["reasoning_effort"] = reasoningEffortForBudget(budget)
+}
+
+// applyWebSearch drops the web search tool. Chat completions only search with the dedicated
+// search models, which take no tools at all.
+func (openAIDefaults) applyWebSearch(_ map[string]any, webSearch webSearchTool) {
+	slog.Debug("Web search is not supported by this provider, dropping the tool", "tool", webSearch.Name)
+}
+
+func (openAIDefaults) applyExtraFields(_ map[string]any) {}
+
+// OpenAIEngine implements Provider for the OpenAI chat completions API and the vendors that
+// speak it
+type OpenAIEngine struct {
+	Provider *config.Provider
+
+	vendor  string
+	dialect OpenAIDialect
+}
+
+// newOpenAIEngine creates the engine of a vendor, named in error messages
+func newOpenAIEngine(provider *config.Provider, vendor string, dialect OpenAIDialect) *OpenAIEngine {
+	return &OpenAIEngine{
+		Provider: provider,
+		vendor:   vendor,
+		dialect:  dialect,
+	}
+}
+
+func (e *OpenAIEngine) Name() string {
+	return e.Provider.Name
+}
+
+func (e *OpenAIEngine) SupportsStreaming() bool {
+	return true
+}
+
+func (e *OpenAIEngine) GetEndpoint() string {
+	return e.Provider.APIBase
+}
+
+func (e *OpenAIEngine) GetAPIKey() string {
+	return e.Provider.GetAPIKey()
+}
+
+// NewRequest posts to the configured chat completions URL with the API key as a bearer token
+func (e *OpenAIEngine) NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error) {
+	req, err := newUpstreamRequest(ctx, e.GetEndpoint(), upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	setBearerAuth(req, upstream.apiKey(e.GetAPIKey))
+
+	return req, nil
+}
+
+func (e *OpenAIEngine) IsStreaming(headers map[string][]string) bool {
+	if contentType, ok := headers["Content-Type"]; ok {
+		for _, ct := range contentType {
+			if ct == ContentTypeEventStream || strings.Contains(ct, "stream") {
+				return true
+			}
+		}
+	}
+
+	if transferEncoding, ok := headers["Transfer-Encoding"]; ok {
+		for _, te := range transferEncoding {
+			if te == TransferEncodingChunked {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// TransformRequest converts an Anthropic request to a chat completions request
+func (e *OpenAIEngine) TransformRequest(anthropicRequest []byte) ([]byte, error) {
+	var request map[string]any
+	if err := json.Unmarshal(anthropicRequest, &request); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic request: %w", err)
+	}
+
+	// Remove Anthropic-specific fields that OpenAI doesn't support
+	cleanedRequest := e.removeAnthropicSpecificFields(request)
+
+	// Handle system parameter - convert it to a system message in messages array
+	if systemContent, hasSystem := cleanedRequest["system"]; hasSystem {
+		if messages, ok := cleanedRequest["messages"].([]any); ok && SystemPromptText(systemContent) != "" {
+			// Create system message, joining the text of system block arrays
+			systemMessage := map[string]any{
+				"role":    "system",
+				"content": SystemPromptText(systemContent),
+			}
+
+			// Prepend system message to messages array
+			cleanedRequest["messages"] = append([]any{systemMessage}, messages...)
+		}
+		// Remove the system parameter as OpenAI doesn't support it at root level
+		delete(cleanedRequest, "system")
+	}
+
+	// Map extended thinking to the vendor's reasoning option
+	if budget, ok := thinkingBudget(cleanedRequest); ok {
+		e.dialect.applyThinking(cleanedRequest, budget)
+	}
+
+	delete(cleanedRequest, "thinking")
+
+	// Map the web search server tool to the vendor's own search
+	if webSearch, ok := extractWebSearchTool(cleanedRequest); ok {
+		e.dialect.applyWebSearch(cleanedRequest, webSearch)
+	}
+
+	// Handle max_tokens parameter - convert to max_completion_tokens for OpenAI compatibility
+	if maxTokens, hasMaxTokens := cleanedRequest["max_tokens"]; hasMaxTokens {
+		cleanedRequest["max_completion_tokens"] = maxTokens
+		delete(cleanedRequest, "max_tokens")
+	}
+
+	// OpenAI-style streams only report usage when asked to, in a chunk after the finish reason
+	if stream, _ := cleanedRequest["stream"].(bool); stream {
+		cleanedRequest["stream_options"] = map[string]any{"include_usage": true}
+	}
+
+	if messages, ok := cleanedRequest["messages"].([]any); ok {
+		cleanedRequest["messages"] = convertOpenAIImages(e.transformMessages(messages))
+	}
+
+	// Transform tools from Claude format to OpenAI format if present
+	if tools, ok := cleanedRequest["tools"].([]any); ok {
+		transformedTools, err := TransformTools(tools)
+
+		switch {
+		case err != nil:
+			// If tools transformation fails, remove tool_choice to prevent validation errors
+			delete(cleanedRequest, "tool_choice")
+		case len(transformedTools) == 0:
+			cleanedRequest["tools"] = transformedTools
+			delete(cleanedRequest, "tool_choice")
+		default:
+			cleanedRequest["tools"] = transformedTools
+			applyOpenAIToolChoice(cleanedRequest)
+		}
+	}
+
+	e.dialect.applyExtraFields(cleanedRequest)
+
+	return json.Marshal(cleanedRequest)
+}
+
+// removeAnthropicSpecificFields removes fields that OpenAI doesn't support
+func (e *OpenAIEngine) removeAnthropicSpecificFields(request map[string]any) map[string]any {
+	fieldsToRemove := []string{"cache_control"}
+
+	// Remove metadata if store is not enabled (OpenAI requirement)
+	if store, hasStore := request["store"]; !hasStore || store != true {
+		fieldsToRemove = append(fieldsToRemove, "metadata")
+	}
+
+	cleaned := RemoveFieldsRecursively(request, fieldsToRemove).(map[string]any)
+
+	// Only keep tool_choice when there are tools to choose from
+	if tools, _ := cleaned["tools"].([]any); len(tools) == 0 {
+		delete(cleaned, "tool_choice")
+	}
+
+	return cleaned
+}
+
+// transformMessages converts tool results and tool uses to OpenAI tool messages and calls
+func (e *OpenAIEngine) transformMessages(messages []any) []any {
+	transformedMessages := make([]any, 0, len(messages))
+
+	for _, message := range messages {
+		msgMap, _ := message.(map[string]any)
+		content, isBlocks := msgMap["content"].([]any)
+
+		switch role, _ := msgMap["role"].(string); {
+		case role == "user" && isBlocks:
+			if toolResultMessages := TransformToolResults(content); len(toolResultMessages) > 0 {
+				transformedMessages = append(transformedMessages, toolResultMessages...)
+				continue
+			}
+		case role == RoleAssistant && isBlocks:
+			transformedMessages = append(transformedMessages, TransformAssistantMessage(msgMap, content))
+			continue
+		}
+
+		transformedMessages = append(transformedMessages, message)
+	}
+
+	return transformedMessages
+}
+
+// TransformResponse converts a chat completion to an Anthropic message
+func (e *OpenAIEngine) TransformResponse(response []byte) ([]byte, error) {
+	var openaiResponse map[string]any
+	if err := json.Unmarshal(response, &openaiResponse); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s response: %w", e.vendor, err)
+	}
+
+	// Some servers return errors in a successful response
+	if errorBody, ok := openaiResponse["error"].(map[string]any); ok {
+		errorType, _ := errorBody["type"].(string)
+		message, _ := errorBody["message"].(string)
+
+		return FormatAnthropicError(e.dialect.mapErrorType(errorType), message), nil
+	}
+
+	choices, _ := openaiResponse["choices"].([]any)
+	if len(choices) == 0 {
+		return nil, errors.New("no choices in response")
+	}
+
+	choice, _ := choices[0].(map[string]any)
+
+	message, ok := choice["message"].(map[string]any)
+	if !ok {
+		return nil, errors.New("no message content in choice")
+	}
+
+	id, _ := openaiResponse["id"].(string)
+
+	anthropicResponse := map[string]any{
+		"id":            id,
+		"type":          "message",
+		"role":          RoleAssistant,
+		"model":         openaiResponse["model"],
+		"content":       e.convertContent(message, id),
+		"stop_reason":   nil,
+		"stop_sequence": nil,
+	}
+
+	if reason, ok := choice["finish_reason"].(string); ok {
+		anthropicResponse["stop_reason"] = e.dialect.convertStopReason(reason)
+	}
+
+	if usage, ok := openaiResponse["usage"].(map[string]any); ok {
+		anthropicResponse["usage"] = e.dialect.convertUsage(usage)
+	}
+
+	return json.Marshal(anthropicResponse)
+}
+
+// convertContent converts the reasoning, text, web search annotations and tool calls of a
+// message to Anthropic content blocks
+func (e *OpenAIEngine) convertContent(message map[string]any, id string) []map[string]any {
+	var content []map[string]any
+
+	// Reasoning comes first, like Anthropic's thinking blocks
+	if reasoning := reasoningText(message); reasoning != "" {
+		content = append(content, map[string]any{
+			"type":      ContentTypeThinking,
+			"thinking":  reasoning,
+			"signature": "",
+		})
+	}
+
+	textContent, _ := message["content"].(string)
+
+	// Web search annotations are returned as a search before the cited answer
+	if results, citations := convertAnnotations(textContent, message["annotations"]); len(results) > 0 {
+		content = append(content, webSearchBlocks(serverToolUseID(id), "", results)...)
+
+		for _, piece := range splitCitedText(textContent, citations) {
+			block := map[string]any{"type": ContentTypeText, "text": piece.Text}
+			if len(piece.Citations) > 0 {
+				block["citations"] = piece.Citations
+			}
+
+			content = append(content, block)
+		}
+	} else if textContent != "" {
+		content = append(content, map[string]any{"type": ContentTypeText, "text": textContent})
+	}
+
+	toolCalls, _ := message["tool_calls"].([]any)
+	for _, toolCall := range toolCalls {
+		if toolUse := e.convertToolCall(toolCall); toolUse != nil {
+			content = append(content, toolUse)
+		}
+	}
+
+	// Older servers return the legacy function_call instead of tool_calls
+	if functionCall, ok := message["function_call"].(map[string]any); ok {
+		content = append(content, e.convertToolCall(map[string]any{
+			"id":       fmt.Sprintf("func_%d", time.Now().UnixNano()),
+			"function": functionCall,
+		}))
+	}
+
+	if len(content) == 0 {
+		content = append(content, map[string]any{"type": ContentTypeText, "text": ""})
+	}
+
+	return content
+}
+
+// convertToolCall converts a tool call to a tool_use block. Arguments that aren't valid JSON
+// become an empty input, so the rest of the response still reaches the client.
+func (e *OpenAIEngine) convertToolCall(toolCall any) map[string]any {
+	toolCallMap, _ := toolCall.(map[string]any)
+
+	function, ok := toolCallMap["function"].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	toolCallID, _ := toolCallMap["id"].(string)
+	name, _ := function["name"].(string)
+	arguments, _ := function["arguments"].(string)
+
+	input := map[string]any{}
+
+	if arguments != "" {
+		if err := json.Unmarshal([]byte(arguments), &input); err != nil {
+			slog.Debug("Tool call arguments are not valid JSON, sending an empty input", "tool", name, "error", err)
+
+			input = map[string]any{}
+		}
+	}
+
+	return map[string]any{
+		"type":  ContentTypeToolUse,
+		"id":    e.convertToolCallID(toolCallID),
+		"name":  name,
+		"input": input,
+	}
+}
+
+// convertToolCallID converts a tool call ID to the Anthropic format
+func (e *OpenAIEngine) convertToolCallID(toolCallID string) string {
+	if strings.HasPrefix(toolCallID, "toolu_") {
+		return toolCallID
+	}
+
+	return "toolu_" + strings.TrimPrefix(toolCallID, "call_")
+}
+
+// convertAnnotations converts the url_citation annotations of a web search into search
+// results and citations. Annotation offsets count characters of the answer text.
+func convertAnnotations(text string, annotations any) ([]webSearchResult, []webCitation) {
+	list, _ := annotations.([]any)
+
+	var (
+		results   []webSearchResult
+		citations []webCitation
+		seen      = make(map[string]bool)
+	)
+
+	for _, annotation := range list {
+		annotationMap, _ := annotation.(map[string]any)
+		if annotationMap["type"] != "url_citation" {
+			continue
+		}
+
+		urlCitation, _ := annotationMap["url_citation"].(map[string]any)
+
+		url, _ := urlCitation["url"].(string)
+		if url == "" {
+			continue
+		}
+
+		title, _ := urlCitation["title"].(string)
+
+		if !seen[url] {
+			seen[url] = true
+			results = append(results, webSearchResult{URL: url, Title: title})
+		}
+
+		start, _ := urlCitation["start_index"].(float64)
+		end, _ := urlCitation["end_index"].(float64)
+
+		citation := webCitation{
+			Start: runeOffsetToByte(text, int(start)),
+			End:   runeOffsetToByte(text, int(end)),
+			URL:   url,
+			Title: title,
+		}
+
+		citation.CitedText, _ = urlCitation["content"].(string)
+		if citation.CitedText == "" && validTextSpan(text, citation.Start, citation.End) {
+			citation.CitedText = text[citation.Start:citation.End]
+		}
+
+		citations = append(citations, citation)
+	}
+
+	return results, citations
+}
+
+// openAIChunk is a chat completion chunk, decoded into the fields the stream conversion reads
+type openAIChunk struct {
+	Choices []openAIChunkChoice `json:"choices"`
+	ID      string              `json:"id"`
+	Model   string              `json:"model"`
+	Usage   map[string]any      `json:"usage"`
+}
+
+type openAIChunkChoice struct {
+	Delta        *openAIDelta `json:"delta"`
+	FinishReason *string      `json:"finish_reason"`
+}
+
+type openAIDelta struct {
+	Annotations []any  `json:"annotations"`
+	Content     string `json:"content"`
+	// Reasoning is returned as reasoning_content or reasoning depending on the server
+	Reasoning        string                `json:"reasoning"`
+	ReasoningContent string                `json:"reasoning_content"`
+	ToolCalls        []openAIToolCallDelta `json:"tool_calls"`
+}
+
+func (d *openAIDelta) reasoning() string {
+	if d.ReasoningContent != "" {
+		return d.ReasoningContent
+	}
+
+	return d.Reasoning
+}
+
+type openAIToolCallDelta struct {
+	Function struct {
+		Arguments string `json:"arguments"`
+		Name      string `json:"name"`
+	} `json:"function"`
+	ID    string `json:"id"`
+	Index *int   `json:"index"`
+}
+
+// TransformStream converts a chat completion chunk to Anthropic stream events
+func (e *OpenAIEngine) TransformStream(chunk []byte, state *StreamState) ([]byte, error) {
+	var openaiChunk openAIChunk
+	if err := json.Unmarshal(chunk, &openaiChunk); err != nil {
+		// A field of an unexpected type leaves the others decoded, like a lenient map lookup
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("failed to unmarshal %s streaming response: %w", e.vendor, err)
+		}
+
+		slog.Debug("Ignoring a chunk field of an unexpected type", "vendor", e.vendor, "error", err)
+	}
+
+	if state.ContentBlocks == nil {
+		state.ContentBlocks = make(map[int]*ContentBlockState)
+	}
+
+	var events []byte
+
+	// Store message ID and model from first chunk
+	if state.MessageID == "" {
+		state.MessageID = openaiChunk.ID
+	}
+
+	if state.Model == "" {
+		state.Model = openaiChunk.Model
+	}
+
+	if len(openaiChunk.Choices) > 0 {
+		firstChoice := openaiChunk.Choices[0]
+
+		if !state.MessageStartSent {
+			events = append(events, FormatSSEEvent("message_start", e.createMessageStartEvent(state, openaiChunk.Usage))...)
+			state.MessageStartSent = true
+		}
+
+		if firstChoice.Delta != nil {
+			events = append(events, e.handleDelta(firstChoice.Delta, state)...)
+		}
+
+		if firstChoice.FinishReason != nil {
+			events = append(events, e.handleFinishReason(*firstChoice.FinishReason, openaiChunk.Usage, state)...)
+		}
+	}
+
+	// The usage requested with stream_options comes last, in a chunk without choices
+	if openaiChunk.Usage != nil {
+		events = append(events, SendPendingMessageDelta(state, e.dialect.convertUsage(openaiChunk.Usage))...)
+	}
+
+	return events, nil
+}
+
+// createMessageStartEvent creates the message_start event. Output tokens are reported by
+// message_delta.
+func (e *OpenAIEngine) createMessageStartEvent(state *StreamState, chunkUsage map[string]any) MessageStartEvent {
+	usage := map[string]any{
+		"input_tokens":  0,
+		"output_tokens": 1,
+	}
+
+	if chunkUsage != nil {
+		for key, value := range e.dialect.convertUsage(chunkUsage) {
+			if key != "output_tokens" {
+				usage[key] = value
+			}
+		}
+	}
+
+	return CreateMessageStartEvent(state.MessageID, state.Model, usage)
+}
+
+// handleDelta streams the reasoning, tool calls or text and web search annotations of a delta
+func (e *OpenAIEngine) handleDelta(delta *openAIDelta, state *StreamState) []byte {
+	var events []byte
+
+	collectOutput(delta, state)
+
+	if reasoning := delta.reasoning(); reasoning != "" {
+		events = append(events, handleThinkingDelta(reasoning, state)...)
+	}
+
+	// Tool calls take priority over text content
+	if delta.ToolCalls != nil {
+		events = append(events, closeThinkingBlock(state)...)
+		events = append(events, e.handleToolCalls(delta.ToolCalls, state)...)
+	} else if delta.Content != "" {
+		events = append(events, closeThinkingBlock(state)...)
+		events = append(events, e.handleTextContent(delta.Content, state)...)
+	}
+
+	// Web search annotations arrive once the answer has been streamed
+	if results, citations := convertAnnotations("", delta.Annotations); len(results) > 0 {
+		events = append(events, webSearchStreamEvents(state, serverToolUseID(state.MessageID), "", results, citations)...)
+	}
+
+	return events
+}
+
+// collectOutput keeps the text generated in a delta, so the usage can be estimated when the
+// upstream doesn't report it
+func collectOutput(delta *openAIDelta, state *StreamState) {
+	state.Output.WriteString(delta.reasoning())
+	state.Output.WriteString(delta.Content)
+
+	for _, toolCall := range delta.ToolCalls {
+		state.Output.WriteString(toolCall.Function.Name)
+		state.Output.WriteString(toolCall.Function.Arguments)
+	}
+}
+
+// handleTextContent streams text into the open text block
+func (e *OpenAIEngine) handleTextContent(content string, state *StreamState) []byte {
+	var events []byte
+
+	textIndex := openTextBlockIndex(state)
+	contentBlock := state.ContentBlocks[textIndex]
+
+	if !contentBlock.StartSent {
+		events = append(events, contentBlockStartEvent(textIndex, newTextBlock())...)
+		contentBlock.StartSent = true
+	}
+
+	return append(events, contentBlockDeltaEvent(textIndex, TextDelta{Type: "text_delta", Text: content})...)
+}
+
+// ToolCallData holds the fields of a streamed tool call
+type ToolCallData struct {
+	Index        int
+	HasIndex     bool
+	ID           string
+	FunctionName string
+	Arguments    string
+}
+
+// handleToolCalls streams tool calls, each into its own tool_use block
+func (e *OpenAIEngine) handleToolCalls(toolCalls []openAIToolCallDelta, state *StreamState) []byte {
+	var events []byte
+
+	for _, toolCall := range toolCalls {
+		data := ToolCallData{
+			ID:           toolCall.ID,
+			FunctionName: toolCall.Function.Name,
+			Arguments:    toolCall.Function.Arguments,
+		}
+
+		if toolCall.Index != nil {
+			data.Index, data.HasIndex = *toolCall.Index, true
+		}
+
+		events = append(events, e.handleSingleToolCall(data, state)...)
+	}
+
+	return events
+}
+
+// handleSingleToolCall streams one tool call fragment. The block starts once the ID and name
+// are known, the arguments follow as input_json_delta events.
+func (e *OpenAIEngine) handleSingleToolCall(data ToolCallData, state *StreamState) []byte {
+	var events []byte
+
+	contentBlockIndex := findOrCreateToolBlock(data, state)
+	if contentBlockIndex == -1 {
+		return events
+	}
+
+	contentBlock := state.ContentBlocks[contentBlockIndex]
+
+	if data.FunctionName != "" {
+		contentBlock.ToolName = data.FunctionName
+	}
+
+	// Most servers send the arguments in increments, some resend everything so far
+	newPart := data.Arguments
+	if contentBlock.Arguments != "" && strings.HasPrefix(data.Arguments, contentBlock.Arguments) {
+		newPart = data.Arguments[len(contentBlock.Arguments):]
+		contentBlock.Arguments = data.Arguments
+	} else {
+		contentBlock.Arguments += data.Arguments
+	}
+
+	if !contentBlock.StartSent {
+		if contentBlock.ToolCallID == "" || contentBlock.ToolName == "" {
+			return events
+		}
+
+		block := newToolUseBlock(ContentTypeToolUse, e.convertToolCallID(contentBlock.ToolCallID), contentBlock.ToolName)
+		events = append(events, contentBlockStartEvent(contentBlockIndex, block)...)
+		contentBlock.StartSent = true
+
+		// Arguments that came before the name are sent along with the start
+		newPart = contentBlock.Arguments
+	}
+
+	if newPart == "" {
+		return events
+	}
+
+	return append(events, contentBlockDeltaEvent(contentBlockIndex, InputJSONDelta{Type: "input_json_delta", PartialJSON: newPart})...)
+}
+
+// findOrCreateToolBlock returns the block of a tool call, found by its index or ID. The
+// first fragment of a call carries the ID and creates the block, fragments that match no
+// block return -1.
+func findOrCreateToolBlock(data ToolCallData, state *StreamState) int {
+	if data.HasIndex {
+		for index, block := range state.ContentBlocks {
+			if block.Type == ContentTypeToolUse && block.ToolCallIndex == data.Index {
+				return index
+			}
+		}
+	}
+
+	if data.ID == "" {
+		return -1
+	}
+
+	for index, block := range state.ContentBlocks {
+		if block.Type == ContentTypeToolUse && block.ToolCallID == data.ID {
+			return index
+		}
+	}
+
+	index := len(state.ContentBlocks)
+	state.ContentBlocks[index] = &ContentBlockState{
+		Type:          ContentTypeToolUse,
+		ToolCallID:    data.ID,
+		ToolCallIndex: data.Index,
+		ToolName:      data.FunctionName,
+	}
+
+	return index
+}
+
+// handleFinishReason closes the open blocks. The message_delta waits for the usage chunk
+// unless the finish chunk carries the usage.
+func (e *OpenAIEngine) handleFinishReason(reason string, chunkUsage map[string]any, state *StreamState) []byte {
+	var usage map[string]any
+	if chunkUsage != nil {
+		usage = e.dialect.convertUsage(chunkUsage)
+	}
+
+	return DeferFinishReason(e.dialect.convertStopReason(reason), usage, state)
+}
@@ -0,0 +1,79 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openAIDialectProviders returns every provider built on the OpenAI engine
+func openAIDialectProviders() map[string]Provider {
+	return map[string]Provider{
+		"openai":     NewOpenAIProvider(&config.Provider{Name: "openai"}),
+		"nvidia":     NewNvidiaProvider(&config.Provider{Name: "nvidia"}),
+		"openrouter": NewOpenRouterProvider(&config.Provider{Name: "openrouter"}),
+		"azure":      NewAzureProvider(&config.Provider{Name: "azure"}),
+	}
+}
+
+// TestOpenAIEngine_GoldenStreams replays the recorded chunks in testdata/openai through every
+// vendor, which must all produce the events of the matching golden file
+func TestOpenAIEngine_GoldenStreams(t *testing.T) {
+	testGoldenStreams(t, "openai", openAIDialectProviders(), NewOpenAIProvider(&config.Provider{Name: "openai"}))
+}
+
+func TestOpenAIEngine_VendorHooks(t *testing.T) {
+	request := func(model string) string {
+		return `{"model":"` + model + `","max_tokens":1024,"messages":[{"role":"user","content":"Hi"}],` +
+			`"thinking":{"type":"enabled","budget_tokens":8000},"tools":[{"type":"web_search_20250305","name":"web_search"}]}`
+	}
+
+	tests := []struct {
+		name     string
+		provider Provider
+		model    string
+		expected string
+	}{
+		{
+			name:     "openai",
+			provider: NewOpenAIProvider(&config.Provider{Name: "openai"}),
+			model:    "o4-mini",
+			expected: `{"model":"o4-mini","max_completion_tokens":1024,"reasoning_effort":"medium","messages":[{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name:     "openai non-reasoning model",
+			provider: NewOpenAIProvider(&config.Provider{Name: "openai"}),
+			model:    "gpt-4o",
+			expected: `{"model":"gpt-4o","max_completion_tokens":1024,"messages":[{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name:     "nvidia",
+			provider: NewNvidiaProvider(&config.Provider{Name: "nvidia"}),
+			model:    "gpt-4o",
+			expected: `{"model":"gpt-4o","max_completion_tokens":1024,"messages":[{"role":"user","content":"Hi"}]}`,
+		},
+		{
+			name:     "openrouter",
+			provider: NewOpenRouterProvider(&config.Provider{Name: "openrouter"}),
+			model:    "gpt-4o",
+			expected: `{"model":"gpt-4o","max_completion_tokens":1024,"reasoning":{"max_tokens":8000},` +
+				`"plugins":[{"id":"web"}],"messages":[{"role":"user","content":"Hi"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.provider.TransformRequest([]byte(request(tt.model)))
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(result))
+		})
+	}
+}
+
+// BenchmarkOpenAIEngine_TransformStream converts the recorded streams, reporting the
+// allocations per chunk
+func BenchmarkOpenAIEngine_TransformStream(b *testing.B) {
+	benchmarkStreams(b, "openai", NewOpenAIProvider(&config.Provider{Name: "openai"}))
+}
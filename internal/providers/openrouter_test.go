@@ -136,7 +136,7 @@ func TestOpenRouterProvider_Transform(t *testing.T) {
 	// Check usage transformation
 	usage, ok := anthropicResponse["usage"].(map[string]any)
 	require.True(t, ok, "usage should be an object")
-	assert.Equal(t, float64(25), usage["input_tokens"], "input_tokens should match")
+	assert.Equal(t, float64(15), usage["input_tokens"], "input_tokens should exclude cached tokens")
 	assert.Equal(t, float64(8), usage["output_tokens"], "output_tokens should match")
 	assert.Equal(t, float64(10), usage["cache_read_input_tokens"], "cache_read_input_tokens should match")
 }
@@ -325,16 +325,16 @@ func TestOpenRouterProvider_WebSearchAnnotations(t *testing.T) {
 				"index": 0,
 				"message": map[string]any{
 					"role":    "assistant",
-					"content": "Based on my search, here's what I found about the weather.",
+					"content": "It's 18°C in San Francisco today.",
 					"annotations": []any{
 						map[string]any{
-							"type":  "web_search",
-							"query": "current weather San Francisco",
-							"results": []any{
-								map[string]any{
-									"title": "Weather in San Francisco",
-									"url":   "https://weather.com/sf",
-								},
+							"type": "url_citation",
+							"url_citation": map[string]any{
+								"url":         "https://weather.com/sf",
+								"title":       "Weather in San Francisco",
+								"content":     "Currently 18°C and sunny",
+								"start_index": 5,
+								"end_index":   26,
 							},
 						},
 					},
@@ -360,14 +360,25 @@ func TestOpenRouterProvider_WebSearchAnnotations(t *testing.T) {
 	err = json.Unmarshal(result, &anthropicResponse)
 	require.NoError(t, err, "should be able to parse result")
 
-	// Check annotations are preserved
-	annotations, ok := anthropicResponse["annotations"].([]any)
-	require.True(t, ok, "annotations should be preserved")
-	require.NotEmpty(t, annotations, "annotations should not be empty")
+	assert.NotContains(t, anthropicResponse, "annotations", "annotations should be converted to content blocks")
 
-	annotation, ok := annotations[0].(map[string]any)
-	require.True(t, ok, "annotation should be a map")
-	assert.Equal(t, "web_search", annotation["type"], "annotation type should match")
+	content, err := json.Marshal(anthropicResponse["content"])
+	require.NoError(t, err)
+
+	// Offsets count characters, so the multi-byte degree sign must not shift the cited span
+	assert.JSONEq(t, `[
+		{"type": "server_tool_use", "id": "srvtoolu_chatcmpl-123", "name": "web_search", "input": {"query": ""}},
+		{"type": "web_search_tool_result", "tool_use_id": "srvtoolu_chatcmpl-123", "content": [
+			{"type": "web_search_result", "url": "https://weather.com/sf", "title": "Weather in San Francisco",
+			 "encrypted_content": "", "page_age": null}
+		]},
+		{"type": "text", "text": "It's "},
+		{"type": "text", "text": "18°C in San Francisco", "citations": [
+			{"type": "web_search_result_location", "url": "https://weather.com/sf", "title": "Weather in San Francisco",
+			 "encrypted_index": "", "cited_text": "Currently 18°C and sunny"}
+		]},
+		{"type": "text", "text": " today."}
+	]`, string(content))
 
 	// Check usage includes server tool use
 	usage, ok := anthropicResponse["usage"].(map[string]any)
@@ -378,6 +389,70 @@ func TestOpenRouterProvider_WebSearchAnnotations(t *testing.T) {
 	assert.Equal(t, float64(1), serverToolUse["web_search_requests"], "web_search_requests should match")
 }
 
+func TestOpenRouterProvider_WebSearch(t *testing.T) {
+	provider := NewOpenRouterProvider(&config.Provider{Name: "openrouter"})
+
+	request := `{"model":"openai/gpt-4o","messages":[{"role":"user","content":"Weather in SF?"}],` +
+		`"tools":[{"type":"web_search_20250305","name":"web_search","max_uses":3}],"tool_choice":{"type":"tool","name":"web_search"}}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var orRequest map[string]any
+	require.NoError(t, json.Unmarshal(result, &orRequest))
+
+	assert.Equal(t, []any{map[string]any{"id": "web"}}, orRequest["plugins"])
+	assert.NotContains(t, orRequest, "tools", "the server tool should not be sent as a function")
+	assert.NotContains(t, orRequest, "tool_choice")
+
+	online := strings.Replace(request, "openai/gpt-4o", "openai/gpt-4o:online", 1)
+
+	result, err = provider.TransformRequest([]byte(online))
+	require.NoError(t, err)
+	assert.NotContains(t, string(result), "plugins", ":online models already search")
+
+	state := &StreamState{}
+
+	var output strings.Builder
+
+	for _, chunk := range []string{
+		`{"id":"gen-1","model":"openai/gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Sunny, 18°C."}}]}`,
+		`{"id":"gen-1","model":"openai/gpt-4o","choices":[{"index":0,"delta":{"annotations":[{"type":"url_citation",` +
+			`"url_citation":{"url":"https://weather.com/sf","title":"SF weather","start_index":0,"end_index":12}}]}}]}`,
+		`{"id":"gen-1","model":"openai/gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`{"id":"gen-1","model":"openai/gpt-4o","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":6}}`,
+	} {
+		events, err := provider.TransformStream([]byte(chunk), state)
+		require.NoError(t, err)
+		output.Write(events)
+	}
+
+	stream := output.String()
+
+	expectedOrder := []string{
+		`"delta":{"text":"Sunny, 18°C.","type":"text_delta"}`,
+		`"delta":{"citation":{"cited_text":"","encrypted_index":"","title":"SF weather",` +
+			`"type":"web_search_result_location","url":"https://weather.com/sf"},"type":"citations_delta"},"index":0`,
+		`data: {"index":0,"type":"content_block_stop"}`,
+		`"content_block":{"id":"srvtoolu_gen-1","input":{},"name":"web_search","type":"server_tool_use"},"index":1`,
+		`"partial_json":"{\"query\":\"\"}"`,
+		`"content_block":{"content":[{"encrypted_content":"","page_age":null,"title":"SF weather",` +
+			`"type":"web_search_result","url":"https://weather.com/sf"}],` +
+			`"tool_use_id":"srvtoolu_gen-1","type":"web_search_tool_result"},"index":2`,
+		`"stop_reason":"end_turn"`,
+	}
+
+	position := 0
+
+	for _, expected := range expectedOrder {
+		index := strings.Index(stream[position:], expected)
+		require.GreaterOrEqual(t, index, 0, "expected %q after position %d in:\n%s", expected, position, stream)
+		position += index + len(expected)
+	}
+
+	assert.Equal(t, 3, strings.Count(stream, "event: content_block_stop"), "every block should be closed once")
+}
+
 func TestOpenRouterProvider_StreamingToolCalls(t *testing.T) {
 	provider := NewOpenRouterProvider(&config.Provider{Name: "openrouter"})
 	state := &StreamState{}
@@ -452,3 +527,57 @@ func TestOpenRouterProvider_StreamingToolCalls(t *testing.T) {
 	startEventCount := strings.Count(combinedResult, "content_block_start")
 	assert.Equal(t, 2, startEventCount, "should have exactly 2 content_block_start events (message_start + tool_use)")
 }
+
+func TestOpenRouterProvider_Reasoning(t *testing.T) {
+	provider := NewOpenRouterProvider(&config.Provider{Name: "openrouter"})
+
+	request := `{
+		"model": "anthropic/claude-sonnet-4",
+		"thinking": {"type": "enabled", "budget_tokens": 8000},
+		"messages": [{"role": "user", "content": "Hi"}]
+	}`
+
+	result, err := provider.TransformRequest([]byte(request))
+	require.NoError(t, err)
+
+	var openrouterReq map[string]any
+	require.NoError(t, json.Unmarshal(result, &openrouterReq))
+	assert.Equal(t, map[string]any{"max_tokens": float64(8000)}, openrouterReq["reasoning"])
+	assert.NotContains(t, openrouterReq, "thinking")
+
+	response := `{
+		"id": "gen-1",
+		"model": "anthropic/claude-sonnet-4",
+		"choices": [{"message": {"role": "assistant", "reasoning": "The user greets me", "content": "Hello!"}, "finish_reason": "stop"}]
+	}`
+
+	result, err = provider.TransformResponse([]byte(response))
+	require.NoError(t, err)
+
+	var anthropicResp map[string]any
+	require.NoError(t, json.Unmarshal(result, &anthropicResp))
+	assert.Equal(t, []any{
+		map[string]any{"type": "thinking", "thinking": "The user greets me", "signature": ""},
+		map[string]any{"type": "text", "text": "Hello!"},
+	}, anthropicResp["content"])
+
+	state := &StreamState{}
+
+	var output []byte
+
+	for _, chunk := range []string{
+		`{"id":"gen-1","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"role":"assistant","reasoning":"The user greets me"}}]}`,
+		`{"id":"gen-1","model":"anthropic/claude-sonnet-4","choices":[{"index":0,"delta":{"content":"Hello!"}}]}`,
+	} {
+		events, err := provider.TransformStream([]byte(chunk), state)
+		require.NoError(t, err)
+
+		output = append(output, events...)
+	}
+
+	stream := string(output)
+	assert.Contains(t, stream, `"delta":{"thinking":"The user greets me","type":"thinking_delta"},"index":0`)
+	assert.Contains(t, stream, `data: {"index":0,"type":"content_block_stop"}`)
+	assert.Contains(t, stream, `"delta":{"text":"Hello!","type":"text_delta"},"index":1`)
+	assert.Less(t, strings.Index(stream, "thinking_delta"), strings.Index(stream, "text_delta"))
+}
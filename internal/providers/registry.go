@@ -1,7 +1,11 @@
 package providers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"strings"
 
@@ -17,6 +21,55 @@ type Provider interface {
 	IsStreaming(headers map[string][]string) bool
 	GetEndpoint() string
 	GetAPIKey() string
+	// NewRequest builds the upstream request, choosing the URL, authentication and
+	// headers the provider's API needs
+	NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error)
+}
+
+// UpstreamRequest is what the proxy asks a provider to send upstream
+type UpstreamRequest struct {
+	// Method is the HTTP method of the client's request
+	Method string
+	// Model is the routed model without the provider prefix
+	Model string
+	// Stream is set when the client asked for a streaming response
+	Stream bool
+	// Body is the request body, already transformed to the provider's format
+	Body []byte
+	// Header holds the client's headers, which are forwarded unless the provider sets them
+	Header http.Header
+	// APIKey is the key to authenticate with. When empty the provider picks its next key.
+	APIKey string
+}
+
+// ErrorTransformer is implemented by providers whose error responses need to be
+// converted before they are forwarded to the client
+type ErrorTransformer interface {
+	TransformError(statusCode int, body []byte) ([]byte, error)
+}
+
+// ModelResponseTransformer is implemented by providers whose responses don't name the
+// model, so the proxy converts them with the model the request was sent to
+type ModelResponseTransformer interface {
+	TransformModelResponse(model string, response []byte) ([]byte, error)
+}
+
+// StreamDecoder yields one upstream stream message per call to Next, ready to be passed
+// to TransformStream. Next returns io.EOF when the stream is finished.
+type StreamDecoder interface {
+	Next() ([]byte, error)
+}
+
+// StreamDecoderProvider is implemented by providers whose streaming responses are not
+// server-sent events
+type StreamDecoderProvider interface {
+	NewStreamDecoder(reader io.Reader) StreamDecoder
+}
+
+// StreamPassthrough is implemented by providers whose upstream streams are already in the
+// Anthropic format, so the proxy copies them to the client unchanged
+type StreamPassthrough interface {
+	PassthroughStream() bool
 }
 
 // StreamState tracks streaming conversion state
@@ -26,6 +79,14 @@ type StreamState struct {
 	Model            string
 	InitialUsage     map[string]any
 
+	// StopReason holds an upstream stop reason until the events that carry it can be sent
+	StopReason string
+
+	// PendingMessageDelta holds a message_delta until the usage that follows the finish
+	// reason arrives, and Output the streamed text to estimate it if it never does
+	PendingMessageDelta *MessageDeltaEvent
+	Output              strings.Builder
+
 	// Content block tracking for multiple blocks (text, tool_use, etc.)
 	ContentBlocks map[int]*ContentBlockState
 	CurrentIndex  int
@@ -106,14 +167,18 @@ func (r *Registry) List() []string {
 	return names
 }
 
-// Initialize registers all built-in providers
-func (r *Registry) Initialize(cfgProviders []config.Provider) {
+// Initialize registers a provider for every configured entry. The implementation is
+// chosen by the entry's type, so several named entries can share one implementation.
+// Entries with an unknown type are not registered and are reported in the error.
+func (r *Registry) Initialize(cfgProviders []config.Provider) error {
+	var errs []error
+
 	for i := range cfgProviders {
 		cfgProvider := &cfgProviders[i]
-		switch cfgProvider.Name {
+		switch cfgProvider.GetType() {
 		case "openrouter":
 			r.Register(NewOpenRouterProvider(cfgProvider))
-		case "openai":
+		case "openai", config.ProviderTypeOpenAICompatible:
 			r.Register(NewOpenAIProvider(cfgProvider))
 		case "anthropic":
 			r.Register(NewAnthropicProvider(cfgProvider))
@@ -121,6 +186,19 @@ func (r *Registry) Initialize(cfgProviders []config.Provider) {
 			r.Register(NewNvidiaProvider(cfgProvider))
 		case "gemini":
 			r.Register(NewGeminiProvider(cfgProvider))
+		case "azure":
+			r.Register(NewAzureProvider(cfgProvider))
+		case "bedrock":
+			r.Register(NewBedrockProvider(cfgProvider))
+		case "vertex":
+			r.Register(NewVertexProvider(cfgProvider))
+		case "ollama":
+			r.Register(NewOllamaProvider(cfgProvider))
+		default:
+			errs = append(errs, fmt.Errorf("provider %q has unknown type %q (supported: %s)",
+				cfgProvider.Name, cfgProvider.GetType(), strings.Join(config.SupportedProviderTypes, ", ")))
 		}
 	}
+
+	return errors.Join(errs...)
 }
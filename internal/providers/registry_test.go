@@ -1,6 +1,9 @@
 package providers
 
 import (
+	"context"
+	"io"
+	"net/http"
 	"testing"
 
 	"github.com/Davincible/claude-code-open/internal/config"
@@ -23,7 +26,7 @@ func TestRegistry_RegisterAndGet(t *testing.T) {
 
 func TestRegistry_GetByDomain(t *testing.T) {
 	registry := NewRegistry()
-	registry.Initialize([]config.Provider{{Name: "openrouter"}, {Name: "openai"}, {Name: "anthropic"}, {Name: "nvidia"}, {Name: "gemini"}})
+	require.NoError(t, registry.Initialize([]config.Provider{{Name: "openrouter"}, {Name: "openai"}, {Name: "anthropic"}, {Name: "nvidia"}, {Name: "gemini"}}))
 
 	testCases := []struct {
 		domain   string
@@ -48,7 +51,7 @@ func TestRegistry_GetByDomain(t *testing.T) {
 
 func TestRegistry_GetByDomain_InvalidURL(t *testing.T) {
 	registry := NewRegistry()
-	registry.Initialize([]config.Provider{{Name: "openrouter"}, {Name: "openai"}, {Name: "anthropic"}, {Name: "nvidia"}, {Name: "gemini"}})
+	require.NoError(t, registry.Initialize([]config.Provider{{Name: "openrouter"}, {Name: "openai"}, {Name: "anthropic"}, {Name: "nvidia"}, {Name: "gemini"}}))
 
 	_, err := registry.GetByDomain("invalid-url")
 	assert.Error(t, err, "should get error for invalid URL")
@@ -56,7 +59,7 @@ func TestRegistry_GetByDomain_InvalidURL(t *testing.T) {
 
 func TestRegistry_GetByDomain_UnknownDomain(t *testing.T) {
 	registry := NewRegistry()
-	registry.Initialize([]config.Provider{{Name: "openrouter"}, {Name: "openai"}, {Name: "anthropic"}, {Name: "nvidia"}, {Name: "gemini"}})
+	require.NoError(t, registry.Initialize([]config.Provider{{Name: "openrouter"}, {Name: "openai"}, {Name: "anthropic"}, {Name: "nvidia"}, {Name: "gemini"}}))
 
 	_, err := registry.GetByDomain("https://unknown-provider.com/api")
 	assert.Error(t, err, "should get error for unknown domain")
@@ -64,7 +67,7 @@ func TestRegistry_GetByDomain_UnknownDomain(t *testing.T) {
 
 func TestRegistry_List(t *testing.T) {
 	registry := NewRegistry()
-	registry.Initialize([]config.Provider{{Name: "openrouter"}, {Name: "openai"}, {Name: "anthropic"}, {Name: "nvidia"}, {Name: "gemini"}})
+	require.NoError(t, registry.Initialize([]config.Provider{{Name: "openrouter"}, {Name: "openai"}, {Name: "anthropic"}, {Name: "nvidia"}, {Name: "gemini"}}))
 
 	providers := registry.List()
 
@@ -83,3 +86,181 @@ func TestRegistry_GetNonExistent(t *testing.T) {
 	_, exists := registry.Get("nonexistent")
 	assert.False(t, exists, "non-existent provider should not exist")
 }
+
+func TestRegistry_Initialize_ProviderType(t *testing.T) {
+	registry := NewRegistry()
+	err := registry.Initialize([]config.Provider{
+		{Name: "vllm-east", Type: config.ProviderTypeOpenAICompatible, APIBase: "http://vllm-east:8000/v1/chat/completions"},
+		{Name: "vllm-west", Type: config.ProviderTypeOpenAICompatible, APIBase: "http://vllm-west:8000/v1/chat/completions"},
+		{Name: "my-gemini", Type: "gemini"},
+		{Name: "unknown", Type: "does-not-exist"},
+	})
+	assert.ErrorContains(t, err, `provider "unknown" has unknown type "does-not-exist"`)
+
+	assert.ElementsMatch(t, []string{"vllm-east", "vllm-west", "my-gemini"}, registry.List())
+
+	east, exists := registry.Get("vllm-east")
+	require.True(t, exists)
+	assert.IsType(t, &OpenAIProvider{}, east)
+	assert.Equal(t, "http://vllm-east:8000/v1/chat/completions", east.GetEndpoint())
+
+	west, exists := registry.Get("vllm-west")
+	require.True(t, exists)
+	assert.Equal(t, "http://vllm-west:8000/v1/chat/completions", west.GetEndpoint())
+
+	gemini, exists := registry.Get("my-gemini")
+	require.True(t, exists)
+	assert.IsType(t, &GeminiProvider{}, gemini)
+}
+
+func TestNewRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		provider       Provider
+		model          string
+		stream         bool
+		expectedURL    string
+		expectedHeader map[string]string
+	}{
+		{
+			name:        "anthropic",
+			provider:    NewAnthropicProvider(configuredProvider("anthropic", "https://api.anthropic.com/v1/messages", "ant-key")),
+			model:       "claude-sonnet-4-20250514",
+			expectedURL: "https://api.anthropic.com/v1/messages",
+			expectedHeader: map[string]string{
+				"x-api-key":         "ant-key",
+				"Authorization":     "",
+				"anthropic-version": AnthropicVersion,
+			},
+		},
+		{
+			name:        "openai",
+			provider:    NewOpenAIProvider(configuredProvider("openai", "https://api.openai.com/v1/chat/completions", "oai-key")),
+			model:       "gpt-4o",
+			expectedURL: "https://api.openai.com/v1/chat/completions",
+			expectedHeader: map[string]string{
+				"Authorization": "Bearer oai-key",
+			},
+		},
+		{
+			name:        "azure",
+			provider:    NewAzureProvider(configuredProvider("azure", "https://contoso.openai.azure.com", "azure-key")),
+			model:       "gpt-4o-prod",
+			expectedURL: "https://contoso.openai.azure.com/openai/deployments/gpt-4o-prod/chat/completions?api-version=" + DefaultAzureAPIVersion,
+			expectedHeader: map[string]string{
+				"api-key":       "azure-key",
+				"Authorization": "",
+			},
+		},
+		{
+			name:        "gemini",
+			provider:    NewGeminiProvider(configuredProvider("gemini", "https://generativelanguage.googleapis.com/v1beta/models", "goog-key")),
+			model:       "gemini-2.5-pro",
+			stream:      true,
+			expectedURL: "https://generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse",
+			expectedHeader: map[string]string{
+				"x-goog-api-key": "goog-key",
+				"Authorization":  "Bearer client-key",
+			},
+		},
+		{
+			name:        "bedrock api key",
+			provider:    NewBedrockProvider(configuredProvider("bedrock", "https://bedrock-runtime.eu-west-1.amazonaws.com", "aws-key")),
+			model:       "amazon.nova-pro-v1:0",
+			expectedURL: "https://bedrock-runtime.eu-west-1.amazonaws.com/model/amazon.nova-pro-v1%3A0/converse",
+			expectedHeader: map[string]string{
+				"Authorization": "Bearer aws-key",
+			},
+		},
+		{
+			name:        "keyless ollama forwards client credentials",
+			provider:    NewOllamaProvider(configuredProvider("ollama", "http://localhost:11434/api/chat", "")),
+			model:       "llama3.1",
+			expectedURL: "http://localhost:11434/api/chat",
+			expectedHeader: map[string]string{
+				"Authorization": "Bearer client-key",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set("Authorization", "Bearer client-key")
+			header.Set("Content-Type", "application/json")
+
+			req, err := tt.provider.NewRequest(context.Background(), UpstreamRequest{
+				Method: http.MethodPost,
+				Model:  tt.model,
+				Stream: tt.stream,
+				Body:   []byte(`{}`),
+				Header: header,
+			})
+			require.NoError(t, err)
+
+			assert.Equal(t, http.MethodPost, req.Method)
+			assert.Equal(t, tt.expectedURL, req.URL.String())
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+			assert.Equal(t, "Bearer client-key", header.Get("Authorization"), "the client's headers must not be modified")
+
+			for name, value := range tt.expectedHeader {
+				assert.Equal(t, value, req.Header.Get(name), name)
+			}
+
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, `{}`, string(body))
+		})
+	}
+}
+
+func TestNewRequest_PickedKey(t *testing.T) {
+	tests := []struct {
+		provider Provider
+		header   string
+		expected string
+	}{
+		{NewAnthropicProvider(configuredProvider("anthropic", "https://api.anthropic.com/v1/messages", "configured-key")),
+			"x-api-key", "picked-key"},
+		{NewOpenAIProvider(configuredProvider("openai", "https://api.openai.com/v1/chat/completions", "configured-key")),
+			"Authorization", "Bearer picked-key"},
+		{NewAzureProvider(configuredProvider("azure", "https://contoso.openai.azure.com", "configured-key")), "api-key", "picked-key"},
+		{NewGeminiProvider(configuredProvider("gemini", "https://generativelanguage.googleapis.com/v1beta/models", "configured-key")),
+			"x-goog-api-key", "picked-key"},
+		{NewBedrockProvider(configuredProvider("bedrock", "https://bedrock-runtime.eu-west-1.amazonaws.com", "configured-key")),
+			"Authorization", "Bearer picked-key"},
+		{NewOllamaProvider(configuredProvider("ollama", "http://localhost:11434/api/chat", "configured-key")),
+			"Authorization", "Bearer picked-key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider.Name(), func(t *testing.T) {
+			req, err := tt.provider.NewRequest(context.Background(), UpstreamRequest{Model: "model", Body: []byte(`{}`), APIKey: "picked-key"})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, req.Header.Get(tt.header))
+		})
+	}
+}
+
+func TestAnthropicNewRequest_KeepsClientVersion(t *testing.T) {
+	provider := NewAnthropicProvider(configuredProvider("anthropic", "https://api.anthropic.com/v1/messages", ""))
+
+	header := http.Header{}
+	header.Set("anthropic-version", "2024-01-01")
+	header.Set("x-api-key", "client-key")
+
+	req, err := provider.NewRequest(context.Background(), UpstreamRequest{Body: []byte(`{}`), Header: header})
+	require.NoError(t, err)
+
+	assert.Equal(t, "2024-01-01", req.Header.Get("anthropic-version"))
+	assert.Equal(t, "client-key", req.Header.Get("x-api-key"))
+}
+
+// configuredProvider applies the configuration defaults to a provider, which also sets up
+// its API keys
+func configuredProvider(name, apiBase, apiKey string) *config.Provider {
+	cfg := &config.Config{Providers: []config.Provider{{Name: name, APIBase: apiBase, APIKey: apiKey}}}
+	config.NewManager("").ApplyDefaults(cfg)
+
+	return &cfg.Providers[0]
+}
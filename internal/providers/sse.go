@@ -0,0 +1,119 @@
+package providers
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MessageToSSE replays a complete Anthropic message as the server-sent events a streaming
+// response would have produced. It is used when a client asked to stream but the upstream
+// only returned a single response.
+func MessageToSSE(message []byte) ([]byte, error) {
+	var msg map[string]any
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic message: %w", err)
+	}
+
+	if msg["type"] != "message" {
+		return nil, fmt.Errorf("can't stream a response of type %v", msg["type"])
+	}
+
+	usage, _ := msg["usage"].(map[string]any)
+
+	// Output tokens are reported by message_delta, like a real stream
+	startUsage := map[string]any{"input_tokens": 0, "output_tokens": 0}
+	for key, value := range usage {
+		if key != "output_tokens" {
+			startUsage[key] = value
+		}
+	}
+
+	id, _ := msg["id"].(string)
+	model, _ := msg["model"].(string)
+
+	events := FormatSSEEvent("message_start", CreateMessageStartEvent(id, model, startUsage))
+
+	blocks, _ := msg["content"].([]any)
+	for index, block := range blocks {
+		if blockMap, ok := block.(map[string]any); ok {
+			events = append(events, contentBlockToSSE(index, blockMap)...)
+		}
+	}
+
+	messageDelta := map[string]any{
+		"type": "message_delta",
+		"delta": map[string]any{
+			"stop_reason":   msg["stop_reason"],
+			"stop_sequence": msg["stop_sequence"],
+		},
+	}
+
+	if outputTokens, ok := usage["output_tokens"]; ok {
+		messageDelta["usage"] = map[string]any{"output_tokens": outputTokens}
+	}
+
+	events = append(events, FormatSSEEvent("message_delta", messageDelta)...)
+	events = append(events, FormatSSEEvent("message_stop", map[string]any{"type": "message_stop"})...)
+
+	return events, nil
+}
+
+// contentBlockToSSE streams one content block as start, delta and stop events. Block types
+// without a delta form are sent whole in the start event.
+func contentBlockToSSE(index int, block map[string]any) []byte {
+	var (
+		start  map[string]any
+		deltas []map[string]any
+	)
+
+	switch block["type"] {
+	case ContentTypeText:
+		start = map[string]any{"type": ContentTypeText, "text": ""}
+
+		citations, _ := block["citations"].([]any)
+		for _, citation := range citations {
+			deltas = append(deltas, map[string]any{"type": "citations_delta", "citation": citation})
+		}
+
+		deltas = append(deltas, map[string]any{"type": "text_delta", "text": block["text"]})
+	case ContentTypeThinking:
+		start = map[string]any{"type": ContentTypeThinking, "thinking": "", "signature": ""}
+		deltas = append(deltas, map[string]any{"type": "thinking_delta", "thinking": block["thinking"]})
+
+		if signature, _ := block["signature"].(string); signature != "" {
+			deltas = append(deltas, map[string]any{"type": "signature_delta", "signature": signature})
+		}
+	case ContentTypeToolUse, ContentTypeServerToolUse:
+		start = map[string]any{"type": block["type"], "id": block["id"], "name": block["name"], "input": map[string]any{}}
+
+		input := block["input"]
+		if input == nil {
+			input = map[string]any{}
+		}
+
+		if inputJSON, err := json.Marshal(input); err == nil {
+			deltas = append(deltas, map[string]any{"type": "input_json_delta", "partial_json": string(inputJSON)})
+		}
+	default:
+		start = block
+	}
+
+	events := FormatSSEEvent("content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         index,
+		"content_block": start,
+	})
+
+	for _, delta := range deltas {
+		events = append(events, FormatSSEEvent("content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": delta,
+		})...)
+	}
+
+	return append(events, FormatSSEEvent("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": index,
+	})...)
+}
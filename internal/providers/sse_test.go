@@ -0,0 +1,171 @@
+package providers
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageToSSE(t *testing.T) {
+	message := `{
+		"id": "msg_1",
+		"type": "message",
+		"role": "assistant",
+		"model": "gemini-2.5-pro",
+		"content": [
+			{"type": "thinking", "thinking": "Look it up", "signature": "sig"},
+			{"type": "text", "text": "Let me check."},
+			{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"stop_sequence": null,
+		"usage": {"input_tokens": 12, "output_tokens": 30, "cache_read_input_tokens": 4}
+	}`
+
+	events, err := MessageToSSE([]byte(message))
+	require.NoError(t, err)
+
+	expected := strings.Join([]string{
+		`event: message_start`,
+		`data: {"message":{"content":[],"id":"msg_1","model":"gemini-2.5-pro","role":"assistant","stop_reason":null,"stop_sequence":null,` +
+			`"type":"message","usage":{"cache_read_input_tokens":4,"input_tokens":12,"output_tokens":0}},"type":"message_start"}`,
+		``,
+		`event: content_block_start`,
+		`data: {"content_block":{"signature":"","thinking":"","type":"thinking"},"index":0,"type":"content_block_start"}`,
+		``,
+		`event: content_block_delta`,
+		`data: {"delta":{"thinking":"Look it up","type":"thinking_delta"},"index":0,"type":"content_block_delta"}`,
+		``,
+		`event: content_block_delta`,
+		`data: {"delta":{"signature":"sig","type":"signature_delta"},"index":0,"type":"content_block_delta"}`,
+		``,
+		`event: content_block_stop`,
+		`data: {"index":0,"type":"content_block_stop"}`,
+		``,
+		`event: content_block_start`,
+		`data: {"content_block":{"text":"","type":"text"},"index":1,"type":"content_block_start"}`,
+		``,
+		`event: content_block_delta`,
+		`data: {"delta":{"text":"Let me check.","type":"text_delta"},"index":1,"type":"content_block_delta"}`,
+		``,
+		`event: content_block_stop`,
+		`data: {"index":1,"type":"content_block_stop"}`,
+		``,
+		`event: content_block_start`,
+		`data: {"content_block":{"id":"toolu_1","input":{},"name":"get_weather","type":"tool_use"},"index":2,"type":"content_block_start"}`,
+		``,
+		`event: content_block_delta`,
+		`data: {"delta":{"partial_json":"{\"city\":\"Paris\"}","type":"input_json_delta"},"index":2,"type":"content_block_delta"}`,
+		``,
+		`event: content_block_stop`,
+		`data: {"index":2,"type":"content_block_stop"}`,
+		``,
+		`event: message_delta`,
+		`data: {"delta":{"stop_reason":"tool_use","stop_sequence":null},"type":"message_delta","usage":{"output_tokens":30}}`,
+		``,
+		`event: message_stop`,
+		`data: {"type":"message_stop"}`,
+		``,
+		``,
+	}, "\n")
+
+	assert.Equal(t, expected, string(events))
+
+	_, err = MessageToSSE([]byte(`{"type":"error","error":{"type":"api_error","message":"boom"}}`))
+	assert.Error(t, err)
+}
+
+func TestSSEDecoder(t *testing.T) {
+	largeData := strings.Repeat("x", 256*1024)
+
+	tests := []struct {
+		name     string
+		stream   string
+		expected []SSEEvent
+	}{
+		{
+			name:   "event types and comments",
+			stream: ": keep-alive\n\nevent: ping\ndata: {}\n\ndata: {\"a\":1}\n\n",
+			expected: []SSEEvent{
+				{Event: "ping", Data: []byte("{}")},
+				{Data: []byte(`{"a":1}`)},
+			},
+		},
+		{
+			name:   "CRLF and CR line endings",
+			stream: "event: a\r\ndata: 1\r\n\r\ndata: 2\r\rdata: 3\n\n",
+			expected: []SSEEvent{
+				{Event: "a", Data: []byte("1")},
+				{Data: []byte("2")},
+				{Data: []byte("3")},
+			},
+		},
+		{
+			name:   "multi-line data",
+			stream: "data: first\ndata:second\ndata\ndata:  indented\n\n",
+			expected: []SSEEvent{
+				{Data: []byte("first\nsecond\n\n indented")},
+			},
+		},
+		{
+			name:   "id carries over and retry",
+			stream: "id: 7\nretry: 3000\ndata: a\n\ndata: b\n\nid\nretry: 1x\ndata: c\n\n",
+			expected: []SSEEvent{
+				{ID: "7", Retry: 3000, Data: []byte("a")},
+				{ID: "7", Data: []byte("b")},
+				{Data: []byte("c")},
+			},
+		},
+		{
+			name:   "events without data are dropped",
+			stream: "event: empty\n\nid: 1\n\ndata: kept\n\n",
+			expected: []SSEEvent{
+				{ID: "1", Data: []byte("kept")},
+			},
+		},
+		{
+			name:   "byte order mark and missing final blank line",
+			stream: "\xEF\xBB\xBFdata: a\n\ndata: [DONE]",
+			expected: []SSEEvent{
+				{Data: []byte("a")},
+				{Data: []byte("[DONE]")},
+			},
+		},
+		{
+			name:   "lines beyond the scanner limit",
+			stream: "data: " + largeData + "\r\n\r\n",
+			expected: []SSEEvent{
+				{Data: []byte(largeData)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Reading a byte at a time splits line endings across reads
+			for _, reader := range []io.Reader{strings.NewReader(tt.stream), iotest.OneByteReader(strings.NewReader(tt.stream))} {
+				decoder := NewSSEDecoder(reader)
+
+				var events []SSEEvent
+
+				for {
+					event, err := decoder.Next()
+					if errors.Is(err, io.EOF) {
+						break
+					}
+
+					require.NoError(t, err)
+
+					events = append(events, *event)
+				}
+
+				assert.Equal(t, tt.expected, events)
+			}
+		})
+	}
+}
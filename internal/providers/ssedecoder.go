@@ -0,0 +1,180 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// SSEEvent is a server-sent event as dispatched by an SSEDecoder
+type SSEEvent struct {
+	// Event is the event type, empty when the stream didn't name it
+	Event string
+	// Data is the event data, the data lines joined by newlines
+	Data []byte
+	// ID is the last event ID the stream set, which carries over to later events
+	ID string
+	// Retry is the reconnection time in milliseconds, zero unless the event set it
+	Retry int
+}
+
+// SSEDecoder parses a text/event-stream incrementally, following the WHATWG HTML event
+// stream interpretation. Lines may end in LF, CRLF or CR and have no length limit, so an
+// event carrying a large tool call or image isn't cut off.
+type SSEDecoder struct {
+	reader *bufio.Reader
+	line   []byte
+	lastID string
+
+	// skipLF is set when the last line ended in CR, so an LF following it belongs to the
+	// same line ending
+	skipLF bool
+	// started is set once the optional byte order mark was checked for
+	started bool
+}
+
+// NewSSEDecoder creates a decoder for a server-sent events stream
+func NewSSEDecoder(reader io.Reader) *SSEDecoder {
+	return &SSEDecoder{reader: bufio.NewReader(reader)}
+}
+
+// Next returns the next event with data. It returns io.EOF when the stream is finished.
+//
+// Unlike a browser, the decoder dispatches an event the stream ended in without the
+// closing blank line, as some upstreams don't send one after their last event.
+func (d *SSEDecoder) Next() (*SSEEvent, error) {
+	var (
+		event   = &SSEEvent{}
+		data    []byte
+		hasData bool
+	)
+
+	for {
+		line, err := d.readLine()
+		if errors.Is(err, io.EOF) {
+			if hasData {
+				event.Data = data
+				event.ID = d.lastID
+
+				return event, nil
+			}
+
+			return nil, io.EOF
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("read event stream: %w", err)
+		}
+
+		// A blank line dispatches the event, events without data are dropped
+		if len(line) == 0 {
+			if !hasData {
+				event = &SSEEvent{}
+				continue
+			}
+
+			event.Data = data
+			event.ID = d.lastID
+
+			return event, nil
+		}
+
+		// Comments, such as keep-alives
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+
+		switch string(field) {
+		case "event":
+			event.Event = string(value)
+		case "data":
+			if hasData {
+				data = append(data, '\n')
+			}
+
+			data = append(data, value...)
+			hasData = true
+		case "id":
+			if !bytes.ContainsRune(value, 0) {
+				d.lastID = string(value)
+			}
+		case "retry":
+			if retry, ok := parseRetry(value); ok {
+				event.Retry = retry
+			}
+		}
+	}
+}
+
+// readLine returns the next line without its line ending. The slice is only valid until
+// the next call.
+func (d *SSEDecoder) readLine() ([]byte, error) {
+	d.line = d.line[:0]
+
+	if !d.started {
+		d.started = true
+
+		if bom, err := d.reader.Peek(3); err == nil && bytes.Equal(bom, []byte("\xEF\xBB\xBF")) {
+			_, _ = d.reader.Discard(len(bom))
+		}
+	}
+
+	for {
+		// Fill the buffer, so the line ending search sees everything received so far
+		if _, err := d.reader.Peek(1); err != nil {
+			if errors.Is(err, io.EOF) && len(d.line) > 0 {
+				return d.line, nil
+			}
+
+			return nil, err
+		}
+
+		buffered, _ := d.reader.Peek(d.reader.Buffered())
+
+		if d.skipLF {
+			d.skipLF = false
+
+			if buffered[0] == '\n' {
+				_, _ = d.reader.Discard(1)
+				continue
+			}
+		}
+
+		end := bytes.IndexAny(buffered, "\r\n")
+		if end < 0 {
+			d.line = append(d.line, buffered...)
+			_, _ = d.reader.Discard(len(buffered))
+
+			continue
+		}
+
+		d.line = append(d.line, buffered[:end]...)
+		d.skipLF = buffered[end] == '\r'
+		_, _ = d.reader.Discard(end + 1)
+
+		return d.line, nil
+	}
+}
+
+// parseRetry parses a retry field, which only counts when it is made of ASCII digits
+func parseRetry(value []byte) (int, bool) {
+	if len(value) == 0 {
+		return 0, false
+	}
+
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+
+	retry, err := strconv.Atoi(string(value))
+
+	return retry, err == nil
+}
@@ -0,0 +1,124 @@
+package providers
+
+import "strings"
+
+const (
+	ContentTypeThinking         = "thinking"
+	ContentTypeRedactedThinking = "redacted_thinking"
+
+	// Thinking budgets below these limits map to the low and medium reasoning efforts
+	lowReasoningBudget    = 4096
+	mediumReasoningBudget = 16384
+)
+
+// thinkingBudget returns budget_tokens of the request's thinking parameter, reporting false
+// when extended thinking is not enabled
+func thinkingBudget(request map[string]any) (int, bool) {
+	thinking, ok := request["thinking"].(map[string]any)
+	if !ok || thinking["type"] != "enabled" {
+		return 0, false
+	}
+
+	budget, ok := thinking["budget_tokens"].(float64)
+	if !ok || budget <= 0 {
+		return 0, false
+	}
+
+	return int(budget), true
+}
+
+// reasoningEffortForBudget maps a thinking budget to an OpenAI reasoning effort
+func reasoningEffortForBudget(budget int) string {
+	switch {
+	case budget < lowReasoningBudget:
+		return "low"
+	case budget < mediumReasoningBudget:
+		return "medium"
+	}
+
+	return "high"
+}
+
+// supportsReasoningEffort reports whether an OpenAI model takes reasoning_effort: the
+// o-series, gpt-5 and gpt-oss reasoning models, with or without a vendor prefix such as
+// "openai/". gpt-5-chat and earlier models such as gpt-4o reject it.
+func supportsReasoningEffort(model string) bool {
+	model = strings.ToLower(model[strings.LastIndex(model, "/")+1:])
+
+	switch {
+	case len(model) > 1 && model[0] == 'o' && model[1] >= '1' && model[1] <= '9':
+		return true
+	case strings.HasPrefix(model, "gpt-5"):
+		return !strings.HasPrefix(model, "gpt-5-chat")
+	}
+
+	return strings.HasPrefix(model, "gpt-oss")
+}
+
+// isThinkingBlock reports whether a content block holds thinking from an earlier turn.
+// Their signatures can only be verified by Anthropic, so other upstreams get them stripped.
+func isThinkingBlock(block map[string]any) bool {
+	blockType, _ := block["type"].(string)
+	return blockType == ContentTypeThinking || blockType == ContentTypeRedactedThinking
+}
+
+// openTextBlockIndex returns the index of the open text block, adding a new block after
+// the existing ones when there is none
+func openTextBlockIndex(state *StreamState) int {
+	return openBlockIndex(state, ContentTypeText)
+}
+
+func openBlockIndex(state *StreamState, blockType string) int {
+	for index, block := range state.ContentBlocks {
+		if block.Type == blockType && !block.StopSent {
+			return index
+		}
+	}
+
+	index := len(state.ContentBlocks)
+	state.ContentBlocks[index] = &ContentBlockState{Type: blockType}
+
+	return index
+}
+
+// handleThinkingDelta streams reasoning text as a thinking block
+func handleThinkingDelta(thinking string, state *StreamState) []byte {
+	var events []byte
+
+	index := openBlockIndex(state, ContentTypeThinking)
+	block := state.ContentBlocks[index]
+
+	if !block.StartSent {
+		events = append(events, contentBlockStartEvent(index, newThinkingBlock())...)
+		block.StartSent = true
+	}
+
+	return append(events, contentBlockDeltaEvent(index, ThinkingDelta{Type: "thinking_delta", Thinking: thinking})...)
+}
+
+// closeThinkingBlock stops an open thinking block, so text and tool blocks that follow the
+// reasoning don't interleave with it
+func closeThinkingBlock(state *StreamState) []byte {
+	for index, block := range state.ContentBlocks {
+		if block.Type == ContentTypeThinking && block.StartSent && !block.StopSent {
+			block.StopSent = true
+
+			return contentBlockStopEvent(index)
+		}
+	}
+
+	return nil
+}
+
+// reasoningText returns the reasoning carried by an OpenAI-style message or delta. OpenAI
+// compatible servers such as DeepSeek and vLLM use reasoning_content, OpenRouter uses
+// reasoning.
+func reasoningText(message map[string]any) string {
+	if reasoning, ok := message["reasoning_content"].(string); ok && reasoning != "" {
+		return reasoning
+	}
+
+	reasoning, _ := message["reasoning"].(string)
+
+	return reasoning
+}
@@ -0,0 +1,89 @@
+package providers
+
+const (
+	toolChoiceAuto = "auto"
+	toolChoiceAny  = "any"
+	toolChoiceTool = "tool"
+	toolChoiceNone = "none"
+)
+
+// toolChoice is a parsed Anthropic tool_choice
+type toolChoice struct {
+	Type                   string
+	Name                   string
+	DisableParallelToolUse bool
+}
+
+// parseToolChoice reads an Anthropic tool_choice object, reporting false for anything else
+func parseToolChoice(value any) (toolChoice, bool) {
+	choiceMap, ok := value.(map[string]any)
+	if !ok {
+		return toolChoice{}, false
+	}
+
+	var choice toolChoice
+
+	choice.Type, _ = choiceMap["type"].(string)
+	choice.Name, _ = choiceMap["name"].(string)
+	choice.DisableParallelToolUse, _ = choiceMap["disable_parallel_tool_use"].(bool)
+
+	switch choice.Type {
+	case toolChoiceAuto, toolChoiceAny, toolChoiceNone:
+		return choice, true
+	case toolChoiceTool:
+		return choice, choice.Name != ""
+	}
+
+	return toolChoice{}, false
+}
+
+// applyOpenAIToolChoice translates an Anthropic tool_choice into OpenAI's tool_choice and
+// parallel_tool_calls. Values that are not Anthropic tool choices, such as the OpenAI
+// strings some clients already send, are left as they are.
+func applyOpenAIToolChoice(request map[string]any) {
+	choice, ok := parseToolChoice(request["tool_choice"])
+	if !ok {
+		return
+	}
+
+	switch choice.Type {
+	case toolChoiceAuto, toolChoiceNone:
+		request["tool_choice"] = choice.Type
+	case toolChoiceAny:
+		request["tool_choice"] = "required"
+	case toolChoiceTool:
+		request["tool_choice"] = map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": choice.Name},
+		}
+	}
+
+	if choice.DisableParallelToolUse && choice.Type != toolChoiceNone {
+		request["parallel_tool_calls"] = false
+	}
+}
+
+// convertToolChoiceToGemini translates an Anthropic tool_choice into a Gemini toolConfig.
+// Gemini has no switch for parallel calls, so disable_parallel_tool_use is not carried over.
+func convertToolChoiceToGemini(value any) map[string]any {
+	choice, ok := parseToolChoice(value)
+	if !ok {
+		return nil
+	}
+
+	functionCallingConfig := map[string]any{}
+
+	switch choice.Type {
+	case toolChoiceAuto:
+		functionCallingConfig["mode"] = "AUTO"
+	case toolChoiceAny:
+		functionCallingConfig["mode"] = "ANY"
+	case toolChoiceNone:
+		functionCallingConfig["mode"] = "NONE"
+	case toolChoiceTool:
+		functionCallingConfig["mode"] = "ANY"
+		functionCallingConfig["allowedFunctionNames"] = []any{choice.Name}
+	}
+
+	return map[string]any{"functionCallingConfig": functionCallingConfig}
+}
@@ -0,0 +1,270 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+const (
+	// VertexAnthropicVersion is the anthropic_version Vertex AI expects for Claude models
+	VertexAnthropicVersion = "vertex-2023-10-16"
+
+	vertexDefaultRegion = "us-central1"
+)
+
+// VertexProvider talks to Google Vertex AI with service account credentials. Gemini
+// models use the Gemini translation, Claude models take the Anthropic format natively.
+type VertexProvider struct {
+	Provider *config.Provider
+
+	gemini *GeminiProvider
+
+	mu          sync.Mutex
+	account     *googleServiceAccount
+	tokenSource *googleTokenSource
+}
+
+func NewVertexProvider(provider *config.Provider) *VertexProvider {
+	return &VertexProvider{
+		Provider: provider,
+		gemini:   NewGeminiProvider(provider),
+	}
+}
+
+func (p *VertexProvider) Name() string {
+	return p.Provider.Name
+}
+
+func (p *VertexProvider) SupportsStreaming() bool {
+	return true
+}
+
+func (p *VertexProvider) GetEndpoint() string {
+	if p.Provider.APIBase != "" {
+		return strings.TrimSuffix(p.Provider.APIBase, "/")
+	}
+
+	region := p.region()
+	if region == "global" {
+		return "https://aiplatform.googleapis.com"
+	}
+
+	return fmt.Sprintf("https://%s-aiplatform.googleapis.com", region)
+}
+
+// GetAPIKey returns no key, Vertex AI authenticates with OAuth access tokens. A configured
+// api_key is never sent, so the proxy doesn't track its health either.
+func (p *VertexProvider) GetAPIKey() string {
+	return ""
+}
+
+func (p *VertexProvider) IsStreaming(headers map[string][]string) bool {
+	for _, ct := range headers["Content-Type"] {
+		if strings.HasPrefix(ct, ContentTypeEventStream) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *VertexProvider) region() string {
+	if p.Provider.Region != "" {
+		return p.Provider.Region
+	}
+
+	return vertexDefaultRegion
+}
+
+// isVertexClaudeModel reports whether a model is served by the Anthropic publisher
+func isVertexClaudeModel(model string) bool {
+	return strings.HasPrefix(model, "claude")
+}
+
+// EndpointURL returns the regional prediction URL for a model
+func (p *VertexProvider) EndpointURL(model string, stream bool) (string, error) {
+	project, err := p.project()
+	if err != nil {
+		return "", err
+	}
+
+	publisher, action := "google", "generateContent"
+
+	switch {
+	case isVertexClaudeModel(model) && stream:
+		publisher, action = "anthropic", "streamRawPredict"
+	case isVertexClaudeModel(model):
+		publisher, action = "anthropic", "rawPredict"
+	case stream:
+		action = "streamGenerateContent?alt=sse"
+	}
+
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/%s/models/%s:%s",
+		p.GetEndpoint(), project, p.region(), publisher, model, action), nil
+}
+
+// NewRequest addresses the model's publisher and authenticates with an access token
+func (p *VertexProvider) NewRequest(ctx context.Context, upstream UpstreamRequest) (*http.Request, error) {
+	endpointURL, err := p.EndpointURL(upstream.Model, upstream.Stream)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := newUpstreamRequest(ctx, endpointURL, upstream)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.SignRequest(req, upstream.Body); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// SignRequest adds an OAuth access token minted from the service account key
+func (p *VertexProvider) SignRequest(req *http.Request, _ []byte) error {
+	tokenSource, err := p.getTokenSource()
+	if err != nil {
+		return err
+	}
+
+	token, err := tokenSource.Token(req.Context())
+	if err != nil {
+		return fmt.Errorf("get Vertex AI access token: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+func (p *VertexProvider) project() (string, error) {
+	if p.Provider.Project != "" {
+		return p.Provider.Project, nil
+	}
+
+	account, err := p.getAccount()
+	if err != nil {
+		return "", err
+	}
+
+	if account.ProjectID == "" {
+		return "", errors.New("no Vertex AI project configured and the service account key has no project_id")
+	}
+
+	return account.ProjectID, nil
+}
+
+// getAccount loads the service account key once it is first needed. Failures are not
+// cached so a missing key file can be fixed without restarting the proxy.
+func (p *VertexProvider) getAccount() (*googleServiceAccount, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.loadAccountLocked()
+}
+
+func (p *VertexProvider) loadAccountLocked() (*googleServiceAccount, error) {
+	if p.account != nil {
+		return p.account, nil
+	}
+
+	credentialsFile := p.Provider.CredentialsFile
+	if credentialsFile == "" {
+		credentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	if credentialsFile == "" {
+		return nil, errors.New("no service account key configured, set credentials_file or GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	account, err := loadGoogleServiceAccount(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	p.account = account
+
+	return account, nil
+}
+
+func (p *VertexProvider) getTokenSource() (*googleTokenSource, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tokenSource != nil {
+		return p.tokenSource, nil
+	}
+
+	account, err := p.loadAccountLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	p.tokenSource = newGoogleTokenSource(account, p.Provider.TokenURL)
+
+	return p.tokenSource, nil
+}
+
+func (p *VertexProvider) TransformRequest(request []byte) ([]byte, error) {
+	var anthropicRequest map[string]any
+	if err := json.Unmarshal(request, &anthropicRequest); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Anthropic request: %w", err)
+	}
+
+	model, _ := anthropicRequest["model"].(string)
+	if !isVertexClaudeModel(model) {
+		return p.gemini.TransformRequest(request)
+	}
+
+	// Claude on Vertex takes the Messages API body with the model in the URL
+	delete(anthropicRequest, "model")
+
+	if _, ok := anthropicRequest["anthropic_version"]; !ok {
+		anthropicRequest["anthropic_version"] = VertexAnthropicVersion
+	}
+
+	return json.Marshal(anthropicRequest)
+}
+
+func (p *VertexProvider) TransformResponse(response []byte) ([]byte, error) {
+	if _, ok := anthropicEventType(response); ok {
+		return response, nil
+	}
+
+	return p.gemini.TransformResponse(response)
+}
+
+func (p *VertexProvider) TransformStream(chunk []byte, state *StreamState) ([]byte, error) {
+	// Claude streams Anthropic events. The handler passes only their data, so the event
+	// line is written again from the event's type.
+	if eventType, ok := anthropicEventType(chunk); ok {
+		return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", eventType, chunk)), nil
+	}
+
+	return p.gemini.TransformStream(chunk, state)
+}
+
+// anthropicEventType returns the type of a JSON body that is an Anthropic message, stream
+// event or error, reporting false for Gemini responses
+func anthropicEventType(data []byte) (string, bool) {
+	var probe struct {
+		Type       string          `json:"type"`
+		Candidates json.RawMessage `json:"candidates"`
+	}
+
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return "", false
+	}
+
+	return probe.Type, probe.Type != "" && probe.Candidates == nil
+}
@@ -0,0 +1,267 @@
+package providers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeServiceAccountKey writes a service account JSON key with a fresh RSA key
+func writeServiceAccountKey(t *testing.T, projectID string) (string, *rsa.PublicKey) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	key, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     projectID,
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		"client_email":   "proxy@my-project.iam.gserviceaccount.com",
+		"token_uri":      "https://oauth2.googleapis.com/token",
+	})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "service-account.json")
+	require.NoError(t, os.WriteFile(path, key, 0600))
+
+	return path, &privateKey.PublicKey
+}
+
+func TestVertexProvider_SignRequest_JWTBearer(t *testing.T) {
+	keyFile, publicKey := writeServiceAccountKey(t, "my-project")
+
+	var tokenRequests atomic.Int32
+
+	// Fake OAuth token endpoint that verifies the signed assertion
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		assert.NoError(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature), "assertion should be signed with the key")
+
+		var header, claims map[string]any
+
+		headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		require.NoError(t, json.Unmarshal(headerJSON, &header))
+		assert.Equal(t, map[string]any{"alg": "RS256", "typ": "JWT", "kid": "key-1"}, header)
+
+		claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, json.Unmarshal(claimsJSON, &claims))
+		assert.Equal(t, "proxy@my-project.iam.gserviceaccount.com", claims["iss"])
+		assert.Equal(t, "https://www.googleapis.com/auth/cloud-platform", claims["scope"])
+		assert.Equal(t, "http://"+r.Host+"/token", claims["aud"], "audience should be the configured token URL")
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"ya29.test-token","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer tokenServer.Close()
+
+	provider := NewVertexProvider(&config.Provider{
+		Name:            "vertex",
+		CredentialsFile: keyFile,
+		TokenURL:        tokenServer.URL + "/token",
+	})
+
+	for range 3 {
+		req, err := http.NewRequest(http.MethodPost, "https://us-central1-aiplatform.googleapis.com/", nil)
+		require.NoError(t, err)
+
+		require.NoError(t, provider.SignRequest(req, nil))
+		assert.Equal(t, "Bearer ya29.test-token", req.Header.Get("Authorization"))
+	}
+
+	assert.Equal(t, int32(1), tokenRequests.Load(), "access token should be cached")
+}
+
+func TestVertexProvider_SignRequest_TokenError(t *testing.T) {
+	keyFile, _ := writeServiceAccountKey(t, "my-project")
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant","error_description":"Invalid JWT Signature."}`))
+	}))
+	defer tokenServer.Close()
+
+	provider := NewVertexProvider(&config.Provider{Name: "vertex", CredentialsFile: keyFile, TokenURL: tokenServer.URL})
+
+	req, err := http.NewRequest(http.MethodPost, "https://us-central1-aiplatform.googleapis.com/", nil)
+	require.NoError(t, err)
+
+	err = provider.SignRequest(req, nil)
+	assert.ErrorContains(t, err, "invalid_grant")
+
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	missing := NewVertexProvider(&config.Provider{Name: "vertex"})
+	assert.ErrorContains(t, missing.SignRequest(req, nil), "credentials_file")
+}
+
+func TestVertexProvider_SignRequest_SharedFetch(t *testing.T) {
+	keyFile, _ := writeServiceAccountKey(t, "my-project")
+
+	var tokenRequests atomic.Int32
+	release := make(chan struct{})
+
+	// Token endpoint that hangs until the test releases it
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		<-release
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"ya29.shared","expires_in":3599}`))
+	}))
+	defer tokenServer.Close()
+
+	provider := NewVertexProvider(&config.Provider{Name: "vertex", CredentialsFile: keyFile, TokenURL: tokenServer.URL})
+
+	// A caller that gives up returns its own context error without waiting for the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://us-central1-aiplatform.googleapis.com/", nil)
+	require.NoError(t, err)
+	assert.ErrorIs(t, provider.SignRequest(req, nil), context.DeadlineExceeded)
+
+	// Callers arriving while the fetch is in flight wait for it instead of starting another
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequest(http.MethodPost, "https://us-central1-aiplatform.googleapis.com/", nil)
+			assert.NoError(t, err)
+			assert.NoError(t, provider.SignRequest(req, nil))
+			assert.Equal(t, "Bearer ya29.shared", req.Header.Get("Authorization"))
+		}()
+	}
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), tokenRequests.Load(), "concurrent callers should share one token request")
+}
+
+func TestVertexProvider_EndpointURL(t *testing.T) {
+	keyFile, _ := writeServiceAccountKey(t, "key-project")
+
+	tests := []struct {
+		name     string
+		provider *config.Provider
+		model    string
+		stream   bool
+		expected string
+	}{
+		{
+			name:     "gemini",
+			provider: &config.Provider{Project: "my-project", Region: "europe-west4"},
+			model:    "gemini-2.0-flash",
+			expected: "https://europe-west4-aiplatform.googleapis.com/v1/projects/my-project/locations/europe-west4/publishers/google/models/gemini-2.0-flash:generateContent",
+		},
+		{
+			name:     "gemini streaming",
+			provider: &config.Provider{Project: "my-project"},
+			model:    "gemini-2.0-flash",
+			stream:   true,
+			expected: "https://us-central1-aiplatform.googleapis.com/v1/projects/my-project/locations/us-central1/publishers/google/models/gemini-2.0-flash:streamGenerateContent?alt=sse",
+		},
+		{
+			name:     "claude",
+			provider: &config.Provider{Project: "my-project", Region: "us-east5"},
+			model:    "claude-3-5-sonnet-v2@20241022",
+			expected: "https://us-east5-aiplatform.googleapis.com/v1/projects/my-project/locations/us-east5/publishers/anthropic/models/claude-3-5-sonnet-v2@20241022:rawPredict",
+		},
+		{
+			name:     "claude streaming on the global endpoint",
+			provider: &config.Provider{Project: "my-project", Region: "global"},
+			model:    "claude-sonnet-4@20250514",
+			stream:   true,
+			expected: "https://aiplatform.googleapis.com/v1/projects/my-project/locations/global/publishers/anthropic/models/claude-sonnet-4@20250514:streamRawPredict",
+		},
+		{
+			name:     "project from service account key",
+			provider: &config.Provider{CredentialsFile: keyFile, APIBase: "http://localhost:8080/"},
+			model:    "gemini-1.5-pro",
+			expected: "http://localhost:8080/v1/projects/key-project/locations/us-central1/publishers/google/models/gemini-1.5-pro:generateContent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.provider.Name = "vertex"
+			provider := NewVertexProvider(tt.provider)
+
+			endpointURL, err := provider.EndpointURL(tt.model, tt.stream)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, endpointURL)
+		})
+	}
+}
+
+func TestVertexProvider_TransformRequest(t *testing.T) {
+	provider := NewVertexProvider(&config.Provider{Name: "vertex"})
+
+	claudeRequest := `{"model":"claude-sonnet-4@20250514","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`
+
+	result, err := provider.TransformRequest([]byte(claudeRequest))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"anthropic_version":"vertex-2023-10-16","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`, string(result))
+
+	geminiRequest := `{"model":"gemini-2.0-flash","max_tokens":100,"messages":[{"role":"user","content":"Hi"}]}`
+
+	result, err = provider.TransformRequest([]byte(geminiRequest))
+	require.NoError(t, err)
+
+	var geminiBody map[string]any
+	require.NoError(t, json.Unmarshal(result, &geminiBody))
+	assert.Contains(t, geminiBody, "contents", "Gemini models should use the Gemini request format")
+	assert.NotContains(t, geminiBody, "messages")
+}
+
+func TestVertexProvider_TransformStream(t *testing.T) {
+	provider := NewVertexProvider(&config.Provider{Name: "vertex"})
+
+	claudeEvent := `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`
+
+	events, err := provider.TransformStream([]byte(claudeEvent), &StreamState{})
+	require.NoError(t, err)
+	assert.Equal(t, "event: content_block_delta\ndata: "+claudeEvent+"\n\n", string(events))
+
+	geminiChunk := `{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"}]}}],"modelVersion":"gemini-2.0-flash"}`
+
+	events, err = provider.TransformStream([]byte(geminiChunk), &StreamState{})
+	require.NoError(t, err)
+	assert.Contains(t, string(events), "event: message_start")
+	assert.Contains(t, string(events), `"text":"Hello"`)
+}
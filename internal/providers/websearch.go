@@ -0,0 +1,327 @@
+package providers
+
+import (
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ContentTypeServerToolUse       = "server_tool_use"
+	ContentTypeWebSearchToolResult = "web_search_tool_result"
+
+	// Anthropic versions the web search tool type, as in web_search_20250305
+	webSearchToolTypePrefix = "web_search_"
+	webSearchToolName       = "web_search"
+)
+
+// webSearchTool holds the options of an Anthropic web search server tool
+type webSearchTool struct {
+	Name           string
+	AllowedDomains []string
+	BlockedDomains []string
+}
+
+// webSearchResult is a page returned by the upstream search
+type webSearchResult struct {
+	URL   string
+	Title string
+}
+
+// webCitation is a span of the answer that cites a search result. Start and End are byte
+// offsets into the answer text, spans that can't be placed are attached to the last block.
+type webCitation struct {
+	Start     int
+	End       int
+	URL       string
+	Title     string
+	CitedText string
+}
+
+// citedText is a piece of the answer text with the citations that support it
+type citedText struct {
+	Text      string
+	Citations []any
+}
+
+func isWebSearchTool(tool any) bool {
+	toolMap, _ := tool.(map[string]any)
+	toolType, _ := toolMap["type"].(string)
+
+	return strings.HasPrefix(toolType, webSearchToolTypePrefix)
+}
+
+// HasWebSearchTool reports whether a request offers Anthropic's web search server tool
+func HasWebSearchTool(request map[string]any) bool {
+	tools, _ := request["tools"].([]any)
+
+	for _, tool := range tools {
+		if isWebSearchTool(tool) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// extractWebSearchTool removes the web search server tool from the request tools, so it can
+// be mapped to the upstream's own grounding. A tool choice naming the tool is dropped as the
+// upstream decides when to search, and so are the tools when none are left.
+func extractWebSearchTool(request map[string]any) (webSearchTool, bool) {
+	tools, _ := request["tools"].([]any)
+
+	var (
+		webSearch webSearchTool
+		found     bool
+	)
+
+	remaining := make([]any, 0, len(tools))
+
+	for _, tool := range tools {
+		if !isWebSearchTool(tool) {
+			remaining = append(remaining, tool)
+			continue
+		}
+
+		toolMap, _ := tool.(map[string]any)
+
+		found = true
+		webSearch.Name, _ = toolMap["name"].(string)
+		webSearch.AllowedDomains = stringSlice(toolMap["allowed_domains"])
+		webSearch.BlockedDomains = stringSlice(toolMap["blocked_domains"])
+	}
+
+	if !found {
+		return webSearchTool{}, false
+	}
+
+	if webSearch.Name == "" {
+		webSearch.Name = webSearchToolName
+	}
+
+	if len(webSearch.AllowedDomains) > 0 || len(webSearch.BlockedDomains) > 0 {
+		slog.Debug("Upstream web search doesn't take domain filters, searching without them",
+			"allowed_domains", webSearch.AllowedDomains, "blocked_domains", webSearch.BlockedDomains)
+	}
+
+	if len(remaining) > 0 {
+		request["tools"] = remaining
+	} else {
+		delete(request, "tools")
+		delete(request, "tool_choice")
+	}
+
+	if choice, ok := parseToolChoice(request["tool_choice"]); ok && choice.Type == toolChoiceTool && choice.Name == webSearch.Name {
+		delete(request, "tool_choice")
+	}
+
+	return webSearch, true
+}
+
+func stringSlice(value any) []string {
+	list, _ := value.([]any)
+
+	var result []string
+
+	for _, item := range list {
+		if str, ok := item.(string); ok {
+			result = append(result, str)
+		}
+	}
+
+	return result
+}
+
+// serverToolUseID builds a server tool use id from an upstream id
+func serverToolUseID(seed string) string {
+	if seed == "" {
+		seed = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return "srvtoolu_" + strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+
+		return '_'
+	}, seed)
+}
+
+// webSearchResultContent converts search results to the content of a web_search_tool_result
+// block. Upstreams don't return encrypted content, so it is left empty.
+func webSearchResultContent(results []webSearchResult) []any {
+	content := make([]any, 0, len(results))
+
+	for _, result := range results {
+		content = append(content, map[string]any{
+			"type":              "web_search_result",
+			"url":               result.URL,
+			"title":             result.Title,
+			"encrypted_content": "",
+			"page_age":          nil,
+		})
+	}
+
+	return content
+}
+
+// webSearchBlocks returns the server_tool_use and web_search_tool_result blocks describing
+// a search the upstream ran
+func webSearchBlocks(id, query string, results []webSearchResult) []map[string]any {
+	return []map[string]any{
+		{
+			"type":  ContentTypeServerToolUse,
+			"id":    id,
+			"name":  webSearchToolName,
+			"input": map[string]any{"query": query},
+		},
+		{
+			"type":        ContentTypeWebSearchToolResult,
+			"tool_use_id": id,
+			"content":     webSearchResultContent(results),
+		},
+	}
+}
+
+func (c webCitation) toAnthropic() map[string]any {
+	return map[string]any{
+		"type":            "web_search_result_location",
+		"url":             c.URL,
+		"title":           c.Title,
+		"encrypted_index": "",
+		"cited_text":      c.CitedText,
+	}
+}
+
+// splitCitedText splits the answer text at the cited spans. Citations of the same span are
+// grouped, spans overlapping an earlier one are attached to the piece they start in.
+func splitCitedText(text string, citations []webCitation) []citedText {
+	if text == "" {
+		return nil
+	}
+
+	var placed, unplaced []webCitation
+
+	for _, citation := range citations {
+		if validTextSpan(text, citation.Start, citation.End) {
+			placed = append(placed, citation)
+		} else {
+			unplaced = append(unplaced, citation)
+		}
+	}
+
+	sort.SliceStable(placed, func(i, j int) bool {
+		return placed[i].Start < placed[j].Start
+	})
+
+	var (
+		pieces []citedText
+		cursor int
+	)
+
+	for _, citation := range placed {
+		if citation.Start < cursor {
+			last := &pieces[len(pieces)-1]
+			last.Citations = append(last.Citations, citation.toAnthropic())
+
+			continue
+		}
+
+		if citation.Start > cursor {
+			pieces = append(pieces, citedText{Text: text[cursor:citation.Start]})
+		}
+
+		pieces = append(pieces, citedText{
+			Text:      text[citation.Start:citation.End],
+			Citations: []any{citation.toAnthropic()},
+		})
+		cursor = citation.End
+	}
+
+	if cursor < len(text) {
+		pieces = append(pieces, citedText{Text: text[cursor:]})
+	}
+
+	last := &pieces[len(pieces)-1]
+	for _, citation := range unplaced {
+		last.Citations = append(last.Citations, citation.toAnthropic())
+	}
+
+	return pieces
+}
+
+func validTextSpan(text string, start, end int) bool {
+	if start < 0 || start >= end || end > len(text) {
+		return false
+	}
+
+	return utf8.RuneStart(text[start]) && (end == len(text) || utf8.RuneStart(text[end]))
+}
+
+// runeOffsetToByte converts a character offset into a byte offset of the text, returning -1
+// when it is out of range
+func runeOffsetToByte(text string, offset int) int {
+	if offset < 0 {
+		return -1
+	}
+
+	position := 0
+
+	for byteIndex := range text {
+		if position == offset {
+			return byteIndex
+		}
+
+		position++
+	}
+
+	if position == offset {
+		return len(text)
+	}
+
+	return -1
+}
+
+// webSearchStreamEvents streams a search the upstream ran. The citations are attached to the
+// open text block, which is closed, and the search blocks follow it since the text has
+// already been streamed.
+func webSearchStreamEvents(state *StreamState, id, query string, results []webSearchResult, citations []webCitation) []byte {
+	var events []byte
+
+	for index, block := range state.ContentBlocks {
+		if block.Type != ContentTypeText || !block.StartSent || block.StopSent {
+			continue
+		}
+
+		for _, citation := range citations {
+			events = append(events, contentBlockDeltaEvent(index, CitationsDelta{Type: "citations_delta", Citation: citation.toAnthropic()})...)
+		}
+
+		block.StopSent = true
+		events = append(events, contentBlockStopEvent(index)...)
+	}
+
+	for _, block := range webSearchBlocks(id, query, results) {
+		index := len(state.ContentBlocks)
+		blockType, _ := block["type"].(string)
+		state.ContentBlocks[index] = &ContentBlockState{Type: blockType, StartSent: true, StopSent: true}
+
+		events = append(events, contentBlockToSSE(index, block)...)
+	}
+
+	return events
+}
+
+// hasWebSearchResult reports whether a stream already carried search results
+func hasWebSearchResult(state *StreamState) bool {
+	for _, block := range state.ContentBlocks {
+		if block.Type == ContentTypeWebSearchToolResult {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,46 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitCitedText(t *testing.T) {
+	text := "One. Two. Three."
+
+	citation := func(start, end int, url string) webCitation {
+		return webCitation{Start: start, End: end, URL: url}
+	}
+
+	pieces := splitCitedText(text, []webCitation{
+		citation(5, 9, "https://b.example"),
+		citation(0, 4, "https://a.example"),
+		citation(5, 9, "https://c.example"),
+		citation(7, 12, "https://overlap.example"),
+		citation(20, 30, "https://out-of-range.example"),
+	})
+
+	var texts []string
+	for _, piece := range pieces {
+		texts = append(texts, piece.Text)
+	}
+
+	assert.Equal(t, []string{"One.", " ", "Two.", " Three."}, texts)
+	assert.Len(t, pieces[0].Citations, 1)
+	assert.Empty(t, pieces[1].Citations)
+	assert.Len(t, pieces[2].Citations, 3, "citations of the same or an overlapping span should be grouped")
+	assert.Len(t, pieces[3].Citations, 1, "citations that can't be placed should go on the last piece")
+
+	assert.Nil(t, splitCitedText("", []webCitation{citation(0, 1, "https://a.example")}))
+}
+
+func TestRuneOffsetToByte(t *testing.T) {
+	text := "21°C now"
+
+	assert.Equal(t, 0, runeOffsetToByte(text, 0))
+	assert.Equal(t, 4, runeOffsetToByte(text, 3))
+	assert.Equal(t, len(text), runeOffsetToByte(text, 8))
+	assert.Equal(t, -1, runeOffsetToByte(text, 9))
+	assert.Equal(t, -1, runeOffsetToByte(text, -1))
+}
@@ -0,0 +1,230 @@
+// Package router picks the provider and model a request is sent to. The configured rules
+// are evaluated in order, followed by built-in rules for the routes of the router config.
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+)
+
+// Request holds what rules match on
+type Request struct {
+	// Model is the model the client asked for
+	Model string
+	// Tokens is the estimated input token count
+	Tokens int
+	// Tools are the names of the tools the request offers
+	Tools []string
+	// Thinking is set when the request enables extended thinking
+	Thinking bool
+	// Stream is set when the client asked for a streaming response
+	Stream bool
+	// System is the text of the system prompt
+	System string
+	// Header holds the client's request headers
+	Header http.Header
+}
+
+// Router evaluates compiled rules
+type Router struct {
+	rules []*rule
+}
+
+type rule struct {
+	config.RouteRule
+
+	model   *regexp.Regexp
+	tools   []*regexp.Regexp
+	headers map[string]*regexp.Regexp
+	system  *regexp.Regexp
+}
+
+// New compiles the rules of a router config. The routes of the config are added after its
+// rules.
+func New(cfg *config.RouterConfig) (*Router, error) {
+	var (
+		router = &Router{}
+		errs   []error
+	)
+
+	for i, routeRule := range slices.Concat(cfg.Rules, builtinRules(cfg)) {
+		compiled, err := compile(routeRule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %d (%s): %w", i, ruleName(routeRule, i), err))
+			continue
+		}
+
+		router.rules = append(router.rules, compiled)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return router, nil
+}
+
+// builtinRules turns the named routes of the config into rules that fire on what the request
+// is: long context above the threshold, background for Claude Code's small fast model, web
+// search when the web_search tool is offered and think when extended thinking is enabled
+func builtinRules(cfg *config.RouterConfig) []config.RouteRule {
+	var rules []config.RouteRule
+
+	if cfg.LongContext != "" {
+		rules = append(rules, config.RouteRule{
+			Name:      "long_context",
+			Target:    cfg.LongContext,
+			MinTokens: cfg.GetLongContextThreshold() + 1,
+		})
+	}
+
+	if cfg.Background != "" {
+		rules = append(rules, config.RouteRule{
+			Name:   "background",
+			Target: cfg.Background,
+			Model:  modelFamily(cfg.GetSmallFastModel()) + "*",
+		})
+	}
+
+	if cfg.WebSearch != "" {
+		rules = append(rules, config.RouteRule{
+			Name:   "web_search",
+			Target: cfg.WebSearch,
+			Tools:  []string{"web_search"},
+		})
+	}
+
+	if cfg.Think != "" {
+		thinking := true
+
+		rules = append(rules, config.RouteRule{
+			Name:     "think",
+			Target:   cfg.Think,
+			Thinking: &thinking,
+		})
+	}
+
+	return rules
+}
+
+// modelDateSuffix matches the snapshot date of a model name, as in claude-3-5-haiku-20241022
+var modelDateSuffix = regexp.MustCompile(`-(\d{8}|latest)$`)
+
+// modelFamily strips the snapshot date or latest alias from a model name, so every snapshot
+// of the family matches
+func modelFamily(model string) string {
+	return modelDateSuffix.ReplaceAllString(model, "")
+}
+
+// Route returns the target of the first rule matching the request and the rule's name. It
+// returns false when no rule matches.
+func (r *Router) Route(request *Request) (target, name string, ok bool) {
+	for i, rule := range r.rules {
+		if rule.matches(request) {
+			return rule.Target, ruleName(rule.RouteRule, i), true
+		}
+	}
+
+	return "", "", false
+}
+
+func ruleName(routeRule config.RouteRule, index int) string {
+	if routeRule.Name != "" {
+		return routeRule.Name
+	}
+
+	return fmt.Sprintf("#%d", index)
+}
+
+func compile(routeRule config.RouteRule) (*rule, error) {
+	if routeRule.Target == "" {
+		return nil, errors.New("target is required")
+	}
+
+	compiled := &rule{RouteRule: routeRule}
+
+	if routeRule.Model != "" {
+		compiled.model = config.CompileGlob(routeRule.Model)
+	}
+
+	for _, tool := range routeRule.Tools {
+		compiled.tools = append(compiled.tools, config.CompileGlob(tool))
+	}
+
+	if len(routeRule.Headers) > 0 {
+		compiled.headers = make(map[string]*regexp.Regexp, len(routeRule.Headers))
+		for name, value := range routeRule.Headers {
+			compiled.headers[name] = config.CompileGlob(value)
+		}
+	}
+
+	if routeRule.System != "" {
+		system, err := regexp.Compile(routeRule.System)
+		if err != nil {
+			return nil, fmt.Errorf("invalid system pattern: %w", err)
+		}
+
+		compiled.system = system
+	}
+
+	return compiled, nil
+}
+
+func (r *rule) matches(request *Request) bool {
+	if r.model != nil && !r.model.MatchString(request.Model) {
+		return false
+	}
+
+	if r.MinTokens > 0 && request.Tokens < r.MinTokens {
+		return false
+	}
+
+	if r.MaxTokens > 0 && request.Tokens > r.MaxTokens {
+		return false
+	}
+
+	if r.HasTools != nil && *r.HasTools != (len(request.Tools) > 0) {
+		return false
+	}
+
+	for _, tool := range r.tools {
+		if !matchesAny(tool, request.Tools) {
+			return false
+		}
+	}
+
+	if r.Thinking != nil && *r.Thinking != request.Thinking {
+		return false
+	}
+
+	if r.Stream != nil && *r.Stream != request.Stream {
+		return false
+	}
+
+	for name, value := range r.headers {
+		if !value.MatchString(request.Header.Get(name)) {
+			return false
+		}
+	}
+
+	if r.system != nil && !r.system.MatchString(request.System) {
+		return false
+	}
+
+	return true
+}
+
+func matchesAny(pattern *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+
+	return false
+}
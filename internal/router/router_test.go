@@ -0,0 +1,202 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_Rules(t *testing.T) {
+	enabled, disabled := true, false
+
+	rules := []config.RouteRule{
+		{Name: "haiku", Target: "openai,gpt-4o-mini", Model: "claude-3-5-haiku*"},
+		{Name: "huge", Target: "gemini,gemini-2.5-pro", MinTokens: 100000},
+		{Name: "small", Target: "groq,llama-3.1-8b", MaxTokens: 100, HasTools: &disabled},
+		{Name: "search", Target: "openrouter,perplexity/sonar", Tools: []string{"web_*"}},
+		{Name: "reasoning", Target: "openai,o3", Thinking: &enabled, Stream: &enabled},
+		{Name: "team", Target: "azure,gpt-4o-prod", Headers: map[string]string{"X-Team": "platform-*"}},
+		{Name: "reviewer", Target: "anthropic,claude-opus-4", System: `(?i)code review`},
+	}
+
+	router, err := New(&config.RouterConfig{Rules: rules})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		request        Request
+		expectedTarget string
+		expectedRule   string
+	}{
+		{
+			name:           "model glob",
+			request:        Request{Model: "claude-3-5-haiku-20241022", Tokens: 50, Tools: []string{"Bash"}},
+			expectedTarget: "openai,gpt-4o-mini",
+			expectedRule:   "haiku",
+		},
+		{
+			name:           "first matching rule wins",
+			request:        Request{Model: "claude-3-5-haiku-20241022", Tokens: 200000},
+			expectedTarget: "openai,gpt-4o-mini",
+			expectedRule:   "haiku",
+		},
+		{
+			name:           "minimum tokens",
+			request:        Request{Model: "claude-sonnet-4", Tokens: 200000},
+			expectedTarget: "gemini,gemini-2.5-pro",
+			expectedRule:   "huge",
+		},
+		{
+			name:           "maximum tokens without tools",
+			request:        Request{Model: "claude-sonnet-4", Tokens: 80},
+			expectedTarget: "groq,llama-3.1-8b",
+			expectedRule:   "small",
+		},
+		{
+			name:           "tool name glob",
+			request:        Request{Model: "claude-sonnet-4", Tokens: 80, Tools: []string{"Bash", "web_search"}},
+			expectedTarget: "openrouter,perplexity/sonar",
+			expectedRule:   "search",
+		},
+		{
+			name:           "thinking and stream",
+			request:        Request{Model: "claude-sonnet-4", Tokens: 1000, Thinking: true, Stream: true},
+			expectedTarget: "openai,o3",
+			expectedRule:   "reasoning",
+		},
+		{
+			name:           "header glob",
+			request:        Request{Model: "claude-sonnet-4", Tokens: 1000, Thinking: true, Header: http.Header{"X-Team": {"platform-infra"}}},
+			expectedTarget: "azure,gpt-4o-prod",
+			expectedRule:   "team",
+		},
+		{
+			name:           "system prompt pattern",
+			request:        Request{Model: "claude-sonnet-4", Tokens: 1000, System: "You are doing a Code Review."},
+			expectedTarget: "anthropic,claude-opus-4",
+			expectedRule:   "reviewer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, rule, ok := router.Route(&tt.request)
+			require.True(t, ok)
+			assert.Equal(t, tt.expectedTarget, target)
+			assert.Equal(t, tt.expectedRule, rule)
+		})
+	}
+
+	_, _, ok := router.Route(&Request{Model: "claude-sonnet-4", Tokens: 1000, Thinking: true})
+	assert.False(t, ok, "a request matching no rule should not be routed")
+}
+
+func TestRouter_BuiltinRoutes(t *testing.T) {
+	t.Setenv("ANTHROPIC_SMALL_FAST_MODEL", "")
+
+	router, err := New(&config.RouterConfig{
+		Default:     "default,claude-3-5-sonnet",
+		LongContext: "longcontext,claude-3-opus",
+		Background:  "background,claude-3-5-haiku",
+		WebSearch:   "websearch,claude-3-5-sonnet:online",
+		Think:       "think,claude-3-5-sonnet",
+		Rules: []config.RouteRule{
+			{Target: "custom,model", Model: "custom-*"},
+		},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		request        Request
+		expectedTarget string
+		expectedRule   string
+	}{
+		{"configured rules first", Request{Model: "custom-model", Tokens: 70000}, "custom,model", "#0"},
+		{"long context", Request{Model: "claude-3-5-haiku-20241022", Tokens: 70000}, "longcontext,claude-3-opus", "long_context"},
+		{"small fast model snapshot", Request{Model: "claude-3-5-haiku-20241022", Tokens: 1000}, "background,claude-3-5-haiku", "background"},
+		{"web search tool", Request{Model: "claude-sonnet-4", Tools: []string{"Bash", "web_search"}}, "websearch,claude-3-5-sonnet:online", "web_search"},
+		{"thinking enabled", Request{Model: "claude-sonnet-4", Thinking: true}, "think,claude-3-5-sonnet", "think"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, rule, ok := router.Route(&tt.request)
+			require.True(t, ok)
+			assert.Equal(t, tt.expectedTarget, target)
+			assert.Equal(t, tt.expectedRule, rule)
+		})
+	}
+
+	_, _, ok := router.Route(&Request{Model: "claude-sonnet-4", Tokens: 1000, Tools: []string{"Bash"}})
+	assert.False(t, ok, "requests without a signal keep their model")
+}
+
+func TestRouter_BuiltinRouteSettings(t *testing.T) {
+	routerConfig := &config.RouterConfig{
+		LongContext:          "longcontext,gemini-2.5-pro",
+		LongContextThreshold: 150000,
+		Background:           "background,gpt-4o-mini",
+	}
+
+	t.Run("long context threshold", func(t *testing.T) {
+		router, err := New(routerConfig)
+		require.NoError(t, err)
+
+		_, _, ok := router.Route(&Request{Model: "claude-sonnet-4", Tokens: 100000})
+		assert.False(t, ok)
+
+		target, _, ok := router.Route(&Request{Model: "claude-sonnet-4", Tokens: 150001})
+		assert.True(t, ok)
+		assert.Equal(t, "longcontext,gemini-2.5-pro", target)
+	})
+
+	t.Run("small fast model from the environment", func(t *testing.T) {
+		t.Setenv("ANTHROPIC_SMALL_FAST_MODEL", "claude-haiku-4-5-20251001")
+
+		router, err := New(routerConfig)
+		require.NoError(t, err)
+
+		target, _, ok := router.Route(&Request{Model: "claude-haiku-4-5-20251001"})
+		assert.True(t, ok)
+		assert.Equal(t, "background,gpt-4o-mini", target)
+
+		_, _, ok = router.Route(&Request{Model: "claude-3-5-haiku-20241022"})
+		assert.False(t, ok, "other haiku models are not the small fast model")
+	})
+
+	t.Run("configured small fast model", func(t *testing.T) {
+		t.Setenv("ANTHROPIC_SMALL_FAST_MODEL", "claude-haiku-4-5")
+
+		configured := *routerConfig
+		configured.SmallFastModel = "claude-3-5-haiku-latest"
+
+		router, err := New(&configured)
+		require.NoError(t, err)
+
+		_, _, ok := router.Route(&Request{Model: "claude-3-5-haiku-20241022"})
+		assert.True(t, ok)
+	})
+}
+
+func TestModelFamily(t *testing.T) {
+	assert.Equal(t, "claude-3-5-haiku", modelFamily("claude-3-5-haiku-20241022"))
+	assert.Equal(t, "claude-3-5-haiku", modelFamily("claude-3-5-haiku-latest"))
+	assert.Equal(t, "claude-haiku-4-5", modelFamily("claude-haiku-4-5"))
+}
+
+func TestRouter_InvalidRules(t *testing.T) {
+	_, err := New(&config.RouterConfig{
+		Rules: []config.RouteRule{
+			{Name: "no-target", Model: "claude-*"},
+			{Name: "bad-pattern", Target: "openai,gpt-4o", System: "("},
+		},
+	})
+
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "rule 0 (no-target): target is required")
+	assert.ErrorContains(t, err, "rule 1 (bad-pattern): invalid system pattern")
+}
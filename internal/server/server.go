@@ -22,6 +22,9 @@ import (
 	"github.com/Davincible/claude-code-open/internal/providers"
 )
 
+// KeyStatusPath serves the masked health of the providers' API keys
+const KeyStatusPath = "/status/keys"
+
 type Server struct {
 	config   *config.Manager
 	registry *providers.Registry
@@ -29,16 +32,19 @@ type Server struct {
 	server   *http.Server
 }
 
-func New(configManager *config.Manager, logger *slog.Logger) *Server {
+func New(configManager *config.Manager, logger *slog.Logger) (*Server, error) {
 	registry := providers.NewRegistry()
 	cfg := configManager.Get()
-	registry.Initialize(cfg.Providers)
+
+	if err := registry.Initialize(cfg.Providers); err != nil {
+		return nil, fmt.Errorf("initialize providers: %w", err)
+	}
 
 	return &Server{
 		config:   configManager,
 		registry: registry,
 		logger:   logger,
-	}
+	}, nil
 }
 
 func (s *Server) Start() error {
@@ -72,6 +78,12 @@ func (s *Server) Start() error {
 		}
 	}()
 
+	// Disabled API keys are probed until the server stops
+	probeCtx, stopProbing := context.WithCancel(context.Background())
+	defer stopProbing()
+
+	go handlers.NewKeyProber(s.config, s.registry, s.logger).Run(probeCtx)
+
 	// Wait for interrupt signal to gracefully shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
@@ -109,12 +121,14 @@ func (s *Server) setupRoutes() *http.ServeMux {
 	// Create handlers
 	proxyHandler := handlers.NewProxyHandler(s.config, s.registry, s.logger)
 	healthHandler := handlers.NewHealthHandler(s.logger)
+	keyStatusHandler := handlers.NewKeyStatusHandler(s.config, s.logger)
 
 	// Setup middleware chains
 	middlewareSet := middleware.NewMiddlewareSet(s.config, s.logger)
 
 	// Apply middleware chains to routes
 	mux.Handle("/health", middlewareSet.HealthChain().Handler(healthHandler))
+	mux.Handle(KeyStatusPath, middlewareSet.DefaultChain().Handler(keyStatusHandler))
 	mux.Handle("/", middlewareSet.DefaultChain().Handler(proxyHandler))
 
 	return mux
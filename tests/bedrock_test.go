@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/handlers"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+func TestBedrockConverseStreamIntegration(t *testing.T) {
+	recordedStream, err := os.ReadFile("testdata/bedrock_converse_stream.bin")
+	require.NoError(t, err)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	var upstreamRequest *http.Request
+
+	var upstreamBody []byte
+
+	// Local stand-in for the Bedrock runtime replaying a recorded ConverseStream response
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequest = r
+		upstreamBody, _ = io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", providers.ContentTypeAmazonEventStream)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(recordedStream)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{
+				Name:    "bedrock",
+				APIBase: upstream.URL,
+				Region:  "us-east-1",
+			},
+		},
+		Router: config.RouterConfig{
+			Default: "bedrock,amazon.nova-pro-v1:0",
+		},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	registry := providers.NewRegistry()
+	registry.Initialize(cfg.Providers)
+
+	handler := handlers.NewProxyHandler(cfgMgr, registry, logger)
+
+	requestBody, err := json.Marshal(map[string]any{
+		"model":      "bedrock,amazon.nova-pro-v1:0",
+		"max_tokens": 100,
+		"stream":     true,
+		"messages": []map[string]any{
+			{"role": "user", "content": "Hello"},
+		},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	require.NotNil(t, upstreamRequest, "request should reach the Bedrock stand-in")
+	assert.Equal(t, "/model/amazon.nova-pro-v1%3A0/converse-stream", upstreamRequest.URL.EscapedPath())
+	assert.True(t, strings.HasPrefix(upstreamRequest.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"),
+		"request should be signed with SigV4")
+	assert.NotEmpty(t, upstreamRequest.Header.Get("X-Amz-Date"))
+	assert.JSONEq(t, `{"messages":[{"role":"user","content":[{"text":"Hello"}]}],"inferenceConfig":{"maxTokens":100}}`, string(upstreamBody))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"text/event-stream"}, rr.Header().Values("Content-Type"))
+
+	body := rr.Body.String()
+	assert.Contains(t, body, "event: message_start")
+	assert.Contains(t, body, `"text":"Hello"`)
+	assert.Contains(t, body, `"text":" from Bedrock"`)
+	assert.Contains(t, body, `"stop_reason":"end_turn"`)
+	assert.Contains(t, body, `"usage":{"input_tokens":12,"output_tokens":4}`)
+	assert.True(t, strings.HasSuffix(body, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+}
@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/handlers"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+// newGeminiTestHandler routes every request to a Gemini provider served by upstream
+func newGeminiTestHandler(t *testing.T, upstream *httptest.Server) http.Handler {
+	t.Helper()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{
+				Name:    "gemini",
+				APIBase: upstream.URL + "/v1beta/models",
+				APIKey:  "test-key",
+			},
+		},
+		Router: config.RouterConfig{
+			Default: "gemini,gemini-2.5-flash",
+		},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	registry := providers.NewRegistry()
+	registry.Initialize(cfg.Providers)
+
+	return handlers.NewProxyHandler(cfgMgr, registry, logger)
+}
+
+func postStreamingMessage(t *testing.T, handler http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+
+	requestBody, err := json.Marshal(map[string]any{
+		"model":      "gemini,gemini-2.5-flash",
+		"max_tokens": 100,
+		"stream":     true,
+		"messages": []map[string]any{
+			{"role": "user", "content": "Hello"},
+		},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func TestGeminiStreamGenerateContentIntegration(t *testing.T) {
+	var upstreamURL string
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamURL = r.URL.String()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+
+		for _, chunk := range []string{
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":"Hello"}]}}],"responseId":"resp-1","modelVersion":"gemini-2.5-flash"}`,
+			`{"candidates":[{"content":{"role":"model","parts":[{"text":" there"}]},"finishReason":"STOP"}],` +
+				`"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":2},"responseId":"resp-1"}`,
+		} {
+			_, _ = w.Write([]byte("data: " + chunk + "\r\n\r\n"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	rr := postStreamingMessage(t, newGeminiTestHandler(t, upstream))
+
+	assert.Equal(t, "/v1beta/models/gemini-2.5-flash:streamGenerateContent?alt=sse", upstreamURL)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	body := rr.Body.String()
+	assert.Contains(t, body, "event: message_start")
+	assert.Contains(t, body, `"text":"Hello"`)
+	assert.Contains(t, body, `"text":" there"`)
+	assert.Contains(t, body, `"stop_reason":"end_turn"`)
+	assert.Contains(t, body, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+}
+
+func TestGeminiSynthesizedStreamIntegration(t *testing.T) {
+	// An upstream that ignores the streaming endpoint and answers with one JSON response
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{
+			"candidates": [{"content": {"role": "model", "parts": [
+				{"text": "Checking"},
+				{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}
+			]}, "finishReason": "STOP"}],
+			"usageMetadata": {"promptTokenCount": 7, "candidatesTokenCount": 5},
+			"responseId": "resp-2",
+			"modelVersion": "gemini-2.5-flash"
+		}`))
+	}))
+	defer upstream.Close()
+
+	rr := postStreamingMessage(t, newGeminiTestHandler(t, upstream))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+
+	body := rr.Body.String()
+	expectedOrder := []string{
+		`"usage":{"input_tokens":7,"output_tokens":0}`,
+		`"content_block":{"text":"","type":"text"},"index":0`,
+		`"delta":{"text":"Checking","type":"text_delta"}`,
+		`data: {"index":0,"type":"content_block_stop"}`,
+		`"name":"get_weather","type":"tool_use"},"index":1`,
+		`"delta":{"partial_json":"{\"city\":\"Paris\"}","type":"input_json_delta"}`,
+		`data: {"index":1,"type":"content_block_stop"}`,
+		`"usage":{"output_tokens":5}`,
+		"event: message_stop",
+	}
+
+	position := 0
+
+	for _, expected := range expectedOrder {
+		index := strings.Index(body[position:], expected)
+		require.GreaterOrEqual(t, index, 0, "expected %q after position %d in:\n%s", expected, position, body)
+		position += index + len(expected)
+	}
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Davincible/claude-code-open/internal/config"
+	"github.com/Davincible/claude-code-open/internal/handlers"
+	"github.com/Davincible/claude-code-open/internal/providers"
+)
+
+func TestOllamaNDJSONStreamIntegration(t *testing.T) {
+	var upstreamBody []byte
+
+	// Local stand-in for Ollama's /api/chat streaming newline-delimited JSON
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/chat", r.URL.Path)
+
+		upstreamBody, _ = io.ReadAll(r.Body)
+
+		w.Header().Set("Content-Type", providers.ContentTypeNDJSON)
+		w.WriteHeader(http.StatusOK)
+
+		for _, line := range []string{
+			`{"model":"qwen2.5-coder","message":{"role":"assistant","content":"Hello"},"done":false}`,
+			`{"model":"qwen2.5-coder","message":{"role":"assistant","content":" from Ollama"},"done":false}`,
+			`{"model":"qwen2.5-coder","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":4}`,
+		} {
+			_, _ = w.Write([]byte(line + "\n"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		Providers: []config.Provider{
+			{
+				Name:    "ollama",
+				APIBase: upstream.URL + "/api/chat",
+			},
+		},
+		Router: config.RouterConfig{
+			Default: "ollama,qwen2.5-coder",
+		},
+	}
+
+	cfgMgr := config.NewManager(t.TempDir())
+	require.NoError(t, cfgMgr.Save(cfg))
+
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	registry := providers.NewRegistry()
+	registry.Initialize(cfg.Providers)
+
+	handler := handlers.NewProxyHandler(cfgMgr, registry, logger)
+
+	requestBody, err := json.Marshal(map[string]any{
+		"model":      "ollama,qwen2.5-coder",
+		"max_tokens": 100,
+		"stream":     true,
+		"messages": []map[string]any{
+			{"role": "user", "content": "Hello"},
+		},
+	})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(requestBody))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.JSONEq(t, `{"model":"qwen2.5-coder","stream":true,"options":{"num_predict":100},"messages":[{"role":"user","content":"Hello"}]}`,
+		string(upstreamBody))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"text/event-stream"}, rr.Header().Values("Content-Type"))
+
+	body := rr.Body.String()
+	assert.Contains(t, body, "event: message_start")
+	assert.Contains(t, body, `"text":"Hello"`)
+	assert.Contains(t, body, `"text":" from Ollama"`)
+	assert.Contains(t, body, `"stop_reason":"end_turn"`)
+	assert.Contains(t, body, `"usage":{"input_tokens":12,"output_tokens":4}`)
+	assert.True(t, strings.HasSuffix(body, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"))
+}